@@ -34,7 +34,7 @@ require (
 	github.com/emersion/go-mbox v1.0.2
 	github.com/emersion/go-message v0.12.1-0.20201221184100-40c3f864532b
 	github.com/emersion/go-sasl v0.0.0-20200509203442-7bfe0ed36a21
-	github.com/emersion/go-smtp v0.14.0
+	github.com/emersion/go-smtp v0.19.0
 	github.com/emersion/go-textwrapper v0.0.0-20200911093747-65d896831594
 	github.com/emersion/go-vcard v0.0.0-20190105225839-8856043f13c5 // indirect
 	github.com/fatih/color v1.9.0
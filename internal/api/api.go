@@ -19,6 +19,7 @@
 //
 // API endpoints:
 //  * /focus, see focusHandler
+//  * /sessions, see sessionsHandler
 package api
 
 import (
@@ -28,6 +29,7 @@ import (
 	"github.com/ProtonMail/proton-bridge/internal/bridge"
 	"github.com/ProtonMail/proton-bridge/internal/config/settings"
 	"github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/ProtonMail/proton-bridge/internal/serverutil"
 	"github.com/ProtonMail/proton-bridge/pkg/listener"
 	"github.com/ProtonMail/proton-bridge/pkg/ports"
 	"github.com/sirupsen/logrus"
@@ -41,14 +43,19 @@ type apiServer struct {
 	host          string
 	settings      *settings.Settings
 	eventListener listener.Listener
+	sessions      *serverutil.Sessions
+	users         usersProvider
 }
 
-// NewAPIServer returns prepared API server struct.
-func NewAPIServer(settings *settings.Settings, eventListener listener.Listener) *apiServer { //nolint[golint]
+// NewAPIServer returns prepared API server struct. Sessions and users are
+// optional, the endpoint with client sessions is not available without them.
+func NewAPIServer(settings *settings.Settings, eventListener listener.Listener, sessions *serverutil.Sessions, users usersProvider) *apiServer { //nolint[golint]
 	return &apiServer{
 		host:          bridge.Host,
 		settings:      settings,
 		eventListener: eventListener,
+		sessions:      sessions,
+		users:         users,
 	}
 }
 
@@ -56,6 +63,9 @@ func NewAPIServer(settings *settings.Settings, eventListener listener.Listener)
 func (api *apiServer) ListenAndServe() {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/focus", wrapper(api, focusHandler))
+	if api.sessions != nil && api.users != nil {
+		mux.HandleFunc("/sessions", wrapper(api, sessionsHandler))
+	}
 
 	addr := api.getAddress()
 	server := &http.Server{
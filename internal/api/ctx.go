@@ -20,6 +20,7 @@ package api
 import (
 	"net/http"
 
+	"github.com/ProtonMail/proton-bridge/internal/serverutil"
 	"github.com/ProtonMail/proton-bridge/pkg/listener"
 )
 
@@ -33,6 +34,8 @@ type handlerContext struct {
 	req           *http.Request
 	resp          http.ResponseWriter
 	eventListener listener.Listener
+	sessions      *serverutil.Sessions
+	users         usersProvider
 }
 
 func wrapper(api *apiServer, callback handler) httpHandler {
@@ -41,6 +44,8 @@ func wrapper(api *apiServer, callback handler) httpHandler {
 			req:           req,
 			resp:          w,
 			eventListener: api.eventListener,
+			sessions:      api.sessions,
+			users:         api.users,
 		}
 		err := callback(ctx)
 		if err != nil {
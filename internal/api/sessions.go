@@ -0,0 +1,79 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ProtonMail/proton-bridge/internal/serverutil"
+	"github.com/ProtonMail/proton-bridge/internal/users"
+)
+
+type usersProvider interface {
+	GetUser(query string) (*users.User, error)
+}
+
+// sessionsHandler returns active IMAP and SMTP sessions of the user as JSON,
+// i.e., clients connected to the Bridge and statistics of their commands.
+//
+// The API listens on localhost without any other protection, so the client
+// has to log in by basic authentication with the same username and bridge
+// password as used by mail clients. Sessions of other users are not listed
+// because they contain remote addresses and usernames.
+func sessionsHandler(ctx handlerContext) error {
+	user, ok := authenticate(ctx)
+	if !ok {
+		ctx.resp.Header().Set("WWW-Authenticate", `Basic realm="Bridge", charset="UTF-8"`)
+		http.Error(ctx.resp, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return nil
+	}
+
+	sessions := []serverutil.SessionInfo{}
+	for _, session := range ctx.sessions.List() {
+		if session.User == "" {
+			continue
+		}
+		if sessionUser, err := ctx.users.GetUser(session.User); err != nil || sessionUser.ID() != user.ID() {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	ctx.resp.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(ctx.resp).Encode(sessions)
+}
+
+func authenticate(ctx handlerContext) (*users.User, bool) {
+	username, password, ok := ctx.req.BasicAuth()
+	if !ok {
+		return nil, false
+	}
+
+	user, err := ctx.users.GetUser(username)
+	if err != nil {
+		return nil, false
+	}
+
+	if err := user.CheckBridgeLogin(password); err != nil {
+		log.WithError(err).Warn("Failed to authenticate client of sessions")
+		return nil, false
+	}
+
+	return user, true
+}
@@ -30,6 +30,8 @@ import (
 	"github.com/ProtonMail/proton-bridge/internal/frontend"
 	"github.com/ProtonMail/proton-bridge/internal/frontend/types"
 	"github.com/ProtonMail/proton-bridge/internal/imap"
+	"github.com/ProtonMail/proton-bridge/internal/serverutil"
+	"github.com/ProtonMail/proton-bridge/internal/sieve"
 	"github.com/ProtonMail/proton-bridge/internal/smtp"
 	"github.com/ProtonMail/proton-bridge/internal/updater"
 	"github.com/pkg/errors"
@@ -70,23 +72,37 @@ func run(b *base.Base, c *cli.Context) error { // nolint[funlen]
 	if err != nil {
 		logrus.WithError(err).Fatal("Failed to load TLS config")
 	}
-	bridge := bridge.New(b.Locations, b.Cache, b.Settings, b.SentryReporter, b.CrashHandler, b.Listener, b.CM, b.Creds, b.Updater, b.Versioner)
+	sievePath, err := b.Locations.ProvideSievePath()
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to provide Sieve path")
+	}
+	sieveScripts := sieve.NewScripts(sievePath)
+	sieveFilter := sieve.NewFilter(sieveScripts)
+
+	bridge := bridge.New(b.Locations, b.Cache, b.Settings, b.SentryReporter, b.CrashHandler, b.Listener, b.CM, b.Creds, b.Updater, b.Versioner, sieveFilter)
 	imapBackend := imap.NewIMAPBackend(b.CrashHandler, b.Listener, b.Cache, bridge)
 	smtpBackend := smtp.NewSMTPBackend(b.CrashHandler, b.Listener, b.Settings, bridge)
+	sessions := serverutil.NewSessions()
+
+	sieveFilter.SetRedirector(smtpBackend)
 
 	go func() {
 		defer b.CrashHandler.HandlePanic()
-		api.NewAPIServer(b.Settings, b.Listener).ListenAndServe()
+		api.NewAPIServer(b.Settings, b.Listener, sessions, bridge).ListenAndServe()
 	}()
 
 	go func() {
 		defer b.CrashHandler.HandlePanic()
 		imapPort := b.Settings.GetInt(settings.IMAPPortKey)
+		imapSSLPort := 0
+		if b.Settings.GetBool(settings.IMAPSSLKey) {
+			imapSSLPort = b.Settings.GetInt(settings.IMAPSSLPortKey)
+		}
 		imap.NewIMAPServer(
 			b.CrashHandler,
 			c.String(flagLogIMAP) == "client" || c.String(flagLogIMAP) == "all",
 			c.String(flagLogIMAP) == "server" || c.String(flagLogIMAP) == "all",
-			imapPort, tlsConfig, imapBackend, b.UserAgent, b.Listener).ListenAndServe()
+			imapPort, imapSSLPort, b.Settings.GetBool(settings.IMAPCompressKey), tlsConfig, imapBackend, b.UserAgent, b.Listener, sessions).ListenAndServe()
 	}()
 
 	go func() {
@@ -96,9 +112,35 @@ func run(b *base.Base, c *cli.Context) error { // nolint[funlen]
 		smtp.NewSMTPServer(
 			b.CrashHandler,
 			c.Bool(flagLogSMTP),
-			smtpPort, useSSL, tlsConfig, smtpBackend, b.Listener).ListenAndServe()
+			smtpPort, useSSL, tlsConfig, smtpBackend, b.Listener, sessions).ListenAndServe()
 	}()
 
+	if relayAddress := b.Settings.Get(settings.SMTPRelayAddressKey); relayAddress != "" {
+		go func() {
+			defer b.CrashHandler.HandlePanic()
+			rules, err := smtp.LoadRelayRules(b.Settings.Get(settings.SMTPRelayRulesKey))
+			if err != nil {
+				logrus.WithError(err).Error("Failed to load SMTP relay rules, relay is disabled")
+				return
+			}
+			smtp.NewSMTPRelayServer(
+				b.CrashHandler,
+				c.Bool(flagLogSMTP),
+				relayAddress, tlsConfig, smtpBackend, rules, b.Listener, sessions).ListenAndServe()
+		}()
+	}
+
+	if b.Settings.GetBool(settings.SieveKey) {
+		go func() {
+			defer b.CrashHandler.HandlePanic()
+			sievePort := b.Settings.GetInt(settings.SievePortKey)
+			sieve.NewManageSieveServer(
+				b.CrashHandler,
+				false,
+				sievePort, tlsConfig, bridge, sieveScripts, b.Listener, sessions).ListenAndServe()
+		}()
+	}
+
 	// Bridge supports no-window option which we should use for autostart.
 	b.Autostart.Exec = append(b.Autostart.Exec, "--"+flagNoWindow)
 
@@ -135,6 +177,7 @@ func run(b *base.Base, c *cli.Context) error { // nolint[funlen]
 		smtpBackend,
 		b.Autostart,
 		b,
+		sessions,
 	)
 
 	return f.Loop()
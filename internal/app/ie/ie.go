@@ -38,7 +38,7 @@ func run(b *base.Base, c *cli.Context) error {
 
 	go func() {
 		defer b.CrashHandler.HandlePanic()
-		api.NewAPIServer(b.Settings, b.Listener).ListenAndServe()
+		api.NewAPIServer(b.Settings, b.Listener, nil, nil).ListenAndServe()
 	}()
 
 	var frontendMode string
@@ -28,6 +28,7 @@ import (
 	"github.com/ProtonMail/proton-bridge/internal/constants"
 	"github.com/ProtonMail/proton-bridge/internal/metrics"
 	"github.com/ProtonMail/proton-bridge/internal/sentry"
+	"github.com/ProtonMail/proton-bridge/internal/store"
 	"github.com/ProtonMail/proton-bridge/internal/updater"
 	"github.com/ProtonMail/proton-bridge/internal/users"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
@@ -61,6 +62,7 @@ func New(
 	credStorer users.CredentialsStorer,
 	updater Updater,
 	versioner Versioner,
+	messageFilter store.MessageFilter,
 ) *Bridge {
 	// Allow DoH before starting the app if the user has previously set this setting.
 	// This allows us to start even if protonmail is blocked.
@@ -68,7 +70,7 @@ func New(
 		clientManager.AllowProxy()
 	}
 
-	storeFactory := newStoreFactory(cache, sentryReporter, panicHandler, eventListener)
+	storeFactory := newStoreFactory(cache, s, sentryReporter, panicHandler, eventListener, messageFilter)
 	u := users.New(locations, panicHandler, eventListener, clientManager, credStorer, storeFactory, true)
 	b := &Bridge{
 		Users: u,
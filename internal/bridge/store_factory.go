@@ -19,8 +19,11 @@ package bridge
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/ProtonMail/proton-bridge/internal/config/settings"
 	"github.com/ProtonMail/proton-bridge/internal/sentry"
 	"github.com/ProtonMail/proton-bridge/internal/store"
 	"github.com/ProtonMail/proton-bridge/internal/users"
@@ -29,37 +32,87 @@ import (
 
 type storeFactory struct {
 	cache          Cacher
+	settings       SettingsProvider
 	sentryReporter *sentry.Reporter
 	panicHandler   users.PanicHandler
 	eventListener  listener.Listener
 	storeCache     *store.Cache
+	messageFilter  store.MessageFilter
 }
 
 func newStoreFactory(
 	cache Cacher,
+	s SettingsProvider,
 	sentryReporter *sentry.Reporter,
 	panicHandler users.PanicHandler,
 	eventListener listener.Listener,
+	messageFilter store.MessageFilter,
 ) *storeFactory {
 	return &storeFactory{
 		cache:          cache,
+		settings:       s,
 		sentryReporter: sentryReporter,
 		panicHandler:   panicHandler,
 		eventListener:  eventListener,
 		storeCache:     store.NewCache(cache.GetIMAPCachePath()),
+		messageFilter:  messageFilter,
 	}
 }
 
 // New creates new store for given user.
 func (f *storeFactory) New(user store.BridgeUser) (*store.Store, error) {
 	storePath := getUserStorePath(f.cache.GetDBDir(), user.ID())
-	return store.New(f.sentryReporter, f.panicHandler, user, f.eventListener, storePath, f.storeCache)
+
+	s, err := store.New(f.sentryReporter, f.panicHandler, user, f.eventListener, storePath, f.storeCache)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.messageFilter != nil {
+		s.SetMessageFilter(f.messageFilter)
+	}
+
+	if user.IsConnected() && f.settings.GetBool(settings.SearchIndexKey) {
+		if err := s.EnableSearchIndex(); err != nil {
+			log.WithError(err).Error("Could not enable search index")
+		}
+	}
+
+	cacheDir := getUserMessageCacheDir(f.cache.GetMessageCacheDir(), user.ID())
+	if sizeLimit := f.settings.GetInt(settings.MessageCacheSizeKey); f.settings.GetBool(settings.MessageCacheKey) && sizeLimit > 0 {
+		if user.IsConnected() {
+			ageLimit := time.Duration(f.settings.GetInt(settings.MessageCacheAgeKey)) * 24 * time.Hour
+			if err := s.EnableMessageCache(cacheDir, int64(sizeLimit)<<20, ageLimit); err != nil {
+				log.WithError(err).Error("Could not enable message cache")
+			}
+		}
+	} else if err := os.RemoveAll(cacheDir); err != nil {
+		// Messages cached before the cache was disabled are not kept.
+		log.WithError(err).Error("Could not remove message cache")
+	}
+
+	if user.IsConnected() {
+		if err := s.EnableOutbox(getUserOutboxDir(f.cache.GetDBDir(), user.ID())); err != nil {
+			log.WithError(err).Error("Could not enable outbox")
+		}
+	}
+
+	return s, nil
 }
 
 // Remove removes all store files for given user.
 func (f *storeFactory) Remove(userID string) error {
 	storePath := getUserStorePath(f.cache.GetDBDir(), userID)
-	return store.RemoveStore(f.storeCache, storePath, userID)
+	if err := store.RemoveStore(f.storeCache, storePath, userID); err != nil {
+		return err
+	}
+
+	// RemoveAll will not return an error if the path does not exist.
+	if err := os.RemoveAll(getUserOutboxDir(f.cache.GetDBDir(), userID)); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(getUserMessageCacheDir(f.cache.GetMessageCacheDir(), userID))
 }
 
 // getUserStorePath returns the file path of the store database for the given userID.
@@ -67,3 +120,13 @@ func getUserStorePath(storeDir string, userID string) (path string) {
 	fileName := fmt.Sprintf("mailbox-%v.db", userID)
 	return filepath.Join(storeDir, fileName)
 }
+
+// getUserMessageCacheDir returns the directory of the message cache for the given userID.
+func getUserMessageCacheDir(cacheDir string, userID string) (path string) {
+	return filepath.Join(cacheDir, userID)
+}
+
+// getUserOutboxDir returns the directory of the outbox for the given userID.
+func getUserOutboxDir(storeDir string, userID string) (path string) {
+	return filepath.Join(storeDir, fmt.Sprintf("outbox-%v", userID))
+}
@@ -31,6 +31,7 @@ type Locator interface {
 type Cacher interface {
 	GetIMAPCachePath() string
 	GetDBDir() string
+	GetMessageCacheDir() string
 }
 
 type SettingsProvider interface {
@@ -38,6 +39,7 @@ type SettingsProvider interface {
 	Set(key string, value string)
 	GetBool(key string) bool
 	SetBool(key string, val bool)
+	GetInt(key string) int
 }
 
 type Updater interface {
@@ -50,6 +50,11 @@ func (c *Cache) GetIMAPCachePath() string {
 	return filepath.Join(c.getCurrentCacheDir(), "user_info.json")
 }
 
+// GetMessageCacheDir returns folder for encrypted message cache of all users.
+func (c *Cache) GetMessageCacheDir() string {
+	return filepath.Join(c.getCurrentCacheDir(), "messages")
+}
+
 // GetTransferDir returns folder for import-export rules files.
 func (c *Cache) GetTransferDir() string {
 	return c.getCurrentCacheDir()
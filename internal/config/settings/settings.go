@@ -32,8 +32,13 @@ const (
 	LastHeartbeatKey       = "last_heartbeat"
 	APIPortKey             = "user_port_api"
 	IMAPPortKey            = "user_port_imap"
+	IMAPSSLPortKey         = "user_port_imaps"
+	IMAPSSLKey             = "user_ssl_imap"
+	IMAPCompressKey        = "user_compress_imap"
 	SMTPPortKey            = "user_port_smtp"
 	SMTPSSLKey             = "user_ssl_smtp"
+	SieveKey               = "user_sieve"
+	SievePortKey           = "user_port_sieve"
 	AllowProxyKey          = "allow_proxy"
 	AutostartKey           = "autostart"
 	AutoUpdateKey          = "autoupdate"
@@ -43,6 +48,16 @@ const (
 	UpdateChannelKey       = "update_channel"
 	RolloutKey             = "rollout"
 	PreferredKeychainKey   = "preferred_keychain"
+	SearchIndexKey         = "search_index"
+	MessageCacheKey        = "message_cache"
+	MessageCacheSizeKey    = "message_cache_size"
+	MessageCacheAgeKey     = "message_cache_age"
+	KeyDiscoveryWKDKey     = "key_discovery_wkd"
+	KeyDiscoveryServerKey  = "key_discovery_keyserver"
+	KeyDiscoveryDirKey     = "key_discovery_dir"
+	KeyDiscoveryHKPKey     = "key_discovery_keyserver_hkp"
+	SMTPRelayAddressKey    = "smtp_relay_address"
+	SMTPRelayRulesKey      = "smtp_relay_rules"
 )
 
 type Settings struct {
@@ -63,9 +78,11 @@ func New(settingsPath string) *Settings {
 }
 
 const (
-	DefaultIMAPPort = "1143"
-	DefaultSMTPPort = "1025"
-	DefaultAPIPort  = "1042"
+	DefaultIMAPPort    = "1143"
+	DefaultIMAPSSLPort = "1993"
+	DefaultSMTPPort    = "1025"
+	DefaultAPIPort     = "1042"
+	DefaultSievePort   = "4190"
 )
 
 func (s *Settings) setDefaultValues() {
@@ -80,11 +97,44 @@ func (s *Settings) setDefaultValues() {
 	s.setDefault(UpdateChannelKey, "")
 	s.setDefault(RolloutKey, fmt.Sprintf("%v", rand.Float64())) //nolint[gosec] G404 It is OK to use weak random number generator here
 	s.setDefault(PreferredKeychainKey, "")
+	s.setDefault(SearchIndexKey, "false")
+
+	// Message cache keeps decrypted content on disk (encrypted by a local
+	// key), so it is opt-in.
+	s.setDefault(MessageCacheKey, "false")
+	s.setDefault(MessageCacheSizeKey, "1024") // MB
+	s.setDefault(MessageCacheAgeKey, "30")    // days, zero means no limit
+
+	// Key discovery reveals recipients to third parties, so it is opt-in.
+	s.setDefault(KeyDiscoveryWKDKey, "false")
+	// Keyserver should be a verifying one (VKS, e.g. https://keys.openpgp.org)
+	// serving only keys with confirmed address. HKP keyservers (hkp:// and
+	// hkps://) serve keys with any self-claimed address and the first found
+	// key is pinned, so they have to be allowed explicitly.
+	s.setDefault(KeyDiscoveryServerKey, "")
+	s.setDefault(KeyDiscoveryHKPKey, "false")
+	s.setDefault(KeyDiscoveryDirKey, "")
 
 	s.setDefault(APIPortKey, DefaultAPIPort)
 	s.setDefault(IMAPPortKey, DefaultIMAPPort)
+	s.setDefault(IMAPSSLPortKey, DefaultIMAPSSLPort)
 	s.setDefault(SMTPPortKey, DefaultSMTPPort)
+	s.setDefault(SievePortKey, DefaultSievePort)
 
 	// By default, stick to STARTTLS. If the user uses catalina+applemail they'll have to change to SSL.
 	s.setDefault(SMTPSSLKey, "false")
+
+	// IMAP is always available with STARTTLS, IMAPS listener is optional.
+	s.setDefault(IMAPSSLKey, "false")
+
+	// Compression is used only by clients asking for it.
+	s.setDefault(IMAPCompressKey, "true")
+
+	// Filters can be managed only by clients asking for it, so ManageSieve
+	// listener is opt-in.
+	s.setDefault(SieveKey, "false")
+
+	// Relay accepts mail from other hosts, so it is opt-in.
+	s.setDefault(SMTPRelayAddressKey, "") // e.g. 0.0.0.0:1026
+	s.setDefault(SMTPRelayRulesKey, "")   // path to JSON file with relay rules
 }
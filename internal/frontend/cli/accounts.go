@@ -24,6 +24,7 @@ import (
 	"github.com/ProtonMail/proton-bridge/internal/bridge"
 	"github.com/ProtonMail/proton-bridge/internal/config/settings"
 	"github.com/ProtonMail/proton-bridge/internal/frontend/types"
+	"github.com/ProtonMail/proton-bridge/internal/store"
 	"github.com/abiosoft/ishell"
 )
 
@@ -77,6 +78,12 @@ func (f *frontendCLI) showAccountAddressInfo(user types.User, address string) {
 		user.GetBridgePassword(),
 		"STARTTLS",
 	)
+	if f.settings.GetBool(settings.IMAPSSLKey) {
+		f.Printf("IMAPS port: %d\nSecurity:   %s\n",
+			f.settings.GetInt(settings.IMAPSSLPortKey),
+			"SSL",
+		)
+	}
 	f.Println("")
 	f.Printf("SMTP Settings\nAddress:   %s\nSMTP port: %d\nUsername:  %s\nPassword:  %s\nSecurity:  %s\n",
 		bridge.Host,
@@ -86,6 +93,16 @@ func (f *frontendCLI) showAccountAddressInfo(user types.User, address string) {
 		smtpSecurity,
 	)
 	f.Println("")
+	if f.settings.GetBool(settings.SieveKey) {
+		f.Printf("ManageSieve Settings\nAddress:   %s\nSieve port: %d\nUsername:  %s\nPassword:  %s\nSecurity:  %s\n",
+			bridge.Host,
+			f.settings.GetInt(settings.SievePortKey),
+			address,
+			user.GetBridgePassword(),
+			"STARTTLS",
+		)
+		f.Println("")
+	}
 }
 
 func (f *frontendCLI) loginAccount(c *ishell.Context) { // nolint[funlen]
@@ -218,3 +235,73 @@ func (f *frontendCLI) changeMode(c *ishell.Context) {
 	}
 	f.Printf("Address mode for account %s changed to %s\n", user.Username(), newMode)
 }
+
+func (f *frontendCLI) changeAliasPolicy(c *ishell.Context) {
+	f.ShowPrompt(false)
+	defer f.ShowPrompt(true)
+
+	user := f.askUserByIndexOrName(c)
+	if user == nil {
+		return
+	}
+
+	current, err := user.GetAliasPolicy()
+	if err != nil {
+		f.printAndLogError("Cannot get alias policy: ", err)
+		return
+	}
+
+	f.Println("Alias policy for account " + bold(user.Username()) + " is " + bold(string(current)) + ".")
+	f.Println("Allowed sender identities for SMTP:")
+	f.Println("  " + bold(string(store.AliasPolicyExact)) + "     only addresses of the account")
+	f.Println("  " + bold(string(store.AliasPolicyPlus)) + "      also plus addresses, e.g. me+project@pm.me")
+	f.Println("  " + bold(string(store.AliasPolicyCatchAll)) + " also any address on domain with catch-all address")
+
+	policy := f.readStringInAttempts("Alias policy", c.ReadLine, func(val string) bool {
+		switch store.AliasPolicy(val) {
+		case store.AliasPolicyExact, store.AliasPolicyPlus, store.AliasPolicyCatchAll:
+			return true
+		}
+		return false
+	})
+	if policy == "" {
+		return
+	}
+
+	if err := user.SetAliasPolicy(store.AliasPolicy(policy)); err != nil {
+		f.printAndLogError("Cannot set alias policy: ", err)
+		return
+	}
+	f.Printf("Alias policy for account %s changed to %s\n", user.Username(), policy)
+}
+
+func (f *frontendCLI) changeAutocryptPreferEncrypt(c *ishell.Context) {
+	f.ShowPrompt(false)
+	defer f.ShowPrompt(true)
+
+	user := f.askUserByIndexOrName(c)
+	if user == nil {
+		return
+	}
+
+	preferEncrypt, err := user.GetAutocryptPreferEncrypt()
+	if err != nil {
+		f.printAndLogError("Cannot get Autocrypt preference: ", err)
+		return
+	}
+
+	msg := "Are you sure you want to " + bold("enable") + " encryption to Autocrypt keys for account " + bold(user.Username())
+	if preferEncrypt {
+		msg = "Are you sure you want to " + bold("disable") + " encryption to Autocrypt keys for account " + bold(user.Username())
+	}
+
+	if !f.yesNoQuestion(msg) {
+		return
+	}
+
+	if err := user.SetAutocryptPreferEncrypt(!preferEncrypt); err != nil {
+		f.printAndLogError("Cannot set Autocrypt preference: ", err)
+		return
+	}
+	f.Printf("Encryption to Autocrypt keys for account %s changed to %t\n", user.Username(), !preferEncrypt)
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/ProtonMail/proton-bridge/internal/events"
 	"github.com/ProtonMail/proton-bridge/internal/frontend/types"
 	"github.com/ProtonMail/proton-bridge/internal/locations"
+	"github.com/ProtonMail/proton-bridge/internal/serverutil"
 	"github.com/ProtonMail/proton-bridge/internal/updater"
 	"github.com/ProtonMail/proton-bridge/pkg/listener"
 
@@ -42,6 +43,7 @@ type frontendCLI struct {
 	eventListener listener.Listener
 	updater       types.Updater
 	bridge        types.Bridger
+	sessions      *serverutil.Sessions
 
 	restarter types.Restarter
 }
@@ -56,6 +58,7 @@ func New( //nolint[funlen]
 	updater types.Updater,
 	bridge types.Bridger,
 	restarter types.Restarter,
+	sessions *serverutil.Sessions,
 ) *frontendCLI { //nolint[golint]
 	fe := &frontendCLI{
 		Shell: ishell.New(),
@@ -65,6 +68,7 @@ func New( //nolint[funlen]
 		eventListener: eventListener,
 		updater:       updater,
 		bridge:        bridge,
+		sessions:      sessions,
 
 		restarter: restarter,
 	}
@@ -97,6 +101,17 @@ func New( //nolint[funlen]
 		Func:      fe.changeMode,
 		Completer: fe.completeUsernames,
 	})
+	changeCmd.AddCmd(&ishell.Cmd{Name: "alias-policy",
+		Help:      "change which plus or catch-all addresses can be used as sender over SMTP. Use index or account name as parameter. (alias: aliases)",
+		Aliases:   []string{"aliases"},
+		Func:      fe.noAccountWrapper(fe.changeAliasPolicy),
+		Completer: fe.completeUsernames,
+	})
+	changeCmd.AddCmd(&ishell.Cmd{Name: "autocrypt",
+		Help:      "enable or disable encryption to keys of recipients announced by Autocrypt. Messages are encrypted only when the recipient prefers encryption as well. Use index or account name as parameter.",
+		Func:      fe.noAccountWrapper(fe.changeAutocryptPreferEncrypt),
+		Completer: fe.completeUsernames,
+	})
 	changeCmd.AddCmd(&ishell.Cmd{Name: "port",
 		Help:    "change port numbers of IMAP and SMTP servers. (alias: p)",
 		Aliases: []string{"p"},
@@ -107,6 +122,21 @@ func New( //nolint[funlen]
 		Aliases: []string{"ssl", "starttls"},
 		Func:    fe.changeSMTPSecurity,
 	})
+	changeCmd.AddCmd(&ishell.Cmd{Name: "imap-security",
+		Help:    "enable or disable IMAPS listener with implicit SSL next to STARTTLS. (alias: imaps)",
+		Aliases: []string{"imaps"},
+		Func:    fe.changeIMAPSecurity,
+	})
+	changeCmd.AddCmd(&ishell.Cmd{Name: "imap-compress",
+		Help:    "enable or disable IMAP COMPRESS=DEFLATE extension. (alias: compress)",
+		Aliases: []string{"compress"},
+		Func:    fe.changeIMAPCompress,
+	})
+	changeCmd.AddCmd(&ishell.Cmd{Name: "sieve",
+		Help:    "enable or disable ManageSieve server for managing Sieve filters. Redirected messages are resent from the address which received them with the original sender in Reply-To, and messages already redirected by the account are not redirected again. (alias: managesieve)",
+		Aliases: []string{"managesieve"},
+		Func:    fe.changeSieve,
+	})
 	fe.AddCmd(changeCmd)
 
 	// DoH commands.
@@ -123,6 +153,34 @@ func New( //nolint[funlen]
 	})
 	fe.AddCmd(dohCmd)
 
+	// Search index commands.
+	searchIndexCmd := &ishell.Cmd{Name: "search-index",
+		Help: "enable or disable local encrypted index used to search message content",
+	}
+	searchIndexCmd.AddCmd(&ishell.Cmd{Name: "enable",
+		Help: "enable local encrypted index used to search message content",
+		Func: fe.enableSearchIndex,
+	})
+	searchIndexCmd.AddCmd(&ishell.Cmd{Name: "disable",
+		Help: "disable local encrypted index used to search message content",
+		Func: fe.disableSearchIndex,
+	})
+	fe.AddCmd(searchIndexCmd)
+
+	// Message cache commands.
+	messageCacheCmd := &ishell.Cmd{Name: "message-cache",
+		Help: "enable or disable local encrypted cache of message content",
+	}
+	messageCacheCmd.AddCmd(&ishell.Cmd{Name: "enable",
+		Help: "enable local encrypted cache of message content",
+		Func: fe.enableMessageCache,
+	})
+	messageCacheCmd.AddCmd(&ishell.Cmd{Name: "disable",
+		Help: "disable local encrypted cache of message content and remove cached messages",
+		Func: fe.disableMessageCache,
+	})
+	fe.AddCmd(messageCacheCmd)
+
 	// Updates commands.
 	updatesCmd := &ishell.Cmd{Name: "updates",
 		Help: "manage bridge updates",
@@ -174,6 +232,12 @@ func New( //nolint[funlen]
 		Func: fe.printCredits,
 	})
 
+	fe.AddCmd(&ishell.Cmd{Name: "sessions",
+		Help:    "print connected IMAP and SMTP clients with their commands and durations. (alias: clients)",
+		Aliases: []string{"clients"},
+		Func:    fe.printSessions,
+	})
+
 	// Account commands.
 	fe.AddCmd(&ishell.Cmd{Name: "list",
 		Help:    "print the list of accounts. (aliases: l, ls)",
@@ -205,6 +269,30 @@ func New( //nolint[funlen]
 		Completer: fe.completeUsernames,
 	})
 
+	// Saved search commands.
+	searchesCmd := &ishell.Cmd{Name: "searches",
+		Help: "manage saved searches shown as read-only mailboxes under Searches/",
+	}
+	searchesCmd.AddCmd(&ishell.Cmd{Name: "list",
+		Help:      "print saved searches of the account. Use index or account name as parameter. (aliases: l, ls)",
+		Func:      fe.noAccountWrapper(fe.listSavedSearches),
+		Aliases:   []string{"l", "ls"},
+		Completer: fe.completeUsernames,
+	})
+	searchesCmd.AddCmd(&ishell.Cmd{Name: "add",
+		Help:      "add saved search to the account. Use index or account name as parameter. (alias: a)",
+		Func:      fe.noAccountWrapper(fe.addSavedSearch),
+		Aliases:   []string{"a"},
+		Completer: fe.completeUsernames,
+	})
+	searchesCmd.AddCmd(&ishell.Cmd{Name: "remove",
+		Help:      "remove saved search from the account. Use index or account name as parameter. (aliases: rm, delete)",
+		Func:      fe.noAccountWrapper(fe.removeSavedSearch),
+		Aliases:   []string{"rm", "delete"},
+		Completer: fe.completeUsernames,
+	})
+	fe.AddCmd(searchesCmd)
+
 	// System commands.
 	fe.AddCmd(&ishell.Cmd{Name: "restart",
 		Help: "restart the bridge.",
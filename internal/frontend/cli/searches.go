@@ -0,0 +1,119 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/internal/store"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/abiosoft/ishell"
+)
+
+func (f *frontendCLI) listSavedSearches(c *ishell.Context) {
+	user := f.askUserByIndexOrName(c)
+	if user == nil {
+		return
+	}
+
+	searches, err := user.ListSavedSearches()
+	if err != nil {
+		f.printAndLogError("Cannot list saved searches: ", err)
+		return
+	}
+
+	if len(searches) == 0 {
+		f.Println("There is no saved search for account " + bold(user.Username()) + ".")
+		return
+	}
+
+	for _, search := range searches {
+		f.Println(bold(store.SavedSearchesPrefix + search.Name))
+		if search.Filter != nil {
+			filter, _ := json.Marshal(search.Filter)
+			f.Println("  Filter:", string(filter))
+		}
+		if search.Query != "" {
+			f.Println("  Query: ", search.Query)
+		}
+	}
+}
+
+func (f *frontendCLI) addSavedSearch(c *ishell.Context) {
+	f.ShowPrompt(false)
+	defer f.ShowPrompt(true)
+
+	user := f.askUserByIndexOrName(c)
+	if user == nil {
+		return
+	}
+
+	name := f.readStringInAttempts("Name", c.ReadLine, isNotEmpty)
+	if name == "" {
+		return
+	}
+
+	f.Println("Use IMAP SEARCH expression, e.g. UNSEEN FROM boss@example.com,")
+	f.Println(`or JSON message filter, e.g. {"From":"boss@example.com","Unread":true}.`)
+	criteria := f.readStringInAttempts("Criteria", c.ReadLine, isNotEmpty)
+	if criteria == "" {
+		return
+	}
+
+	search := store.SavedSearch{Name: name}
+	if strings.HasPrefix(criteria, "{") {
+		search.Filter = &pmapi.MessagesFilter{}
+		if err := json.Unmarshal([]byte(criteria), search.Filter); err != nil {
+			f.printAndLogError("Cannot parse message filter: ", err)
+			return
+		}
+	} else {
+		search.Query = criteria
+	}
+
+	if err := user.AddSavedSearch(search); err != nil {
+		f.printAndLogError("Cannot add saved search: ", err)
+		return
+	}
+
+	f.Println("Saved search is available as mailbox " + bold(store.SavedSearchesPrefix+name) + ".")
+}
+
+func (f *frontendCLI) removeSavedSearch(c *ishell.Context) {
+	f.ShowPrompt(false)
+	defer f.ShowPrompt(true)
+
+	user := f.askUserByIndexOrName(c)
+	if user == nil {
+		return
+	}
+
+	name := f.readStringInAttempts("Name", c.ReadLine, isNotEmpty)
+	if name == "" {
+		return
+	}
+
+	if !f.yesNoQuestion("Are you sure you want to remove saved search " + bold(name)) {
+		return
+	}
+
+	if err := user.RemoveSavedSearch(strings.TrimPrefix(name, store.SavedSearchesPrefix)); err != nil {
+		f.printAndLogError("Cannot remove saved search: ", err)
+	}
+}
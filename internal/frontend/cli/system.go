@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ProtonMail/proton-bridge/internal/config/settings"
 	"github.com/ProtonMail/proton-bridge/pkg/ports"
@@ -69,6 +70,40 @@ func (f *frontendCLI) deleteCache(c *ishell.Context) {
 	f.Stop()
 }
 
+func (f *frontendCLI) printSessions(c *ishell.Context) {
+	sessions := f.sessions.List()
+	if len(sessions) == 0 {
+		f.Println("No client is connected.")
+		return
+	}
+
+	now := time.Now()
+	spacing := "%-4s %-5s %-22s %-25s %-20s %-15s %-10s %-10s\n"
+	f.Printf(bold(spacing), "#", "proto", "address", "user", "client", "mailbox", "connected", "idle")
+	for _, session := range sessions {
+		f.Printf(spacing,
+			strconv.Itoa(session.ID),
+			session.Protocol,
+			session.RemoteAddress,
+			session.User,
+			session.Client,
+			session.Mailbox,
+			now.Sub(session.Started).Round(time.Second),
+			now.Sub(session.LastActivity).Round(time.Second),
+		)
+		for _, command := range session.Commands {
+			average := command.TotalDuration / time.Duration(command.Count)
+			f.Printf("     %-15s count %-6d avg %-12s max %s\n",
+				command.Name,
+				command.Count,
+				average.Round(time.Microsecond),
+				command.MaxDuration.Round(time.Microsecond),
+			)
+		}
+	}
+	f.Println()
+}
+
 func (f *frontendCLI) changeSMTPSecurity(c *ishell.Context) {
 	f.ShowPrompt(false)
 	defer f.ShowPrompt(true)
@@ -89,7 +124,61 @@ func (f *frontendCLI) changeSMTPSecurity(c *ishell.Context) {
 	}
 }
 
-func (f *frontendCLI) changePort(c *ishell.Context) {
+func (f *frontendCLI) changeIMAPSecurity(c *ishell.Context) {
+	f.ShowPrompt(false)
+	defer f.ShowPrompt(true)
+
+	isSSL := f.settings.GetBool(settings.IMAPSSLKey)
+	msg := fmt.Sprintf("Are you sure you want to enable IMAPS (SSL) on port %s next to STARTTLS and restart the Bridge", f.settings.Get(settings.IMAPSSLPortKey))
+	if isSSL {
+		msg = "Are you sure you want to disable IMAPS (SSL) and keep only STARTTLS and restart the Bridge"
+	}
+
+	if f.yesNoQuestion(msg) {
+		f.settings.SetBool(settings.IMAPSSLKey, !isSSL)
+		f.Println("Restarting Bridge...")
+		f.restarter.SetToRestart()
+		f.Stop()
+	}
+}
+
+func (f *frontendCLI) changeIMAPCompress(c *ishell.Context) {
+	f.ShowPrompt(false)
+	defer f.ShowPrompt(true)
+
+	isCompress := f.settings.GetBool(settings.IMAPCompressKey)
+	msg := "Are you sure you want to allow IMAP clients to use compression and restart the Bridge"
+	if isCompress {
+		msg = "Are you sure you want to disable IMAP compression and restart the Bridge"
+	}
+
+	if f.yesNoQuestion(msg) {
+		f.settings.SetBool(settings.IMAPCompressKey, !isCompress)
+		f.Println("Restarting Bridge...")
+		f.restarter.SetToRestart()
+		f.Stop()
+	}
+}
+
+func (f *frontendCLI) changeSieve(c *ishell.Context) {
+	f.ShowPrompt(false)
+	defer f.ShowPrompt(true)
+
+	isSieve := f.settings.GetBool(settings.SieveKey)
+	msg := fmt.Sprintf("Are you sure you want to enable ManageSieve on port %s and restart the Bridge", f.settings.Get(settings.SievePortKey))
+	if isSieve {
+		msg = "Are you sure you want to disable ManageSieve and restart the Bridge"
+	}
+
+	if f.yesNoQuestion(msg) {
+		f.settings.SetBool(settings.SieveKey, !isSieve)
+		f.Println("Restarting Bridge...")
+		f.restarter.SetToRestart()
+		f.Stop()
+	}
+}
+
+func (f *frontendCLI) changePort(c *ishell.Context) { //nolint[funlen]
 	f.ShowPrompt(false)
 	defer f.ShowPrompt(true)
 
@@ -100,6 +189,17 @@ func (f *frontendCLI) changePort(c *ishell.Context) {
 	}
 	imapPortChanged := newIMAPPort != currentPort
 
+	// IMAPS port is asked only when the listener is enabled.
+	currentPort = f.settings.Get(settings.IMAPSSLPortKey)
+	newIMAPSSLPort := currentPort
+	if f.settings.GetBool(settings.IMAPSSLKey) {
+		newIMAPSSLPort = f.readStringInAttempts("Set IMAPS port (current "+currentPort+")", c.ReadLine, f.isPortFree)
+		if newIMAPSSLPort == "" {
+			newIMAPSSLPort = currentPort
+		}
+	}
+	imapSSLPortChanged := newIMAPSSLPort != currentPort
+
 	currentPort = f.settings.Get(settings.SMTPPortKey)
 	newSMTPPort := f.readStringInAttempts("Set SMTP port (current "+currentPort+")", c.ReadLine, f.isPortFree)
 	if newSMTPPort == "" {
@@ -112,9 +212,15 @@ func (f *frontendCLI) changePort(c *ishell.Context) {
 		return
 	}
 
-	if imapPortChanged || smtpPortChanged {
-		f.Println("Saving values IMAP:", newIMAPPort, "SMTP:", newSMTPPort)
+	if f.settings.GetBool(settings.IMAPSSLKey) && (newIMAPSSLPort == newIMAPPort || newIMAPSSLPort == newSMTPPort) {
+		f.Println("IMAPS port must be different from IMAP and SMTP ports!")
+		return
+	}
+
+	if imapPortChanged || imapSSLPortChanged || smtpPortChanged {
+		f.Println("Saving values IMAP:", newIMAPPort, "IMAPS:", newIMAPSSLPort, "SMTP:", newSMTPPort)
 		f.settings.Set(settings.IMAPPortKey, newIMAPPort)
+		f.settings.Set(settings.IMAPSSLPortKey, newIMAPSSLPort)
 		f.settings.Set(settings.SMTPPortKey, newSMTPPort)
 		f.Println("Restarting Bridge...")
 		f.restarter.SetToRestart()
@@ -152,6 +258,78 @@ func (f *frontendCLI) disallowProxy(c *ishell.Context) {
 	}
 }
 
+func (f *frontendCLI) enableSearchIndex(c *ishell.Context) {
+	f.ShowPrompt(false)
+	defer f.ShowPrompt(true)
+
+	if f.settings.GetBool(settings.SearchIndexKey) {
+		f.Println("Bridge is already set to index message content for search.")
+		return
+	}
+
+	f.Println("Bridge will download and decrypt all messages to build a local encrypted search index.")
+
+	if f.yesNoQuestion("Are you sure you want to enable the search index and restart the Bridge") {
+		f.settings.SetBool(settings.SearchIndexKey, true)
+		f.Println("Restarting Bridge...")
+		f.restarter.SetToRestart()
+		f.Stop()
+	}
+}
+
+func (f *frontendCLI) disableSearchIndex(c *ishell.Context) {
+	f.ShowPrompt(false)
+	defer f.ShowPrompt(true)
+
+	if !f.settings.GetBool(settings.SearchIndexKey) {
+		f.Println("Bridge is already set to NOT index message content for search.")
+		return
+	}
+
+	if f.yesNoQuestion("Are you sure you want to disable the search index and restart the Bridge") {
+		f.settings.SetBool(settings.SearchIndexKey, false)
+		f.Println("Restarting Bridge...")
+		f.restarter.SetToRestart()
+		f.Stop()
+	}
+}
+
+func (f *frontendCLI) enableMessageCache(c *ishell.Context) {
+	f.ShowPrompt(false)
+	defer f.ShowPrompt(true)
+
+	if f.settings.GetBool(settings.MessageCacheKey) {
+		f.Println("Bridge is already set to cache message content.")
+		return
+	}
+
+	f.Printf("Bridge will keep up to %d MB of fetched messages in a local encrypted cache.\n", f.settings.GetInt(settings.MessageCacheSizeKey))
+
+	if f.yesNoQuestion("Are you sure you want to enable the message cache and restart the Bridge") {
+		f.settings.SetBool(settings.MessageCacheKey, true)
+		f.Println("Restarting Bridge...")
+		f.restarter.SetToRestart()
+		f.Stop()
+	}
+}
+
+func (f *frontendCLI) disableMessageCache(c *ishell.Context) {
+	f.ShowPrompt(false)
+	defer f.ShowPrompt(true)
+
+	if !f.settings.GetBool(settings.MessageCacheKey) {
+		f.Println("Bridge is already set to NOT cache message content.")
+		return
+	}
+
+	if f.yesNoQuestion("Are you sure you want to disable the message cache and restart the Bridge") {
+		f.settings.SetBool(settings.MessageCacheKey, false)
+		f.Println("Restarting Bridge...")
+		f.restarter.SetToRestart()
+		f.Stop()
+	}
+}
+
 func (f *frontendCLI) isPortFree(port string) bool {
 	port = strings.ReplaceAll(port, ":", "")
 	if port == "" || port == currentPort {
@@ -30,6 +30,7 @@ import (
 	"github.com/ProtonMail/proton-bridge/internal/frontend/types"
 	"github.com/ProtonMail/proton-bridge/internal/importexport"
 	"github.com/ProtonMail/proton-bridge/internal/locations"
+	"github.com/ProtonMail/proton-bridge/internal/serverutil"
 	"github.com/ProtonMail/proton-bridge/internal/updater"
 	"github.com/ProtonMail/proton-bridge/pkg/listener"
 	"github.com/sirupsen/logrus"
@@ -66,6 +67,7 @@ func New(
 	noEncConfirmator types.NoEncConfirmator,
 	autostart *autostart.App,
 	restarter types.Restarter,
+	sessions *serverutil.Sessions,
 ) Frontend {
 	bridgeWrap := types.NewBridgeWrap(bridge)
 	return newBridgeFrontend(
@@ -84,6 +86,7 @@ func New(
 		noEncConfirmator,
 		autostart,
 		restarter,
+		sessions,
 	)
 }
 
@@ -103,6 +106,7 @@ func newBridgeFrontend(
 	noEncConfirmator types.NoEncConfirmator,
 	autostart *autostart.App,
 	restarter types.Restarter,
+	sessions *serverutil.Sessions,
 ) Frontend {
 	switch frontendType {
 	case "cli":
@@ -114,6 +118,7 @@ func newBridgeFrontend(
 			updater,
 			bridge,
 			restarter,
+			sessions,
 		)
 	default:
 		return qt.New(
@@ -21,6 +21,7 @@ package types
 import (
 	"github.com/ProtonMail/proton-bridge/internal/bridge"
 	"github.com/ProtonMail/proton-bridge/internal/importexport"
+	"github.com/ProtonMail/proton-bridge/internal/store"
 	"github.com/ProtonMail/proton-bridge/internal/transfer"
 	"github.com/ProtonMail/proton-bridge/internal/updater"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
@@ -68,6 +69,16 @@ type User interface {
 	GetBridgePassword() string
 	SwitchAddressMode() error
 	Logout() error
+
+	ListSavedSearches() ([]store.SavedSearch, error)
+	AddSavedSearch(store.SavedSearch) error
+	RemoveSavedSearch(name string) error
+
+	GetAliasPolicy() (store.AliasPolicy, error)
+	SetAliasPolicy(store.AliasPolicy) error
+
+	GetAutocryptPreferEncrypt() (bool, error)
+	SetAutocryptPreferEncrypt(bool) error
 }
 
 // Bridger is an interface of bridge needed by frontend.
@@ -93,7 +93,7 @@ func newIMAPBackend(
 	bridge bridger,
 	eventListener listener.Listener,
 ) *imapBackend {
-	return &imapBackend{
+	backend := &imapBackend{
 		panicHandler:  panicHandler,
 		bridge:        bridge,
 		updates:       newIMAPUpdates(),
@@ -107,6 +107,20 @@ func newIMAPBackend(
 		imapCachePath: cache.GetIMAPCachePath(),
 		imapCacheLock: &sync.RWMutex{},
 	}
+
+	backend.updates.setOtherAddresses(backend.getOtherAddresses)
+
+	return backend
+}
+
+// getOtherAddresses returns addresses of the account which list mailboxes
+// of the address in their namespace of other addresses.
+func (ib *imapBackend) getOtherAddresses(address string) []string {
+	user, err := ib.bridge.GetUser(address)
+	if err != nil {
+		return nil
+	}
+	return getOtherAddresses(user, address)
 }
 
 func (ib *imapBackend) getUser(address string) (*imapUser, error) {
@@ -206,6 +220,12 @@ func (ib *imapBackend) Updates() <-chan goIMAPBackend.Update {
 	return ib.updates.ch
 }
 
+// SetUpdateListener sets the function which gets updates of all mailboxes,
+// not only of the selected ones as IDLE does.
+func (ib *imapBackend) SetUpdateListener(listener func(goIMAPBackend.Update)) {
+	ib.updates.setListener(listener)
+}
+
 func (ib *imapBackend) CreateMessageLimit() *uint32 {
 	return nil
 }
@@ -38,6 +38,7 @@ type bridgeUser interface {
 	IsCombinedAddressMode() bool
 	GetAddressID(address string) (string, error)
 	GetPrimaryAddress() string
+	GetStoreAddresses() []string
 	Logout() error
 	CloseConnection(address string)
 	GetStore() storeUserProvider
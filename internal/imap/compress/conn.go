@@ -0,0 +1,67 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package compress
+
+import (
+	"compress/flate"
+	"io"
+	"net"
+)
+
+// deflateConn compresses all data written to the connection and
+// decompresses all data read from it. Every write is flushed so the other
+// side receives whole responses.
+type deflateConn struct {
+	net.Conn
+
+	r io.ReadCloser
+	w *flate.Writer
+}
+
+// NewDeflateConn returns connection using DEFLATE compression. It is used
+// also by clients after the server accepted COMPRESS command.
+func NewDeflateConn(conn net.Conn) (net.Conn, error) {
+	w, err := flate.NewWriter(conn, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	return &deflateConn{
+		Conn: conn,
+		r:    flate.NewReader(conn),
+		w:    w,
+	}, nil
+}
+
+func (c *deflateConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *deflateConn) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, c.w.Flush()
+}
+
+func (c *deflateConn) Close() error {
+	_ = c.w.Close()
+	_ = c.r.Close()
+	return c.Conn.Close()
+}
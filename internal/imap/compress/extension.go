@@ -0,0 +1,148 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package compress implements COMPRESS extension (RFC4978) with DEFLATE
+// mechanism.
+package compress
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/server"
+)
+
+// Capability extension identifier.
+const Capability = "COMPRESS=DEFLATE"
+
+const (
+	compressCommand  = "COMPRESS"
+	deflateMechanism = "DEFLATE"
+
+	// codeCompressionActive is response code sent when the compression
+	// is already active.
+	codeCompressionActive = "COMPRESSIONACTIVE"
+)
+
+// Handler handles COMPRESS command.
+type Handler struct {
+	ext *extension
+}
+
+// Parse checks the only argument is the DEFLATE mechanism.
+func (h *Handler) Parse(fields []interface{}) error {
+	if len(fields) != 1 {
+		return errors.New("mechanism expected")
+	}
+
+	mechanism, ok := fields[0].(string)
+	if !ok || !strings.EqualFold(mechanism, deflateMechanism) {
+		return errors.New("unsupported compression mechanism")
+	}
+
+	return nil
+}
+
+// Handle the COMPRESS request. The connection is upgraded after the OK
+// response is sent.
+func (h *Handler) Handle(conn server.Conn) error {
+	if conn.Context().State&imap.AuthenticatedState == 0 {
+		return server.ErrNotAuthenticated
+	}
+
+	if h.ext.isActive(conn.Context()) {
+		return server.ErrStatusResp(&imap.StatusResp{
+			Type: imap.StatusRespNo,
+			Code: codeCompressionActive,
+			Info: "Compression is already active",
+		})
+	}
+	return nil
+}
+
+// Upgrade starts the compression.
+func (h *Handler) Upgrade(conn server.Conn) error {
+	err := conn.Upgrade(func(netConn net.Conn) (net.Conn, error) {
+		conn.WaitReady()
+		return NewDeflateConn(netConn)
+	})
+	if err != nil {
+		return err
+	}
+
+	h.ext.setActive(conn.Context())
+	return nil
+}
+
+type extension struct {
+	lock   sync.Mutex
+	active map[*server.Context]struct{}
+}
+
+// NewExtension returns new COMPRESS extension.
+func NewExtension() server.ConnExtension {
+	return &extension{active: map[*server.Context]struct{}{}}
+}
+
+func (ext *extension) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState == 0 || ext.isActive(c.Context()) {
+		return nil
+	}
+	return []string{Capability}
+}
+
+func (ext *extension) Command(name string) server.HandlerFactory {
+	if name != compressCommand {
+		return nil
+	}
+
+	return func() server.Handler {
+		return &Handler{ext: ext}
+	}
+}
+
+func (ext *extension) NewConn(conn server.Conn) server.Conn {
+	ctx := conn.Context()
+
+	go func() {
+		<-ctx.LoggedOut
+
+		ext.lock.Lock()
+		delete(ext.active, ctx)
+		ext.lock.Unlock()
+	}()
+
+	return conn
+}
+
+func (ext *extension) isActive(ctx *server.Context) bool {
+	ext.lock.Lock()
+	defer ext.lock.Unlock()
+
+	_, ok := ext.active[ctx]
+	return ok
+}
+
+func (ext *extension) setActive(ctx *server.Context) {
+	ext.lock.Lock()
+	defer ext.lock.Unlock()
+
+	ext.active[ctx] = struct{}{}
+}
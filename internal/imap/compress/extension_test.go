@@ -0,0 +1,55 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package compress
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	h := &Handler{}
+
+	require.NoError(t, h.Parse([]interface{}{"DEFLATE"}))
+	require.NoError(t, h.Parse([]interface{}{"deflate"}))
+	require.Error(t, h.Parse([]interface{}{}))
+	require.Error(t, h.Parse([]interface{}{"GZIP"}))
+	require.Error(t, h.Parse([]interface{}{"DEFLATE", "DEFLATE"}))
+}
+
+func TestDeflateConn(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close() //nolint[errcheck]
+	defer serverConn.Close() //nolint[errcheck]
+
+	client, err := NewDeflateConn(clientConn)
+	require.NoError(t, err)
+	server, err := NewDeflateConn(serverConn)
+	require.NoError(t, err)
+
+	go func() {
+		_, _ = client.Write([]byte("a1 NOOP\r\n"))
+	}()
+
+	line, err := bufio.NewReader(server).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "a1 NOOP\r\n", line)
+}
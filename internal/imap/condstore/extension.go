@@ -0,0 +1,288 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package condstore DOES NOT implement full RFC7162!
+//
+// Excluded parts are:
+// * Response code `NOMODSEQ`: All mailboxes of Bridge support persistent
+//   mod-sequences so it would never return this response
+// * Sequence match data of QRESYNC parameter: It is only an optimisation of
+//   the VANISHED response and the full list of vanished UIDs is sent instead
+// * MODSEQ search criterion inside of OR or NOT: Only the top-level MODSEQ
+//   criterion is supported
+//
+// Unsolicited responses of updates are sent by go-imap to all connections in
+// the same form. The extension rewrites them for every connection: FETCH
+// responses get MODSEQ when CONDSTORE is enabled and EXPUNGE responses are
+// replaced by VANISHED when QRESYNC is enabled. The data needed for that is
+// passed by MessageUpdate and ExpungeUpdate to Notify.
+//
+// Otherwise the standard RFC7162 is followed.
+package condstore
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/server"
+	"github.com/sirupsen/logrus"
+)
+
+// Capability extension identifiers.
+const (
+	Capability        = "CONDSTORE"
+	QResyncCapability = "QRESYNC"
+)
+
+// FetchModSeq is the fetch item of the mod-sequence of the message.
+const FetchModSeq imap.FetchItem = "MODSEQ"
+
+// StatusHighestModSeq is the status item of the highest mod-sequence of
+// the mailbox.
+const StatusHighestModSeq imap.StatusItem = "HIGHESTMODSEQ"
+
+const (
+	codeHighestModSeq = "HIGHESTMODSEQ"
+	codeModified      = "MODIFIED"
+	codeClosed        = "CLOSED"
+)
+
+var log = logrus.WithField("pkg", "imap/condstore") //nolint[gochecknoglobals]
+
+// Mailbox is a mailbox supporting mod-sequences.
+type Mailbox interface {
+	backend.Mailbox
+
+	// HighestModSeq returns the highest mod-sequence of the mailbox.
+	HighestModSeq() (uint64, error)
+
+	// ModSeqs returns mod-sequences of the messages in the sequence set
+	// keyed by UID or sequence number, depending on uid.
+	ModSeqs(uid bool, seqSet *imap.SeqSet) (map[uint32]uint64, error)
+
+	// VanishedUIDs returns UIDs of messages expunged after the mod-sequence.
+	// When the mod-sequence is too old, it may return all UIDs which are
+	// not in the mailbox, expunged before the mod-sequence or not.
+	VanishedUIDs(modSeq uint64) ([]uint32, error)
+
+	// LockModSeqs blocks changes of flags done by other STORE commands
+	// until the returned function is called, so the mod-sequences can be
+	// checked and changed atomically.
+	LockModSeqs() (unlock func())
+}
+
+// Extension of CONDSTORE and QRESYNC.
+type Extension interface {
+	server.Extension
+
+	// Notify gets all updates sent by the backend to go-imap.
+	Notify(update backend.Update)
+}
+
+// FormatModSeq returns the mod-sequence in the form used by IMAP responses.
+func FormatModSeq(modSeq uint64) imap.RawString {
+	return imap.RawString(strconv.FormatUint(modSeq, 10))
+}
+
+func parseModSeq(f interface{}) (uint64, error) {
+	s, err := imap.ParseString(f)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// connState holds which extensions were enabled by the client and
+// the data of updates of the selected mailbox.
+type connState struct {
+	lock      sync.Mutex
+	condStore bool
+	qResync   bool
+
+	username    string
+	mailboxName string
+	pending     []*pendingUpdate
+}
+
+func (s *connState) enableCondStore() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.condStore = true
+}
+
+func (s *connState) enableQResync() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// QRESYNC implies CONDSTORE.
+	s.condStore = true
+	s.qResync = true
+}
+
+func (s *connState) isCondStoreEnabled() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.condStore
+}
+
+func (s *connState) isQResyncEnabled() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.qResync
+}
+
+type extension struct {
+	lock   sync.Mutex
+	states map[*server.Context]*connState
+}
+
+// NewExtension of CONDSTORE and QRESYNC.
+func NewExtension() Extension {
+	return &extension{
+		states: make(map[*server.Context]*connState),
+	}
+}
+
+func (ext *extension) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState != 0 {
+		return []string{Capability, QResyncCapability}
+	}
+	return nil
+}
+
+func (ext *extension) Command(name string) server.HandlerFactory {
+	switch name {
+	case "SELECT":
+		return func() server.Handler { return &selectHandler{ext: ext} }
+	case "EXAMINE":
+		return func() server.Handler {
+			hdlr := &selectHandler{ext: ext}
+			hdlr.ReadOnly = true
+			return hdlr
+		}
+	case "FETCH":
+		return func() server.Handler { return &fetchHandler{ext: ext} }
+	case "STORE":
+		return func() server.Handler { return &storeHandler{ext: ext} }
+	case "SEARCH":
+		return func() server.Handler { return &searchHandler{ext: ext} }
+	}
+
+	return nil
+}
+
+// NewConn registers the state of the new connection which is dropped once
+// the client is logged out. Unsolicited responses of the connection are
+// passed through the state to be rewritten.
+func (ext *extension) NewConn(conn server.Conn) server.Conn {
+	ctx := conn.Context()
+	state := &connState{}
+
+	ext.lock.Lock()
+	ext.states[ctx] = state
+	ext.lock.Unlock()
+
+	// Responses of commands are written directly, only updates are sent
+	// to the channel of the context.
+	responses := make(chan imap.WriterTo)
+	upstream := ctx.Responses
+	ctx.Responses = responses
+
+	go func() {
+		defer func() {
+			ext.lock.Lock()
+			delete(ext.states, ctx)
+			ext.lock.Unlock()
+		}()
+
+		for {
+			select {
+			case res := <-responses:
+				select {
+				case upstream <- &updateResponse{res: res, state: state}:
+				case <-ctx.LoggedOut:
+					return
+				}
+			case <-ctx.LoggedOut:
+				return
+			}
+		}
+	}()
+
+	return conn
+}
+
+func (ext *extension) getState(conn server.Conn) *connState {
+	ext.lock.Lock()
+	defer ext.lock.Unlock()
+
+	state, ok := ext.states[conn.Context()]
+	if !ok {
+		// Should not happen, but it is better to not fail the command.
+		log.Warn("Missing state of connection")
+		state = &connState{}
+		ext.states[conn.Context()] = state
+	}
+	return state
+}
+
+// Enable enables CONDSTORE or QRESYNC for the connection.
+func (ext *extension) Enable(conn server.Conn, capability string) bool {
+	switch capability {
+	case Capability:
+		ext.getState(conn).enableCondStore()
+	case QResyncCapability:
+		ext.getState(conn).enableQResync()
+	default:
+		return false
+	}
+	return true
+}
+
+func writeVanishedEarlier(conn server.Conn, uids []uint32) error {
+	if len(uids) == 0 {
+		return nil
+	}
+
+	seqSet := &imap.SeqSet{}
+	seqSet.AddNum(uids...)
+
+	return conn.WriteResp(imap.NewUntaggedResp([]interface{}{
+		imap.RawString("VANISHED"),
+		[]interface{}{imap.RawString("EARLIER")},
+		seqSet,
+	}))
+}
+
+func getMailbox(conn server.Conn) (Mailbox, error) {
+	ctx := conn.Context()
+	if ctx.Mailbox == nil {
+		return nil, server.ErrNoMailboxSelected
+	}
+
+	mailbox, ok := ctx.Mailbox.(Mailbox)
+	if !ok {
+		return nil, errors.New("mod-sequences are not supported by mailbox")
+	}
+	return mailbox, nil
+}
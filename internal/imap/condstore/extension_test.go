@@ -0,0 +1,185 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package condstore
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSelectParams(t *testing.T) {
+	cmd := &selectHandler{}
+	require.NoError(t, cmd.Parse([]interface{}{"INBOX", []interface{}{"CONDSTORE"}}))
+	assert.Equal(t, "INBOX", cmd.Mailbox)
+	assert.True(t, cmd.condStore)
+	assert.Nil(t, cmd.qResync)
+
+	cmd = &selectHandler{}
+	require.NoError(t, cmd.Parse([]interface{}{"INBOX", []interface{}{
+		"QRESYNC", []interface{}{"67890007", "20050715194045000", "41,43:211,214:541", []interface{}{"1:10", "1:10"}},
+	}}))
+	require.NotNil(t, cmd.qResync)
+	assert.Equal(t, uint32(67890007), cmd.qResync.uidValidity)
+	assert.Equal(t, uint64(20050715194045000), cmd.qResync.modSeq)
+	assert.Equal(t, "41,43:211,214:541", cmd.qResync.knownUIDs.String())
+
+	cmd = &selectHandler{}
+	assert.Error(t, cmd.Parse([]interface{}{"INBOX", []interface{}{"QRESYNC"}}))
+	assert.Error(t, cmd.Parse([]interface{}{"INBOX", []interface{}{"UNKNOWN"}}))
+}
+
+func TestParseFetchModifiers(t *testing.T) {
+	cmd := &fetchHandler{}
+	require.NoError(t, cmd.Parse([]interface{}{"1:*", []interface{}{"FLAGS"}, []interface{}{"CHANGEDSINCE", "12345", "VANISHED"}}))
+	assert.True(t, cmd.hasChangedSince)
+	assert.Equal(t, uint64(12345), cmd.changedSince)
+	assert.True(t, cmd.vanished)
+
+	cmd = &fetchHandler{}
+	assert.Error(t, cmd.Parse([]interface{}{"1:*", "FLAGS", []interface{}{"CHANGEDSINCE"}}))
+}
+
+func TestParseStoreModifiers(t *testing.T) {
+	cmd := &storeHandler{}
+	require.NoError(t, cmd.Parse([]interface{}{"1:3", []interface{}{"UNCHANGEDSINCE", "320162338"}, "+FLAGS.SILENT", []interface{}{`\Deleted`}}))
+	assert.True(t, cmd.hasUnchangedSince)
+	assert.Equal(t, uint64(320162338), cmd.unchangedSince)
+	assert.Equal(t, imap.StoreItem("+FLAGS.SILENT"), cmd.Item)
+	assert.Equal(t, "1:3", cmd.SeqSet.String())
+
+	cmd = &storeHandler{}
+	require.NoError(t, cmd.Parse([]interface{}{"1", "FLAGS", []interface{}{`\Seen`}}))
+	assert.False(t, cmd.hasUnchangedSince)
+}
+
+func TestParseSearchModSeq(t *testing.T) {
+	cmd := &searchHandler{}
+	require.NoError(t, cmd.Parse([]interface{}{"UNSEEN", "MODSEQ", "620162338"}))
+	assert.True(t, cmd.hasModSeq)
+	assert.Equal(t, uint64(620162338), cmd.modSeq)
+	assert.Equal(t, []string{`\Seen`}, cmd.Criteria.WithoutFlags)
+
+	cmd = &searchHandler{}
+	require.NoError(t, cmd.Parse([]interface{}{"MODSEQ", `/flags/\draft`, "all", "620162338"}))
+	assert.True(t, cmd.hasModSeq)
+	assert.Equal(t, uint64(620162338), cmd.modSeq)
+
+	cmd = &searchHandler{}
+	require.NoError(t, cmd.Parse([]interface{}{"UNSEEN"}))
+	assert.False(t, cmd.hasModSeq)
+
+	// MODSEQ as an argument of other criteria is not the MODSEQ criterion.
+	cmd = &searchHandler{}
+	require.NoError(t, cmd.Parse([]interface{}{"SUBJECT", "modseq"}))
+	assert.False(t, cmd.hasModSeq)
+	assert.Equal(t, []string{"modseq"}, cmd.Criteria.Header["Subject"])
+
+	cmd = &searchHandler{}
+	require.NoError(t, cmd.Parse([]interface{}{"CHARSET", "UTF-8", "OR", "HEADER", "X-Tag", "MODSEQ", "TEXT", "modseq", "MODSEQ", "5"}))
+	assert.True(t, cmd.hasModSeq)
+	assert.Equal(t, uint64(5), cmd.modSeq)
+	require.Len(t, cmd.Criteria.Or, 1)
+	assert.Equal(t, []string{"MODSEQ"}, cmd.Criteria.Or[0][0].Header["X-Tag"])
+	assert.Equal(t, []string{"modseq"}, cmd.Criteria.Or[0][1].Text)
+}
+
+type testMailbox struct {
+	Mailbox
+
+	modSeqs map[uint32]uint64
+}
+
+func (m *testMailbox) ModSeqs(uid bool, seqSet *imap.SeqSet) (map[uint32]uint64, error) {
+	modSeqs := map[uint32]uint64{}
+	for id, modSeq := range m.modSeqs {
+		if seqSet.Contains(id) {
+			modSeqs[id] = modSeq
+		}
+	}
+	return modSeqs, nil
+}
+
+func TestGetChanged(t *testing.T) {
+	mailbox := &testMailbox{modSeqs: map[uint32]uint64{1: 5, 2: 10, 3: 7, 4: 12}}
+
+	seqSet, _ := imap.ParseSeqSet("1:3")
+	changed, err := getChanged(mailbox, true, seqSet, 6)
+	require.NoError(t, err)
+	assert.Equal(t, "2:3", changed.String())
+
+	seqSet, _ = imap.ParseSeqSet("1:*")
+	changed, err = getChanged(mailbox, true, seqSet, 12)
+	require.NoError(t, err)
+	assert.True(t, changed.Empty())
+}
+
+func TestRewriteResponse(t *testing.T) {
+	state := &connState{}
+	state.enableQResync()
+	state.setSelected("user", "INBOX")
+
+	state.addPending("user", "INBOX", &pendingUpdate{uid: 12, modSeq: 100})
+	state.addPending("user", "INBOX", &pendingUpdate{isExpunge: true, seqNum: 3, uid: 14})
+	state.addPending("user", "Archive", &pendingUpdate{uid: 13, modSeq: 101})
+	state.addPending("other", "INBOX", &pendingUpdate{uid: 13, modSeq: 102})
+
+	rewrite := func(line string) string {
+		return string(state.rewriteResponse([]byte(line)))
+	}
+
+	assert.Equal(t, "* 2 FETCH (FLAGS (\\Seen) UID 13)\r\n", rewrite("* 2 FETCH (FLAGS (\\Seen) UID 13)\r\n"))
+	assert.Equal(t, "* 1 FETCH (FLAGS (\\Seen) UID 12 MODSEQ (100))\r\n", rewrite("* 1 FETCH (FLAGS (\\Seen) UID 12)\r\n"))
+	assert.Equal(t, "* 1 FETCH (FLAGS (\\Seen) UID 12)\r\n", rewrite("* 1 FETCH (FLAGS (\\Seen) UID 12)\r\n"))
+	assert.Equal(t, "* 2 EXPUNGE\r\n", rewrite("* 2 EXPUNGE\r\n"))
+	assert.Equal(t, "* VANISHED 14\r\n", rewrite("* 3 EXPUNGE\r\n"))
+	assert.Equal(t, "* 3 EXISTS\r\n", rewrite("* 3 EXISTS\r\n"))
+	assert.Empty(t, state.pending)
+}
+
+func TestRewriteResponseWithoutExtensions(t *testing.T) {
+	state := &connState{}
+	state.setSelected("user", "INBOX")
+
+	state.addPending("user", "INBOX", &pendingUpdate{uid: 12, modSeq: 100})
+	state.addPending("user", "INBOX", &pendingUpdate{isExpunge: true, seqNum: 3, uid: 14})
+	assert.Empty(t, state.pending)
+
+	state.enableCondStore()
+	state.addPending("user", "INBOX", &pendingUpdate{isExpunge: true, seqNum: 3, uid: 14})
+	assert.Empty(t, state.pending)
+
+	state.addPending("user", "INBOX", &pendingUpdate{uid: 12, modSeq: 100})
+	state.setSelected("user", "Archive")
+	assert.Empty(t, state.pending)
+}
+
+func TestUpdateWriter(t *testing.T) {
+	state := &connState{}
+	state.enableQResync()
+	state.setSelected("user", "INBOX")
+	state.addPending("user", "INBOX", &pendingUpdate{isExpunge: true, seqNum: 3, uid: 14})
+
+	b := &bytes.Buffer{}
+	res := &updateResponse{res: imap.NewUntaggedResp([]interface{}{uint32(3), imap.RawString("EXPUNGE")}), state: state}
+	require.NoError(t, res.WriteTo(imap.NewWriter(b)))
+	assert.Equal(t, "* VANISHED 14\r\n", b.String())
+}
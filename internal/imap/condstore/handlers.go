@@ -0,0 +1,642 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package condstore
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/server"
+)
+
+// qResyncParams are the parameters of QRESYNC select parameter.
+type qResyncParams struct {
+	uidValidity uint32
+	modSeq      uint64
+	knownUIDs   *imap.SeqSet
+}
+
+// selectHandler handles SELECT and EXAMINE with CONDSTORE and QRESYNC
+// parameters and adds HIGHESTMODSEQ to every successful response.
+type selectHandler struct {
+	server.Select
+	ext *extension
+
+	condStore bool
+	qResync   *qResyncParams
+}
+
+func (cmd *selectHandler) Parse(fields []interface{}) error {
+	if err := cmd.Select.Parse(fields); err != nil {
+		return err
+	}
+
+	if len(fields) < 2 {
+		return nil
+	}
+
+	params, ok := fields[1].([]interface{})
+	if !ok {
+		return errors.New("select parameters must be a list")
+	}
+
+	for i := 0; i < len(params); i++ {
+		name, err := imap.ParseString(params[i])
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToUpper(name) {
+		case Capability:
+			cmd.condStore = true
+		case QResyncCapability:
+			i++
+			if i >= len(params) {
+				return errors.New("missing QRESYNC parameters")
+			}
+			if cmd.qResync, err = parseQResyncParams(params[i]); err != nil {
+				return err
+			}
+		default:
+			return errors.New("unknown select parameter")
+		}
+	}
+
+	return nil
+}
+
+func parseQResyncParams(f interface{}) (*qResyncParams, error) {
+	fields, ok := f.([]interface{})
+	if !ok || len(fields) < 2 {
+		return nil, errors.New("QRESYNC parameters must be a list of UIDVALIDITY and mod-sequence")
+	}
+
+	params := &qResyncParams{}
+
+	var err error
+	if params.uidValidity, err = imap.ParseNumber(fields[0]); err != nil {
+		return nil, err
+	}
+	if params.modSeq, err = parseModSeq(fields[1]); err != nil {
+		return nil, err
+	}
+
+	// Sequence match data (the optional list after known UIDs) is ignored.
+	if len(fields) > 2 {
+		if knownUIDs, ok := fields[2].(string); ok {
+			if params.knownUIDs, err = imap.ParseSeqSet(knownUIDs); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return params, nil
+}
+
+func (cmd *selectHandler) Handle(conn server.Conn) error {
+	state := cmd.ext.getState(conn)
+
+	if cmd.qResync != nil && !state.isQResyncEnabled() {
+		return errors.New("QRESYNC is not enabled")
+	}
+	if cmd.condStore {
+		state.enableCondStore()
+	}
+
+	if state.isQResyncEnabled() && conn.Context().Mailbox != nil {
+		if err := conn.WriteResp(&imap.StatusResp{
+			Type: imap.StatusRespOk,
+			Code: codeClosed,
+			Info: "Previous mailbox closed",
+		}); err != nil {
+			return err
+		}
+	}
+
+	err := cmd.Select.Handle(conn)
+	if statusErr, ok := err.(*imap.ErrStatusResp); !ok || statusErr.Resp == nil || statusErr.Resp.Type != imap.StatusRespOk {
+		state.setSelected("", "")
+		return err
+	}
+
+	ctx := conn.Context()
+	state.setSelected(ctx.User.Username(), ctx.Mailbox.Name())
+
+	mailbox, ok := ctx.Mailbox.(Mailbox)
+	if !ok {
+		return err
+	}
+
+	highestModSeq, modSeqErr := mailbox.HighestModSeq()
+	if modSeqErr != nil {
+		return modSeqErr
+	}
+
+	if writeErr := conn.WriteResp(&imap.StatusResp{
+		Type:      imap.StatusRespOk,
+		Code:      codeHighestModSeq,
+		Arguments: []interface{}{FormatModSeq(highestModSeq)},
+		Info:      "Highest",
+	}); writeErr != nil {
+		return writeErr
+	}
+
+	if cmd.qResync != nil {
+		if resyncErr := cmd.resync(conn, mailbox); resyncErr != nil {
+			return resyncErr
+		}
+	}
+
+	return err
+}
+
+// resync sends expunges and changes since the mod-sequence known by the
+// client. Nothing is sent if the UIDVALIDITY was changed in the meantime.
+func (cmd *selectHandler) resync(conn server.Conn, mailbox Mailbox) error {
+	status, err := mailbox.Status([]imap.StatusItem{imap.StatusUidValidity})
+	if err != nil {
+		return err
+	}
+	if status.UidValidity != cmd.qResync.uidValidity {
+		return nil
+	}
+
+	vanishedUIDs, err := mailbox.VanishedUIDs(cmd.qResync.modSeq)
+	if err != nil {
+		return err
+	}
+
+	if cmd.qResync.knownUIDs != nil {
+		knownUIDs := []uint32{}
+		for _, uid := range vanishedUIDs {
+			if cmd.qResync.knownUIDs.Contains(uid) {
+				knownUIDs = append(knownUIDs, uid)
+			}
+		}
+		vanishedUIDs = knownUIDs
+	}
+
+	if err := writeVanishedEarlier(conn, vanishedUIDs); err != nil {
+		return err
+	}
+
+	allUIDs := &imap.SeqSet{}
+	allUIDs.AddRange(1, 0)
+
+	changedUIDs, err := getChanged(mailbox, true, allUIDs, cmd.qResync.modSeq)
+	if err != nil || changedUIDs.Empty() {
+		return err
+	}
+
+	fetch := &server.Fetch{}
+	fetch.SeqSet = changedUIDs
+	fetch.Items = []imap.FetchItem{imap.FetchUid, imap.FetchFlags, FetchModSeq}
+	return fetch.UidHandle(conn)
+}
+
+// getChanged returns messages from seqSet with mod-sequence higher than modSeq.
+func getChanged(mailbox Mailbox, uid bool, seqSet *imap.SeqSet, modSeq uint64) (*imap.SeqSet, error) {
+	modSeqs, err := mailbox.ModSeqs(uid, seqSet)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := &imap.SeqSet{}
+	for id, messageModSeq := range modSeqs {
+		if messageModSeq > modSeq {
+			changed.AddNum(id)
+		}
+	}
+	return changed, nil
+}
+
+// fetchHandler handles FETCH with CHANGEDSINCE and VANISHED modifiers
+// and MODSEQ item.
+type fetchHandler struct {
+	server.Fetch
+	ext *extension
+
+	changedSince    uint64
+	hasChangedSince bool
+	vanished        bool
+}
+
+func (cmd *fetchHandler) Parse(fields []interface{}) error {
+	if err := cmd.Fetch.Parse(fields); err != nil {
+		return err
+	}
+
+	if len(fields) < 3 {
+		return nil
+	}
+
+	modifiers, ok := fields[2].([]interface{})
+	if !ok {
+		return errors.New("fetch modifiers must be a list")
+	}
+
+	for i := 0; i < len(modifiers); i++ {
+		name, err := imap.ParseString(modifiers[i])
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToUpper(name) {
+		case "CHANGEDSINCE":
+			i++
+			if i >= len(modifiers) {
+				return errors.New("missing CHANGEDSINCE mod-sequence")
+			}
+			if cmd.changedSince, err = parseModSeq(modifiers[i]); err != nil {
+				return err
+			}
+			cmd.hasChangedSince = true
+		case "VANISHED":
+			cmd.vanished = true
+		default:
+			return errors.New("unknown fetch modifier")
+		}
+	}
+
+	return nil
+}
+
+func (cmd *fetchHandler) Handle(conn server.Conn) error {
+	return cmd.handle(false, conn)
+}
+
+func (cmd *fetchHandler) UidHandle(conn server.Conn) error { //nolint[golint]
+	return cmd.handle(true, conn)
+}
+
+func (cmd *fetchHandler) handle(uid bool, conn server.Conn) error {
+	state := cmd.ext.getState(conn)
+
+	if cmd.vanished {
+		if !uid {
+			return errors.New("VANISHED modifier is allowed only with UID FETCH")
+		}
+		if !state.isQResyncEnabled() {
+			return errors.New("QRESYNC is not enabled")
+		}
+		if !cmd.hasChangedSince {
+			return errors.New("VANISHED modifier requires CHANGEDSINCE")
+		}
+	}
+
+	if cmd.hasChangedSince || cmd.hasItem(FetchModSeq) {
+		state.enableCondStore()
+	}
+
+	if cmd.hasChangedSince || (state.isCondStoreEnabled() && cmd.hasItem(imap.FetchFlags)) {
+		cmd.addItem(FetchModSeq)
+	}
+
+	if cmd.hasChangedSince {
+		if err := cmd.filterChanged(uid, conn); err != nil {
+			return err
+		}
+		if cmd.SeqSet.Empty() {
+			return nil
+		}
+	}
+
+	if uid {
+		return cmd.Fetch.UidHandle(conn)
+	}
+	return cmd.Fetch.Handle(conn)
+}
+
+func (cmd *fetchHandler) filterChanged(uid bool, conn server.Conn) error {
+	mailbox, err := getMailbox(conn)
+	if err != nil {
+		return err
+	}
+
+	if cmd.vanished {
+		vanishedUIDs, err := mailbox.VanishedUIDs(cmd.changedSince)
+		if err != nil {
+			return err
+		}
+
+		requestedUIDs := []uint32{}
+		for _, vanishedUID := range vanishedUIDs {
+			if cmd.SeqSet.Contains(vanishedUID) {
+				requestedUIDs = append(requestedUIDs, vanishedUID)
+			}
+		}
+
+		if err := writeVanishedEarlier(conn, requestedUIDs); err != nil {
+			return err
+		}
+	}
+
+	cmd.SeqSet, err = getChanged(mailbox, uid, cmd.SeqSet, cmd.changedSince)
+	return err
+}
+
+func (cmd *fetchHandler) hasItem(item imap.FetchItem) bool {
+	for _, requested := range cmd.Items {
+		if requested == item {
+			return true
+		}
+	}
+	return false
+}
+
+func (cmd *fetchHandler) addItem(item imap.FetchItem) {
+	if !cmd.hasItem(item) {
+		cmd.Items = append(cmd.Items, item)
+	}
+}
+
+// storeHandler handles STORE with UNCHANGEDSINCE modifier.
+type storeHandler struct {
+	server.Store
+	ext *extension
+
+	unchangedSince    uint64
+	hasUnchangedSince bool
+}
+
+func (cmd *storeHandler) Parse(fields []interface{}) error {
+	if len(fields) > 1 {
+		if modifiers, ok := fields[1].([]interface{}); ok {
+			if err := cmd.parseModifiers(modifiers); err != nil {
+				return err
+			}
+			fields = append([]interface{}{fields[0]}, fields[2:]...)
+		}
+	}
+
+	return cmd.Store.Parse(fields)
+}
+
+func (cmd *storeHandler) parseModifiers(modifiers []interface{}) error {
+	for i := 0; i < len(modifiers); i++ {
+		name, err := imap.ParseString(modifiers[i])
+		if err != nil {
+			return err
+		}
+
+		if !strings.EqualFold(name, "UNCHANGEDSINCE") {
+			return errors.New("unknown store modifier")
+		}
+
+		i++
+		if i >= len(modifiers) {
+			return errors.New("missing UNCHANGEDSINCE mod-sequence")
+		}
+		if cmd.unchangedSince, err = parseModSeq(modifiers[i]); err != nil {
+			return err
+		}
+		cmd.hasUnchangedSince = true
+	}
+
+	return nil
+}
+
+func (cmd *storeHandler) Handle(conn server.Conn) error {
+	return cmd.handle(false, conn)
+}
+
+func (cmd *storeHandler) UidHandle(conn server.Conn) error { //nolint[golint]
+	return cmd.handle(true, conn)
+}
+
+func (cmd *storeHandler) handle(uid bool, conn server.Conn) error {
+	if !cmd.hasUnchangedSince {
+		if mailbox, ok := conn.Context().Mailbox.(Mailbox); ok {
+			defer mailbox.LockModSeqs()()
+		}
+		return cmd.store(uid, conn)
+	}
+
+	cmd.ext.getState(conn).enableCondStore()
+
+	mailbox, err := getMailbox(conn)
+	if err != nil {
+		return err
+	}
+
+	// No other STORE can change the flags between the check of
+	// mod-sequences and the change itself.
+	defer mailbox.LockModSeqs()()
+
+	modSeqs, err := mailbox.ModSeqs(uid, cmd.SeqSet)
+	if err != nil {
+		return err
+	}
+
+	unchanged := &imap.SeqSet{}
+	modified := &imap.SeqSet{}
+	for id, modSeq := range modSeqs {
+		if modSeq > cmd.unchangedSince {
+			modified.AddNum(id)
+		} else {
+			unchanged.AddNum(id)
+		}
+	}
+
+	if !unchanged.Empty() {
+		cmd.SeqSet = unchanged
+		if err := cmd.store(uid, conn); err != nil {
+			return err
+		}
+	}
+
+	if modified.Empty() {
+		return nil
+	}
+
+	return &imap.ErrStatusResp{Resp: &imap.StatusResp{
+		Type:      imap.StatusRespOk,
+		Code:      codeModified,
+		Arguments: []interface{}{modified},
+		Info:      "Conditional STORE failed",
+	}}
+}
+
+func (cmd *storeHandler) store(uid bool, conn server.Conn) error {
+	if uid {
+		return cmd.Store.UidHandle(conn)
+	}
+	return cmd.Store.Handle(conn)
+}
+
+// searchHandler handles SEARCH with MODSEQ criterion.
+type searchHandler struct {
+	server.Search
+	ext *extension
+
+	modSeq    uint64
+	hasModSeq bool
+}
+
+func (cmd *searchHandler) Parse(fields []interface{}) error {
+	rest := []interface{}{}
+
+	// The MODSEQ criterion is recognised only at positions of top-level
+	// criteria, not as an argument of another key or inside NOT or OR.
+	for i := 0; i < len(fields); {
+		if name, ok := fields[i].(string); !ok || !strings.EqualFold(name, "MODSEQ") {
+			n := getCriterionLength(fields[i:], i == 0)
+			rest = append(rest, fields[i:i+n]...)
+			i += n
+			continue
+		}
+
+		// Entry name and type are optional and ignored as there are no
+		// mod-sequences of individual metadata items.
+		if i+1 < len(fields) && !isModSeq(fields[i+1]) {
+			i += 2
+		}
+
+		i++
+		if i >= len(fields) {
+			return errors.New("missing MODSEQ mod-sequence")
+		}
+
+		var err error
+		if cmd.modSeq, err = parseModSeq(fields[i]); err != nil {
+			return err
+		}
+		cmd.hasModSeq = true
+		i++
+	}
+
+	if len(rest) == 0 && cmd.hasModSeq {
+		rest = append(rest, "ALL")
+	}
+
+	return cmd.Search.Parse(rest)
+}
+
+// getCriterionLength returns the number of fields of the search criterion
+// at the beginning of fields, together with its arguments. CHARSET is
+// accepted only as the first one. Malformed criteria are left to go-imap.
+func getCriterionLength(fields []interface{}, first bool) int {
+	name, ok := fields[0].(string)
+	if !ok {
+		return 1
+	}
+
+	n := 1
+	switch strings.ToUpper(name) {
+	case "CHARSET":
+		if first {
+			n = 2
+		}
+	case "BCC", "BEFORE", "BODY", "CC", "FROM", "KEYWORD", "LARGER", "ON",
+		"SENTBEFORE", "SENTON", "SENTSINCE", "SINCE", "SMALLER", "SUBJECT",
+		"TEXT", "TO", "UID", "UNKEYWORD":
+		n = 2
+	case "HEADER":
+		n = 3
+	case "NOT":
+		if len(fields) > 1 {
+			n += getCriterionLength(fields[1:], false)
+		}
+	case "OR":
+		for i := 0; i < 2 && n < len(fields); i++ {
+			n += getCriterionLength(fields[n:], false)
+		}
+	case "MODSEQ":
+		n = 2
+		if len(fields) > 1 && !isModSeq(fields[1]) {
+			n = 4
+		}
+	}
+
+	if n > len(fields) {
+		return len(fields)
+	}
+	return n
+}
+
+func isModSeq(f interface{}) bool {
+	_, err := parseModSeq(f)
+	return err == nil
+}
+
+func (cmd *searchHandler) Handle(conn server.Conn) error {
+	return cmd.handle(false, conn)
+}
+
+func (cmd *searchHandler) UidHandle(conn server.Conn) error { //nolint[golint]
+	return cmd.handle(true, conn)
+}
+
+func (cmd *searchHandler) handle(uid bool, conn server.Conn) error {
+	if !cmd.hasModSeq {
+		if uid {
+			return cmd.Search.UidHandle(conn)
+		}
+		return cmd.Search.Handle(conn)
+	}
+
+	cmd.ext.getState(conn).enableCondStore()
+
+	mailbox, err := getMailbox(conn)
+	if err != nil {
+		return err
+	}
+
+	ids, err := mailbox.SearchMessages(uid, cmd.Criteria)
+	if err != nil {
+		return err
+	}
+
+	fields := []interface{}{imap.RawString("SEARCH")}
+
+	if len(ids) != 0 {
+		seqSet := &imap.SeqSet{}
+		seqSet.AddNum(ids...)
+
+		modSeqs, err := mailbox.ModSeqs(uid, seqSet)
+		if err != nil {
+			return err
+		}
+
+		matched := []uint32{}
+		highestModSeq := uint64(0)
+		for _, id := range ids {
+			modSeq, ok := modSeqs[id]
+			if !ok || modSeq < cmd.modSeq {
+				continue
+			}
+			matched = append(matched, id)
+			if modSeq > highestModSeq {
+				highestModSeq = modSeq
+			}
+		}
+
+		sort.Slice(matched, func(i, j int) bool { return matched[i] < matched[j] })
+		for _, id := range matched {
+			fields = append(fields, id)
+		}
+
+		if len(matched) != 0 {
+			fields = append(fields, []interface{}{imap.RawString("MODSEQ"), FormatModSeq(highestModSeq)})
+		}
+	}
+
+	return conn.WriteResp(imap.NewUntaggedResp(fields))
+}
@@ -0,0 +1,241 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package condstore
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+)
+
+// maxPendingUpdates is the maximum number of updates waiting for their
+// response per connection. Updates can be dropped by go-imap (for example,
+// when STORE is silent) and their data would never be used.
+const maxPendingUpdates = 1000
+
+//nolint[gochecknoglobals]
+var (
+	fetchRespRegexp   = regexp.MustCompile(`^\* \d+ FETCH \((.*)\)\r\n$`)
+	fetchUIDRegexp    = regexp.MustCompile(`(?:^| )UID (\d+)(?: |$)`)
+	fetchModSeqRegexp = regexp.MustCompile(`(?:^| )MODSEQ \(`)
+	expungeRespRegexp = regexp.MustCompile(`^\* (\d+) EXPUNGE\r\n$`)
+)
+
+// MessageUpdate is a message update with the mod-sequence of the message.
+// go-imap sends the embedded update to all connections; connections which
+// enabled CONDSTORE get the MODSEQ item in the FETCH response as well.
+type MessageUpdate struct {
+	*backend.MessageUpdate
+
+	ModSeq uint64
+}
+
+// ExpungeUpdate is an expunge update with the UID of the expunged message.
+// go-imap sends the embedded update to all connections; connections which
+// enabled QRESYNC get the VANISHED response instead of EXPUNGE.
+type ExpungeUpdate struct {
+	*backend.ExpungeUpdate
+
+	UID uint32
+}
+
+// UnwrapUpdate returns the update which can be sent by go-imap.
+func UnwrapUpdate(update backend.Update) backend.Update {
+	switch update := update.(type) {
+	case *MessageUpdate:
+		return update.MessageUpdate
+	case *ExpungeUpdate:
+		return update.ExpungeUpdate
+	}
+	return update
+}
+
+// pendingUpdate is the data of an update which is not known by go-imap.
+// It waits until the response of the update is written to the connection.
+type pendingUpdate struct {
+	isExpunge bool
+	seqNum    uint32
+	uid       uint32
+	modSeq    uint64
+}
+
+// Notify passes the data of updates not known by go-imap to connections
+// which have the updated mailbox selected.
+func (ext *extension) Notify(update backend.Update) {
+	var pending *pendingUpdate
+
+	switch update := update.(type) {
+	case *MessageUpdate:
+		pending = &pendingUpdate{uid: update.Message.Uid, modSeq: update.ModSeq}
+	case *ExpungeUpdate:
+		pending = &pendingUpdate{isExpunge: true, seqNum: update.SeqNum, uid: update.UID}
+	default:
+		return
+	}
+
+	ext.lock.Lock()
+	defer ext.lock.Unlock()
+
+	for _, state := range ext.states {
+		state.addPending(update.Username(), update.Mailbox(), pending)
+	}
+}
+
+// setSelected sets the mailbox selected by the connection. The name and
+// username are the ones used by go-imap to match updates with connections.
+// Updates of the previously selected mailbox are dropped.
+func (s *connState) setSelected(username, mailboxName string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.username = username
+	s.mailboxName = mailboxName
+	s.pending = nil
+}
+
+func (s *connState) addPending(username, mailboxName string, pending *pendingUpdate) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.mailboxName == "" || s.username != username || s.mailboxName != mailboxName {
+		return
+	}
+	if (pending.isExpunge && !s.qResync) || (!pending.isExpunge && !s.condStore) {
+		return
+	}
+
+	if len(s.pending) >= maxPendingUpdates {
+		s.pending = s.pending[1:]
+	}
+	s.pending = append(s.pending, pending)
+}
+
+// popPending removes and returns the first pending update matching
+// the function.
+func (s *connState) popPending(isMatch func(*pendingUpdate) bool) *pendingUpdate {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, pending := range s.pending {
+		if isMatch(pending) {
+			s.pending = append(s.pending[:i], s.pending[i+1:]...)
+			return pending
+		}
+	}
+	return nil
+}
+
+// rewriteResponse adds MODSEQ to FETCH responses for connections with
+// CONDSTORE enabled and replaces EXPUNGE responses by VANISHED responses
+// for connections with QRESYNC enabled. Responses without pending data
+// are kept as they are.
+func (s *connState) rewriteResponse(line []byte) []byte {
+	if match := fetchRespRegexp.FindSubmatch(line); match != nil {
+		uidMatch := fetchUIDRegexp.FindSubmatch(match[1])
+		if uidMatch == nil || fetchModSeqRegexp.Match(match[1]) {
+			return line
+		}
+
+		uid, err := strconv.ParseUint(string(uidMatch[1]), 10, 32)
+		if err != nil {
+			return line
+		}
+
+		pending := s.popPending(func(pending *pendingUpdate) bool {
+			return !pending.isExpunge && pending.uid == uint32(uid)
+		})
+		if pending == nil {
+			return line
+		}
+
+		end := len(line) - len(")\r\n")
+		return []byte(fmt.Sprintf("%s MODSEQ (%d))\r\n", line[:end], pending.modSeq))
+	}
+
+	if match := expungeRespRegexp.FindSubmatch(line); match != nil {
+		seqNum, err := strconv.ParseUint(string(match[1]), 10, 32)
+		if err != nil {
+			return line
+		}
+
+		pending := s.popPending(func(pending *pendingUpdate) bool {
+			return pending.isExpunge && pending.seqNum == uint32(seqNum)
+		})
+		if pending == nil {
+			return line
+		}
+
+		return []byte(fmt.Sprintf("* VANISHED %d\r\n", pending.uid))
+	}
+
+	return line
+}
+
+// updateResponse is an unsolicited response of an update which is
+// rewritten according to the extensions enabled by the connection.
+type updateResponse struct {
+	res   imap.WriterTo
+	state *connState
+}
+
+func (r *updateResponse) WriteTo(w *imap.Writer) error {
+	uw := &updateWriter{w: w, state: r.state}
+	if err := r.res.WriteTo(imap.NewWriter(uw)); err != nil {
+		return err
+	}
+	return uw.flush()
+}
+
+// updateWriter passes every complete line of the response to the state
+// to be rewritten.
+type updateWriter struct {
+	w     *imap.Writer
+	state *connState
+	buf   []byte
+}
+
+func (uw *updateWriter) Write(b []byte) (int, error) {
+	uw.buf = append(uw.buf, b...)
+
+	for {
+		i := bytes.Index(uw.buf, []byte("\r\n"))
+		if i < 0 {
+			return len(b), nil
+		}
+
+		line := uw.buf[:i+2]
+		uw.buf = uw.buf[i+2:]
+
+		if _, err := uw.w.Write(uw.state.rewriteResponse(line)); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (uw *updateWriter) flush() error {
+	if len(uw.buf) == 0 {
+		return nil
+	}
+	_, err := uw.w.Write(uw.buf)
+	uw.buf = nil
+	return err
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package enable implements ENABLE command (RFC5161).
+//
+// The command itself does not know any capability. Extensions which can be
+// enabled by the client implement Enabler and are passed to NewExtension.
+package enable
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/server"
+)
+
+// Capability extension identifier.
+const Capability = "ENABLE"
+
+const (
+	enableCommand  = "ENABLE"
+	enabledCommand = "ENABLED"
+)
+
+// Enabler is an extension which can be enabled by the client.
+type Enabler interface {
+	// Enable enables the capability for the connection. It returns false
+	// when the capability is not known by the extension.
+	Enable(conn server.Conn, capability string) bool
+}
+
+// Handler for ENABLE command.
+type Handler struct {
+	enablers     []Enabler
+	capabilities []string
+}
+
+// Parse the list of capabilities to enable.
+func (h *Handler) Parse(fields []interface{}) error {
+	if len(fields) == 0 {
+		return errors.New("missing capability")
+	}
+
+	for _, f := range fields {
+		capability, err := imap.ParseString(f)
+		if err != nil {
+			return err
+		}
+		h.capabilities = append(h.capabilities, strings.ToUpper(capability))
+	}
+
+	return nil
+}
+
+// Handle the ENABLE request. Capabilities which are unknown are ignored as
+// requested by RFC; only the enabled ones are listed in the response.
+func (h *Handler) Handle(conn server.Conn) error {
+	if conn.Context().State&imap.AuthenticatedState == 0 {
+		return server.ErrNotAuthenticated
+	}
+
+	// RFC 5161 allows ENABLE only in the authenticated state, because
+	// extensions may change responses for the selected mailbox.
+	if conn.Context().Mailbox != nil {
+		return errors.New("ENABLE is not allowed in the selected state")
+	}
+
+	enabled := []interface{}{imap.RawString(enabledCommand)}
+	for _, capability := range h.capabilities {
+		for _, enabler := range h.enablers {
+			if enabler.Enable(conn, capability) {
+				enabled = append(enabled, imap.RawString(capability))
+				break
+			}
+		}
+	}
+
+	return conn.WriteResp(imap.NewUntaggedResp(enabled))
+}
+
+type extension struct {
+	enablers []Enabler
+}
+
+// NewExtension of ENABLE. Only extensions implementing Enabler can be
+// enabled, others are skipped.
+func NewExtension(extensions ...server.Extension) server.Extension {
+	ext := &extension{}
+	for _, e := range extensions {
+		if enabler, ok := e.(Enabler); ok {
+			ext.enablers = append(ext.enablers, enabler)
+		}
+	}
+	return ext
+}
+
+func (ext *extension) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState != 0 {
+		return []string{Capability}
+	}
+	return nil
+}
+
+func (ext *extension) Command(name string) server.HandlerFactory {
+	if name != enableCommand {
+		return nil
+	}
+
+	return func() server.Handler {
+		return &Handler{enablers: ext.enablers}
+	}
+}
@@ -18,7 +18,11 @@
 package id
 
 import (
+	"sync"
+	"time"
+
 	imapid "github.com/ProtonMail/go-imap-id"
+	"github.com/emersion/go-imap"
 	imapserver "github.com/emersion/go-imap/server"
 )
 
@@ -26,10 +30,19 @@ type currentClientSetter interface {
 	SetClient(name, version string)
 }
 
+// clientMailbox is a mailbox which wants to know which client appends
+// the message, e.g., to handle the copy of the sent message per client.
+type clientMailbox interface {
+	CreateMessageFromClient(client string, flags []string, date time.Time, body imap.Literal) error
+}
+
 // Extension for IMAP server.
 type extension struct {
 	extID        imapserver.ConnExtension
 	clientSetter currentClientSetter
+
+	lock    sync.RWMutex
+	clients map[*imapserver.Context]string
 }
 
 func (ext *extension) Capabilities(conn imapserver.Conn) []string {
@@ -37,6 +50,12 @@ func (ext *extension) Capabilities(conn imapserver.Conn) []string {
 }
 
 func (ext *extension) Command(name string) imapserver.HandlerFactory {
+	if name == "APPEND" {
+		return func() imapserver.Handler {
+			return &appendHandler{ext: ext}
+		}
+	}
+
 	newIDHandler := ext.extID.Command(name)
 	if newIDHandler == nil {
 		return nil
@@ -44,8 +63,8 @@ func (ext *extension) Command(name string) imapserver.HandlerFactory {
 	return func() imapserver.Handler {
 		if hdlrID, ok := newIDHandler().(*imapid.Handler); ok {
 			return &handler{
-				hdlrID:       hdlrID,
-				clientSetter: ext.clientSetter,
+				hdlrID: hdlrID,
+				ext:    ext,
 			}
 		}
 		return nil
@@ -53,27 +72,91 @@ func (ext *extension) Command(name string) imapserver.HandlerFactory {
 }
 
 func (ext *extension) NewConn(conn imapserver.Conn) imapserver.Conn {
+	ctx := conn.Context()
+
+	go func() {
+		<-ctx.LoggedOut
+
+		ext.lock.Lock()
+		delete(ext.clients, ctx)
+		ext.lock.Unlock()
+	}()
+
 	return ext.extID.NewConn(conn)
 }
 
+// setClient remembers the client name of the connection. The global client
+// setter is kept for the backend which does not know about connections.
+func (ext *extension) setClient(ctx *imapserver.Context, name, version string) {
+	ext.lock.Lock()
+	ext.clients[ctx] = name
+	ext.lock.Unlock()
+
+	ext.clientSetter.SetClient(name, version)
+}
+
+// client returns the client name of the connection or empty string when
+// the client did not send the ID command.
+func (ext *extension) client(ctx *imapserver.Context) string {
+	ext.lock.RLock()
+	defer ext.lock.RUnlock()
+
+	return ext.clients[ctx]
+}
+
 type handler struct {
-	hdlrID       *imapid.Handler
-	clientSetter currentClientSetter
+	hdlrID *imapid.Handler
+	ext    *extension
 }
 
 func (hdlr *handler) Parse(fields []interface{}) error {
 	return hdlr.hdlrID.Parse(fields)
 }
 
+// Client returns name and version of the client sent by the ID command.
+func (hdlr *handler) Client() (name, version string) {
+	id := hdlr.hdlrID.Command.ID
+	return id[imapid.FieldName], id[imapid.FieldVersion]
+}
+
 func (hdlr *handler) Handle(conn imapserver.Conn) error {
 	err := hdlr.hdlrID.Handle(conn)
 	if err == nil {
 		id := hdlr.hdlrID.Command.ID
-		hdlr.clientSetter.SetClient(id[imapid.FieldName], id[imapid.FieldVersion])
+		hdlr.ext.setClient(conn.Context(), id[imapid.FieldName], id[imapid.FieldVersion])
 	}
 	return err
 }
 
+// appendHandler passes the client name of the connection to the mailbox.
+// Other mailboxes are handled by the builtin APPEND.
+type appendHandler struct {
+	imapserver.Append
+
+	ext *extension
+}
+
+func (hdlr *appendHandler) Handle(conn imapserver.Conn) error {
+	ctx := conn.Context()
+	if ctx.User == nil {
+		return imapserver.ErrNotAuthenticated
+	}
+
+	mbox, err := ctx.User.GetMailbox(hdlr.Mailbox)
+	if err != nil {
+		return hdlr.Append.Handle(conn)
+	}
+
+	clientMbox, ok := mbox.(clientMailbox)
+	if !ok {
+		return hdlr.Append.Handle(conn)
+	}
+
+	// Untagged EXISTS is not sent here because the bridge backend
+	// notifies clients about new messages by updates.
+	return clientMbox.CreateMessageFromClient(hdlr.ext.client(ctx), hdlr.Flags, hdlr.Date, hdlr.Message)
+}
+
 // NewExtension returns extension which is adding RFC2871 ID capability, with
 // direct interface to set information about email client to backend.
 func NewExtension(serverID imapid.ID, clientSetter currentClientSetter) imapserver.Extension {
@@ -81,6 +164,7 @@ func NewExtension(serverID imapid.ID, clientSetter currentClientSetter) imapserv
 		return &extension{
 			extID:        conExtID,
 			clientSetter: clientSetter,
+			clients:      map[*imapserver.Context]string{},
 		}
 	}
 	return nil
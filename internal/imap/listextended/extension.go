@@ -0,0 +1,378 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package listextended implements LIST-EXTENDED (RFC5258) together with
+// the SPECIAL-USE (RFC6154) selection and return options.
+//
+// Special-use attributes are provided by mailboxes themselves and they are
+// returned always, not only when requested by RETURN (SPECIAL-USE).
+// The REMOTE selection option is accepted but ignored as there are no remote
+// mailboxes in Bridge. LIST without any extended syntax is handled by
+// the default go-imap handler.
+package listextended
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/server"
+	"github.com/emersion/go-imap/utf7"
+)
+
+// Capability extension identifiers.
+const (
+	Capability           = "LIST-EXTENDED"
+	SpecialUseCapability = "SPECIAL-USE"
+)
+
+const (
+	listCommand = "LIST"
+	returnKey   = "RETURN"
+
+	optionSubscribed     = "SUBSCRIBED"
+	optionRemote         = "REMOTE"
+	optionRecursiveMatch = "RECURSIVEMATCH"
+	optionSpecialUse     = "SPECIAL-USE"
+	optionChildren       = "CHILDREN"
+
+	subscribedAttr = "\\Subscribed"
+	childInfo      = "CHILDINFO"
+)
+
+// specialUseAttrs are mailbox attributes defined by RFC6154.
+var specialUseAttrs = map[string]bool{ //nolint[gochecknoglobals]
+	imap.AllAttr:     true,
+	imap.ArchiveAttr: true,
+	imap.DraftsAttr:  true,
+	imap.FlaggedAttr: true,
+	imap.JunkAttr:    true,
+	imap.SentAttr:    true,
+	imap.TrashAttr:   true,
+}
+
+// Handler for extended LIST command.
+type Handler struct {
+	server.List
+
+	isExtended bool
+	patterns   []string
+
+	selectSubscribed     bool
+	selectSpecialUse     bool
+	selectRecursiveMatch bool
+
+	returnSubscribed bool
+	returnChildren   bool
+}
+
+// Parse the extended LIST command. Selection options, multiple mailbox
+// patterns and return options are supported.
+func (h *Handler) Parse(fields []interface{}) error {
+	if len(fields) > 0 {
+		if options, ok := fields[0].([]interface{}); ok {
+			h.isExtended = true
+			if err := h.parseSelectionOptions(options); err != nil {
+				return err
+			}
+			fields = fields[1:]
+		}
+	}
+
+	if len(fields) > 3 {
+		if key, ok := fields[2].(string); ok && strings.EqualFold(key, returnKey) {
+			h.isExtended = true
+			options, ok := fields[3].([]interface{})
+			if !ok {
+				return errors.New("return options must be a list")
+			}
+			if err := h.parseReturnOptions(options); err != nil {
+				return err
+			}
+			fields = fields[:2]
+		}
+	}
+
+	if len(fields) > 1 {
+		if patterns, ok := fields[1].([]interface{}); ok {
+			h.isExtended = true
+			if err := h.parsePatterns(patterns); err != nil {
+				return err
+			}
+			fields = []interface{}{fields[0], ""}
+		}
+	}
+
+	if err := h.List.Parse(fields); err != nil {
+		return err
+	}
+
+	if len(h.patterns) == 0 {
+		h.patterns = []string{h.Mailbox}
+	}
+
+	return nil
+}
+
+func (h *Handler) parseSelectionOptions(options []interface{}) error {
+	for _, f := range options {
+		option, err := imap.ParseString(f)
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToUpper(option) {
+		case optionSubscribed:
+			h.selectSubscribed = true
+			h.returnSubscribed = true
+		case optionSpecialUse:
+			h.selectSpecialUse = true
+		case optionRecursiveMatch:
+			h.selectRecursiveMatch = true
+		case optionRemote:
+		default:
+			return errors.New("unknown selection option")
+		}
+	}
+
+	if h.selectRecursiveMatch && !h.selectSubscribed && !h.selectSpecialUse {
+		return errors.New("RECURSIVEMATCH must be used with other selection option")
+	}
+
+	return nil
+}
+
+func (h *Handler) parseReturnOptions(options []interface{}) error {
+	for _, f := range options {
+		option, err := imap.ParseString(f)
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToUpper(option) {
+		case optionSubscribed:
+			h.returnSubscribed = true
+		case optionChildren:
+			h.returnChildren = true
+		case optionSpecialUse:
+		default:
+			return errors.New("unknown return option")
+		}
+	}
+
+	return nil
+}
+
+func (h *Handler) parsePatterns(patterns []interface{}) error {
+	dec := utf7.Encoding.NewDecoder()
+
+	for _, f := range patterns {
+		pattern, err := imap.ParseString(f)
+		if err != nil {
+			return err
+		}
+		if pattern, err = dec.String(pattern); err != nil {
+			return err
+		}
+		h.patterns = append(h.patterns, imap.CanonicalMailboxName(pattern))
+	}
+
+	return nil
+}
+
+// Handle the LIST request.
+func (h *Handler) Handle(conn server.Conn) error {
+	if !h.isExtended {
+		return h.List.Handle(conn)
+	}
+
+	ctx := conn.Context()
+	if ctx.User == nil {
+		return server.ErrNotAuthenticated
+	}
+
+	infos, err := listInfos(ctx.User, false)
+	if err != nil {
+		return err
+	}
+
+	subscribed := map[string]bool{}
+	if h.returnSubscribed {
+		subscribedInfos, err := listInfos(ctx.User, true)
+		if err != nil {
+			return err
+		}
+		for _, info := range subscribedInfos {
+			subscribed[info.Name] = true
+		}
+	}
+
+	for _, info := range infos {
+		if !h.matchPatterns(info) {
+			continue
+		}
+
+		var extendedData []interface{}
+		if !h.isSelected(info, subscribed) {
+			if !h.selectRecursiveMatch || !h.hasSelectedChild(info, infos, subscribed) {
+				continue
+			}
+			extendedData = h.getChildInfo()
+		}
+
+		if err := conn.WriteResp(h.getResp(info, infos, subscribed, extendedData)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func listInfos(user backend.User, subscribed bool) ([]*imap.MailboxInfo, error) {
+	mailboxes, err := user.ListMailboxes(subscribed)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []*imap.MailboxInfo{}
+	for _, mailbox := range mailboxes {
+		info, err := mailbox.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+func (h *Handler) matchPatterns(info *imap.MailboxInfo) bool {
+	for _, pattern := range h.patterns {
+		if info.Match(h.Reference, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) isSelected(info *imap.MailboxInfo, subscribed map[string]bool) bool {
+	if h.selectSubscribed && !subscribed[info.Name] {
+		return false
+	}
+	if h.selectSpecialUse && !hasSpecialUse(info) {
+		return false
+	}
+	return true
+}
+
+func (h *Handler) hasSelectedChild(info *imap.MailboxInfo, infos []*imap.MailboxInfo, subscribed map[string]bool) bool {
+	for _, child := range infos {
+		if isChild(info, child) && h.isSelected(child, subscribed) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) getChildInfo() []interface{} {
+	criteria := []interface{}{}
+	if h.selectSubscribed {
+		criteria = append(criteria, optionSubscribed)
+	}
+	if h.selectSpecialUse {
+		criteria = append(criteria, optionSpecialUse)
+	}
+	return []interface{}{childInfo, criteria}
+}
+
+func (h *Handler) getResp(info *imap.MailboxInfo, infos []*imap.MailboxInfo, subscribed map[string]bool, extendedData []interface{}) *imap.DataResp {
+	respInfo := &imap.MailboxInfo{
+		Attributes: append([]string{}, info.Attributes...),
+		Delimiter:  info.Delimiter,
+		Name:       info.Name,
+	}
+
+	if h.returnSubscribed && subscribed[info.Name] {
+		respInfo.Attributes = append(respInfo.Attributes, subscribedAttr)
+	}
+
+	if h.returnChildren && !hasAttr(info, imap.NoInferiorsAttr) {
+		childrenAttr := imap.HasNoChildrenAttr
+		for _, child := range infos {
+			if isChild(info, child) {
+				childrenAttr = imap.HasChildrenAttr
+				break
+			}
+		}
+		respInfo.Attributes = append(respInfo.Attributes, childrenAttr)
+	}
+
+	fields := []interface{}{imap.RawString(listCommand)}
+	fields = append(fields, respInfo.Format()...)
+	if extendedData != nil {
+		fields = append(fields, extendedData)
+	}
+
+	return imap.NewUntaggedResp(fields)
+}
+
+func isChild(parent, child *imap.MailboxInfo) bool {
+	return parent.Delimiter != "" && strings.HasPrefix(child.Name, parent.Name+parent.Delimiter)
+}
+
+func hasSpecialUse(info *imap.MailboxInfo) bool {
+	for _, attr := range info.Attributes {
+		if specialUseAttrs[attr] {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAttr(info *imap.MailboxInfo, attr string) bool {
+	for _, infoAttr := range info.Attributes {
+		if strings.EqualFold(infoAttr, attr) {
+			return true
+		}
+	}
+	return false
+}
+
+type extension struct{}
+
+// NewExtension of LIST-EXTENDED and SPECIAL-USE.
+func NewExtension() server.Extension {
+	return &extension{}
+}
+
+func (ext *extension) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState != 0 {
+		return []string{Capability, SpecialUseCapability}
+	}
+	return nil
+}
+
+func (ext *extension) Command(name string) server.HandlerFactory {
+	if name != listCommand {
+		return nil
+	}
+
+	return func() server.Handler {
+		return &Handler{}
+	}
+}
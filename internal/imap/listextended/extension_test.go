@@ -0,0 +1,71 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package listextended
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBasicList(t *testing.T) {
+	h := &Handler{}
+	require.NoError(t, h.Parse([]interface{}{"", "*"}))
+	assert.False(t, h.isExtended)
+	assert.Equal(t, []string{"*"}, h.patterns)
+}
+
+func TestParseExtendedList(t *testing.T) {
+	h := &Handler{}
+	require.NoError(t, h.Parse([]interface{}{
+		[]interface{}{"SUBSCRIBED", "RECURSIVEMATCH"},
+		"",
+		[]interface{}{"INBOX", "Folders/*"},
+		"RETURN",
+		[]interface{}{"CHILDREN", "SPECIAL-USE"},
+	}))
+	assert.True(t, h.isExtended)
+	assert.True(t, h.selectSubscribed)
+	assert.True(t, h.selectRecursiveMatch)
+	assert.True(t, h.returnSubscribed)
+	assert.True(t, h.returnChildren)
+	assert.Equal(t, []string{"INBOX", "Folders/*"}, h.patterns)
+
+	h = &Handler{}
+	require.NoError(t, h.Parse([]interface{}{"", "*", "RETURN", []interface{}{"SPECIAL-USE"}}))
+	assert.True(t, h.isExtended)
+	assert.Equal(t, []string{"*"}, h.patterns)
+}
+
+func TestParseInvalidExtendedList(t *testing.T) {
+	assert.Error(t, (&Handler{}).Parse([]interface{}{[]interface{}{"RECURSIVEMATCH"}, "", "*"}))
+	assert.Error(t, (&Handler{}).Parse([]interface{}{[]interface{}{"UNKNOWN"}, "", "*"}))
+	assert.Error(t, (&Handler{}).Parse([]interface{}{"", "*", "RETURN", []interface{}{"UNKNOWN"}}))
+}
+
+func TestSelectSpecialUse(t *testing.T) {
+	h := &Handler{selectSpecialUse: true}
+
+	sent := &imap.MailboxInfo{Attributes: []string{imap.NoInferiorsAttr, imap.SentAttr}, Delimiter: "/", Name: "Sent"}
+	inbox := &imap.MailboxInfo{Attributes: []string{imap.NoInferiorsAttr}, Delimiter: "/", Name: "INBOX"}
+
+	assert.True(t, h.isSelected(sent, nil))
+	assert.False(t, h.isSelected(inbox, nil))
+}
@@ -21,6 +21,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ProtonMail/proton-bridge/internal/imap/condstore"
 	"github.com/ProtonMail/proton-bridge/pkg/message"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 	"github.com/emersion/go-imap"
@@ -32,6 +33,10 @@ type imapMailbox struct {
 	user         *imapUser
 	name         string
 
+	// namespace is the prefix of the name for mailboxes of other addresses
+	// in split mode. Name without the prefix is used for updates.
+	namespace string
+
 	log *logrus.Entry
 
 	storeUser    storeUserProvider
@@ -85,7 +90,7 @@ func (im *imapMailbox) Name() string {
 	// Called from go-imap in goroutines - we need to handle panics for each function.
 	defer im.panicHandler.HandlePanic()
 
-	return im.name
+	return im.namespace + im.name
 }
 
 // Info returns this mailbox info.
@@ -96,7 +101,7 @@ func (im *imapMailbox) Info() (*imap.MailboxInfo, error) {
 	info := &imap.MailboxInfo{
 		Attributes: im.getFlags(),
 		Delimiter:  im.storeMailbox.GetDelimiter(),
-		Name:       im.name,
+		Name:       im.namespace + im.name,
 	}
 
 	return info, nil
@@ -139,7 +144,7 @@ func (im *imapMailbox) Status(items []imap.StatusItem) (*imap.MailboxStatus, err
 	l := log.WithField("status-label", im.storeMailbox.LabelID())
 	l.Data["user"] = im.storeUser.UserID()
 	l.Data["address"] = im.storeAddress.AddressID()
-	status := imap.NewMailboxStatus(im.name, items)
+	status := imap.NewMailboxStatus(im.namespace+im.name, items)
 	status.UidValidity = im.storeMailbox.UIDValidity()
 	status.Flags = []string{
 		imap.SeenFlag, strings.ToUpper(imap.SeenFlag),
@@ -169,6 +174,14 @@ func (im *imapMailbox) Status(items []imap.StatusItem) (*imap.MailboxStatus, err
 		return nil, err
 	}
 
+	if _, ok := status.Items[condstore.StatusHighestModSeq]; ok {
+		highestModSeq, err := im.storeMailbox.GetHighestModSeq()
+		if err != nil {
+			return nil, err
+		}
+		status.Items[condstore.StatusHighestModSeq] = condstore.FormatModSeq(highestModSeq)
+	}
+
 	return status, nil
 }
 
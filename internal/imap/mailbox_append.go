@@ -42,11 +42,19 @@ import (
 // via a mailbox update.
 func (im *imapMailbox) CreateMessage(flags []string, date time.Time, body imap.Literal) error {
 	return im.logCommand(func() error {
-		return im.createMessage(flags, date, body)
+		return im.createMessage("", flags, date, body)
 	}, "APPEND", flags, date)
 }
 
-func (im *imapMailbox) createMessage(imapFlags []string, date time.Time, r imap.Literal) error { //nolint[funlen]
+// CreateMessageFromClient is the same as CreateMessage, but it knows the name
+// of the client appending the message to handle copies of sent messages.
+func (im *imapMailbox) CreateMessageFromClient(client string, flags []string, date time.Time, body imap.Literal) error {
+	return im.logCommand(func() error {
+		return im.createMessage(client, flags, date, body)
+	}, "APPEND", client, flags, date)
+}
+
+func (im *imapMailbox) createMessage(client string, imapFlags []string, date time.Time, r imap.Literal) error { //nolint[funlen]
 	// Called from go-imap in goroutines - we need to handle panics for each function.
 	defer im.panicHandler.HandlePanic()
 
@@ -73,6 +81,15 @@ func (im *imapMailbox) createMessage(imapFlags []string, date time.Time, r imap.
 		return im.createDraftMessage(kr, addr.Email, body)
 	}
 
+	hdr, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(body)))
+	if err != nil {
+		return err
+	}
+
+	if isSentCopy, err := im.appendSentCopy(client, hdr, imapFlags); isSentCopy {
+		return err
+	}
+
 	if im.storeMailbox.LabelID() == pmapi.SentLabel {
 		m, _, _, _, err := message.Parse(bytes.NewReader(body))
 		if err != nil {
@@ -95,11 +112,6 @@ func (im *imapMailbox) createMessage(imapFlags []string, date time.Time, r imap.
 		}
 	}
 
-	hdr, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(body)))
-	if err != nil {
-		return err
-	}
-
 	// Avoid appending a message which is already on the server. Apply the new label instead.
 	// This always happens with Outlook because it uses APPEND instead of COPY.
 	internalID := hdr.Get("X-Pm-Internal-Id")
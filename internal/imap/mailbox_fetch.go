@@ -22,6 +22,7 @@ import (
 	"context"
 
 	"github.com/ProtonMail/proton-bridge/internal/imap/cache"
+	"github.com/ProtonMail/proton-bridge/internal/imap/condstore"
 	"github.com/ProtonMail/proton-bridge/pkg/message"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 	"github.com/emersion/go-imap"
@@ -76,6 +77,12 @@ func (im *imapMailbox) getMessage(
 			if msg.Uid, err = storeMessage.UID(); err != nil {
 				return nil, err
 			}
+		case condstore.FetchModSeq:
+			modSeq, err := storeMessage.ModSeq()
+			if err != nil {
+				return nil, err
+			}
+			msg.Items[condstore.FetchModSeq] = []interface{}{condstore.FormatModSeq(modSeq)}
 		case imap.FetchAll, imap.FetchFast, imap.FetchFull, imap.FetchRFC822, imap.FetchRFC822Header, imap.FetchRFC822Text:
 			fallthrough // this is list of defined items by go-imap, but items can be also sections generated from requests
 		default:
@@ -172,6 +179,17 @@ func (im *imapMailbox) getBodyAndStructure(
 		return structure, bodyReader, nil
 	}
 
+	// return the message which was found in disk cache
+	if body, diskStructure, ok := im.storeUser.LoadCachedMessage(m.ID); ok {
+		// Store could be recreated in the meantime (e.g. after resync).
+		if size := int64(len(body)); m.Size != size {
+			m.Size = size
+			cacheMessageInStore(storeMessage, diskStructure, body, im.log.WithField("msgID", m.ID))
+		}
+		cache.SaveMail(id, body, diskStructure)
+		return diskStructure, bytes.NewReader(body), nil
+	}
+
 	structure, body, err := im.buildMessage(m)
 	bodyReader = bytes.NewReader(body)
 	size := int64(len(body))
@@ -199,6 +217,7 @@ func (im *imapMailbox) getBodyAndStructure(
 	// Drafts can change therefore we don't want to cache them.
 	if !isMessageInDraftFolder(m) {
 		cache.SaveMail(id, body, structure)
+		im.storeUser.SaveCachedMessage(m.ID, body, structure)
 	}
 
 	return structure, bodyReader, err
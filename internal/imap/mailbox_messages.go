@@ -22,7 +22,6 @@ import (
 	"net/mail"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/ProtonMail/proton-bridge/internal/imap/uidplus"
 	"github.com/ProtonMail/proton-bridge/pkg/message"
@@ -243,7 +242,9 @@ func (im *imapMailbox) labelMessages(uid bool, seqSet *imap.SeqSet, targetLabel
 	// messages can be removed from source during labeling (e.g. folder1 -> folder2).
 	sourceSeqSet := im.storeMailbox.GetUIDList(messageIDs)
 
-	targetStoreMailbox, err := im.storeAddress.GetMailbox(targetLabel)
+	// Target within the same namespace of other address is addressed
+	// by the full name, but the store knows only the name without prefix.
+	targetStoreMailbox, err := im.storeAddress.GetMailbox(strings.TrimPrefix(targetLabel, im.namespace))
 	if err != nil {
 		return err
 	}
@@ -306,183 +307,6 @@ func (im *imapMailbox) labelMessages(uid bool, seqSet *imap.SeqSet, targetLabel
 	return uidplus.CopyResponse(targetStoreMailbox.UIDValidity(), sourceSeqSet, targetSeqSet)
 }
 
-// SearchMessages searches messages. The returned list must contain UIDs if
-// uid is set to true, or sequence numbers otherwise.
-func (im *imapMailbox) SearchMessages(isUID bool, criteria *imap.SearchCriteria) (ids []uint32, err error) { //nolint[gocyclo]
-	// Called from go-imap in goroutines - we need to handle panics for each function.
-	defer im.panicHandler.HandlePanic()
-
-	if criteria.Not != nil || criteria.Or != nil {
-		return nil, errors.New("unsupported search query")
-	}
-
-	if criteria.Body != nil || criteria.Text != nil {
-		log.Warn("Body and Text criteria not applied")
-	}
-
-	var apiIDs []string
-	if criteria.SeqNum != nil {
-		apiIDs, err = im.apiIDsFromSeqSet(false, criteria.SeqNum)
-	} else {
-		apiIDs, err = im.storeMailbox.GetAPIIDsFromSequenceRange(1, 0)
-	}
-	if err != nil {
-		return nil, err
-	}
-
-	if criteria.Uid != nil {
-		apiIDsByUID, err := im.apiIDsFromSeqSet(true, criteria.Uid)
-		if err != nil {
-			return nil, err
-		}
-		apiIDs = arrayIntersection(apiIDs, apiIDsByUID)
-	}
-
-	for _, apiID := range apiIDs {
-		// Get message.
-		storeMessage, err := im.storeMailbox.GetMessage(apiID)
-		if err != nil {
-			log.Warnf("search messages: cannot get message %q from db: %v", apiID, err)
-			continue
-		}
-		m := storeMessage.Message()
-
-		// Filter by time.
-		if !criteria.Before.IsZero() {
-			if truncated := criteria.Before.Truncate(24 * time.Hour); m.Time > truncated.Unix() {
-				continue
-			}
-		}
-		if !criteria.Since.IsZero() {
-			if truncated := criteria.Since.Truncate(24 * time.Hour); m.Time < truncated.Unix() {
-				continue
-			}
-		}
-
-		// In order to speed up search it is not needed to check if IsFullHeaderCached.
-		header := storeMessage.GetMIMEHeader()
-
-		if !criteria.SentBefore.IsZero() || !criteria.SentSince.IsZero() {
-			t, err := mail.Header(header).Date()
-			if err != nil || t.IsZero() {
-				t = time.Unix(m.Time, 0)
-			}
-			if !criteria.SentBefore.IsZero() {
-				if truncated := criteria.SentBefore.Truncate(24 * time.Hour); t.Unix() > truncated.Unix() {
-					continue
-				}
-			}
-			if !criteria.SentSince.IsZero() {
-				if truncated := criteria.SentSince.Truncate(24 * time.Hour); t.Unix() < truncated.Unix() {
-					continue
-				}
-			}
-		}
-
-		// Filter by headers.
-		headerMatch := true
-		for criteriaKey, criteriaValues := range criteria.Header {
-			for _, criteriaValue := range criteriaValues {
-				if criteriaValue == "" {
-					continue
-				}
-				switch criteriaKey {
-				case "Subject":
-					headerMatch = strings.Contains(strings.ToLower(m.Subject), strings.ToLower(criteriaValue))
-				case "From":
-					headerMatch = addressMatch([]*mail.Address{m.Sender}, criteriaValue)
-				case "To":
-					headerMatch = addressMatch(m.ToList, criteriaValue)
-				case "Cc":
-					headerMatch = addressMatch(m.CCList, criteriaValue)
-				case "Bcc":
-					headerMatch = addressMatch(m.BCCList, criteriaValue)
-				default:
-					if messageValue := header.Get(criteriaKey); messageValue == "" {
-						headerMatch = false // Field is not in header.
-					} else if !strings.Contains(strings.ToLower(messageValue), strings.ToLower(criteriaValue)) {
-						headerMatch = false // Field is in header but value not matched (case insensitive).
-					}
-				}
-				if !headerMatch {
-					break
-				}
-			}
-			if !headerMatch {
-				break
-			}
-		}
-		if !headerMatch {
-			continue
-		}
-
-		// Filter by flags.
-		messageFlagsMap := make(map[string]bool)
-		if isStringInList(m.LabelIDs, pmapi.StarredLabel) {
-			messageFlagsMap[imap.FlaggedFlag] = true
-		}
-		if !m.Unread {
-			messageFlagsMap[imap.SeenFlag] = true
-		}
-		if m.Has(pmapi.FlagReplied) || m.Has(pmapi.FlagRepliedAll) {
-			messageFlagsMap[imap.AnsweredFlag] = true
-		}
-		if m.Has(pmapi.FlagSent) || m.Has(pmapi.FlagReceived) {
-			messageFlagsMap[imap.DraftFlag] = true
-		}
-		if !m.Has(pmapi.FlagOpened) {
-			messageFlagsMap[imap.RecentFlag] = true
-		}
-		if storeMessage.IsMarkedDeleted() {
-			messageFlagsMap[imap.DeletedFlag] = true
-		}
-
-		flagMatch := true
-		for _, flag := range criteria.WithFlags {
-			if !messageFlagsMap[flag] {
-				flagMatch = false
-				break
-			}
-		}
-		for _, flag := range criteria.WithoutFlags {
-			if messageFlagsMap[flag] {
-				flagMatch = false
-				break
-			}
-		}
-		if !flagMatch {
-			continue
-		}
-
-		// Filter by size (only if size was already calculated).
-		if m.Size > 0 {
-			if criteria.Larger != 0 && m.Size <= int64(criteria.Larger) {
-				continue
-			}
-			if criteria.Smaller != 0 && m.Size >= int64(criteria.Smaller) {
-				continue
-			}
-		}
-
-		// Add the ID to response.
-		var id uint32
-		if isUID {
-			id, err = storeMessage.UID()
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			id, err = storeMessage.SequenceNumber()
-			if err != nil {
-				return nil, err
-			}
-		}
-		ids = append(ids, id)
-	}
-
-	return ids, nil
-}
-
 // ListMessages returns a list of messages. seqset must be interpreted as UIDs
 // if uid is set to true and as message sequence numbers otherwise. See RFC
 // 3501 section 6.4.5 for a list of items that can be requested.
@@ -0,0 +1,67 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/internal/imap/metadata"
+	"github.com/emersion/go-imap"
+)
+
+// GetMetadata returns the label color and special-use attributes.
+func (im *imapMailbox) GetMetadata() (map[string]string, error) {
+	// Called from go-imap in goroutines - we need to handle panics for each function.
+	defer im.panicHandler.HandlePanic()
+
+	entries := map[string]string{}
+
+	// System mailboxes have no color which could be changed by user.
+	if color := im.storeMailbox.Color(); color != "" && !im.storeMailbox.IsSystem() {
+		entries[metadata.ColorEntry] = color
+	}
+
+	specialUse := []string{}
+	for _, flag := range im.getFlags() {
+		if flag != imap.NoInferiorsAttr {
+			specialUse = append(specialUse, flag)
+		}
+	}
+	if len(specialUse) != 0 {
+		entries[metadata.SpecialUseEntry] = strings.Join(specialUse, " ")
+	}
+
+	return entries, nil
+}
+
+// SetMetadata updates the label color. Other entries cannot be set.
+func (im *imapMailbox) SetMetadata(entry string, value *string) error {
+	// Called from go-imap in goroutines - we need to handle panics for each function.
+	defer im.panicHandler.HandlePanic()
+
+	if entry != metadata.ColorEntry {
+		return metadata.ErrReadOnly
+	}
+
+	if value == nil {
+		return errors.New("color cannot be removed")
+	}
+
+	return im.storeMailbox.SetColor(*value)
+}
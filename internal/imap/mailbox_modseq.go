@@ -0,0 +1,78 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"github.com/emersion/go-imap"
+)
+
+// HighestModSeq returns the highest mod-sequence of the mailbox.
+func (im *imapMailbox) HighestModSeq() (uint64, error) {
+	// Called from go-imap in goroutines - we need to handle panics for each function.
+	defer im.panicHandler.HandlePanic()
+
+	return im.storeMailbox.GetHighestModSeq()
+}
+
+// ModSeqs returns mod-sequences of the messages in the sequence set keyed
+// by UID or sequence number, depending on uid.
+func (im *imapMailbox) ModSeqs(uid bool, seqSet *imap.SeqSet) (map[uint32]uint64, error) {
+	// Called from go-imap in goroutines - we need to handle panics for each function.
+	defer im.panicHandler.HandlePanic()
+
+	apiIDs, err := im.apiIDsFromSeqSet(uid, seqSet)
+	if err != nil {
+		return nil, err
+	}
+
+	apiModSeqs, err := im.storeMailbox.GetModSeqs(apiIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	modSeqs := make(map[uint32]uint64, len(apiModSeqs))
+	for apiID, modSeq := range apiModSeqs {
+		storeMessage, err := im.storeMailbox.GetMessage(apiID)
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := getMessageID(uid, storeMessage)
+		if err != nil {
+			return nil, err
+		}
+
+		modSeqs[id] = modSeq
+	}
+
+	return modSeqs, nil
+}
+
+// LockModSeqs blocks changes of flags by other STORE commands of the mailbox
+// until the returned function is called.
+func (im *imapMailbox) LockModSeqs() (unlock func()) {
+	return im.storeMailbox.LockFlags()
+}
+
+// VanishedUIDs returns UIDs of messages expunged after the mod-sequence.
+func (im *imapMailbox) VanishedUIDs(modSeq uint64) ([]uint32, error) {
+	// Called from go-imap in goroutines - we need to handle panics for each function.
+	defer im.panicHandler.HandlePanic()
+
+	return im.storeMailbox.GetVanishedUIDs(modSeq)
+}
@@ -36,36 +36,47 @@ import (
 //		Labels						<< this
 //			Labels/Security
 //
+// The same is used for saved searches, if there is any:
+//
+//		Searches					<< this
+//			Searches/Unread from boss
+//
+// The same is used for namespace of other addresses in split mode:
+//
+//		Other Users					<< this
+//			Other Users/alias@pm.me			<< this
+//				Other Users/alias@pm.me/INBOX
+//
 // This mailbox cannot be modified or read in any way.
 type imapRootMailbox struct {
-	isFolder bool
+	name string
 }
 
 func newFoldersRootMailbox() *imapRootMailbox {
-	return &imapRootMailbox{isFolder: true}
+	return &imapRootMailbox{name: store.UserFoldersMailboxName}
 }
 
 func newLabelsRootMailbox() *imapRootMailbox {
-	return &imapRootMailbox{isFolder: false}
+	return &imapRootMailbox{name: store.UserLabelsMailboxName}
+}
+
+func newSearchesRootMailbox() *imapRootMailbox {
+	return &imapRootMailbox{name: store.SavedSearchesMailboxName}
+}
+
+func newNamespaceRootMailbox(name string) *imapRootMailbox {
+	return &imapRootMailbox{name: name}
 }
 
 func (m *imapRootMailbox) Name() string {
-	if m.isFolder {
-		return store.UserFoldersMailboxName
-	}
-	return store.UserLabelsMailboxName
+	return m.name
 }
 
 func (m *imapRootMailbox) Info() (info *imap.MailboxInfo, err error) {
 	info = &imap.MailboxInfo{
 		Attributes: []string{imap.NoSelectAttr},
 		Delimiter:  store.PathDelimiter,
-	}
-
-	if m.isFolder {
-		info.Name = store.UserFoldersMailboxName
-	} else {
-		info.Name = store.UserLabelsMailboxName
+		Name:       m.name,
 	}
 
 	return
@@ -73,16 +84,12 @@ func (m *imapRootMailbox) Info() (info *imap.MailboxInfo, err error) {
 
 func (m *imapRootMailbox) Status(_ []imap.StatusItem) (*imap.MailboxStatus, error) {
 	status := &imap.MailboxStatus{}
-	if m.isFolder {
-		status.Name = store.UserFoldersMailboxName
-	} else {
-		status.Name = store.UserLabelsMailboxName
-	}
+	status.Name = m.name
 	return status, nil
 }
 
 func (m *imapRootMailbox) SetSubscribed(_ bool) error {
-	return errors.New("cannot subscribe or unsubsribe to Labels or Folders mailboxes")
+	return errors.New("cannot subscribe or unsubsribe to Labels, Folders or Searches mailboxes")
 }
 
 func (m *imapRootMailbox) Check() error {
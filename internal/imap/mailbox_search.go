@@ -0,0 +1,484 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"bytes"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/store"
+	"github.com/ProtonMail/proton-bridge/pkg/message/parser"
+	pmmime "github.com/ProtonMail/proton-bridge/pkg/mime"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/emersion/go-imap"
+	"github.com/pkg/errors"
+)
+
+// SearchMessages searches messages. The returned list must contain UIDs if
+// uid is set to true, or sequence numbers otherwise.
+func (im *imapMailbox) SearchMessages(isUID bool, criteria *imap.SearchCriteria) (ids []uint32, err error) {
+	// Called from go-imap in goroutines - we need to handle panics for each function.
+	defer im.panicHandler.HandlePanic()
+
+	storeMessages, err := im.searchStoreMessages(criteria)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, storeMessage := range storeMessages {
+		id, err := getMessageID(isUID, storeMessage)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// searchStoreMessages returns messages matching the criteria ordered by
+// sequence numbers.
+func (im *imapMailbox) searchStoreMessages(criteria *imap.SearchCriteria) ([]storeMessageProvider, error) {
+	var apiIDs []string
+	var err error
+	if criteria.SeqNum != nil {
+		apiIDs, err = im.apiIDsFromSeqSet(false, criteria.SeqNum)
+	} else {
+		apiIDs, err = im.storeMailbox.GetAPIIDsFromSequenceRange(1, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if criteria.Uid != nil {
+		apiIDsByUID, err := im.apiIDsFromSeqSet(true, criteria.Uid)
+		if err != nil {
+			return nil, err
+		}
+		apiIDs = arrayIntersection(apiIDs, apiIDsByUID)
+	}
+
+	// Top-level sequence sets are already applied to the list of messages.
+	criteria = withoutSeqSets(criteria)
+
+	search := newMailboxSearch(im)
+	storeMessages := []storeMessageProvider{}
+
+	for _, apiID := range apiIDs {
+		// Get message.
+		storeMessage, err := im.storeMailbox.GetMessage(apiID)
+		if err != nil {
+			log.Warnf("search messages: cannot get message %q from db: %v", apiID, err)
+			continue
+		}
+
+		isMatch, err := search.match(newSearchMessage(storeMessage), criteria)
+		if err != nil {
+			log.Warnf("search messages: cannot match message %q: %v", apiID, err)
+			continue
+		}
+		if !isMatch {
+			continue
+		}
+
+		storeMessages = append(storeMessages, storeMessage)
+	}
+
+	return storeMessages, nil
+}
+
+// withoutSeqSets returns copy of the criteria without sequence number and
+// UID sets. Nested criteria are kept as they are.
+func withoutSeqSets(criteria *imap.SearchCriteria) *imap.SearchCriteria {
+	c := *criteria
+	c.SeqNum = nil
+	c.Uid = nil
+	return &c
+}
+
+// getMessageID returns UID of the message if isUID is set to true, or its
+// sequence number otherwise.
+func getMessageID(isUID bool, storeMessage storeMessageProvider) (uint32, error) {
+	if isUID {
+		return storeMessage.UID()
+	}
+	return storeMessage.SequenceNumber()
+}
+
+// mailboxSearch evaluates search criteria against messages of one mailbox.
+// Sequence sets used in nested criteria and results from the search index
+// are resolved only once per search.
+type mailboxSearch struct {
+	im           *imapMailbox
+	seqSets      map[*imap.SeqSet]map[string]bool
+	indexResults map[string]map[string]bool
+}
+
+func newMailboxSearch(im *imapMailbox) *mailboxSearch {
+	return &mailboxSearch{
+		im:           im,
+		seqSets:      make(map[*imap.SeqSet]map[string]bool),
+		indexResults: make(map[string]map[string]bool),
+	}
+}
+
+// searchMessage holds the message being searched together with lazily
+// computed data which is expensive to get, such as the decrypted body.
+type searchMessage struct {
+	storeMessage storeMessageProvider
+
+	flags     map[string]bool
+	isIndexed *bool
+
+	isTextLoaded bool
+	header       string
+	body         string
+}
+
+func newSearchMessage(storeMessage storeMessageProvider) *searchMessage {
+	return &searchMessage{storeMessage: storeMessage}
+}
+
+// match returns whether the message satisfies all the criteria. Nested NOT
+// and OR criteria are evaluated recursively.
+func (s *mailboxSearch) match(sm *searchMessage, criteria *imap.SearchCriteria) (bool, error) { //nolint[gocyclo,funlen]
+	m := sm.storeMessage.Message()
+
+	if criteria.SeqNum != nil {
+		if isMatch, err := s.inSeqSet(false, criteria.SeqNum, m.ID); err != nil || !isMatch {
+			return false, err
+		}
+	}
+	if criteria.Uid != nil {
+		if isMatch, err := s.inSeqSet(true, criteria.Uid, m.ID); err != nil || !isMatch {
+			return false, err
+		}
+	}
+
+	// Filter by time.
+	if !criteria.Before.IsZero() {
+		if truncated := criteria.Before.Truncate(24 * time.Hour); m.Time > truncated.Unix() {
+			return false, nil
+		}
+	}
+	if !criteria.Since.IsZero() {
+		if truncated := criteria.Since.Truncate(24 * time.Hour); m.Time < truncated.Unix() {
+			return false, nil
+		}
+	}
+
+	// In order to speed up search it is not needed to check if IsFullHeaderCached.
+	header := sm.storeMessage.GetMIMEHeader()
+
+	if !criteria.SentBefore.IsZero() || !criteria.SentSince.IsZero() {
+		t, err := mail.Header(header).Date()
+		if err != nil || t.IsZero() {
+			t = time.Unix(m.Time, 0)
+		}
+		if !criteria.SentBefore.IsZero() {
+			if truncated := criteria.SentBefore.Truncate(24 * time.Hour); t.Unix() > truncated.Unix() {
+				return false, nil
+			}
+		}
+		if !criteria.SentSince.IsZero() {
+			if truncated := criteria.SentSince.Truncate(24 * time.Hour); t.Unix() < truncated.Unix() {
+				return false, nil
+			}
+		}
+	}
+
+	// Filter by headers.
+	for criteriaKey, criteriaValues := range criteria.Header {
+		for _, criteriaValue := range criteriaValues {
+			if criteriaValue == "" {
+				continue
+			}
+			if !matchHeader(m, header, criteriaKey, criteriaValue) {
+				return false, nil
+			}
+		}
+	}
+
+	// Filter by flags.
+	messageFlagsMap := sm.getFlags()
+	for _, flag := range criteria.WithFlags {
+		if !messageFlagsMap[flag] {
+			return false, nil
+		}
+	}
+	for _, flag := range criteria.WithoutFlags {
+		if messageFlagsMap[flag] {
+			return false, nil
+		}
+	}
+
+	// Filter by size.
+	if criteria.Larger != 0 || criteria.Smaller != 0 {
+		size, err := s.getSize(sm)
+		if err != nil {
+			return false, err
+		}
+		if criteria.Larger != 0 && size <= int64(criteria.Larger) {
+			return false, nil
+		}
+		if criteria.Smaller != 0 && size >= int64(criteria.Smaller) {
+			return false, nil
+		}
+	}
+
+	for _, not := range criteria.Not {
+		if isMatch, err := s.match(sm, not); err != nil || isMatch {
+			return false, err
+		}
+	}
+
+	for _, or := range criteria.Or {
+		isMatch, err := s.match(sm, or[0])
+		if err != nil {
+			return false, err
+		}
+		if !isMatch {
+			if isMatch, err = s.match(sm, or[1]); err != nil || !isMatch {
+				return false, err
+			}
+		}
+	}
+
+	// Filter by content as the last step because it can need the whole
+	// message to be downloaded and decrypted.
+	for _, body := range criteria.Body {
+		if isMatch, err := s.matchText(sm, body, false); err != nil || !isMatch {
+			return false, err
+		}
+	}
+	for _, text := range criteria.Text {
+		if isMatch, err := s.matchText(sm, text, true); err != nil || !isMatch {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// matchText returns whether the message body (or header, if withHeader is
+// set) contains the query. When the message is indexed, the search index is
+// used to skip messages which cannot contain the query; the remaining ones
+// are built and searched for the substring in the same way as messages which
+// are not indexed.
+func (s *mailboxSearch) matchText(sm *searchMessage, query string, withHeader bool) (bool, error) {
+	if apiIDs, ok := s.searchIndex(query, withHeader); ok && s.isIndexed(sm) && !apiIDs[sm.storeMessage.ID()] {
+		// The index does not contain the whole header, only the body of
+		// the message can be excluded. Cached header is good enough.
+		if !withHeader {
+			return false, nil
+		}
+		return containsFold(decodeSearchableHeader(sm.storeMessage.GetHeader()), query), nil
+	}
+
+	if err := s.loadText(sm); err != nil {
+		return false, err
+	}
+
+	if withHeader && containsFold(sm.header, query) {
+		return true, nil
+	}
+	return containsFold(sm.body, query), nil
+}
+
+// searchIndex returns IDs of messages matching the query in the search
+// index. It returns false if the index cannot answer the query.
+func (s *mailboxSearch) searchIndex(query string, withHeader bool) (map[string]bool, bool) {
+	key := fmt.Sprintf("%v:%s", withHeader, query)
+
+	apiIDs, ok := s.indexResults[key]
+	if !ok {
+		var err error
+		if apiIDs, err = s.im.storeUser.SearchIndex(query, withHeader); err != nil {
+			if err != store.ErrSearchIndexUnavailable {
+				log.WithError(err).Warn("Cannot use search index")
+			}
+			apiIDs = nil
+		}
+		s.indexResults[key] = apiIDs
+	}
+
+	return apiIDs, apiIDs != nil
+}
+
+func (s *mailboxSearch) isIndexed(sm *searchMessage) bool {
+	if sm.isIndexed == nil {
+		isIndexed := s.im.storeUser.IsMessageIndexed(sm.storeMessage.ID())
+		sm.isIndexed = &isIndexed
+	}
+	return *sm.isIndexed
+}
+
+// getSize returns RFC822 size of the message. The size is known only once
+// the message was built, so the message is built if needed.
+func (s *mailboxSearch) getSize(sm *searchMessage) (int64, error) {
+	if size := sm.storeMessage.Message().Size; size > 0 {
+		return size, nil
+	}
+
+	_, bodyReader, err := s.im.getBodyAndStructure(sm.storeMessage, nil)
+	if err != nil {
+		return 0, err
+	}
+	if bodyReader.Size() == 0 {
+		return 0, errors.New("unknown message size")
+	}
+	return bodyReader.Size(), nil
+}
+
+// inSeqSet returns whether the message is within the sequence set.
+func (s *mailboxSearch) inSeqSet(uid bool, seqSet *imap.SeqSet, apiID string) (bool, error) {
+	apiIDs, ok := s.seqSets[seqSet]
+	if !ok {
+		list, err := s.im.apiIDsFromSeqSet(uid, seqSet)
+		if err != nil {
+			return false, err
+		}
+		apiIDs = make(map[string]bool, len(list))
+		for _, id := range list {
+			apiIDs[id] = true
+		}
+		s.seqSets[seqSet] = apiIDs
+	}
+	return apiIDs[apiID], nil
+}
+
+// loadText builds the message (or takes it from the cache) and extracts
+// the decoded header and text parts for matching BODY and TEXT criteria.
+func (s *mailboxSearch) loadText(sm *searchMessage) error {
+	if sm.isTextLoaded {
+		return nil
+	}
+
+	structure, bodyReader, err := s.im.getBodyAndStructure(sm.storeMessage, nil)
+	if err != nil {
+		return err
+	}
+	if structure == nil {
+		return errors.New("missing body structure")
+	}
+
+	header, err := structure.GetMailHeaderBytes(bodyReader)
+	if err != nil {
+		return errors.Wrap(err, "failed to get header")
+	}
+	if _, err := bodyReader.Seek(0, 0); err != nil {
+		return err
+	}
+	body, err := getSearchableBody(bodyReader)
+	if err != nil {
+		return err
+	}
+
+	sm.header = decodeSearchableHeader(header)
+	sm.body = body
+	sm.isTextLoaded = true
+	return nil
+}
+
+func (sm *searchMessage) getFlags() map[string]bool {
+	if sm.flags != nil {
+		return sm.flags
+	}
+
+	m := sm.storeMessage.Message()
+	sm.flags = make(map[string]bool)
+	if isStringInList(m.LabelIDs, pmapi.StarredLabel) {
+		sm.flags[imap.FlaggedFlag] = true
+	}
+	if !m.Unread {
+		sm.flags[imap.SeenFlag] = true
+	}
+	if m.Has(pmapi.FlagReplied) || m.Has(pmapi.FlagRepliedAll) {
+		sm.flags[imap.AnsweredFlag] = true
+	}
+	if m.Has(pmapi.FlagSent) || m.Has(pmapi.FlagReceived) {
+		sm.flags[imap.DraftFlag] = true
+	}
+	if !m.Has(pmapi.FlagOpened) {
+		sm.flags[imap.RecentFlag] = true
+	}
+	if sm.storeMessage.IsMarkedDeleted() {
+		sm.flags[imap.DeletedFlag] = true
+	}
+	return sm.flags
+}
+
+func matchHeader(m *pmapi.Message, header textproto.MIMEHeader, key, value string) bool {
+	switch key {
+	case "Subject":
+		return containsFold(m.Subject, value)
+	case "From":
+		return addressMatch([]*mail.Address{m.Sender}, value)
+	case "To":
+		return addressMatch(m.ToList, value)
+	case "Cc":
+		return addressMatch(m.CCList, value)
+	case "Bcc":
+		return addressMatch(m.BCCList, value)
+	default:
+		// Field has to be in header and value matched (case insensitive).
+		messageValue := header.Get(key)
+		return messageValue != "" && containsFold(messageValue, value)
+	}
+}
+
+// getSearchableBody returns the decoded content of all text parts of the
+// message literal converted to UTF-8.
+func getSearchableBody(literal *bytes.Reader) (string, error) {
+	p, err := parser.New(literal)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse message")
+	}
+
+	var body strings.Builder
+	if err := p.NewWalker().
+		RegisterContentTypeHandler("text/.*", func(p *parser.Part) error {
+			if err := p.ConvertToUTF8(); err != nil {
+				log.WithError(err).Warn("Cannot convert text part to UTF-8")
+			}
+			body.Write(p.Body)
+			body.WriteString("\n")
+			return nil
+		}).
+		Walk(); err != nil {
+		return "", err
+	}
+
+	return body.String(), nil
+}
+
+func decodeSearchableHeader(header []byte) string {
+	decoded, err := pmmime.DecodeHeader(string(header))
+	if err != nil {
+		return string(header)
+	}
+	return decoded
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"net/mail"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/imap/sortthread"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/emersion/go-imap"
+)
+
+// SortMessages returns messages matching search criteria sorted by sort
+// criteria. The returned list must contain UIDs if uid is set to true, or
+// sequence numbers otherwise.
+func (im *imapMailbox) SortMessages(isUID bool, sortCriteria []sortthread.SortCriterion, searchCriteria *imap.SearchCriteria) ([]uint32, error) {
+	// Called from go-imap in goroutines - we need to handle panics for each function.
+	defer im.panicHandler.HandlePanic()
+
+	storeMessages, err := im.searchStoreMessages(searchCriteria)
+	if err != nil {
+		return nil, err
+	}
+
+	// Size is not known before the message is built, avoid building
+	// messages when it is not needed.
+	needsSize := false
+	for _, criterion := range sortCriteria {
+		if criterion.Field == sortthread.SortSize {
+			needsSize = true
+		}
+	}
+
+	messages := make([]*sortthread.SortMessage, 0, len(storeMessages))
+	for _, storeMessage := range storeMessages {
+		id, err := getMessageID(isUID, storeMessage)
+		if err != nil {
+			return nil, err
+		}
+
+		m := storeMessage.Message()
+		message := &sortthread.SortMessage{
+			ID:      id,
+			Arrival: time.Unix(m.Time, 0),
+			Date:    getMessageDate(storeMessage),
+			To:      m.ToList,
+			Cc:      m.CCList,
+			Subject: m.Subject,
+		}
+		if m.Sender != nil {
+			message.From = []*mail.Address{m.Sender}
+		}
+		if needsSize {
+			if message.Size, err = im.getSize(storeMessage); err != nil {
+				return nil, err
+			}
+		}
+
+		messages = append(messages, message)
+	}
+
+	return sortthread.Sort(messages, sortCriteria), nil
+}
+
+// ThreadMessages returns threads of messages matching search criteria.
+// Threads contain UIDs if uid is set to true, or sequence numbers otherwise.
+func (im *imapMailbox) ThreadMessages(isUID bool, algorithm sortthread.ThreadAlgorithm, searchCriteria *imap.SearchCriteria) ([]*sortthread.Thread, error) {
+	// Called from go-imap in goroutines - we need to handle panics for each function.
+	defer im.panicHandler.HandlePanic()
+
+	storeMessages, err := im.searchStoreMessages(searchCriteria)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*sortthread.ThreadMessage, 0, len(storeMessages))
+	for _, storeMessage := range storeMessages {
+		id, err := getMessageID(isUID, storeMessage)
+		if err != nil {
+			return nil, err
+		}
+
+		m := storeMessage.Message()
+		header := storeMessage.GetMIMEHeader()
+
+		message := &sortthread.ThreadMessage{
+			ID:             id,
+			Subject:        m.Subject,
+			Date:           getMessageDate(storeMessage),
+			ConversationID: m.ConversationID,
+		}
+
+		// The same Message-Id is used when building the message.
+		if ids := sortthread.ParseMessageIDs(header.Get("Message-Id")); len(ids) != 0 {
+			message.MessageID = ids[0]
+		} else if m.ExternalID != "" {
+			message.MessageID = "<" + m.ExternalID + ">"
+		} else {
+			message.MessageID = "<" + m.ID + "@" + pmapi.InternalIDDomain + ">"
+		}
+
+		message.References = sortthread.ParseMessageIDs(header.Get("References"))
+		if len(message.References) == 0 {
+			if ids := sortthread.ParseMessageIDs(header.Get("In-Reply-To")); len(ids) != 0 {
+				message.References = ids[:1]
+			}
+		}
+
+		messages = append(messages, message)
+	}
+
+	return sortthread.Threads(algorithm, messages), nil
+}
+
+// getMessageDate returns the date from the Date header, or the internal date
+// if the header is missing or invalid.
+func getMessageDate(storeMessage storeMessageProvider) time.Time {
+	if date, err := mail.ParseDate(storeMessage.GetMIMEHeader().Get("Date")); err == nil {
+		return date
+	}
+	return time.Unix(storeMessage.Message().Time, 0)
+}
@@ -0,0 +1,387 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package metadata implements METADATA extension (RFC5464).
+//
+// Entries are provided by mailboxes, there is no storage of arbitrary
+// entries. Excluded parts are:
+// * Server entries: Server has no entries, all of them are reported as NIL
+//   and they cannot be set
+// * Unsolicited METADATA responses: Changes done by other clients are not
+//   announced
+package metadata
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/server"
+	"github.com/emersion/go-imap/utf7"
+)
+
+// Capability extension identifier.
+const Capability = "METADATA"
+
+// Entries provided by Bridge mailboxes.
+const (
+	ColorEntry      = "/private/color"
+	SpecialUseEntry = "/private/specialuse"
+)
+
+const (
+	getMetadataCommand = "GETMETADATA"
+	setMetadataCommand = "SETMETADATA"
+	metadataResponse   = "METADATA"
+
+	optionMaxSize = "MAXSIZE"
+	optionDepth   = "DEPTH"
+
+	depthInfinity = -1
+
+	codeMetadata = "METADATA"
+	longEntries  = "LONGENTRIES"
+
+	privatePrefix = "/private/"
+	sharedPrefix  = "/shared/"
+)
+
+// ErrReadOnly is returned when the entry cannot be set.
+var ErrReadOnly = errors.New("entry cannot be set")
+
+// Mailbox is a mailbox supporting METADATA.
+type Mailbox interface {
+	// GetMetadata returns all entries of the mailbox which have a value.
+	GetMetadata() (map[string]string, error)
+
+	// SetMetadata sets the value of the entry, nil value removes it.
+	// ErrReadOnly is returned for entries which cannot be changed.
+	SetMetadata(entry string, value *string) error
+}
+
+// parseEntry returns the entry name in lower case or error if the name is
+// not valid.
+func parseEntry(f interface{}) (string, error) {
+	entry, err := imap.ParseString(f)
+	if err != nil {
+		return "", err
+	}
+
+	// Top-level entries can be used only to get their sub-entries by DEPTH.
+	entry = strings.ToLower(entry)
+	if entry+"/" == privatePrefix || entry+"/" == sharedPrefix {
+		return entry, nil
+	}
+	if !strings.HasPrefix(entry, privatePrefix) && !strings.HasPrefix(entry, sharedPrefix) {
+		return "", errors.New("entry must start with /private/ or /shared/")
+	}
+	if strings.HasSuffix(entry, "/") || strings.Contains(entry, "//") || strings.ContainsAny(entry, "*%") {
+		return "", errors.New("invalid entry name " + entry)
+	}
+
+	return entry, nil
+}
+
+func parseMailbox(f interface{}) (string, error) {
+	name, err := imap.ParseString(f)
+	if err != nil {
+		return "", err
+	}
+
+	if name, err = utf7.Encoding.NewDecoder().String(name); err != nil {
+		return "", err
+	}
+
+	return imap.CanonicalMailboxName(name), nil
+}
+
+// getMailbox returns nil mailbox for the empty name which means the server.
+func getMailbox(conn server.Conn, name string) (Mailbox, error) {
+	ctx := conn.Context()
+	if ctx.User == nil {
+		return nil, server.ErrNotAuthenticated
+	}
+
+	if name == "" {
+		return nil, nil
+	}
+
+	mailbox, err := ctx.User.GetMailbox(name)
+	if err != nil {
+		return nil, err
+	}
+
+	metadataMailbox, ok := mailbox.(Mailbox)
+	if !ok {
+		return nil, errors.New("metadata are not supported by mailbox")
+	}
+	return metadataMailbox, nil
+}
+
+// GetHandler handles GETMETADATA command.
+type GetHandler struct {
+	Mailbox string
+	Entries []string
+	MaxSize int
+	Depth   int
+}
+
+// Parse options, mailbox name and entries.
+func (h *GetHandler) Parse(fields []interface{}) (err error) {
+	h.MaxSize = -1
+
+	if len(fields) > 0 {
+		if options, ok := fields[0].([]interface{}); ok {
+			if err := h.parseOptions(options); err != nil {
+				return err
+			}
+			fields = fields[1:]
+		}
+	}
+
+	if len(fields) != 2 {
+		return errors.New("mailbox and entries are expected")
+	}
+
+	if h.Mailbox, err = parseMailbox(fields[0]); err != nil {
+		return err
+	}
+
+	entries, ok := fields[1].([]interface{})
+	if !ok {
+		entries = []interface{}{fields[1]}
+	}
+	if len(entries) == 0 {
+		return errors.New("no entries")
+	}
+
+	for _, f := range entries {
+		entry, err := parseEntry(f)
+		if err != nil {
+			return err
+		}
+		h.Entries = append(h.Entries, entry)
+	}
+
+	return nil
+}
+
+func (h *GetHandler) parseOptions(options []interface{}) error {
+	if len(options)%2 != 0 {
+		return errors.New("option without value")
+	}
+
+	for i := 0; i < len(options); i += 2 {
+		name, err := imap.ParseString(options[i])
+		if err != nil {
+			return err
+		}
+		value, err := imap.ParseString(options[i+1])
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToUpper(name) {
+		case optionMaxSize:
+			if h.MaxSize, err = strconv.Atoi(value); err != nil || h.MaxSize < 0 {
+				return errors.New("invalid MAXSIZE")
+			}
+		case optionDepth:
+			switch strings.ToLower(value) {
+			case "0":
+				h.Depth = 0
+			case "1":
+				h.Depth = 1
+			case "infinity":
+				h.Depth = depthInfinity
+			default:
+				return errors.New("invalid DEPTH")
+			}
+		default:
+			return errors.New("unknown option " + name)
+		}
+	}
+
+	return nil
+}
+
+// isMatch returns whether the entry was requested directly or by DEPTH.
+func (h *GetHandler) isMatch(requested, entry string) bool {
+	if requested == entry {
+		return true
+	}
+	if h.Depth == 0 || !strings.HasPrefix(entry, requested+"/") {
+		return false
+	}
+	return h.Depth == depthInfinity || !strings.Contains(entry[len(requested)+1:], "/")
+}
+
+// Handle the GETMETADATA request.
+func (h *GetHandler) Handle(conn server.Conn) error {
+	mailbox, err := getMailbox(conn, h.Mailbox)
+	if err != nil {
+		return err
+	}
+
+	values := map[string]string{}
+	if mailbox != nil {
+		if values, err = mailbox.GetMetadata(); err != nil {
+			return err
+		}
+	}
+
+	entries := make([]string, 0, len(values))
+	for entry := range values {
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+
+	attrs := []interface{}{}
+	longest := 0
+	for _, requested := range h.Entries {
+		found := false
+		for _, entry := range entries {
+			if !h.isMatch(requested, entry) {
+				continue
+			}
+			found = true
+			value := values[entry]
+			if h.MaxSize >= 0 && len(value) > h.MaxSize {
+				if len(value) > longest {
+					longest = len(value)
+				}
+				continue
+			}
+			attrs = append(attrs, imap.RawString(entry), value)
+		}
+		if !found {
+			attrs = append(attrs, imap.RawString(requested), nil)
+		}
+	}
+
+	if len(attrs) != 0 {
+		name, err := utf7.Encoding.NewEncoder().String(h.Mailbox)
+		if err != nil {
+			return err
+		}
+
+		if err := conn.WriteResp(imap.NewUntaggedResp([]interface{}{imap.RawString(metadataResponse), name, attrs})); err != nil {
+			return err
+		}
+	}
+
+	if longest == 0 {
+		return nil
+	}
+
+	return &imap.ErrStatusResp{Resp: &imap.StatusResp{
+		Type:      imap.StatusRespOk,
+		Code:      codeMetadata,
+		Arguments: []interface{}{imap.RawString(longEntries), uint32(longest)},
+		Info:      "GETMETADATA completed",
+	}}
+}
+
+// SetHandler handles SETMETADATA command.
+type SetHandler struct {
+	Mailbox string
+	Entries []string
+	Values  []*string
+}
+
+// Parse mailbox name and list of entries with values.
+func (h *SetHandler) Parse(fields []interface{}) (err error) {
+	if len(fields) != 2 {
+		return errors.New("mailbox and entries are expected")
+	}
+
+	if h.Mailbox, err = parseMailbox(fields[0]); err != nil {
+		return err
+	}
+
+	list, ok := fields[1].([]interface{})
+	if !ok || len(list) == 0 || len(list)%2 != 0 {
+		return errors.New("list of entries and values is expected")
+	}
+
+	for i := 0; i < len(list); i += 2 {
+		entry, err := parseEntry(list[i])
+		if err != nil {
+			return err
+		}
+
+		var value *string
+		if list[i+1] != nil {
+			s, err := imap.ParseString(list[i+1])
+			if err != nil {
+				return err
+			}
+			value = &s
+		}
+
+		h.Entries = append(h.Entries, entry)
+		h.Values = append(h.Values, value)
+	}
+
+	return nil
+}
+
+// Handle the SETMETADATA request.
+func (h *SetHandler) Handle(conn server.Conn) error {
+	mailbox, err := getMailbox(conn, h.Mailbox)
+	if err != nil {
+		return err
+	}
+	if mailbox == nil {
+		return errors.New("server entries cannot be set")
+	}
+
+	for i, entry := range h.Entries {
+		if err := mailbox.SetMetadata(entry, h.Values[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type extension struct{}
+
+// NewExtension of METADATA.
+func NewExtension() server.Extension {
+	return &extension{}
+}
+
+func (ext *extension) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState != 0 {
+		return []string{Capability}
+	}
+	return nil
+}
+
+func (ext *extension) Command(name string) server.HandlerFactory {
+	switch name {
+	case getMetadataCommand:
+		return func() server.Handler { return &GetHandler{} }
+	case setMetadataCommand:
+		return func() server.Handler { return &SetHandler{} }
+	}
+
+	return nil
+}
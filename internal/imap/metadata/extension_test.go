@@ -0,0 +1,81 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package metadata
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGetMetadata(t *testing.T) {
+	h := &GetHandler{}
+	require.NoError(t, h.Parse([]interface{}{"INBOX", "/Private/Color"}))
+	assert.Equal(t, "INBOX", h.Mailbox)
+	assert.Equal(t, []string{ColorEntry}, h.Entries)
+	assert.Equal(t, -1, h.MaxSize)
+	assert.Equal(t, 0, h.Depth)
+
+	h = &GetHandler{}
+	require.NoError(t, h.Parse([]interface{}{
+		[]interface{}{"MAXSIZE", "1024", "DEPTH", "infinity"},
+		"",
+		[]interface{}{"/private", "/shared/comment"},
+	}))
+	assert.Equal(t, "", h.Mailbox)
+	assert.Equal(t, []string{"/private", "/shared/comment"}, h.Entries)
+	assert.Equal(t, 1024, h.MaxSize)
+	assert.Equal(t, depthInfinity, h.Depth)
+}
+
+func TestParseInvalidGetMetadata(t *testing.T) {
+	assert.Error(t, (&GetHandler{}).Parse([]interface{}{"INBOX"}))
+	assert.Error(t, (&GetHandler{}).Parse([]interface{}{"INBOX", "/color"}))
+	assert.Error(t, (&GetHandler{}).Parse([]interface{}{"INBOX", "/private/color/"}))
+	assert.Error(t, (&GetHandler{}).Parse([]interface{}{"INBOX", "/private/*"}))
+	assert.Error(t, (&GetHandler{}).Parse([]interface{}{[]interface{}{"DEPTH", "2"}, "INBOX", ColorEntry}))
+	assert.Error(t, (&GetHandler{}).Parse([]interface{}{[]interface{}{"MAXSIZE"}, "INBOX", ColorEntry}))
+}
+
+func TestParseSetMetadata(t *testing.T) {
+	h := &SetHandler{}
+	require.NoError(t, h.Parse([]interface{}{"Folders/mbox", []interface{}{ColorEntry, "#7272a7", "/private/comment", nil}}))
+	assert.Equal(t, "Folders/mbox", h.Mailbox)
+	assert.Equal(t, []string{ColorEntry, "/private/comment"}, h.Entries)
+	require.Len(t, h.Values, 2)
+	assert.Equal(t, "#7272a7", *h.Values[0])
+	assert.Nil(t, h.Values[1])
+
+	assert.Error(t, (&SetHandler{}).Parse([]interface{}{"INBOX", []interface{}{ColorEntry}}))
+	assert.Error(t, (&SetHandler{}).Parse([]interface{}{"INBOX", ColorEntry, "#7272a7"}))
+}
+
+func TestIsMatch(t *testing.T) {
+	h := &GetHandler{}
+	assert.True(t, h.isMatch(ColorEntry, ColorEntry))
+	assert.False(t, h.isMatch("/private", ColorEntry))
+
+	h.Depth = 1
+	assert.True(t, h.isMatch("/private", ColorEntry))
+	assert.False(t, h.isMatch("/private", "/private/vendor/color"))
+	assert.False(t, h.isMatch("/private/col", ColorEntry))
+
+	h.Depth = depthInfinity
+	assert.True(t, h.isMatch("/private", "/private/vendor/color"))
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package namespace implements NAMESPACE extension (RFC2342).
+//
+// Namespaces are provided by the logged in user. Users without namespaces
+// have only the personal namespace with empty prefix.
+package namespace
+
+import (
+	"errors"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/server"
+)
+
+// Capability extension identifier.
+const Capability = "NAMESPACE"
+
+const namespaceCommand = "NAMESPACE"
+
+// defaultDelimiter is used by the personal namespace of users which do not
+// provide namespaces.
+const defaultDelimiter = "/"
+
+// Namespace is a prefix of mailbox names with its hierarchy delimiter.
+type Namespace struct {
+	Prefix    string
+	Delimiter string
+}
+
+// User is a user supporting NAMESPACE.
+type User interface {
+	// Namespaces returns personal namespaces, namespaces of other users,
+	// and shared namespaces of the user.
+	Namespaces() (personal, other, shared []Namespace)
+}
+
+// format returns namespaces in the form of NAMESPACE response, i.e., NIL
+// for no namespace or list of prefixes with delimiters.
+func format(namespaces []Namespace) interface{} {
+	if len(namespaces) == 0 {
+		return nil
+	}
+
+	list := make([]interface{}, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		list = append(list, []interface{}{namespace.Prefix, namespace.Delimiter})
+	}
+	return list
+}
+
+// Handler handles NAMESPACE command.
+type Handler struct{}
+
+// Parse checks there are no arguments.
+func (h *Handler) Parse(fields []interface{}) error {
+	if len(fields) != 0 {
+		return errors.New("no arguments expected")
+	}
+	return nil
+}
+
+// Handle the NAMESPACE request.
+func (h *Handler) Handle(conn server.Conn) error {
+	ctx := conn.Context()
+	if ctx.User == nil {
+		return server.ErrNotAuthenticated
+	}
+
+	personal := []Namespace{{Prefix: "", Delimiter: defaultDelimiter}}
+	var other, shared []Namespace
+	if user, ok := ctx.User.(User); ok {
+		personal, other, shared = user.Namespaces()
+	}
+
+	return conn.WriteResp(imap.NewUntaggedResp([]interface{}{
+		imap.RawString(namespaceCommand),
+		format(personal),
+		format(other),
+		format(shared),
+	}))
+}
+
+type extension struct{}
+
+// NewExtension of NAMESPACE.
+func NewExtension() server.Extension {
+	return &extension{}
+}
+
+func (ext *extension) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState != 0 {
+		return []string{Capability}
+	}
+	return nil
+}
+
+func (ext *extension) Command(name string) server.HandlerFactory {
+	if name == namespaceCommand {
+		return func() server.Handler { return &Handler{} }
+	}
+
+	return nil
+}
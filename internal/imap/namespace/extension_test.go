@@ -0,0 +1,40 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package namespace
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	assert.NoError(t, (&Handler{}).Parse([]interface{}{}))
+	assert.Error(t, (&Handler{}).Parse([]interface{}{"INBOX"}))
+}
+
+func TestFormat(t *testing.T) {
+	assert.Nil(t, format(nil))
+	assert.Equal(t, []interface{}{
+		[]interface{}{"", "/"},
+	}, format([]Namespace{{Prefix: "", Delimiter: "/"}}))
+	assert.Equal(t, []interface{}{
+		[]interface{}{"Other Users/", "/"},
+		[]interface{}{"#shared/", "/"},
+	}, format([]Namespace{{Prefix: "Other Users/", Delimiter: "/"}, {Prefix: "#shared/", Delimiter: "/"}}))
+}
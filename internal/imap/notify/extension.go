@@ -0,0 +1,668 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package notify DOES NOT implement full RFC5465!
+//
+// Changes of mailboxes which are not selected are reported by STATUS
+// responses. Excluded parts are:
+// * Events of the selected mailbox: They are always sent the same way as
+//   without NOTIFY, i.e., SELECTED and SELECTED-DELAYED filters are accepted
+//   but they do not change anything
+// * MailboxName event: New mailboxes are announced by LIST to all
+//   connections even without NOTIFY, renames and deletions are not announced,
+//   so the event is rejected with BADEVENT
+// * Fetch attributes of MessageNew event: Only STATUS is sent for
+//   non-selected mailboxes, and the selected mailbox is not affected, so
+//   NOTIFY with fetch attributes is rejected
+// * SubscriptionChange, AnnotationChange and metadata events are not
+//   supported
+package notify
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-imap/server"
+	"github.com/emersion/go-imap/utf7"
+)
+
+// Capability extension identifier.
+const Capability = "NOTIFY"
+
+// Supported events.
+const (
+	MessageNew     = "MessageNew"
+	MessageExpunge = "MessageExpunge"
+	FlagChange     = "FlagChange"
+)
+
+const (
+	notifyCommand = "NOTIFY"
+
+	setKey    = "SET"
+	noneKey   = "NONE"
+	statusKey = "STATUS"
+
+	filterSelected        = "SELECTED"
+	filterSelectedDelayed = "SELECTED-DELAYED"
+	filterInboxes         = "INBOXES"
+	filterPersonal        = "PERSONAL"
+	filterSubscribed      = "SUBSCRIBED"
+	filterSubtree         = "SUBTREE"
+	filterMailboxes       = "MAILBOXES"
+
+	codeBadEvent = "BADEVENT"
+
+	inbox = "INBOX"
+)
+
+// statusDelay is the time for which changes of the same mailbox are
+// collected and reported by one STATUS response.
+const statusDelay = 200 * time.Millisecond
+
+// statusItems are sent in STATUS responses of non-selected mailboxes.
+var statusItems = []imap.StatusItem{ //nolint[gochecknoglobals]
+	imap.StatusMessages,
+	imap.StatusUidNext,
+	imap.StatusUidValidity,
+	imap.StatusUnseen,
+}
+
+// canonicalEvents maps upper-case event names to supported events.
+var canonicalEvents = map[string]string{ //nolint[gochecknoglobals]
+	strings.ToUpper(MessageNew):     MessageNew,
+	strings.ToUpper(MessageExpunge): MessageExpunge,
+	strings.ToUpper(FlagChange):     FlagChange,
+}
+
+// errBadEvent is returned when the client asks for an unsupported event.
+var errBadEvent = &imap.ErrStatusResp{Resp: &imap.StatusResp{ //nolint[gochecknoglobals]
+	Type: imap.StatusRespNo,
+	Code: codeBadEvent,
+	Arguments: []interface{}{[]interface{}{
+		imap.RawString(MessageNew),
+		imap.RawString(MessageExpunge),
+		imap.RawString(FlagChange),
+	}},
+	Info: "Unsupported event",
+}}
+
+// errFetchAttributes is returned when the client asks for fetch attributes
+// of new messages.
+var errFetchAttributes = &imap.ErrStatusResp{Resp: &imap.StatusResp{ //nolint[gochecknoglobals]
+	Type: imap.StatusRespNo,
+	Info: "Fetch attributes of MessageNew are not supported",
+}}
+
+// eventGroup is a set of events requested for mailboxes matching a filter.
+type eventGroup struct {
+	filter    string
+	mailboxes []string
+	events    map[string]bool
+
+	hasUnsupportedEvent bool
+	hasFetchAttributes  bool
+}
+
+func (g *eventGroup) hasMessageEvents() bool {
+	return g.events[MessageNew] || g.events[MessageExpunge] || g.events[FlagChange]
+}
+
+// isMatch returns whether the mailbox is matched by the filter of the group.
+// Selected filters never match because the selected mailbox is not affected.
+func (g *eventGroup) isMatch(name, delimiter string, isSubscribed func(string) bool) bool {
+	switch g.filter {
+	case filterInboxes:
+		return name == inbox
+	case filterPersonal:
+		return true
+	case filterSubscribed:
+		return isSubscribed(name)
+	case filterSubtree:
+		for _, mailbox := range g.mailboxes {
+			if name == mailbox || strings.HasPrefix(name, mailbox+delimiter) {
+				return true
+			}
+		}
+	case filterMailboxes:
+		for _, mailbox := range g.mailboxes {
+			if name == mailbox {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// connState holds event groups set by NOTIFY and mailboxes with changes
+// which were not reported yet. The context of the connection is changed by
+// the goroutine serving the connection, so handlers running there copy what
+// is needed to the state, and other goroutines use only the copy.
+type connState struct {
+	groups    []*eventGroup
+	user      backend.User
+	selected  string
+	responses chan<- imap.WriterTo
+
+	pending   []string
+	isPending map[string]bool
+	wake      chan struct{}
+}
+
+// update copies the user and the selected mailbox from the context.
+// It must be called by the goroutine serving the connection with extension
+// lock held.
+func (state *connState) update(ctx *server.Context) {
+	state.user = ctx.User
+	state.responses = ctx.Responses
+
+	if ctx.Mailbox != nil {
+		state.selected = ctx.Mailbox.Name()
+	} else {
+		state.selected = ""
+	}
+}
+
+// getGroup returns the first group matching the mailbox, or nil.
+func getGroup(user backend.User, groups []*eventGroup, mailbox backend.Mailbox) (*eventGroup, error) {
+	if len(groups) == 0 {
+		return nil, nil
+	}
+
+	info, err := mailbox.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	var subscribed map[string]bool
+	isSubscribed := func(name string) bool {
+		if subscribed == nil {
+			subscribed = map[string]bool{}
+			if mailboxes, err := user.ListMailboxes(true); err == nil {
+				for _, mailbox := range mailboxes {
+					subscribed[mailbox.Name()] = true
+				}
+			}
+		}
+		return subscribed[name]
+	}
+
+	for _, group := range groups {
+		if group.isMatch(info.Name, info.Delimiter, isSubscribed) {
+			return group, nil
+		}
+	}
+	return nil, nil
+}
+
+// Handler handles NOTIFY command.
+type Handler struct {
+	ext *extension
+
+	Status bool
+	Groups []*eventGroup
+}
+
+// Parse NOTIFY NONE or NOTIFY SET with event groups.
+func (h *Handler) Parse(fields []interface{}) error {
+	if len(fields) == 0 {
+		return errors.New("not enough arguments")
+	}
+
+	key, err := imap.ParseString(fields[0])
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToUpper(key) {
+	case noneKey:
+		if len(fields) != 1 {
+			return errors.New("NOTIFY NONE has no arguments")
+		}
+		return nil
+	case setKey:
+	default:
+		return errors.New("unknown NOTIFY argument " + key)
+	}
+
+	fields = fields[1:]
+	if len(fields) > 0 {
+		if status, ok := fields[0].(string); ok && strings.ToUpper(status) == statusKey {
+			h.Status = true
+			fields = fields[1:]
+		}
+	}
+
+	if len(fields) == 0 {
+		return errors.New("no event groups")
+	}
+
+	for _, f := range fields {
+		group, err := parseEventGroup(f)
+		if err != nil {
+			return err
+		}
+		h.Groups = append(h.Groups, group)
+	}
+
+	return nil
+}
+
+func parseEventGroup(f interface{}) (*eventGroup, error) {
+	fields, ok := f.([]interface{})
+	if !ok || len(fields) < 2 {
+		return nil, errors.New("event group must be a list of filter and events")
+	}
+
+	filter, err := imap.ParseString(fields[0])
+	if err != nil {
+		return nil, err
+	}
+
+	group := &eventGroup{filter: strings.ToUpper(filter), events: map[string]bool{}}
+
+	switch group.filter {
+	case filterSelected, filterSelectedDelayed, filterInboxes, filterPersonal, filterSubscribed:
+		fields = fields[1:]
+	case filterSubtree, filterMailboxes:
+		if len(fields) < 3 {
+			return nil, errors.New("missing mailboxes")
+		}
+		if group.mailboxes, err = parseMailboxes(fields[1]); err != nil {
+			return nil, err
+		}
+		fields = fields[2:]
+	default:
+		return nil, errors.New("unknown filter " + filter)
+	}
+
+	if len(fields) != 1 {
+		return nil, errors.New("events must be a list or NONE")
+	}
+
+	if err := group.parseEvents(fields[0]); err != nil {
+		return nil, err
+	}
+
+	return group, nil
+}
+
+func parseMailboxes(f interface{}) ([]string, error) {
+	fields, ok := f.([]interface{})
+	if !ok {
+		fields = []interface{}{f}
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("no mailboxes")
+	}
+
+	mailboxes := []string{}
+	for _, f := range fields {
+		name, err := imap.ParseString(f)
+		if err != nil {
+			return nil, err
+		}
+		if name, err = utf7.Encoding.NewDecoder().String(name); err != nil {
+			return nil, err
+		}
+		mailboxes = append(mailboxes, imap.CanonicalMailboxName(name))
+	}
+	return mailboxes, nil
+}
+
+func (g *eventGroup) parseEvents(f interface{}) error {
+	if none, ok := f.(string); ok && strings.ToUpper(none) == noneKey {
+		return nil
+	}
+
+	fields, ok := f.([]interface{})
+	if !ok || len(fields) == 0 {
+		return errors.New("events must be a list or NONE")
+	}
+
+	for i, f := range fields {
+		// Fetch attributes of MessageNew are not a syntax error, NO response
+		// is returned when handling the command.
+		if _, ok := f.([]interface{}); ok && i > 0 && g.events[MessageNew] {
+			g.hasFetchAttributes = true
+			continue
+		}
+
+		name, err := imap.ParseString(f)
+		if err != nil {
+			return err
+		}
+
+		// Unsupported event is not a syntax error, NO response with
+		// BADEVENT code is returned when handling the command.
+		event, ok := canonicalEvents[strings.ToUpper(name)]
+		if !ok {
+			g.hasUnsupportedEvent = true
+			continue
+		}
+		g.events[event] = true
+	}
+
+	if g.events[MessageNew] != g.events[MessageExpunge] {
+		return errors.New("MessageNew and MessageExpunge must be used together")
+	}
+	if g.events[FlagChange] && !g.events[MessageNew] {
+		return errors.New("FlagChange requires MessageNew and MessageExpunge")
+	}
+
+	return nil
+}
+
+// Handle the NOTIFY request.
+func (h *Handler) Handle(conn server.Conn) error {
+	ctx := conn.Context()
+	if ctx.User == nil {
+		return server.ErrNotAuthenticated
+	}
+
+	for _, group := range h.Groups {
+		if group.hasUnsupportedEvent {
+			return errBadEvent
+		}
+		if group.hasFetchAttributes {
+			return errFetchAttributes
+		}
+	}
+
+	state := h.ext.getState(conn)
+	if state == nil {
+		return errors.New("NOTIFY is not available")
+	}
+
+	h.ext.lock.Lock()
+	state.groups = h.Groups
+	state.update(ctx)
+	h.ext.lock.Unlock()
+
+	if !h.Status {
+		return nil
+	}
+
+	mailboxes, err := ctx.User.ListMailboxes(false)
+	if err != nil {
+		return err
+	}
+
+	for _, mailbox := range mailboxes {
+		if ctx.Mailbox != nil && ctx.Mailbox.Name() == mailbox.Name() {
+			continue
+		}
+
+		// Status of non-selectable mailboxes cannot be requested.
+		info, err := mailbox.Info()
+		if err != nil {
+			return err
+		}
+		if isNoSelect(info) {
+			continue
+		}
+
+		group, err := getGroup(ctx.User, h.Groups, mailbox)
+		if err != nil {
+			return err
+		}
+		if group == nil || !group.hasMessageEvents() {
+			continue
+		}
+
+		status, err := mailbox.Status(statusItems)
+		if err != nil {
+			return err
+		}
+		if err := conn.WriteResp(&responses.Status{Mailbox: status}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isNoSelect(info *imap.MailboxInfo) bool {
+	for _, attr := range info.Attributes {
+		if attr == imap.NoSelectAttr {
+			return true
+		}
+	}
+	return false
+}
+
+// Extension of NOTIFY which has to get all updates of the backend,
+// including updates of mailboxes which are not selected.
+type Extension interface {
+	server.Extension
+	server.ConnExtension
+
+	// Notify must not block.
+	Notify(update backend.Update)
+}
+
+type extension struct {
+	next func(string) server.HandlerFactory
+
+	lock   sync.Mutex
+	states map[*server.Context]*connState
+}
+
+// NewExtension of NOTIFY. Commands changing the selected mailbox are taken
+// from next and wrapped to keep track of the selected mailbox, so the
+// extension has to be enabled before the extensions providing them.
+func NewExtension(next func(string) server.HandlerFactory) Extension {
+	return &extension{
+		next:   next,
+		states: map[*server.Context]*connState{},
+	}
+}
+
+func (ext *extension) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState != 0 {
+		return []string{Capability}
+	}
+	return nil
+}
+
+func (ext *extension) Command(name string) server.HandlerFactory {
+	switch name {
+	case notifyCommand:
+		return func() server.Handler {
+			return &Handler{ext: ext}
+		}
+	case "SELECT", "EXAMINE", "CLOSE", "UNSELECT":
+		newHandler := ext.next(name)
+		if newHandler == nil {
+			return nil
+		}
+		return func() server.Handler {
+			return &selectionHandler{Handler: newHandler(), ext: ext}
+		}
+	}
+
+	return nil
+}
+
+// selectionHandler updates the state of the connection after the command
+// which changes the selected mailbox.
+type selectionHandler struct {
+	server.Handler
+
+	ext *extension
+}
+
+func (h *selectionHandler) Handle(conn server.Conn) error {
+	err := h.Handler.Handle(conn)
+
+	if state := h.ext.getState(conn); state != nil {
+		h.ext.lock.Lock()
+		state.update(conn.Context())
+		h.ext.lock.Unlock()
+	}
+
+	return err
+}
+
+func (ext *extension) NewConn(c server.Conn) server.Conn {
+	ctx := c.Context()
+	state := &connState{
+		isPending: map[string]bool{},
+		wake:      make(chan struct{}, 1),
+	}
+
+	ext.lock.Lock()
+	ext.states[ctx] = state
+	ext.lock.Unlock()
+
+	go func() {
+		ext.sendStatuses(state, ctx.LoggedOut)
+
+		ext.lock.Lock()
+		delete(ext.states, ctx)
+		ext.lock.Unlock()
+	}()
+
+	return c
+}
+
+func (ext *extension) getState(conn server.Conn) *connState {
+	ext.lock.Lock()
+	defer ext.lock.Unlock()
+
+	return ext.states[conn.Context()]
+}
+
+// Notify schedules STATUS responses for connections which asked for events
+// of the updated mailbox. Updates of the selected mailbox are sent by
+// go-imap server.
+func (ext *extension) Notify(update backend.Update) {
+	mailboxName := update.Mailbox()
+	if mailboxName == "" {
+		return
+	}
+
+	ext.lock.Lock()
+	defer ext.lock.Unlock()
+
+	for _, state := range ext.states {
+		if len(state.groups) == 0 || state.isPending[mailboxName] {
+			continue
+		}
+		if state.user == nil || state.user.Username() != update.Username() {
+			continue
+		}
+		if state.selected == mailboxName {
+			continue
+		}
+
+		state.isPending[mailboxName] = true
+		state.pending = append(state.pending, mailboxName)
+
+		select {
+		case state.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// sendStatuses sends STATUS responses of pending mailboxes until the client
+// logs out. Changes of the same mailbox are collected for a while to be
+// reported by one response. Responses wait for the connection to take them,
+// so no change is lost when the connection is busy.
+func (ext *extension) sendStatuses(state *connState, loggedOut <-chan struct{}) {
+	for {
+		select {
+		case <-state.wake:
+		case <-loggedOut:
+			return
+		}
+
+		select {
+		case <-time.After(statusDelay):
+		case <-loggedOut:
+			return
+		}
+
+		for {
+			mailboxName, ok := ext.popPending(state)
+			if !ok {
+				break
+			}
+
+			ch, res := ext.getStatus(state, mailboxName)
+			if res == nil {
+				continue
+			}
+
+			select {
+			case ch <- res:
+			case <-loggedOut:
+				return
+			}
+		}
+	}
+}
+
+func (ext *extension) popPending(state *connState) (string, bool) {
+	ext.lock.Lock()
+	defer ext.lock.Unlock()
+
+	if len(state.pending) == 0 {
+		return "", false
+	}
+
+	mailboxName := state.pending[0]
+	state.pending = state.pending[1:]
+	delete(state.isPending, mailboxName)
+
+	return mailboxName, true
+}
+
+// getStatus returns the STATUS response of the mailbox together with the
+// channel to send it to, or nil when the mailbox should not be reported.
+func (ext *extension) getStatus(state *connState, mailboxName string) (chan<- imap.WriterTo, imap.WriterTo) {
+	ext.lock.Lock()
+	user, groups, selected, ch := state.user, state.groups, state.selected, state.responses
+	ext.lock.Unlock()
+
+	if user == nil || ch == nil || selected == mailboxName {
+		return nil, nil
+	}
+
+	mailbox, err := user.GetMailbox(mailboxName)
+	if err != nil {
+		return nil, nil
+	}
+
+	group, err := getGroup(user, groups, mailbox)
+	if err != nil || group == nil || !group.hasMessageEvents() {
+		return nil, nil
+	}
+
+	status, err := mailbox.Status(statusItems)
+	if err != nil {
+		return nil, nil
+	}
+
+	return ch, &responses.Status{Mailbox: status}
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-imap/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNotifyNone(t *testing.T) {
+	h := &Handler{}
+	require.NoError(t, h.Parse([]interface{}{"NONE"}))
+	assert.False(t, h.Status)
+	assert.Empty(t, h.Groups)
+
+	assert.Error(t, (&Handler{}).Parse([]interface{}{"NONE", "STATUS"}))
+}
+
+func TestParseNotifySet(t *testing.T) {
+	h := &Handler{}
+	require.NoError(t, h.Parse([]interface{}{
+		"SET", "STATUS",
+		[]interface{}{"selected", []interface{}{"MessageNew", "MessageExpunge"}},
+		[]interface{}{"SUBTREE", []interface{}{"Folders"}, []interface{}{"messagenew", "messageexpunge", "flagchange"}},
+		[]interface{}{"MAILBOXES", "INBOX", "NONE"},
+	}))
+	assert.True(t, h.Status)
+	require.Len(t, h.Groups, 3)
+
+	assert.Equal(t, filterSelected, h.Groups[0].filter)
+	assert.Equal(t, map[string]bool{MessageNew: true, MessageExpunge: true}, h.Groups[0].events)
+
+	assert.Equal(t, filterSubtree, h.Groups[1].filter)
+	assert.Equal(t, []string{"Folders"}, h.Groups[1].mailboxes)
+	assert.True(t, h.Groups[1].hasMessageEvents())
+
+	assert.Equal(t, filterMailboxes, h.Groups[2].filter)
+	assert.Equal(t, []string{"INBOX"}, h.Groups[2].mailboxes)
+	assert.False(t, h.Groups[2].hasMessageEvents())
+}
+
+func TestParseNotifyUnsupportedEvent(t *testing.T) {
+	h := &Handler{}
+	require.NoError(t, h.Parse([]interface{}{
+		"SET", []interface{}{"PERSONAL", []interface{}{"MessageNew", "MessageExpunge", "AnnotationChange"}},
+	}))
+	require.Len(t, h.Groups, 1)
+	assert.True(t, h.Groups[0].hasUnsupportedEvent)
+}
+
+func TestParseNotifyMailboxName(t *testing.T) {
+	h := &Handler{}
+	require.NoError(t, h.Parse([]interface{}{
+		"SET", []interface{}{"PERSONAL", []interface{}{"MailboxName"}},
+	}))
+	require.Len(t, h.Groups, 1)
+	assert.True(t, h.Groups[0].hasUnsupportedEvent)
+}
+
+func TestParseNotifyFetchAttributes(t *testing.T) {
+	h := &Handler{}
+	require.NoError(t, h.Parse([]interface{}{
+		"SET", []interface{}{"SELECTED", []interface{}{"MessageNew", []interface{}{"UID", "FLAGS"}, "MessageExpunge"}},
+	}))
+	require.Len(t, h.Groups, 1)
+	assert.True(t, h.Groups[0].hasFetchAttributes)
+	assert.True(t, h.Groups[0].hasMessageEvents())
+}
+
+func TestParseInvalidNotify(t *testing.T) {
+	assert.Error(t, (&Handler{}).Parse([]interface{}{}))
+	assert.Error(t, (&Handler{}).Parse([]interface{}{"SET"}))
+	assert.Error(t, (&Handler{}).Parse([]interface{}{"SET", "STATUS"}))
+	assert.Error(t, (&Handler{}).Parse([]interface{}{"SET", []interface{}{"UNKNOWN", "NONE"}}))
+	assert.Error(t, (&Handler{}).Parse([]interface{}{"SET", []interface{}{"SUBTREE", "NONE"}}))
+	assert.Error(t, (&Handler{}).Parse([]interface{}{"SET", []interface{}{"PERSONAL", []interface{}{"MessageNew"}}}))
+	assert.Error(t, (&Handler{}).Parse([]interface{}{"SET", []interface{}{"PERSONAL", []interface{}{"FlagChange"}}}))
+}
+
+func TestEventGroupIsMatch(t *testing.T) {
+	isSubscribed := func(name string) bool { return name == "Folders/mbox" }
+
+	personal := &eventGroup{filter: filterPersonal}
+	assert.True(t, personal.isMatch("INBOX", "/", isSubscribed))
+	assert.True(t, personal.isMatch("Folders/mbox", "/", isSubscribed))
+
+	inboxes := &eventGroup{filter: filterInboxes}
+	assert.True(t, inboxes.isMatch("INBOX", "/", isSubscribed))
+	assert.False(t, inboxes.isMatch("Archive", "/", isSubscribed))
+
+	subscribed := &eventGroup{filter: filterSubscribed}
+	assert.True(t, subscribed.isMatch("Folders/mbox", "/", isSubscribed))
+	assert.False(t, subscribed.isMatch("Folders/other", "/", isSubscribed))
+
+	subtree := &eventGroup{filter: filterSubtree, mailboxes: []string{"Folders"}}
+	assert.True(t, subtree.isMatch("Folders", "/", isSubscribed))
+	assert.True(t, subtree.isMatch("Folders/mbox", "/", isSubscribed))
+	assert.False(t, subtree.isMatch("FoldersX", "/", isSubscribed))
+
+	mailboxes := &eventGroup{filter: filterMailboxes, mailboxes: []string{"Folders"}}
+	assert.True(t, mailboxes.isMatch("Folders", "/", isSubscribed))
+	assert.False(t, mailboxes.isMatch("Folders/mbox", "/", isSubscribed))
+
+	selected := &eventGroup{filter: filterSelected}
+	assert.False(t, selected.isMatch("INBOX", "/", isSubscribed))
+}
+
+func newTestState(t *testing.T, selected string) (*extension, *connState, chan imap.WriterTo) {
+	user, err := memory.New().Login(nil, "username", "password")
+	require.NoError(t, err)
+
+	ch := make(chan imap.WriterTo)
+	state := &connState{
+		groups:    []*eventGroup{{filter: filterPersonal, events: map[string]bool{MessageNew: true, MessageExpunge: true}}},
+		user:      user,
+		selected:  selected,
+		responses: ch,
+		isPending: map[string]bool{},
+		wake:      make(chan struct{}, 1),
+	}
+
+	ext := &extension{states: map[*server.Context]*connState{{}: state}}
+
+	return ext, state, ch
+}
+
+func TestNotifySendsStatusWithoutDropping(t *testing.T) {
+	ext, state, ch := newTestState(t, "")
+
+	loggedOut := make(chan struct{})
+	defer close(loggedOut)
+	go ext.sendStatuses(state, loggedOut)
+
+	ext.Notify(backend.NewUpdate("username", "INBOX"))
+	ext.Notify(backend.NewUpdate("username", "INBOX"))
+	ext.Notify(backend.NewUpdate("other", "INBOX"))
+
+	// The response waits for the connection however busy it is.
+	time.Sleep(statusDelay + time.Second)
+
+	select {
+	case res := <-ch:
+		status, ok := res.(*responses.Status)
+		require.True(t, ok)
+		assert.Equal(t, "INBOX", status.Mailbox.Name)
+	case <-time.After(time.Second):
+		t.Fatal("status was not sent")
+	}
+
+	// Both updates of the same user are reported by one response.
+	select {
+	case <-ch:
+		t.Fatal("status was sent twice")
+	case <-time.After(statusDelay + 100*time.Millisecond):
+	}
+}
+
+func TestNotifySkipsSelectedMailbox(t *testing.T) {
+	ext, state, _ := newTestState(t, "INBOX")
+
+	ext.Notify(backend.NewUpdate("username", "INBOX"))
+
+	assert.Empty(t, state.pending)
+}
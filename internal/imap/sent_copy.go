@@ -0,0 +1,142 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/internal/imap/uidplus"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-message/textproto"
+)
+
+// sentCopyPolicy decides what to do with the copy of the message sent over
+// SMTP which the client appends to its sent folder. The sent message is
+// already on the server, so importing the copy would make a duplicate.
+type sentCopyPolicy int
+
+const (
+	// sentCopyMerge labels the sent message with the mailbox and applies
+	// flags of the copy. It suits clients which keep sent messages in
+	// another folder than Sent, e.g., Outlook.
+	sentCopyMerge sentCopyPolicy = iota
+
+	// sentCopySkip ignores the copy appended to Sent or to the mailbox
+	// which already has the sent message. Copy appended elsewhere is
+	// labelled the same way as by merge, so it does not get lost.
+	sentCopySkip
+)
+
+// sentCopyPolicies by name of the client sent by the IMAP ID command.
+// Default policy is merge.
+//
+// Thunderbird appends the copy with \Seen flag to Sent where the message
+// already is. Any change of the message would cause an update and thus
+// the message would be downloaded again.
+var sentCopyPolicies = map[string]sentCopyPolicy{ //nolint[gochecknoglobals]
+	"thunderbird": sentCopySkip,
+}
+
+func getSentCopyPolicy(client string) sentCopyPolicy {
+	client = strings.ToLower(client)
+	for name, policy := range sentCopyPolicies {
+		if strings.Contains(client, name) {
+			return policy
+		}
+	}
+	return sentCopyMerge
+}
+
+// appendSentCopy handles the message if it is a copy of the message sent
+// over SMTP. The match is done by Message-Id recorded when the message was
+// sent, so it works after restart as well. It returns false when the message
+// is not a copy of any known sent message and should be appended as usual.
+func (im *imapMailbox) appendSentCopy(client string, hdr textproto.Header, imapFlags []string) (bool, error) {
+	sent, err := im.storeUser.GetSentMessageByExternalID(hdr.Get("Message-Id"))
+	if err != nil {
+		im.log.WithError(err).Warn("Cannot get sent message record")
+		return false, nil
+	}
+	if sent == nil {
+		return false, nil
+	}
+
+	if !im.user.user.IsCombinedAddressMode() && im.storeAddress.AddressID() != sent.AddressID {
+		return false, nil
+	}
+
+	// The sent message could be deleted already or not synced yet.
+	msg, err := im.storeMailbox.GetMessage(sent.MessageID)
+	if err != nil {
+		return false, nil
+	}
+
+	isInMailbox := msg.Message().HasLabelID(im.storeMailbox.LabelID()) && !msg.IsMarkedDeleted()
+
+	logEntry := im.log.WithField("client", client).WithField("messageID", sent.MessageID)
+
+	switch getSentCopyPolicy(client) {
+	case sentCopySkip:
+		if !isInMailbox && im.storeMailbox.LabelID() != pmapi.SentLabel {
+			logEntry.Info("Labelling existing message with APPEND of copy of sent message")
+
+			return true, im.labelExistingMessage(msg.ID(), msg.IsMarkedDeleted())
+		}
+
+		logEntry.Info("Skipping APPEND of copy of sent message")
+
+		uids := &uidplus.OrderedSeq{}
+		if isInMailbox {
+			uids = im.storeMailbox.GetUIDList([]string{msg.ID()})
+		}
+		return true, uidplus.AppendResponse(im.storeMailbox.UIDValidity(), uids)
+
+	default:
+		logEntry.Info("Merging APPEND of copy of sent message")
+
+		return true, im.mergeSentCopy(msg, isInMailbox, imapFlags)
+	}
+}
+
+// mergeSentCopy applies flags of the copy to the sent message and adds
+// the message to the mailbox.
+func (im *imapMailbox) mergeSentCopy(msg storeMessageProvider, isInMailbox bool, imapFlags []string) error {
+	apiIDs := []string{msg.ID()}
+
+	for _, flag := range imapFlags {
+		var err error
+
+		switch flag {
+		case imap.SeenFlag:
+			err = im.storeMailbox.MarkMessagesRead(apiIDs)
+		case imap.FlaggedFlag:
+			err = im.storeMailbox.MarkMessagesStarred(apiIDs)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if isInMailbox {
+		return uidplus.AppendResponse(im.storeMailbox.UIDValidity(), im.storeMailbox.GetUIDList(apiIDs))
+	}
+
+	return im.labelExistingMessage(msg.ID(), msg.IsMarkedDeleted())
+}
@@ -28,8 +28,16 @@ import (
 	imapid "github.com/ProtonMail/go-imap-id"
 	"github.com/ProtonMail/proton-bridge/internal/bridge"
 	"github.com/ProtonMail/proton-bridge/internal/config/useragent"
+	imapcompress "github.com/ProtonMail/proton-bridge/internal/imap/compress"
+	"github.com/ProtonMail/proton-bridge/internal/imap/condstore"
+	"github.com/ProtonMail/proton-bridge/internal/imap/enable"
 	"github.com/ProtonMail/proton-bridge/internal/imap/id"
 	"github.com/ProtonMail/proton-bridge/internal/imap/idle"
+	"github.com/ProtonMail/proton-bridge/internal/imap/listextended"
+	"github.com/ProtonMail/proton-bridge/internal/imap/metadata"
+	"github.com/ProtonMail/proton-bridge/internal/imap/namespace"
+	"github.com/ProtonMail/proton-bridge/internal/imap/notify"
+	"github.com/ProtonMail/proton-bridge/internal/imap/sortthread"
 	"github.com/ProtonMail/proton-bridge/internal/imap/uidplus"
 	"github.com/ProtonMail/proton-bridge/internal/serverutil"
 	"github.com/ProtonMail/proton-bridge/pkg/listener"
@@ -50,20 +58,54 @@ type Server struct {
 	debugClient  bool
 	debugServer  bool
 	port         int
+	sslPort      int
 
 	server     *imapserver.Server
 	controller serverutil.Controller
 }
 
+// updateListenerSetter is a backend which can pass updates of all mailboxes
+// to the listener, not only of the selected ones.
+type updateListenerSetter interface {
+	SetUpdateListener(func(backend.Update))
+}
+
+// getCommand returns the handler factory of the command the same way as
+// go-imap does, i.e., from the first extension providing it, or the builtin
+// one.
+func getCommand(
+	extensions []imapserver.Extension,
+	builtin func(string) imapserver.HandlerFactory,
+	name string,
+) imapserver.HandlerFactory {
+	for _, ext := range extensions {
+		if newHandler := ext.Command(name); newHandler != nil {
+			return newHandler
+		}
+	}
+	return builtin(name)
+}
+
+// joinUpdateListeners returns the listener passing updates to all listeners.
+func joinUpdateListeners(listeners ...func(backend.Update)) func(backend.Update) {
+	return func(update backend.Update) {
+		for _, listener := range listeners {
+			listener(update)
+		}
+	}
+}
+
 // NewIMAPServer constructs a new IMAP server configured with the given options.
 func NewIMAPServer(
 	panicHandler panicHandler,
 	debugClient, debugServer bool,
-	port int,
+	port, sslPort int,
+	compress bool,
 	tls *tls.Config,
 	imapBackend backend.Backend,
 	userAgent *useragent.UserAgent,
 	eventListener listener.Listener,
+	sessions *serverutil.Sessions,
 ) *Server {
 	server := &Server{
 		panicHandler: panicHandler,
@@ -71,14 +113,22 @@ func NewIMAPServer(
 		debugClient:  debugClient,
 		debugServer:  debugServer,
 		port:         port,
+		sslPort:      sslPort,
 	}
 
-	server.server = newGoIMAPServer(tls, imapBackend, server.Address(), userAgent)
+	server.server = newGoIMAPServer(tls, imapBackend, server.Address(), userAgent, sessions, compress)
 	server.controller = serverutil.NewController(server, eventListener)
 	return server
 }
 
-func newGoIMAPServer(tls *tls.Config, backend backend.Backend, address string, userAgent *useragent.UserAgent) *imapserver.Server {
+func newGoIMAPServer( //nolint[funlen]
+	tls *tls.Config,
+	backend backend.Backend,
+	address string,
+	userAgent *useragent.UserAgent,
+	sessions *serverutil.Sessions,
+	compress bool,
+) *imapserver.Server {
 	server := imapserver.New(backend)
 	server.TLSConfig = tls
 	server.AllowInsecureAuth = true
@@ -106,7 +156,9 @@ func newGoIMAPServer(tls *tls.Config, backend backend.Backend, address string, u
 		})
 	})
 
-	server.Enable(
+	condStore := condstore.NewExtension()
+
+	extensions := []imapserver.Extension{
 		idle.NewExtension(),
 		imapmove.NewExtension(),
 		id.NewExtension(serverID, userAgent),
@@ -114,7 +166,38 @@ func newGoIMAPServer(tls *tls.Config, backend backend.Backend, address string, u
 		imapappendlimit.NewExtension(),
 		imapunselect.NewExtension(),
 		uidplus.NewExtension(),
-	)
+		condStore,
+		enable.NewExtension(condStore),
+		listextended.NewExtension(),
+		sortthread.NewExtension(),
+		metadata.NewExtension(),
+		namespace.NewExtension(),
+	}
+
+	if compress {
+		extensions = append(extensions, imapcompress.NewExtension())
+	}
+
+	// Builtin commands are taken from a server without extensions.
+	builtin := imapserver.New(backend).Command
+
+	// NOTIFY needs to wrap commands changing the selected mailbox, so it is
+	// the first one and takes the commands from the other extensions.
+	if listenerSetter, ok := backend.(updateListenerSetter); ok {
+		others := extensions
+		notifyExt := notify.NewExtension(func(name string) imapserver.HandlerFactory {
+			return getCommand(others, builtin, name)
+		})
+		listenerSetter.SetUpdateListener(joinUpdateListeners(condStore.Notify, notifyExt.Notify))
+		extensions = append([]imapserver.Extension{notifyExt}, others...)
+	}
+
+	// Session extension needs to be the first one to wrap all commands.
+	if sessions != nil {
+		server.Enable(newSessionExtension(sessions, extensions, builtin))
+	}
+
+	server.Enable(extensions...)
 
 	return server
 }
@@ -133,6 +216,15 @@ func (s *Server) Address() string            { return fmt.Sprintf("%s:%d", bridg
 func (s *Server) TLSConfig() *tls.Config     { return s.server.TLSConfig }
 func (s *Server) HandlePanic()               { s.panicHandler.HandlePanic() }
 
+// SSLAddress implements serverutil.SSLAddressProvider interface. IMAPS is
+// served next to STARTTLS only if its port is set.
+func (s *Server) SSLAddress() string {
+	if s.sslPort == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", bridge.Host, s.sslPort)
+}
+
 func (s *Server) DebugServer() bool { return s.debugServer }
 func (s *Server) DebugClient() bool { return s.debugClient }
 
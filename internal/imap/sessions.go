@@ -0,0 +1,182 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/serverutil"
+	imapserver "github.com/emersion/go-imap/server"
+)
+
+// clientProvider is a handler which knows the client name, i.e., handler
+// of the ID command.
+type clientProvider interface {
+	Client() (name, version string)
+}
+
+// sessionExtension records connections and their commands to sessions.
+// It has to be enabled before all other extensions because go-imap uses
+// the first extension providing the command. Handlers are looked up in the
+// other extensions first and then in the builtin commands, the same way as
+// go-imap does, and then they are wrapped to measure their duration.
+type sessionExtension struct {
+	sessions   *serverutil.Sessions
+	extensions []imapserver.Extension
+	builtin    func(string) imapserver.HandlerFactory
+
+	lock sync.Mutex
+	ids  map[*imapserver.Context]int
+}
+
+func newSessionExtension(
+	sessions *serverutil.Sessions,
+	extensions []imapserver.Extension,
+	builtin func(string) imapserver.HandlerFactory,
+) *sessionExtension {
+	return &sessionExtension{
+		sessions:   sessions,
+		extensions: extensions,
+		builtin:    builtin,
+		ids:        map[*imapserver.Context]int{},
+	}
+}
+
+func (ext *sessionExtension) Capabilities(c imapserver.Conn) []string {
+	return nil
+}
+
+func (ext *sessionExtension) Command(name string) imapserver.HandlerFactory {
+	newHandler := getCommand(ext.extensions, ext.builtin, name)
+	if newHandler == nil {
+		return nil
+	}
+
+	return func() imapserver.Handler {
+		return ext.wrap(name, newHandler())
+	}
+}
+
+func (ext *sessionExtension) NewConn(conn imapserver.Conn) imapserver.Conn {
+	ctx := conn.Context()
+	id := ext.sessions.Add(serverutil.IMAP, conn.Info().RemoteAddr.String())
+
+	ext.lock.Lock()
+	ext.ids[ctx] = id
+	ext.lock.Unlock()
+
+	go func() {
+		<-ctx.LoggedOut
+
+		ext.lock.Lock()
+		delete(ext.ids, ctx)
+		ext.lock.Unlock()
+
+		ext.sessions.Remove(id)
+	}()
+
+	return conn
+}
+
+func (ext *sessionExtension) record(conn imapserver.Conn, handler imapserver.Handler, name string, duration time.Duration) {
+	ext.lock.Lock()
+	id, ok := ext.ids[conn.Context()]
+	ext.lock.Unlock()
+	if !ok {
+		return
+	}
+
+	ctx := conn.Context()
+	if ctx.User != nil {
+		ext.sessions.SetUser(id, ctx.User.Username())
+	}
+	if ctx.Mailbox != nil {
+		ext.sessions.SetMailbox(id, ctx.Mailbox.Name())
+	} else {
+		ext.sessions.SetMailbox(id, "")
+	}
+	if provider, ok := handler.(clientProvider); ok {
+		if clientName, clientVersion := provider.Client(); clientName != "" {
+			ext.sessions.SetClient(id, strings.TrimSpace(clientName+" "+clientVersion))
+		}
+	}
+
+	ext.sessions.AddCommand(id, name, duration)
+}
+
+// wrap returns handler measuring the command. Optional interfaces of the
+// handler have to be kept, otherwise go-imap would not use them.
+func (ext *sessionExtension) wrap(name string, handler imapserver.Handler) imapserver.Handler {
+	h := &sessionHandler{Handler: handler, ext: ext, name: name}
+
+	switch handler := handler.(type) {
+	case imapserver.Upgrader:
+		return &sessionUpgradeHandler{sessionHandler: h, upgrader: handler}
+	case imapserver.UidHandler:
+		return &sessionUIDHandler{sessionHandler: h, uidHandler: handler}
+	}
+
+	return h
+}
+
+type sessionHandler struct {
+	imapserver.Handler
+
+	ext  *sessionExtension
+	name string
+}
+
+// Parse uses the subcommand for UID commands, such as UID FETCH.
+func (h *sessionHandler) Parse(fields []interface{}) error {
+	if h.name == "UID" && len(fields) > 0 {
+		if subcommand, ok := fields[0].(string); ok {
+			h.name += " " + strings.ToUpper(subcommand)
+		}
+	}
+	return h.Handler.Parse(fields)
+}
+
+func (h *sessionHandler) Handle(conn imapserver.Conn) error {
+	start := time.Now()
+	err := h.Handler.Handle(conn)
+	h.ext.record(conn, h.Handler, h.name, time.Since(start))
+	return err
+}
+
+type sessionUpgradeHandler struct {
+	*sessionHandler
+
+	upgrader imapserver.Upgrader
+}
+
+func (h *sessionUpgradeHandler) Upgrade(conn imapserver.Conn) error {
+	return h.upgrader.Upgrade(conn)
+}
+
+// sessionUIDHandler is used by UID command which is measured as a whole.
+type sessionUIDHandler struct {
+	*sessionHandler
+
+	uidHandler imapserver.UidHandler
+}
+
+func (h *sessionUIDHandler) UidHandle(conn imapserver.Conn) error { //nolint[golint]
+	return h.uidHandler.UidHandle(conn)
+}
@@ -0,0 +1,301 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package sortthread implements SORT and THREAD extensions (RFC5256)
+// including DISPLAYFROM and DISPLAYTO sort criteria (RFC5957).
+//
+// Besides ORDEREDSUBJECT and REFERENCES thread algorithms, it provides
+// X-CONVERSATION algorithm which groups messages by Proton conversation.
+// Threads of this algorithm are flat, the first message of the conversation
+// is the parent of all other messages of the conversation.
+//
+// Only US-ASCII and UTF-8 charsets are fully supported, see SEARCH.
+package sortthread
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/server"
+)
+
+// Capability extension identifiers.
+const (
+	SortCapability         = "SORT"
+	SortDisplayCapability  = "SORT=DISPLAY"
+	ThreadCapabilityPrefix = "THREAD="
+)
+
+const (
+	sortCommand   = "SORT"
+	threadCommand = "THREAD"
+)
+
+// SortField is a field by which messages can be sorted.
+type SortField string
+
+// Sort fields defined by RFC5256 and RFC5957.
+const (
+	SortArrival     SortField = "ARRIVAL"
+	SortCc          SortField = "CC"
+	SortDate        SortField = "DATE"
+	SortFrom        SortField = "FROM"
+	SortSize        SortField = "SIZE"
+	SortSubject     SortField = "SUBJECT"
+	SortTo          SortField = "TO"
+	SortDisplayFrom SortField = "DISPLAYFROM"
+	SortDisplayTo   SortField = "DISPLAYTO"
+)
+
+const sortReverse = "REVERSE"
+
+// SortCriterion is one sort key of SORT command.
+type SortCriterion struct {
+	Field   SortField
+	Reverse bool
+}
+
+// ThreadAlgorithm is an algorithm used to build threads.
+type ThreadAlgorithm string
+
+// Supported thread algorithms.
+const (
+	OrderedSubject ThreadAlgorithm = "ORDEREDSUBJECT"
+	References     ThreadAlgorithm = "REFERENCES"
+	Conversation   ThreadAlgorithm = "X-CONVERSATION"
+)
+
+func isSupportedAlgorithm(algorithm ThreadAlgorithm) bool {
+	switch algorithm {
+	case OrderedSubject, References, Conversation:
+		return true
+	}
+	return false
+}
+
+// SortMailbox is a mailbox supporting SORT command.
+type SortMailbox interface {
+	// SortMessages returns messages matching search criteria sorted by sort
+	// criteria. The returned list must contain UIDs if uid is set to true,
+	// or sequence numbers otherwise.
+	SortMessages(uid bool, sortCriteria []SortCriterion, searchCriteria *imap.SearchCriteria) ([]uint32, error)
+}
+
+// ThreadMailbox is a mailbox supporting THREAD command.
+type ThreadMailbox interface {
+	// ThreadMessages returns threads of messages matching search criteria.
+	// Threads must contain UIDs if uid is set to true, or sequence numbers
+	// otherwise.
+	ThreadMessages(uid bool, algorithm ThreadAlgorithm, searchCriteria *imap.SearchCriteria) ([]*Thread, error)
+}
+
+// parseSearch parses charset and search criteria common for SORT and THREAD.
+func parseSearch(fields []interface{}) (*imap.SearchCriteria, error) {
+	if len(fields) < 2 {
+		return nil, errors.New("missing charset or search criteria")
+	}
+
+	search := &commands.Search{}
+	if err := search.Parse(append([]interface{}{"CHARSET"}, fields...)); err != nil {
+		return nil, err
+	}
+
+	return search.Criteria, nil
+}
+
+// SortHandler handles SORT command.
+type SortHandler struct {
+	SortCriteria   []SortCriterion
+	SearchCriteria *imap.SearchCriteria
+}
+
+// Parse sort criteria, charset and search criteria.
+func (h *SortHandler) Parse(fields []interface{}) (err error) {
+	if len(fields) < 3 {
+		return errors.New("no enough arguments")
+	}
+
+	if h.SortCriteria, err = parseSortCriteria(fields[0]); err != nil {
+		return err
+	}
+
+	h.SearchCriteria, err = parseSearch(fields[1:])
+	return err
+}
+
+func parseSortCriteria(f interface{}) ([]SortCriterion, error) {
+	fields, ok := f.([]interface{})
+	if !ok || len(fields) == 0 {
+		return nil, errors.New("sort criteria must be a non-empty list")
+	}
+
+	criteria := []SortCriterion{}
+	reverse := false
+	for _, f := range fields {
+		name, err := imap.ParseString(f)
+		if err != nil {
+			return nil, err
+		}
+
+		field := SortField(strings.ToUpper(name))
+		switch field {
+		case sortReverse:
+			reverse = true
+			continue
+		case SortArrival, SortCc, SortDate, SortFrom, SortSize, SortSubject, SortTo, SortDisplayFrom, SortDisplayTo:
+		default:
+			return nil, errors.New("unknown sort criterion " + name)
+		}
+
+		criteria = append(criteria, SortCriterion{Field: field, Reverse: reverse})
+		reverse = false
+	}
+
+	if reverse {
+		return nil, errors.New("REVERSE must be followed by sort criterion")
+	}
+
+	return criteria, nil
+}
+
+// Handle the SORT request.
+func (h *SortHandler) Handle(conn server.Conn) error {
+	return h.handle(false, conn)
+}
+
+// UidHandle handles the UID SORT request.
+func (h *SortHandler) UidHandle(conn server.Conn) error { //nolint[golint]
+	return h.handle(true, conn)
+}
+
+func (h *SortHandler) handle(uid bool, conn server.Conn) error {
+	ctx := conn.Context()
+	if ctx.Mailbox == nil {
+		return server.ErrNoMailboxSelected
+	}
+
+	mailbox, ok := ctx.Mailbox.(SortMailbox)
+	if !ok {
+		return errors.New("SORT is not supported")
+	}
+
+	ids, err := mailbox.SortMessages(uid, h.SortCriteria, h.SearchCriteria)
+	if err != nil {
+		return err
+	}
+
+	fields := []interface{}{imap.RawString(sortCommand)}
+	for _, id := range ids {
+		fields = append(fields, id)
+	}
+
+	return conn.WriteResp(imap.NewUntaggedResp(fields))
+}
+
+// ThreadHandler handles THREAD command.
+type ThreadHandler struct {
+	Algorithm      ThreadAlgorithm
+	SearchCriteria *imap.SearchCriteria
+}
+
+// Parse thread algorithm, charset and search criteria.
+func (h *ThreadHandler) Parse(fields []interface{}) error {
+	if len(fields) < 3 {
+		return errors.New("no enough arguments")
+	}
+
+	name, err := imap.ParseString(fields[0])
+	if err != nil {
+		return err
+	}
+
+	h.Algorithm = ThreadAlgorithm(strings.ToUpper(name))
+	if !isSupportedAlgorithm(h.Algorithm) {
+		return errors.New("unknown thread algorithm " + name)
+	}
+
+	h.SearchCriteria, err = parseSearch(fields[1:])
+	return err
+}
+
+// Handle the THREAD request.
+func (h *ThreadHandler) Handle(conn server.Conn) error {
+	return h.handle(false, conn)
+}
+
+// UidHandle handles the UID THREAD request.
+func (h *ThreadHandler) UidHandle(conn server.Conn) error { //nolint[golint]
+	return h.handle(true, conn)
+}
+
+func (h *ThreadHandler) handle(uid bool, conn server.Conn) error {
+	ctx := conn.Context()
+	if ctx.Mailbox == nil {
+		return server.ErrNoMailboxSelected
+	}
+
+	mailbox, ok := ctx.Mailbox.(ThreadMailbox)
+	if !ok {
+		return errors.New("THREAD is not supported")
+	}
+
+	threads, err := mailbox.ThreadMessages(uid, h.Algorithm, h.SearchCriteria)
+	if err != nil {
+		return err
+	}
+
+	fields := []interface{}{imap.RawString(threadCommand)}
+	if len(threads) != 0 {
+		fields = append(fields, imap.RawString(FormatThreads(threads)))
+	}
+
+	return conn.WriteResp(imap.NewUntaggedResp(fields))
+}
+
+type extension struct{}
+
+// NewExtension of SORT and THREAD.
+func NewExtension() server.Extension {
+	return &extension{}
+}
+
+func (ext *extension) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState == 0 {
+		return nil
+	}
+
+	return []string{
+		SortCapability,
+		SortDisplayCapability,
+		ThreadCapabilityPrefix + string(OrderedSubject),
+		ThreadCapabilityPrefix + string(References),
+		ThreadCapabilityPrefix + string(Conversation),
+	}
+}
+
+func (ext *extension) Command(name string) server.HandlerFactory {
+	switch name {
+	case sortCommand:
+		return func() server.Handler { return &SortHandler{} }
+	case threadCommand:
+		return func() server.Handler { return &ThreadHandler{} }
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sortthread
+
+import (
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SortMessage holds data of a message needed for sorting.
+type SortMessage struct {
+	ID      uint32
+	Arrival time.Time
+	Date    time.Time
+	Size    uint32
+	From    []*mail.Address
+	To      []*mail.Address
+	Cc      []*mail.Address
+	Subject string
+}
+
+// Sort returns IDs of messages sorted by the criteria. Messages are expected
+// to be ordered by sequence numbers which is used when all criteria are equal.
+func Sort(messages []*SortMessage, criteria []SortCriterion) []uint32 {
+	sorted := append([]*SortMessage{}, messages...)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		for _, criterion := range criteria {
+			cmp := compareSortField(sorted[i], sorted[j], criterion.Field)
+			if criterion.Reverse {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+
+	ids := make([]uint32, 0, len(sorted))
+	for _, message := range sorted {
+		ids = append(ids, message.ID)
+	}
+	return ids
+}
+
+func compareSortField(a, b *SortMessage, field SortField) int {
+	switch field {
+	case SortArrival:
+		return compareTime(a.Arrival, b.Arrival)
+	case SortDate:
+		return compareTime(a.Date, b.Date)
+	case SortSize:
+		return compareUint(a.Size, b.Size)
+	case SortCc:
+		return compareFold(getAddrMailbox(a.Cc), getAddrMailbox(b.Cc))
+	case SortFrom:
+		return compareFold(getAddrMailbox(a.From), getAddrMailbox(b.From))
+	case SortTo:
+		return compareFold(getAddrMailbox(a.To), getAddrMailbox(b.To))
+	case SortDisplayFrom:
+		return compareFold(getDisplayName(a.From), getDisplayName(b.From))
+	case SortDisplayTo:
+		return compareFold(getDisplayName(a.To), getDisplayName(b.To))
+	case SortSubject:
+		aSubject, _ := GetBaseSubject(a.Subject)
+		bSubject, _ := GetBaseSubject(b.Subject)
+		return compareFold(aSubject, bSubject)
+	}
+	return 0
+}
+
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+func compareUint(a, b uint32) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareFold compares strings using i;ascii-casemap collation.
+func compareFold(a, b string) int {
+	return strings.Compare(toUpperASCII(a), toUpperASCII(b))
+}
+
+func toUpperASCII(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		return r
+	}, s)
+}
+
+// getAddrMailbox returns the local part of the first address.
+func getAddrMailbox(addresses []*mail.Address) string {
+	if len(addresses) == 0 || addresses[0] == nil {
+		return ""
+	}
+	address := addresses[0].Address
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		return address[:i]
+	}
+	return address
+}
+
+// getDisplayName returns the display name of the first address or its
+// address if the name is empty, as defined by RFC5957.
+func getDisplayName(addresses []*mail.Address) string {
+	if len(addresses) == 0 || addresses[0] == nil {
+		return ""
+	}
+	if addresses[0].Name != "" {
+		return addresses[0].Name
+	}
+	return addresses[0].Address
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sortthread
+
+import (
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSortCriteria(t *testing.T) {
+	criteria, err := parseSortCriteria([]interface{}{"reverse", "DATE", "SUBJECT"})
+	require.NoError(t, err)
+	assert.Equal(t, []SortCriterion{{Field: SortDate, Reverse: true}, {Field: SortSubject}}, criteria)
+
+	_, err = parseSortCriteria([]interface{}{})
+	assert.Error(t, err)
+	_, err = parseSortCriteria([]interface{}{"UNKNOWN"})
+	assert.Error(t, err)
+	_, err = parseSortCriteria([]interface{}{"DATE", "REVERSE"})
+	assert.Error(t, err)
+}
+
+func TestSortHandlerParse(t *testing.T) {
+	h := &SortHandler{}
+	require.NoError(t, h.Parse([]interface{}{[]interface{}{"ARRIVAL"}, "UTF-8", "ALL"}))
+	assert.Equal(t, []SortCriterion{{Field: SortArrival}}, h.SortCriteria)
+	assert.NotNil(t, h.SearchCriteria)
+
+	assert.Error(t, (&SortHandler{}).Parse([]interface{}{[]interface{}{"ARRIVAL"}, "UTF-8"}))
+}
+
+func TestSort(t *testing.T) {
+	date := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	messages := []*SortMessage{
+		{ID: 1, Date: date.Add(2 * time.Hour), Size: 30, Subject: "Re: beta", From: []*mail.Address{{Name: "Zed", Address: "a@pm.me"}}},
+		{ID: 2, Date: date, Size: 10, Subject: "alpha", From: []*mail.Address{{Address: "c@pm.me"}}},
+		{ID: 3, Date: date.Add(time.Hour), Size: 20, Subject: "Beta", From: []*mail.Address{{Name: "Bob", Address: "b@pm.me"}}},
+		{ID: 4, Date: date.Add(time.Hour), Size: 20, Subject: "gamma"},
+	}
+
+	tests := []struct {
+		criteria []SortCriterion
+		want     []uint32
+	}{
+		{[]SortCriterion{{Field: SortDate}}, []uint32{2, 3, 4, 1}},
+		{[]SortCriterion{{Field: SortDate, Reverse: true}}, []uint32{1, 3, 4, 2}},
+		{[]SortCriterion{{Field: SortSize}, {Field: SortSubject, Reverse: true}}, []uint32{2, 4, 3, 1}},
+		{[]SortCriterion{{Field: SortSubject}}, []uint32{2, 1, 3, 4}},
+		{[]SortCriterion{{Field: SortFrom}}, []uint32{4, 1, 3, 2}},
+		{[]SortCriterion{{Field: SortDisplayFrom}}, []uint32{4, 3, 2, 1}},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, Sort(messages, tc.criteria), "criteria %v", tc.criteria)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sortthread
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	subjectLeaderRegexp = regexp.MustCompile(`(?i)^(\[[^\[\]]*\]\s*)*(re|fwd?)\s*(\[[^\[\]]*\]\s*)?:\s*`) //nolint[gochecknoglobals]
+	subjectBlobRegexp   = regexp.MustCompile(`^\[[^\[\]]*\]\s*`)                                          //nolint[gochecknoglobals]
+)
+
+const (
+	subjectFwdTrailer = "(fwd)"
+	subjectFwdHeader  = "[fwd:"
+)
+
+// GetBaseSubject returns the base subject as defined by RFC5256 section 2.1
+// and whether the subject indicates a reply or a forward. The subject must
+// be already decoded.
+func GetBaseSubject(subject string) (base string, isReply bool) {
+	// Step 1: all sequences of whitespace are converted to a single space.
+	base = strings.Join(strings.Fields(subject), " ")
+
+	for {
+		// Step 2: remove all trailing "(fwd)".
+		for hasSuffixFold(base, subjectFwdTrailer) {
+			base = strings.TrimSpace(base[:len(base)-len(subjectFwdTrailer)])
+			isReply = true
+		}
+
+		// Steps 3, 4 and 5: remove leading "Re:", "Fwd:" and blobs until
+		// there is nothing to remove.
+		for {
+			previous := base
+
+			if loc := subjectLeaderRegexp.FindStringIndex(base); loc != nil {
+				base = base[loc[1]:]
+				isReply = true
+			}
+
+			if loc := subjectBlobRegexp.FindStringIndex(base); loc != nil && loc[1] < len(base) {
+				base = base[loc[1]:]
+			}
+
+			if base == previous {
+				break
+			}
+		}
+
+		// Step 6: remove "[fwd:" and "]" wrapper and start again.
+		if len(base) > len(subjectFwdHeader) && hasPrefixFold(base, subjectFwdHeader) && strings.HasSuffix(base, "]") {
+			base = strings.TrimSpace(base[len(subjectFwdHeader) : len(base)-1])
+			isReply = true
+			continue
+		}
+
+		return base, isReply
+	}
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+func hasSuffixFold(s, suffix string) bool {
+	return len(s) >= len(suffix) && strings.EqualFold(s[len(s)-len(suffix):], suffix)
+}
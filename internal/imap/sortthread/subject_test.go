@@ -0,0 +1,57 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sortthread
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBaseSubject(t *testing.T) {
+	tests := []struct {
+		subject     string
+		wantBase    string
+		wantIsReply bool
+	}{
+		{"Hello", "Hello", false},
+		{"  Hello \t  world  ", "Hello world", false},
+		{"Re: Hello", "Hello", true},
+		{"RE:Hello", "Hello", true},
+		{"Re: Fwd: Re: Hello", "Hello", true},
+		{"Fw: Hello", "Hello", true},
+		{"Re [2]: Hello", "Hello", true},
+		{"[list] Re: Hello", "Hello", true},
+		{"[list] Hello", "Hello", false},
+		{"[list]", "[list]", false},
+		{"Hello (fwd)", "Hello", true},
+		{"[Fwd: Hello]", "Hello", true},
+		{"[Fwd: Re: Hello] (fwd)", "Hello", true},
+		{"Reply", "Reply", false},
+		{"", "", false},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.subject, func(t *testing.T) {
+			base, isReply := GetBaseSubject(tc.subject)
+			assert.Equal(t, tc.wantBase, base)
+			assert.Equal(t, tc.wantIsReply, isReply)
+		})
+	}
+}
@@ -0,0 +1,400 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sortthread
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var msgIDRegexp = regexp.MustCompile(`<[^<>]+>`) //nolint[gochecknoglobals]
+
+// ParseMessageIDs returns all message IDs in a header value such as
+// References or In-Reply-To.
+func ParseMessageIDs(value string) []string {
+	return msgIDRegexp.FindAllString(value, -1)
+}
+
+// ThreadMessage holds data of a message needed for threading.
+type ThreadMessage struct {
+	ID             uint32
+	MessageID      string
+	References     []string
+	Subject        string
+	Date           time.Time
+	ConversationID string
+}
+
+// Thread is a message together with its replies. Threads of REFERENCES
+// algorithm can have dummy parent (with zero ID) of messages which are
+// replies of the same message not present in the mailbox.
+type Thread struct {
+	ID       uint32
+	Children []*Thread
+}
+
+// Threads returns threads of messages built by the algorithm. Messages are
+// expected to be ordered by sequence numbers which is used when messages
+// have the same date.
+func Threads(algorithm ThreadAlgorithm, messages []*ThreadMessage) []*Thread {
+	switch algorithm {
+	case OrderedSubject:
+		return threadByKey(messages, func(message *ThreadMessage) string {
+			base, _ := GetBaseSubject(message.Subject)
+			return base
+		})
+	case Conversation:
+		return threadByKey(messages, func(message *ThreadMessage) string {
+			return message.ConversationID
+		})
+	case References:
+		return threadByReferences(messages)
+	}
+	return nil
+}
+
+// FormatThreads returns threads in the form of THREAD response.
+func FormatThreads(threads []*Thread) string {
+	var b strings.Builder
+	for _, thread := range threads {
+		formatThread(&b, thread)
+	}
+	return b.String()
+}
+
+func formatThread(b *strings.Builder, thread *Thread) {
+	b.WriteString("(")
+
+	// Thread with one child continues on the same level.
+	node := thread
+	ids := []string{}
+	for {
+		if node.ID != 0 {
+			ids = append(ids, strconv.FormatUint(uint64(node.ID), 10))
+		}
+		if len(node.Children) != 1 || node.ID == 0 {
+			break
+		}
+		node = node.Children[0]
+	}
+	b.WriteString(strings.Join(ids, " "))
+
+	if len(node.Children) != 0 {
+		if len(ids) != 0 {
+			b.WriteString(" ")
+		}
+		for _, child := range node.Children {
+			formatThread(b, child)
+		}
+	}
+
+	b.WriteString(")")
+}
+
+func sortByDate(messages []*ThreadMessage) []*ThreadMessage {
+	sorted := append([]*ThreadMessage{}, messages...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Date.Before(sorted[j].Date)
+	})
+	return sorted
+}
+
+// threadByKey groups messages with the same key into flat threads. The
+// oldest message is the parent of all other messages with the same key.
+// Messages with empty key are not grouped.
+func threadByKey(messages []*ThreadMessage, getKey func(*ThreadMessage) string) []*Thread {
+	threads := []*Thread{}
+	threadsByKey := map[string]*Thread{}
+
+	for _, message := range sortByDate(messages) {
+		key := getKey(message)
+		if thread, ok := threadsByKey[key]; ok && key != "" {
+			thread.Children = append(thread.Children, &Thread{ID: message.ID})
+			continue
+		}
+
+		thread := &Thread{ID: message.ID}
+		threadsByKey[key] = thread
+		threads = append(threads, thread)
+	}
+
+	return threads
+}
+
+// container is a node of REFERENCES algorithm. Dummy containers have no
+// message.
+type container struct {
+	message  *ThreadMessage
+	order    int
+	parent   *container
+	children []*container
+}
+
+func (c *container) isDummy() bool {
+	return c.message == nil
+}
+
+// isAncestorOf returns whether c is other or its ancestor.
+func (c *container) isAncestorOf(other *container) bool {
+	for node := other; node != nil; node = node.parent {
+		if node == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *container) addChild(child *container) {
+	child.parent = c
+	c.children = append(c.children, child)
+}
+
+func (c *container) removeChild(child *container) {
+	for i, other := range c.children {
+		if other == child {
+			c.children = append(c.children[:i], c.children[i+1:]...)
+			break
+		}
+	}
+	child.parent = nil
+}
+
+// getFirstMessage returns the message which represents the container when
+// sorting, i.e., its own message or the message of its first child.
+func (c *container) getFirstMessage() *container {
+	for node := c; node != nil; {
+		if !node.isDummy() || len(node.children) == 0 {
+			return node
+		}
+		node = node.children[0]
+	}
+	return c
+}
+
+func (c *container) getSubject() string {
+	if first := c.getFirstMessage(); !first.isDummy() {
+		return first.message.Subject
+	}
+	return ""
+}
+
+// threadByReferences implements REFERENCES algorithm of RFC5256.
+func threadByReferences(messages []*ThreadMessage) []*Thread {
+	containers := []*container{}
+	containersByID := map[string]*container{}
+
+	getContainer := func(msgID string) *container {
+		if c, ok := containersByID[msgID]; ok {
+			return c
+		}
+		c := &container{}
+		containersByID[msgID] = c
+		containers = append(containers, c)
+		return c
+	}
+
+	// Step 1: link messages by their references.
+	for order, message := range messages {
+		msgID := message.MessageID
+		if c, ok := containersByID[msgID]; msgID == "" || (ok && !c.isDummy()) {
+			// Messages without or with duplicate Message-ID get unique one.
+			msgID = "<" + strconv.Itoa(order) + "@bridge.thread>"
+		}
+
+		c := getContainer(msgID)
+		c.message = message
+		c.order = order
+
+		var parent *container
+		for _, reference := range message.References {
+			refContainer := getContainer(reference)
+			if parent != nil && refContainer.parent == nil && !refContainer.isAncestorOf(parent) {
+				parent.addChild(refContainer)
+			}
+			parent = refContainer
+		}
+
+		if parent != nil && c.isAncestorOf(parent) {
+			parent = nil
+		}
+		if c.parent != nil {
+			c.parent.removeChild(c)
+		}
+		if parent != nil {
+			parent.addChild(c)
+		}
+	}
+
+	// Step 2: gather root set.
+	roots := []*container{}
+	for _, c := range containers {
+		if c.parent == nil {
+			roots = append(roots, c)
+		}
+	}
+
+	// Step 4: prune dummy containers.
+	roots = pruneContainers(roots, true)
+
+	// Step 5: group root set by base subject.
+	roots = groupBySubject(roots)
+
+	// Step 7: sort siblings by date.
+	sortContainers(roots)
+
+	threads := make([]*Thread, 0, len(roots))
+	for _, root := range roots {
+		threads = append(threads, toThread(root))
+	}
+	return threads
+}
+
+// pruneContainers removes dummies without children and replaces dummies by
+// their children. Dummies in the root set are replaced only if they have
+// just one child.
+func pruneContainers(containers []*container, isRoot bool) []*container {
+	pruned := []*container{}
+
+	for _, c := range containers {
+		c.children = pruneContainers(c.children, false)
+		for _, child := range c.children {
+			child.parent = c
+		}
+
+		if c.isDummy() {
+			if len(c.children) == 0 {
+				continue
+			}
+			if !isRoot || len(c.children) == 1 {
+				for _, child := range c.children {
+					child.parent = c.parent
+				}
+				pruned = append(pruned, c.children...)
+				continue
+			}
+		}
+
+		pruned = append(pruned, c)
+	}
+
+	return pruned
+}
+
+// groupBySubject merges threads from the root set with the same base subject.
+func groupBySubject(roots []*container) []*container { //nolint[funlen]
+	subjects := map[string]*container{}
+
+	for _, root := range roots {
+		subject, isReply := GetBaseSubject(root.getSubject())
+		if subject == "" {
+			continue
+		}
+
+		existing, ok := subjects[subject]
+		if !ok {
+			subjects[subject] = root
+			continue
+		}
+
+		_, isExistingReply := GetBaseSubject(existing.getSubject())
+		if (root.isDummy() && !existing.isDummy()) || (isExistingReply && !isReply) {
+			subjects[subject] = root
+		}
+	}
+
+	grouped := []*container{}
+	replaced := map[*container]*container{}
+
+	for _, root := range roots {
+		subject, isReply := GetBaseSubject(root.getSubject())
+		existing, ok := subjects[subject]
+		if subject == "" || !ok || existing == root {
+			grouped = append(grouped, root)
+			continue
+		}
+
+		if newDummy, ok := replaced[existing]; ok {
+			existing = newDummy
+		}
+
+		_, isExistingReply := GetBaseSubject(existing.getSubject())
+
+		switch {
+		case root.isDummy() && existing.isDummy():
+			for _, child := range root.children {
+				existing.addChild(child)
+			}
+		case existing.isDummy():
+			existing.addChild(root)
+		case !isExistingReply && isReply:
+			existing.addChild(root)
+		default:
+			// Both are messages (or the existing one is a reply), so they
+			// become siblings under a new dummy.
+			dummy := &container{}
+			replaced[subjects[subject]] = dummy
+			dummy.addChild(existing)
+			dummy.addChild(root)
+			grouped = append(grouped, dummy)
+		}
+	}
+
+	// Remove roots which were moved under another root; order of roots
+	// does not matter as they are sorted afterwards.
+	roots = []*container{}
+	for _, root := range grouped {
+		if root.parent == nil {
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
+
+// sortContainers sorts siblings by date of their messages; dummies are
+// sorted by their first child. Messages with the same date keep order.
+func sortContainers(containers []*container) {
+	for _, c := range containers {
+		sortContainers(c.children)
+	}
+
+	sort.SliceStable(containers, func(i, j int) bool {
+		a := containers[i].getFirstMessage()
+		b := containers[j].getFirstMessage()
+		if a.isDummy() || b.isDummy() {
+			return false
+		}
+		if !a.message.Date.Equal(b.message.Date) {
+			return a.message.Date.Before(b.message.Date)
+		}
+		return a.order < b.order
+	})
+}
+
+func toThread(c *container) *Thread {
+	thread := &Thread{}
+	if !c.isDummy() {
+		thread.ID = c.message.ID
+	}
+	for _, child := range c.children {
+		thread.Children = append(thread.Children, toThread(child))
+	}
+	return thread
+}
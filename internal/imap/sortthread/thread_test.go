@@ -0,0 +1,93 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sortthread
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatThreads(t *testing.T) {
+	threads := []*Thread{
+		{ID: 2},
+		{ID: 3, Children: []*Thread{{ID: 6, Children: []*Thread{
+			{ID: 4, Children: []*Thread{{ID: 23}}},
+			{ID: 44, Children: []*Thread{{ID: 7, Children: []*Thread{{ID: 96}}}}},
+		}}}},
+		{Children: []*Thread{{ID: 5}, {ID: 8}}},
+	}
+
+	assert.Equal(t, "(2)(3 6 (4 23)(44 7 96))((5)(8))", FormatThreads(threads))
+	assert.Equal(t, "", FormatThreads(nil))
+}
+
+func TestParseMessageIDs(t *testing.T) {
+	assert.Equal(t, []string{"<a@pm.me>", "<b@pm.me>"}, ParseMessageIDs("<a@pm.me>\r\n <b@pm.me>"))
+	assert.Empty(t, ParseMessageIDs("invalid"))
+}
+
+func TestThreadHandlerParse(t *testing.T) {
+	h := &ThreadHandler{}
+	assert.NoError(t, h.Parse([]interface{}{"x-conversation", "US-ASCII", "ALL"}))
+	assert.Equal(t, Conversation, h.Algorithm)
+
+	assert.Error(t, (&ThreadHandler{}).Parse([]interface{}{"UNKNOWN", "US-ASCII", "ALL"}))
+}
+
+func newThreadMessages() []*ThreadMessage {
+	date := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	return []*ThreadMessage{
+		{ID: 1, MessageID: "<1@pm.me>", Subject: "Hello", Date: date, ConversationID: "a"},
+		{ID: 2, MessageID: "<2@pm.me>", Subject: "Other", Date: date.Add(time.Hour), ConversationID: "b"},
+		{ID: 3, MessageID: "<3@pm.me>", Subject: "Re: Hello", Date: date.Add(2 * time.Hour), ConversationID: "a", References: []string{"<1@pm.me>"}},
+		{ID: 4, MessageID: "<4@pm.me>", Subject: "Re: Hello", Date: date.Add(3 * time.Hour), ConversationID: "a", References: []string{"<1@pm.me>", "<3@pm.me>"}},
+		{ID: 5, MessageID: "<5@pm.me>", Subject: "Re: Missing", Date: date.Add(4 * time.Hour), References: []string{"<missing@pm.me>"}},
+		{ID: 6, MessageID: "<6@pm.me>", Subject: "Re: Missing", Date: date.Add(5 * time.Hour), References: []string{"<missing@pm.me>"}},
+		{ID: 7, MessageID: "<7@pm.me>", Subject: "Re: Other", Date: date.Add(-time.Hour), ConversationID: "b"},
+	}
+}
+
+func TestThreadOrderedSubject(t *testing.T) {
+	threads := Threads(OrderedSubject, newThreadMessages())
+	assert.Equal(t, "(7 2)(1 (3)(4))(5 6)", FormatThreads(threads))
+}
+
+func TestThreadConversation(t *testing.T) {
+	threads := Threads(Conversation, newThreadMessages())
+	assert.Equal(t, "(7 2)(1 (3)(4))(5)(6)", FormatThreads(threads))
+}
+
+func TestThreadReferences(t *testing.T) {
+	threads := Threads(References, newThreadMessages())
+	assert.Equal(t, "(1 3 4)(2 7)((5)(6))", FormatThreads(threads))
+}
+
+func TestThreadReferencesLoop(t *testing.T) {
+	date := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	messages := []*ThreadMessage{
+		{ID: 1, MessageID: "<1@pm.me>", Subject: "A", Date: date, References: []string{"<2@pm.me>"}},
+		{ID: 2, MessageID: "<2@pm.me>", Subject: "B", Date: date, References: []string{"<1@pm.me>"}},
+		{ID: 3, MessageID: "<1@pm.me>", Subject: "C", Date: date},
+	}
+
+	threads := Threads(References, messages)
+	assert.Equal(t, "(2 1)(3)", FormatThreads(threads))
+}
@@ -45,6 +45,14 @@ type storeUserProvider interface {
 		parentID string) (*pmapi.Message, []*pmapi.Attachment, error)
 
 	SetChangeNotifier(store.ChangeNotifier)
+
+	IsMessageIndexed(apiID string) bool
+	SearchIndex(query string, withHeader bool) (map[string]bool, error)
+
+	LoadCachedMessage(apiID string) ([]byte, *pkgMsg.BodyStructure, bool)
+	SaveCachedMessage(apiID string, literal []byte, structure *pkgMsg.BodyStructure)
+
+	GetSentMessageByExternalID(externalID string) (*store.SentMessage, error)
 }
 
 type storeAddressProvider interface {
@@ -64,9 +72,11 @@ type storeMailboxProvider interface {
 	IsSystem() bool
 	IsFolder() bool
 	IsLabel() bool
+	IsSearch() bool
 	UIDValidity() uint32
 
 	Rename(newName string) error
+	SetColor(color string) error
 	Delete() error
 
 	GetAPIIDsFromUIDRange(start, stop uint32) ([]string, error)
@@ -78,6 +88,10 @@ type storeMailboxProvider interface {
 	GetUIDList(apiIDs []string) *uidplus.OrderedSeq
 	GetUIDByHeader(header *mail.Header) uint32
 	GetDelimiter() string
+	GetHighestModSeq() (uint64, error)
+	GetModSeqs(apiIDs []string) (map[string]uint64, error)
+	GetVanishedUIDs(modSeq uint64) ([]uint32, error)
+	LockFlags() (unlock func())
 
 	GetMessage(apiID string) (storeMessageProvider, error)
 	FetchMessage(apiID string) (storeMessageProvider, error)
@@ -97,6 +111,7 @@ type storeMessageProvider interface {
 	ID() string
 	UID() (uint32, error)
 	SequenceNumber() (uint32, error)
+	ModSeq() (uint64, error)
 	Message() *pmapi.Message
 	IsMarkedDeleted() bool
 
@@ -22,6 +22,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ProtonMail/proton-bridge/internal/imap/condstore"
 	"github.com/ProtonMail/proton-bridge/internal/store"
 	"github.com/ProtonMail/proton-bridge/pkg/message"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
@@ -42,6 +43,8 @@ type imapUpdates struct {
 	blocking        map[string]bool
 	delayedExpunges map[string][]chan struct{}
 	ch              chan goIMAPBackend.Update
+	listener        func(goIMAPBackend.Update)
+	otherAddresses  func(address string) []string
 }
 
 func newIMAPUpdates() *imapUpdates {
@@ -125,7 +128,7 @@ func (iu *imapUpdates) Notice(address, notice string) {
 
 func (iu *imapUpdates) UpdateMessage(
 	address, mailboxName string,
-	uid, sequenceNumber uint32,
+	uid, sequenceNumber uint32, modSeq uint64,
 	msg *pmapi.Message, hasDeletedFlag bool,
 ) {
 	log.WithFields(logrus.Fields{
@@ -136,27 +139,33 @@ func (iu *imapUpdates) UpdateMessage(
 		"flags":   message.GetFlags(msg),
 		"deleted": hasDeletedFlag,
 	}).Trace("IDLE update")
-	update := new(goIMAPBackend.MessageUpdate)
-	update.Update = goIMAPBackend.NewUpdate(address, mailboxName)
-	update.Message = imap.NewMessage(sequenceNumber, []imap.FetchItem{imap.FetchFlags, imap.FetchUid})
-	update.Message.Flags = message.GetFlags(msg)
+	updateMessage := imap.NewMessage(sequenceNumber, []imap.FetchItem{imap.FetchFlags, imap.FetchUid})
+	updateMessage.Flags = message.GetFlags(msg)
 	if hasDeletedFlag {
-		update.Message.Flags = append(update.Message.Flags, imap.DeletedFlag)
+		updateMessage.Flags = append(updateMessage.Flags, imap.DeletedFlag)
 	}
-	update.Message.Uid = uid
-	iu.sendIMAPUpdate(update, iu.isBlocking(address, mailboxName, operationUpdateMessage))
+	updateMessage.Uid = uid
+	iu.sendMailboxUpdate(address, mailboxName, iu.isBlocking(address, mailboxName, operationUpdateMessage), func(address, mailboxName string) goIMAPBackend.Update {
+		update := new(goIMAPBackend.MessageUpdate)
+		update.Update = goIMAPBackend.NewUpdate(address, mailboxName)
+		update.Message = updateMessage
+		return &condstore.MessageUpdate{MessageUpdate: update, ModSeq: modSeq}
+	})
 }
 
-func (iu *imapUpdates) DeleteMessage(address, mailboxName string, sequenceNumber uint32) {
+func (iu *imapUpdates) DeleteMessage(address, mailboxName string, uid, sequenceNumber uint32) {
 	log.WithFields(logrus.Fields{
 		"address": address,
 		"mailbox": mailboxName,
 		"seqNum":  sequenceNumber,
+		"uid":     uid,
 	}).Trace("IDLE delete")
-	update := new(goIMAPBackend.ExpungeUpdate)
-	update.Update = goIMAPBackend.NewUpdate(address, mailboxName)
-	update.SeqNum = sequenceNumber
-	iu.sendIMAPUpdate(update, iu.isBlocking(address, mailboxName, operationDeleteMessage))
+	iu.sendMailboxUpdate(address, mailboxName, iu.isBlocking(address, mailboxName, operationDeleteMessage), func(address, mailboxName string) goIMAPBackend.Update {
+		update := new(goIMAPBackend.ExpungeUpdate)
+		update.Update = goIMAPBackend.NewUpdate(address, mailboxName)
+		update.SeqNum = sequenceNumber
+		return &condstore.ExpungeUpdate{ExpungeUpdate: update, UID: uid}
+	})
 }
 
 func (iu *imapUpdates) MailboxCreated(address, mailboxName string) {
@@ -182,13 +191,59 @@ func (iu *imapUpdates) MailboxStatus(address, mailboxName string, total, unread,
 		"unread":       unread,
 		"unreadSeqNum": unreadSeqNum,
 	}).Trace("IDLE status")
-	update := new(goIMAPBackend.MailboxUpdate)
-	update.Update = goIMAPBackend.NewUpdate(address, mailboxName)
-	update.MailboxStatus = imap.NewMailboxStatus(mailboxName, []imap.StatusItem{imap.StatusMessages, imap.StatusUnseen})
-	update.MailboxStatus.Messages = total
-	update.MailboxStatus.Unseen = unread
-	update.MailboxStatus.UnseenSeqNum = unreadSeqNum
-	iu.sendIMAPUpdate(update, true)
+	iu.sendMailboxUpdate(address, mailboxName, true, func(address, mailboxName string) goIMAPBackend.Update {
+		update := new(goIMAPBackend.MailboxUpdate)
+		update.Update = goIMAPBackend.NewUpdate(address, mailboxName)
+		update.MailboxStatus = imap.NewMailboxStatus(mailboxName, []imap.StatusItem{imap.StatusMessages, imap.StatusUnseen})
+		update.MailboxStatus.Messages = total
+		update.MailboxStatus.Unseen = unread
+		update.MailboxStatus.UnseenSeqNum = unreadSeqNum
+		return update
+	})
+}
+
+// setListener sets the function which gets all updates regardless of
+// the mailbox selected by connections, such as NOTIFY extension.
+// The listener must not block.
+func (iu *imapUpdates) setListener(listener func(goIMAPBackend.Update)) {
+	iu.lock.Lock()
+	defer iu.lock.Unlock()
+
+	iu.listener = listener
+}
+
+// setOtherAddresses sets the function returning addresses which list
+// mailboxes of the address in their namespace of other addresses.
+func (iu *imapUpdates) setOtherAddresses(otherAddresses func(address string) []string) {
+	iu.lock.Lock()
+	defer iu.lock.Unlock()
+
+	iu.otherAddresses = otherAddresses
+}
+
+// sendMailboxUpdate sends the update of the mailbox created by newUpdate.
+// go-imap sends updates only to connections of the same username with the
+// mailbox of the same name selected, so the update is also sent to other
+// addresses of the account under the name the mailbox has in their
+// namespace of other addresses.
+func (iu *imapUpdates) sendMailboxUpdate(
+	address, mailboxName string,
+	isBlocking bool,
+	newUpdate func(address, mailboxName string) goIMAPBackend.Update,
+) {
+	iu.sendIMAPUpdate(newUpdate(address, mailboxName), isBlocking)
+
+	iu.lock.Lock()
+	otherAddresses := iu.otherAddresses
+	iu.lock.Unlock()
+
+	if otherAddresses == nil {
+		return
+	}
+
+	for _, otherAddress := range otherAddresses(address) {
+		iu.sendIMAPUpdate(newUpdate(otherAddress, getNamespacePrefix(address)+mailboxName), isBlocking)
+	}
 }
 
 func (iu *imapUpdates) sendIMAPUpdate(update goIMAPBackend.Update, isBlocking bool) {
@@ -197,6 +252,17 @@ func (iu *imapUpdates) sendIMAPUpdate(update goIMAPBackend.Update, isBlocking bo
 		return
 	}
 
+	iu.lock.Lock()
+	listener := iu.listener
+	iu.lock.Unlock()
+
+	if listener != nil {
+		listener(update)
+	}
+
+	// Listeners can get updates with data which go-imap does not know.
+	update = condstore.UnwrapUpdate(update)
+
 	done := update.Done()
 	go func() {
 		select {
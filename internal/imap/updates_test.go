@@ -21,6 +21,7 @@ import (
 	"testing"
 	"time"
 
+	goIMAPBackend "github.com/emersion/go-imap/backend"
 	"github.com/stretchr/testify/require"
 )
 
@@ -58,3 +59,25 @@ func TestUpdatesCannotDelete(t *testing.T) {
 
 	require.True(t, duration > 200*time.Millisecond)
 }
+
+func TestUpdatesOfMailboxInNamespace(t *testing.T) {
+	u := newIMAPUpdates()
+	u.setOtherAddresses(func(address string) []string {
+		require.Equal(t, "Secondary@pm.me", address)
+		return []string{"primary@pm.me"}
+	})
+
+	u.DeleteMessage("Secondary@pm.me", "INBOX", 7, 3)
+
+	updates := map[string]string{}
+	for i := 0; i < 2; i++ {
+		update := <-u.ch
+		require.Equal(t, uint32(3), update.(*goIMAPBackend.ExpungeUpdate).SeqNum)
+		updates[update.Username()] = update.Mailbox()
+	}
+
+	require.Equal(t, map[string]string{
+		"Secondary@pm.me": "INBOX",
+		"primary@pm.me":   "Other Users/secondary@pm.me/INBOX",
+	}, updates)
+}
@@ -22,6 +22,8 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/ProtonMail/proton-bridge/internal/imap/namespace"
+	"github.com/ProtonMail/proton-bridge/internal/store"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 	imapquota "github.com/emersion/go-imap-quota"
 	goIMAPBackend "github.com/emersion/go-imap/backend"
@@ -31,6 +33,10 @@ var (
 	errNoSuchMailbox = errors.New("no such mailbox") //nolint[gochecknoglobals]
 )
 
+// otherUsersNamespace is the root of mailboxes of other addresses of the
+// account in split mode, e.g., "Other Users/alias@pm.me/INBOX".
+const otherUsersNamespace = "Other Users"
+
 type imapUser struct {
 	panicHandler panicHandler
 	backend      *imapBackend
@@ -130,17 +136,33 @@ func (iu *imapUser) ListMailboxes(showOnlySubcribed bool) ([]goIMAPBackend.Mailb
 	// Called from go-imap in goroutines - we need to handle panics for each function.
 	defer iu.panicHandler.HandlePanic()
 
-	mailboxes := []goIMAPBackend.Mailbox{}
-	for _, storeMailbox := range iu.storeAddress.ListMailboxes() {
-		if showOnlySubcribed && !iu.isSubscribed(storeMailbox.LabelID()) {
-			continue
-		}
-		mailbox := newIMAPMailbox(iu.panicHandler, iu, storeMailbox, iu.backend.builder)
-		mailboxes = append(mailboxes, mailbox)
-	}
-
+	mailboxes := iu.listAddressMailboxes(iu, "", showOnlySubcribed)
 	mailboxes = append(mailboxes, newLabelsRootMailbox())
 	mailboxes = append(mailboxes, newFoldersRootMailbox())
+	if hasSavedSearches(iu) {
+		mailboxes = append(mailboxes, newSearchesRootMailbox())
+	}
+
+	if otherAddresses := iu.otherAddresses(); len(otherAddresses) != 0 {
+		mailboxes = append(mailboxes, newNamespaceRootMailbox(otherUsersNamespace))
+
+		for _, address := range otherAddresses {
+			otherUser, err := iu.backend.getUser(address)
+			if err != nil {
+				log.WithField("address", address).WithError(err).Warn("Could not get other address")
+				continue
+			}
+
+			prefix := getNamespacePrefix(otherUser.currentAddressLowercase)
+			mailboxes = append(mailboxes, newNamespaceRootMailbox(strings.TrimSuffix(prefix, store.PathDelimiter)))
+			mailboxes = append(mailboxes, iu.listAddressMailboxes(otherUser, prefix, showOnlySubcribed)...)
+			mailboxes = append(mailboxes, newNamespaceRootMailbox(prefix+store.UserLabelsMailboxName))
+			mailboxes = append(mailboxes, newNamespaceRootMailbox(prefix+store.UserFoldersMailboxName))
+			if hasSavedSearches(otherUser) {
+				mailboxes = append(mailboxes, newNamespaceRootMailbox(prefix+store.SavedSearchesMailboxName))
+			}
+		}
+	}
 
 	log.WithField("mailboxes", mailboxes).Trace("Listing mailboxes")
 
@@ -152,7 +174,13 @@ func (iu *imapUser) GetMailbox(name string) (mb goIMAPBackend.Mailbox, err error
 	// Called from go-imap in goroutines - we need to handle panics for each function.
 	defer iu.panicHandler.HandlePanic()
 
-	storeMailbox, err := iu.storeAddress.GetMailbox(name)
+	addressUser, addressName, err := iu.resolveMailboxName(name)
+	if err != nil {
+		log.WithField("name", name).WithError(err).Error("Could not get mailbox")
+		return
+	}
+
+	storeMailbox, err := addressUser.storeAddress.GetMailbox(addressName)
 	if err != nil {
 		logMsg := log.WithField("name", name).WithError(err)
 
@@ -167,7 +195,11 @@ func (iu *imapUser) GetMailbox(name string) (mb goIMAPBackend.Mailbox, err error
 		return
 	}
 
-	return newIMAPMailbox(iu.panicHandler, iu, storeMailbox, iu.backend.builder), nil
+	mailbox := newIMAPMailbox(iu.panicHandler, addressUser, storeMailbox, iu.backend.builder)
+	if addressUser != iu {
+		mailbox.namespace = getNamespacePrefix(addressUser.currentAddressLowercase)
+	}
+	return mailbox, nil
 }
 
 // CreateMailbox creates a new mailbox.
@@ -175,7 +207,12 @@ func (iu *imapUser) CreateMailbox(name string) error {
 	// Called from go-imap in goroutines - we need to handle panics for each function.
 	defer iu.panicHandler.HandlePanic()
 
-	return iu.storeAddress.CreateMailbox(name)
+	addressUser, addressName, err := iu.resolveMailboxName(name)
+	if err != nil {
+		return err
+	}
+
+	return addressUser.storeAddress.CreateMailbox(addressName)
 }
 
 // DeleteMailbox permanently removes the mailbox with the given name.
@@ -183,7 +220,12 @@ func (iu *imapUser) DeleteMailbox(name string) (err error) {
 	// Called from go-imap in goroutines - we need to handle panics for each function.
 	defer iu.panicHandler.HandlePanic()
 
-	storeMailbox, err := iu.storeAddress.GetMailbox(name)
+	addressUser, addressName, err := iu.resolveMailboxName(name)
+	if err != nil {
+		return
+	}
+
+	storeMailbox, err := addressUser.storeAddress.GetMailbox(addressName)
 	if err != nil {
 		log.WithField("name", name).WithError(err).Error("Could not get mailbox")
 		return
@@ -199,13 +241,117 @@ func (iu *imapUser) RenameMailbox(oldName, newName string) (err error) {
 	// Called from go-imap in goroutines - we need to handle panics for each function.
 	defer iu.panicHandler.HandlePanic()
 
-	storeMailbox, err := iu.storeAddress.GetMailbox(oldName)
+	oldAddressUser, oldAddressName, err := iu.resolveMailboxName(oldName)
+	if err != nil {
+		return
+	}
+
+	newAddressUser, newAddressName, err := iu.resolveMailboxName(newName)
+	if err != nil {
+		return
+	}
+
+	if oldAddressUser != newAddressUser {
+		return errors.New("cannot move mailbox to other address")
+	}
+
+	storeMailbox, err := oldAddressUser.storeAddress.GetMailbox(oldAddressName)
 	if err != nil {
 		log.WithField("name", oldName).WithError(err).Error("Could not get mailbox")
 		return
 	}
 
-	return storeMailbox.Rename(newName)
+	return storeMailbox.Rename(newAddressName)
+}
+
+// Namespaces returns personal namespace and, in split mode with more than
+// one address, namespace of other addresses of the account.
+func (iu *imapUser) Namespaces() (personal, other, shared []namespace.Namespace) {
+	// Called from go-imap in goroutines - we need to handle panics for each function.
+	defer iu.panicHandler.HandlePanic()
+
+	personal = []namespace.Namespace{{Prefix: "", Delimiter: store.PathDelimiter}}
+	if len(iu.otherAddresses()) != 0 {
+		other = []namespace.Namespace{{Prefix: otherUsersNamespace + store.PathDelimiter, Delimiter: store.PathDelimiter}}
+	}
+	return personal, other, nil
+}
+
+// otherAddresses returns addresses of the account other than the logged in
+// one.
+func (iu *imapUser) otherAddresses() []string {
+	return getOtherAddresses(iu.user, iu.currentAddressLowercase)
+}
+
+// getOtherAddresses returns addresses of the account of the user other than
+// the given one. In combined mode all addresses share the same mailboxes,
+// therefore there is no other address.
+func getOtherAddresses(user bridgeUser, address string) []string {
+	if user.IsCombinedAddressMode() {
+		return nil
+	}
+
+	addresses := []string{}
+	for _, other := range user.GetStoreAddresses() {
+		if !strings.EqualFold(other, address) {
+			addresses = append(addresses, other)
+		}
+	}
+	return addresses
+}
+
+// resolveMailboxName returns the user of the address the mailbox belongs to
+// and the name of the mailbox within that address. Names outside of the
+// namespace of other addresses belong to the logged in address.
+func (iu *imapUser) resolveMailboxName(name string) (*imapUser, string, error) {
+	prefix := otherUsersNamespace + store.PathDelimiter
+	if !strings.HasPrefix(name, prefix) {
+		return iu, name, nil
+	}
+	name = strings.TrimPrefix(name, prefix)
+
+	for _, address := range iu.otherAddresses() {
+		addressPrefix := strings.ToLower(address) + store.PathDelimiter
+		if len(name) > len(addressPrefix) && strings.ToLower(name[:len(addressPrefix)]) == addressPrefix {
+			addressUser, err := iu.backend.getUser(address)
+			if err != nil {
+				return nil, "", err
+			}
+			return addressUser, name[len(addressPrefix):], nil
+		}
+	}
+
+	return nil, "", errNoSuchMailbox
+}
+
+// getNamespacePrefix returns prefix of mailbox names of the other address.
+func getNamespacePrefix(address string) string {
+	return otherUsersNamespace + store.PathDelimiter + strings.ToLower(address) + store.PathDelimiter
+}
+
+// hasSavedSearches returns whether the address has any saved search mailbox.
+func hasSavedSearches(addressUser *imapUser) bool {
+	for _, storeMailbox := range addressUser.storeAddress.ListMailboxes() {
+		if storeMailbox.IsSearch() {
+			return true
+		}
+	}
+	return false
+}
+
+// listAddressMailboxes returns mailboxes of the address of the user with
+// the prefix used for the namespace.
+func (iu *imapUser) listAddressMailboxes(addressUser *imapUser, prefix string, showOnlySubcribed bool) []goIMAPBackend.Mailbox {
+	mailboxes := []goIMAPBackend.Mailbox{}
+	for _, storeMailbox := range addressUser.storeAddress.ListMailboxes() {
+		if showOnlySubcribed && !iu.isSubscribed(storeMailbox.LabelID()) {
+			continue
+		}
+		mailbox := newIMAPMailbox(iu.panicHandler, addressUser, storeMailbox, iu.backend.builder)
+		mailbox.namespace = prefix
+		mailboxes = append(mailboxes, mailbox)
+	}
+	return mailboxes
 }
 
 // Logout is called when this User will no longer be used, likely because the
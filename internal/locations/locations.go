@@ -144,6 +144,16 @@ func (l *Locations) GetOldCachePath() string {
 	return filepath.Join(l.userCache, "cache")
 }
 
+// ProvideSievePath returns a location for user Sieve scripts (e.g. ~/.config/<company>/<app>/sieve).
+// It creates it if it doesn't already exist.
+func (l *Locations) ProvideSievePath() (string, error) {
+	if err := os.MkdirAll(l.getSievePath(), 0700); err != nil {
+		return "", err
+	}
+
+	return l.getSievePath(), nil
+}
+
 // ProvideUpdatesPath returns a location for update files (e.g. ~/.cache/<company>/<app>/updates).
 // It creates it if it doesn't already exist.
 func (l *Locations) ProvideUpdatesPath() (string, error) {
@@ -168,6 +178,10 @@ func (l *Locations) getSettingsPath() string {
 	return l.userConfig
 }
 
+func (l *Locations) getSievePath() string {
+	return filepath.Join(l.userConfig, "sieve")
+}
+
 func (l *Locations) getLogsPath() string {
 	return filepath.Join(l.userCache, "logs")
 }
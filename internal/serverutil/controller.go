@@ -81,21 +81,17 @@ func (c *controller) ListenAndServe() {
 	l := c.log.WithField("useSSL", c.server.UseSSL()).
 		WithField("address", c.server.Address())
 
-	var listener net.Listener
-	var err error
-
-	if c.server.UseSSL() {
-		listener, err = tls.Listen("tcp", c.server.Address(), c.server.TLSConfig())
-	} else {
-		listener, err = net.Listen("tcp", c.server.Address())
-	}
-
+	listener, err := c.listen(c.server.Address(), c.server.UseSSL())
 	if err != nil {
 		l.WithError(err).Error("Cannot start listner.")
 		c.signals.Emit(events.ErrorEvent, string(c.server.Protocol())+" failed: "+err.Error())
 		return
 	}
 
+	if provider, ok := c.server.(SSLAddressProvider); ok && provider.SSLAddress() != "" {
+		go c.serveSSL(provider.SSLAddress())
+	}
+
 	// When starting the Bridge, we don't want to retry to notify user
 	// quickly about the issue. Very probably retry will not help anyway.
 	l.Info("Starting server")
@@ -103,6 +99,32 @@ func (c *controller) ListenAndServe() {
 	l.WithError(err).Debug("GoSMTP not serving")
 }
 
+func (c *controller) listen(address string, useSSL bool) (net.Listener, error) {
+	if useSSL {
+		return tls.Listen("tcp", address, c.server.TLSConfig())
+	}
+	return net.Listen("tcp", address)
+}
+
+// serveSSL serves the additional implicit TLS listener. Failure of this
+// listener does not stop the main one.
+func (c *controller) serveSSL(address string) {
+	defer c.server.HandlePanic()
+
+	l := c.log.WithField("useSSL", true).WithField("address", address)
+
+	listener, err := c.listen(address, true)
+	if err != nil {
+		l.WithError(err).Error("Cannot start SSL listener.")
+		c.signals.Emit(events.ErrorEvent, string(c.server.Protocol())+" SSL failed: "+err.Error())
+		return
+	}
+
+	l.Info("Starting SSL listener")
+	err = c.server.Serve(&connListener{listener, c.server})
+	l.WithError(err).Debug("SSL listener not serving")
+}
+
 func monitorDisconnectedUsers(s Server, l listener.Listener, done <-chan void) {
 	ch := make(chan string)
 	l.Add(events.CloseConnectionEvent, ch)
@@ -23,4 +23,6 @@ const (
 	HTTP = Protocol("HTTP")
 	IMAP = Protocol("IMAP")
 	SMTP = Protocol("SMTP")
+
+	ManageSieve = Protocol("ManageSieve")
 )
@@ -39,3 +39,10 @@ type Server interface {
 	Serve(net.Listener) error
 	StopServe() error
 }
+
+// SSLAddressProvider is a server which listens also with implicit TLS on
+// an additional address next to the main one. Empty address means there is
+// no additional listener.
+type SSLAddressProvider interface {
+	SSLAddress() string
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package serverutil
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// SessionInfo is a snapshot of one client session.
+type SessionInfo struct {
+	ID            int           `json:"id"`
+	Protocol      Protocol      `json:"protocol"`
+	RemoteAddress string        `json:"remoteAddress"`
+	User          string        `json:"user,omitempty"`
+	Client        string        `json:"client,omitempty"`
+	Mailbox       string        `json:"mailbox,omitempty"`
+	Started       time.Time     `json:"started"`
+	LastActivity  time.Time     `json:"lastActivity"`
+	Commands      []CommandInfo `json:"commands"`
+}
+
+// CommandInfo holds statistics of one command within a session.
+type CommandInfo struct {
+	Name          string        `json:"name"`
+	Count         int           `json:"count"`
+	TotalDuration time.Duration `json:"totalDuration"`
+	MaxDuration   time.Duration `json:"maxDuration"`
+}
+
+// Sessions keeps track of active client sessions of all servers. It holds
+// only metadata about the sessions, no private data of the messages.
+type Sessions struct {
+	lock     sync.RWMutex
+	lastID   int
+	sessions map[int]*SessionInfo
+	commands map[int]map[string]*CommandInfo
+}
+
+// NewSessions returns empty session tracker.
+func NewSessions() *Sessions {
+	return &Sessions{
+		sessions: map[int]*SessionInfo{},
+		commands: map[int]map[string]*CommandInfo{},
+	}
+}
+
+// Add registers a new session and returns its ID.
+func (s *Sessions) Add(protocol Protocol, remoteAddress string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.lastID++
+	now := time.Now()
+	s.sessions[s.lastID] = &SessionInfo{
+		ID:            s.lastID,
+		Protocol:      protocol,
+		RemoteAddress: remoteAddress,
+		Started:       now,
+		LastActivity:  now,
+	}
+	s.commands[s.lastID] = map[string]*CommandInfo{}
+
+	return s.lastID
+}
+
+// Remove unregisters the session.
+func (s *Sessions) Remove(id int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.sessions, id)
+	delete(s.commands, id)
+}
+
+// SetUser sets the user logged in by the session.
+func (s *Sessions) SetUser(id int, user string) {
+	s.update(id, func(session *SessionInfo) { session.User = user })
+}
+
+// SetClient sets the client name, e.g., from IMAP ID command.
+func (s *Sessions) SetClient(id int, client string) {
+	s.update(id, func(session *SessionInfo) { session.Client = client })
+}
+
+// SetMailbox sets the mailbox selected by the session.
+func (s *Sessions) SetMailbox(id int, mailbox string) {
+	s.update(id, func(session *SessionInfo) { session.Mailbox = mailbox })
+}
+
+// AddCommand records the finished command and its duration.
+func (s *Sessions) AddCommand(id int, name string, duration time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+	session.LastActivity = time.Now()
+
+	command, ok := s.commands[id][name]
+	if !ok {
+		command = &CommandInfo{Name: name}
+		s.commands[id][name] = command
+	}
+	command.Count++
+	command.TotalDuration += duration
+	if duration > command.MaxDuration {
+		command.MaxDuration = duration
+	}
+}
+
+func (s *Sessions) update(id int, callback func(*SessionInfo)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if session, ok := s.sessions[id]; ok {
+		callback(session)
+	}
+}
+
+// List returns snapshot of all active sessions ordered by the time they
+// were started.
+func (s *Sessions) List() []SessionInfo {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	list := make([]SessionInfo, 0, len(s.sessions))
+	for id, session := range s.sessions {
+		info := *session
+		info.Commands = make([]CommandInfo, 0, len(s.commands[id]))
+		for _, command := range s.commands[id] {
+			info.Commands = append(info.Commands, *command)
+		}
+		sort.Slice(info.Commands, func(i, j int) bool {
+			return info.Commands[i].Name < info.Commands[j].Name
+		})
+		list = append(list, info)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	return list
+}
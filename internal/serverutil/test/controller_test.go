@@ -18,10 +18,13 @@
 package test
 
 import (
+	"io/ioutil"
 	"net/http"
+	"os"
 	"testing"
 	"time"
 
+	"github.com/ProtonMail/proton-bridge/internal/config/tls"
 	"github.com/ProtonMail/proton-bridge/internal/events"
 	"github.com/ProtonMail/proton-bridge/internal/serverutil"
 	"github.com/ProtonMail/proton-bridge/pkg/listener"
@@ -84,6 +87,42 @@ func TestControllerFailOnBusyPort(t *testing.T) {
 	}
 }
 
+func TestControllerListenSSLAddress(t *testing.T) {
+	r, s, l, c := setup(t)
+
+	dir, err := ioutil.TempDir("", "test-tls")
+	r.NoError(err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	template, err := tls.NewTLSTemplate()
+	r.NoError(err)
+	r.NoError(tls.New(dir).GenerateCerts(template))
+	s.tls, err = tls.New(dir).GetConfig()
+	r.NoError(err)
+	s.sslPort = 11189
+
+	errorCh := l.ProvideChannel(events.ErrorEvent)
+
+	r.True(s.sslPortIsFree())
+	go c.ListenAndServe()
+	r.Eventually(s.portIsOccupied, time.Second, 50*time.Millisecond)
+	r.Eventually(s.sslPortIsOccupied, time.Second, 50*time.Millisecond)
+
+	r.NoError(s.ping())
+	r.NoError(s.pingSSL())
+
+	c.Close()
+	r.Eventually(s.portIsFree, time.Second, 50*time.Millisecond)
+	r.Eventually(s.sslPortIsFree, time.Second, 50*time.Millisecond)
+
+	select {
+	case msg := <-errorCh:
+		r.Fail("Expected no error but have %q", msg)
+	case <-time.Tick(100 * time.Millisecond):
+		break
+	}
+}
+
 func TestControllerCallDisconnectUser(t *testing.T) {
 	r, s, l, c := setup(t)
 
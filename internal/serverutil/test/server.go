@@ -40,8 +40,9 @@ type testServer struct {
 	debugClient bool
 	calledDisconnected int
 
-	port int
-	tls  *tls.Config
+	port    int
+	sslPort int
+	tls     *tls.Config
 
 	localDebug, remoteDebug io.Writer
 }
@@ -52,6 +53,13 @@ func (s *testServer) Address() string             { return fmt.Sprintf("127.0.0.
 func (s *testServer) TLSConfig() *tls.Config      { return s.tls }
 func (s *testServer) HandlePanic()                {}
 
+func (s *testServer) SSLAddress() string {
+	if s.sslPort == 0 {
+		return ""
+	}
+	return fmt.Sprintf("127.0.0.1:%d", s.sslPort)
+}
+
 func (s *testServer) DebugServer() bool { return s.debugServer }
 func (s *testServer) DebugClient() bool { return s.debugClient }
 func (s *testServer) SetLoggers(localDebug, remoteDebug io.Writer) {
@@ -86,3 +94,21 @@ func (s *testServer) ping() error {
 
 	return resp.Body.Close()
 }
+
+func (s *testServer) sslPortIsFree() bool {
+	return ports.IsPortFree(s.sslPort)
+}
+
+func (s *testServer) sslPortIsOccupied() bool {
+	return !ports.IsPortFree(s.sslPort)
+}
+
+func (s *testServer) pingSSL() error {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: s.tls.RootCAs}}}
+	resp, err := client.Get("https://" + s.SSLAddress() + "/ping")
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
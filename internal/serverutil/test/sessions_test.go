@@ -0,0 +1,63 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/serverutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessions(t *testing.T) {
+	r := require.New(t)
+	s := serverutil.NewSessions()
+
+	imapID := s.Add(serverutil.IMAP, "127.0.0.1:1234")
+	smtpID := s.Add(serverutil.SMTP, "127.0.0.1:5678")
+
+	s.SetUser(imapID, "user@pm.me")
+	s.SetClient(imapID, "Thunderbird 78.0")
+	s.SetMailbox(imapID, "INBOX")
+	s.AddCommand(imapID, "SELECT", 2*time.Millisecond)
+	s.AddCommand(imapID, "FETCH", 1*time.Millisecond)
+	s.AddCommand(imapID, "FETCH", 3*time.Millisecond)
+
+	list := s.List()
+	r.Len(list, 2)
+	r.Equal(imapID, list[0].ID)
+	r.Equal(serverutil.IMAP, list[0].Protocol)
+	r.Equal("127.0.0.1:1234", list[0].RemoteAddress)
+	r.Equal("user@pm.me", list[0].User)
+	r.Equal("Thunderbird 78.0", list[0].Client)
+	r.Equal("INBOX", list[0].Mailbox)
+	r.Equal([]serverutil.CommandInfo{
+		{Name: "FETCH", Count: 2, TotalDuration: 4 * time.Millisecond, MaxDuration: 3 * time.Millisecond},
+		{Name: "SELECT", Count: 1, TotalDuration: 2 * time.Millisecond, MaxDuration: 2 * time.Millisecond},
+	}, list[0].Commands)
+	r.Equal(smtpID, list[1].ID)
+	r.Empty(list[1].Commands)
+
+	s.Remove(imapID)
+	s.AddCommand(imapID, "LOGOUT", time.Millisecond)
+
+	list = s.List()
+	r.Len(list, 1)
+	r.Equal(smtpID, list[0].ID)
+}
@@ -0,0 +1,490 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sieve
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/serverutil"
+	"github.com/ProtonMail/proton-bridge/pkg/sieve"
+	"github.com/emersion/go-sasl"
+	"github.com/pkg/errors"
+)
+
+const (
+	implementation = "ProtonMail Bridge"
+	version        = "1.0"
+
+	// Response codes defined by RFC5804 section 1.3.
+	codeActive        = "ACTIVE"
+	codeAlreadyExists = "ALREADYEXISTS"
+	codeNonExistent   = "NONEXISTENT"
+	codeQuotaMaxSize  = "QUOTA/MAXSIZE"
+	codeTag           = "TAG"
+)
+
+var errLogout = errors.New("logout")
+
+// conn is one client connection of the ManageSieve server.
+type conn struct {
+	server *Server
+
+	netConn     net.Conn
+	reader      *protocolReader
+	writer      *bufio.Writer
+	localDebug  io.Writer
+	remoteDebug io.Writer
+	isTLS       bool
+	sessionID   int
+
+	lock     sync.Mutex
+	user     bridgeUser
+	username string
+}
+
+func newConn(server *Server, netConn net.Conn, localDebug, remoteDebug io.Writer) *conn {
+	c := &conn{
+		server:      server,
+		localDebug:  localDebug,
+		remoteDebug: remoteDebug,
+	}
+	c.setNetConn(netConn)
+	return c
+}
+
+func (c *conn) setNetConn(netConn net.Conn) {
+	c.netConn = netConn
+
+	var r io.Reader = netConn
+	if c.remoteDebug != nil {
+		r = io.TeeReader(r, c.remoteDebug)
+	}
+	c.reader = newProtocolReader(r)
+
+	var w io.Writer = netConn
+	if c.localDebug != nil {
+		w = io.MultiWriter(w, c.localDebug)
+	}
+	c.writer = bufio.NewWriter(w)
+}
+
+func (c *conn) close() error {
+	return c.netConn.Close()
+}
+
+func (c *conn) getUsername() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.username
+}
+
+func (c *conn) getUser() bridgeUser {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.user
+}
+
+func (c *conn) serve() {
+	defer c.close() //nolint[errcheck]
+
+	if c.server.sessions != nil {
+		c.sessionID = c.server.sessions.Add(serverutil.ManageSieve, c.netConn.RemoteAddr().String())
+		defer c.server.sessions.Remove(c.sessionID)
+	}
+
+	c.writeCapabilities()
+	c.write(response("OK", "", implementation+" ready"))
+
+	for {
+		if err := c.writer.Flush(); err != nil {
+			return
+		}
+
+		name, args, err := c.reader.readCommand()
+		if _, ok := err.(protocolError); ok {
+			c.write(response("NO", "", err.Error()))
+			continue
+		}
+		if err == errLineTooLong || err == errLiteralTooLong {
+			c.write(response("BYE", "", err.Error()))
+			_ = c.writer.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		start := time.Now()
+		err = c.handle(name, args)
+		if c.server.sessions != nil {
+			c.server.sessions.AddCommand(c.sessionID, name, time.Since(start))
+		}
+		if err != nil {
+			_ = c.writer.Flush()
+			return
+		}
+	}
+}
+
+// handle handles one command. Only errors breaking the connection are
+// returned, command failures are sent to the client as NO responses.
+func (c *conn) handle(name string, args []string) error { //nolint[funlen]
+	switch name {
+	case "CAPABILITY":
+		c.writeCapabilities()
+		c.write(response("OK", "", ""))
+		return nil
+	case "NOOP":
+		c.handleNoop(args)
+		return nil
+	case "LOGOUT":
+		c.write(response("OK", "", "Logout completed"))
+		return errLogout
+	case "STARTTLS":
+		return c.handleStartTLS()
+	case "AUTHENTICATE":
+		return c.handleAuthenticate(args)
+	}
+
+	user := c.getUser()
+	if user == nil {
+		switch name {
+		case "LISTSCRIPTS", "GETSCRIPT", "PUTSCRIPT", "CHECKSCRIPT", "SETACTIVE", "DELETESCRIPT", "RENAMESCRIPT", "HAVESPACE":
+			c.write(response("NO", "", "Authentication required"))
+		default:
+			c.write(response("NO", "", "Unknown command "+name))
+		}
+		return nil
+	}
+
+	switch name {
+	case "LISTSCRIPTS":
+		c.handleListScripts(user.ID(), args)
+	case "GETSCRIPT":
+		c.handleGetScript(user.ID(), args)
+	case "PUTSCRIPT":
+		c.handlePutScript(user.ID(), args)
+	case "CHECKSCRIPT":
+		c.handleCheckScript(args)
+	case "SETACTIVE":
+		c.handleSetActive(user.ID(), args)
+	case "DELETESCRIPT":
+		c.handleDeleteScript(user.ID(), args)
+	case "RENAMESCRIPT":
+		c.handleRenameScript(user.ID(), args)
+	case "HAVESPACE":
+		c.handleHaveSpace(args)
+	default:
+		c.write(response("NO", "", "Unknown command "+name))
+	}
+
+	return nil
+}
+
+func (c *conn) write(line string) {
+	_, _ = c.writer.WriteString(line)
+}
+
+func (c *conn) writeCapabilities() {
+	c.write(quote("IMPLEMENTATION") + " " + quote(implementation) + "\r\n")
+	c.write(quote("SIEVE") + " " + quote(strings.Join(sieve.Extensions(), " ")) + "\r\n")
+	if c.getUser() == nil {
+		c.write(quote("SASL") + " " + quote(sasl.Plain) + "\r\n")
+	}
+	if !c.isTLS && c.server.tls != nil {
+		c.write(quote("STARTTLS") + "\r\n")
+	}
+	c.write(quote("VERSION") + " " + quote(version) + "\r\n")
+}
+
+// writeError sends NO response for the error of script operation.
+func (c *conn) writeError(err error) {
+	switch err {
+	case ErrNoSuchScript:
+		c.write(response("NO", codeNonExistent, err.Error()))
+	case ErrScriptExists:
+		c.write(response("NO", codeAlreadyExists, err.Error()))
+	case ErrActiveScript:
+		c.write(response("NO", codeActive, err.Error()))
+	case ErrScriptTooBig:
+		c.write(response("NO", codeQuotaMaxSize, err.Error()))
+	default:
+		c.write(response("NO", "", err.Error()))
+	}
+}
+
+func (c *conn) checkArgs(args []string, count int) bool {
+	if len(args) != count {
+		c.write(response("NO", "", "Invalid number of arguments"))
+		return false
+	}
+	return true
+}
+
+func (c *conn) handleNoop(args []string) {
+	if len(args) > 1 {
+		c.write(response("NO", "", "Invalid number of arguments"))
+		return
+	}
+	if len(args) == 1 {
+		c.write(response("OK", codeTag+" "+quote(args[0]), "Done"))
+		return
+	}
+	c.write(response("OK", "", "Done"))
+}
+
+func (c *conn) handleStartTLS() error {
+	if c.isTLS || c.server.tls == nil {
+		c.write(response("NO", "", "TLS is not available"))
+		return nil
+	}
+
+	c.write(response("OK", "", "Begin TLS negotiation"))
+	if err := c.writer.Flush(); err != nil {
+		return err
+	}
+
+	tlsConn := tls.Server(c.netConn, c.server.tls)
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	c.setNetConn(tlsConn)
+	c.isTLS = true
+	c.lock.Unlock()
+
+	c.writeCapabilities()
+	c.write(response("OK", "", "TLS negotiation successful"))
+	return nil
+}
+
+func (c *conn) handleAuthenticate(args []string) error {
+	if c.getUser() != nil {
+		c.write(response("NO", "", "Already authenticated"))
+		return nil
+	}
+	if len(args) < 1 || len(args) > 2 {
+		c.write(response("NO", "", "Invalid number of arguments"))
+		return nil
+	}
+	if !strings.EqualFold(args[0], sasl.Plain) {
+		c.write(response("NO", "", "Unsupported authentication mechanism"))
+		return nil
+	}
+
+	var user bridgeUser
+	var username string
+
+	server := sasl.NewPlainServer(func(identity, name, password string) error {
+		if identity != "" && identity != name {
+			return errors.New("identities not supported")
+		}
+
+		var err error
+		if user, err = c.server.bridge.GetUser(strings.ToLower(name)); err != nil {
+			log.Warn("Cannot get user: ", err)
+			return err
+		}
+		if err = user.CheckBridgeLogin(password); err != nil {
+			log.WithError(err).Error("Could not check bridge password")
+			return err
+		}
+
+		username = strings.ToLower(name)
+		return nil
+	})
+
+	var saslResponse []byte
+	if len(args) == 2 {
+		var err error
+		if saslResponse, err = base64.StdEncoding.DecodeString(args[1]); err != nil {
+			c.write(response("NO", "", "Invalid base64 string"))
+			return nil
+		}
+	}
+
+	for {
+		challenge, done, err := server.Next(saslResponse)
+		if err != nil {
+			c.write(response("NO", "", "Authentication failed"))
+			return nil
+		}
+		if done {
+			break
+		}
+
+		c.write(quote(base64.StdEncoding.EncodeToString(challenge)) + "\r\n")
+		if err := c.writer.Flush(); err != nil {
+			return err
+		}
+
+		fields, err := c.reader.readFields()
+		if err != nil {
+			return err
+		}
+		if len(fields) != 1 || fields[0] == "*" {
+			c.write(response("NO", "", "Authentication cancelled"))
+			return nil
+		}
+		if saslResponse, err = base64.StdEncoding.DecodeString(fields[0]); err != nil {
+			c.write(response("NO", "", "Invalid base64 string"))
+			return nil
+		}
+	}
+
+	c.lock.Lock()
+	c.user = user
+	c.username = username
+	c.lock.Unlock()
+
+	if c.server.sessions != nil {
+		c.server.sessions.SetUser(c.sessionID, username)
+	}
+
+	c.write(response("OK", "", "Logged in"))
+	return nil
+}
+
+func (c *conn) handleListScripts(userID string, args []string) {
+	if !c.checkArgs(args, 0) {
+		return
+	}
+
+	scripts, err := c.server.scripts.List(userID)
+	if err != nil {
+		c.writeError(err)
+		return
+	}
+
+	for _, script := range scripts {
+		if script.Active {
+			c.write(quote(script.Name) + " ACTIVE\r\n")
+		} else {
+			c.write(quote(script.Name) + "\r\n")
+		}
+	}
+	c.write(response("OK", "", ""))
+}
+
+func (c *conn) handleGetScript(userID string, args []string) {
+	if !c.checkArgs(args, 1) {
+		return
+	}
+
+	content, err := c.server.scripts.Get(userID, args[0])
+	if err != nil {
+		c.writeError(err)
+		return
+	}
+
+	c.write("{" + strconv.Itoa(len(content)) + "}\r\n" + content + "\r\n")
+	c.write(response("OK", "", ""))
+}
+
+func (c *conn) handlePutScript(userID string, args []string) {
+	if !c.checkArgs(args, 2) {
+		return
+	}
+
+	if err := c.server.scripts.Put(userID, args[0], args[1]); err != nil {
+		c.writeError(err)
+		return
+	}
+	c.write(response("OK", "", ""))
+}
+
+func (c *conn) handleCheckScript(args []string) {
+	if !c.checkArgs(args, 1) {
+		return
+	}
+
+	if err := c.server.scripts.Check(args[0]); err != nil {
+		c.writeError(err)
+		return
+	}
+	c.write(response("OK", "", ""))
+}
+
+func (c *conn) handleSetActive(userID string, args []string) {
+	if !c.checkArgs(args, 1) {
+		return
+	}
+
+	if err := c.server.scripts.SetActive(userID, args[0]); err != nil {
+		c.writeError(err)
+		return
+	}
+	c.write(response("OK", "", ""))
+}
+
+func (c *conn) handleDeleteScript(userID string, args []string) {
+	if !c.checkArgs(args, 1) {
+		return
+	}
+
+	if err := c.server.scripts.Delete(userID, args[0]); err != nil {
+		c.writeError(err)
+		return
+	}
+	c.write(response("OK", "", ""))
+}
+
+func (c *conn) handleRenameScript(userID string, args []string) {
+	if !c.checkArgs(args, 2) {
+		return
+	}
+
+	if err := c.server.scripts.Rename(userID, args[0], args[1]); err != nil {
+		c.writeError(err)
+		return
+	}
+	c.write(response("OK", "", ""))
+}
+
+func (c *conn) handleHaveSpace(args []string) {
+	if !c.checkArgs(args, 2) {
+		return
+	}
+
+	if err := checkScriptName(args[0]); err != nil {
+		c.writeError(err)
+		return
+	}
+
+	size, err := strconv.Atoi(args[1])
+	if err != nil || size < 0 {
+		c.write(response("NO", "", "Invalid size"))
+		return
+	}
+	if size > maxScriptSize {
+		c.writeError(ErrScriptTooBig)
+		return
+	}
+	c.write(response("OK", "", ""))
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sieve
+
+import (
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+
+	"github.com/ProtonMail/proton-bridge/internal/store"
+	pkgMIME "github.com/ProtonMail/proton-bridge/pkg/mime"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/ProtonMail/proton-bridge/pkg/sieve"
+	"github.com/pkg/errors"
+)
+
+const (
+	flagSeen    = `\Seen`
+	flagFlagged = `\Flagged`
+)
+
+// Redirector sends the existing message to other recipients.
+type Redirector interface {
+	RedirectMessage(userID, addressID, messageID string, to []string) error
+}
+
+// Filter applies active Sieve scripts on received messages.
+type Filter struct {
+	scripts *Scripts
+
+	lock       sync.RWMutex
+	redirector Redirector
+}
+
+// NewFilter returns filter using scripts from the storage.
+func NewFilter(scripts *Scripts) *Filter {
+	return &Filter{scripts: scripts}
+}
+
+// SetRedirector sets the redirector used by redirect action. Without
+// redirector, redirected messages are kept in INBOX.
+func (f *Filter) SetRedirector(redirector Redirector) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.redirector = redirector
+}
+
+func (f *Filter) getRedirector() Redirector {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return f.redirector
+}
+
+// FilterMessage implements store.MessageFilter.
+func (f *Filter) FilterMessage(userID string, message *pmapi.Message) (*store.FilterActions, error) {
+	script, err := f.scripts.Active(userID)
+	if err != nil || script == nil {
+		return nil, err
+	}
+
+	result, err := script.Execute(newMessage(message))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to execute script")
+	}
+
+	actions := &store.FilterActions{
+		Mailboxes: result.FileInto,
+		Keep:      result.Keep,
+	}
+
+	for _, flag := range result.Flags {
+		switch {
+		case strings.EqualFold(flag, flagSeen):
+			actions.MarkRead = true
+		case strings.EqualFold(flag, flagFlagged):
+			actions.Star = true
+		default:
+			log.WithField("flag", flag).Debug("Ignoring unsupported flag")
+		}
+	}
+
+	if len(result.Redirect) != 0 {
+		if err := f.redirect(userID, message, result.Redirect); err != nil {
+			log.WithError(err).Warn("Failed to redirect message, keeping it")
+			actions.Keep = true
+		}
+	}
+
+	return actions, nil
+}
+
+func (f *Filter) redirect(userID string, message *pmapi.Message, to []string) error {
+	redirector := f.getRedirector()
+	if redirector == nil {
+		return errors.New("redirect is not available")
+	}
+
+	return redirector.RedirectMessage(userID, message.AddressID, message.ID, to)
+}
+
+// message adapts API message to sieve.Message. Headers which are not part
+// of the message metadata are constructed from other fields.
+type message struct {
+	*pmapi.Message
+}
+
+func newMessage(m *pmapi.Message) sieve.Message {
+	return &message{Message: m}
+}
+
+func (m *message) Header(name string) []string {
+	if values, ok := m.Message.Header[textproto.CanonicalMIMEHeaderKey(name)]; ok {
+		decoded := make([]string, len(values))
+		for i, value := range values {
+			decoded[i] = decodeHeader(value)
+		}
+		return decoded
+	}
+
+	switch strings.ToLower(name) {
+	case "from":
+		if m.Sender != nil {
+			return formatAddressList([]*mail.Address{m.Sender})
+		}
+	case "to":
+		return formatAddressList(m.ToList)
+	case "cc":
+		return formatAddressList(m.CCList)
+	case "reply-to":
+		return formatAddressList(m.ReplyTos)
+	case "subject":
+		return []string{m.Subject}
+	}
+
+	return nil
+}
+
+func (m *message) Size() int64 {
+	return m.Message.Size
+}
+
+func decodeHeader(value string) string {
+	decoded, err := pkgMIME.WordDec.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// formatAddressList returns addresses as one header value without encoding
+// of the names, as the values would be after decoding of the header.
+func formatAddressList(addresses []*mail.Address) []string {
+	if len(addresses) == 0 {
+		return nil
+	}
+
+	formatted := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		if address.Name == "" {
+			formatted = append(formatted, address.Address)
+		} else {
+			formatted = append(formatted, quoteName(address.Name)+" <"+address.Address+">")
+		}
+	}
+
+	return []string{strings.Join(formatted, ", ")}
+}
+
+// quoteName quotes the display name if it contains special characters so
+// the address list can be parsed back.
+func quoteName(name string) string {
+	if !strings.ContainsAny(name, `()<>[]:;@\,."`) {
+		return name
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sieve
+
+import (
+	"errors"
+	"net/mail"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/internal/store"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/require"
+)
+
+type testRedirector struct {
+	to  []string
+	err error
+}
+
+func (r *testRedirector) RedirectMessage(userID, addressID, messageID string, to []string) error {
+	r.to = append(r.to, to...)
+	return r.err
+}
+
+func newTestFilter(t *testing.T, script string) (*Filter, func()) {
+	scripts, clear := newTestScripts(t)
+
+	require.NoError(t, scripts.Put("user", "test", script))
+	require.NoError(t, scripts.SetActive("user", "test"))
+
+	return NewFilter(scripts), clear
+}
+
+func newTestMessage() *pmapi.Message {
+	return &pmapi.Message{
+		ID:        "msg",
+		AddressID: "addr",
+		Subject:   "Weekly report",
+		Sender:    &mail.Address{Name: "Doe, John", Address: "john@example.com"},
+		ToList:    []*mail.Address{{Address: "me@pm.me"}},
+		Size:      1000,
+	}
+}
+
+func TestFilterWithoutScript(t *testing.T) {
+	scripts, clear := newTestScripts(t)
+	defer clear()
+
+	actions, err := NewFilter(scripts).FilterMessage("user", newTestMessage())
+	require.NoError(t, err)
+	require.Nil(t, actions)
+}
+
+func TestFilterFileIntoAndFlags(t *testing.T) {
+	filter, clear := newTestFilter(t, `require ["fileinto", "imap4flags"];
+if address :domain "from" "example.com" {
+	fileinto :flags ["\\Seen", "\\Flagged", "$label1"] "Folders/Example";
+}`)
+	defer clear()
+
+	actions, err := filter.FilterMessage("user", newTestMessage())
+	require.NoError(t, err)
+	require.Equal(t, &store.FilterActions{
+		Mailboxes: []string{"Folders/Example"},
+		MarkRead:  true,
+		Star:      true,
+	}, actions)
+}
+
+func TestFilterHeaderFromMetadata(t *testing.T) {
+	filter, clear := newTestFilter(t, `if allof(header :contains "subject" "report", header :is "from" "\"Doe, John\" <john@example.com>") { discard; }`)
+	defer clear()
+
+	actions, err := filter.FilterMessage("user", newTestMessage())
+	require.NoError(t, err)
+	require.Equal(t, &store.FilterActions{}, actions)
+}
+
+func TestFilterRedirect(t *testing.T) {
+	filter, clear := newTestFilter(t, `redirect "other@example.com";`)
+	defer clear()
+
+	actions, err := filter.FilterMessage("user", newTestMessage())
+	require.NoError(t, err)
+	require.True(t, actions.Keep, "message is kept without redirector")
+
+	redirector := &testRedirector{}
+	filter.SetRedirector(redirector)
+
+	actions, err = filter.FilterMessage("user", newTestMessage())
+	require.NoError(t, err)
+	require.False(t, actions.Keep)
+	require.Equal(t, []string{"other@example.com"}, redirector.to)
+
+	redirector.err = errors.New("failed")
+
+	actions, err = filter.FilterMessage("user", newTestMessage())
+	require.NoError(t, err)
+	require.True(t, actions.Keep, "message is kept when redirect fails")
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sieve
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	maxLineLength    = 8192
+	maxLiteralLength = maxScriptSize + maxLineLength
+)
+
+var (
+	errLineTooLong    = errors.New("line is too long")
+	errLiteralTooLong = errors.New("literal is too long")
+)
+
+// protocolError is a syntax error of the command. The rest of the command
+// is consumed so the connection can continue.
+type protocolError string
+
+func (err protocolError) Error() string {
+	return string(err)
+}
+
+// protocolReader reads commands of ManageSieve clients (RFC5804 section 4).
+type protocolReader struct {
+	r *bufio.Reader
+}
+
+func newProtocolReader(r io.Reader) *protocolReader {
+	return &protocolReader{r: bufio.NewReader(r)}
+}
+
+// readCommand returns upper-cased command name with its arguments. Atoms,
+// quoted strings, and literals are all returned as strings.
+func (pr *protocolReader) readCommand() (string, []string, error) {
+	fields, err := pr.readFields()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(fields) == 0 {
+		return "", nil, protocolError("empty command")
+	}
+	return strings.ToUpper(fields[0]), fields[1:], nil
+}
+
+// readFields reads one line with all literals it contains.
+func (pr *protocolReader) readFields() ([]string, error) {
+	fields := []string{}
+
+	for {
+		line, err := pr.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		literalLength, rest, err := parseLine(line, &fields)
+		if err != nil {
+			return nil, err
+		}
+		if literalLength < 0 {
+			if strings.TrimSpace(rest) != "" {
+				return nil, protocolError("unexpected characters at the end of line")
+			}
+			return fields, nil
+		}
+
+		literal := make([]byte, literalLength)
+		if _, err := io.ReadFull(pr.r, literal); err != nil {
+			return nil, err
+		}
+		fields = append(fields, string(literal))
+	}
+}
+
+func (pr *protocolReader) readLine() (string, error) {
+	line := []byte{}
+	for {
+		part, isPrefix, err := pr.r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, part...)
+		if len(line) > maxLineLength {
+			return "", errLineTooLong
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// parseLine appends fields of the line. If the line ends with a literal
+// announcement, its length is returned, otherwise the length is -1.
+func parseLine(line string, fields *[]string) (int, string, error) { //nolint[funlen]
+	for {
+		line = strings.TrimLeft(line, " ")
+		if line == "" {
+			return -1, "", nil
+		}
+
+		switch line[0] {
+		case '"':
+			value, rest, err := parseQuoted(line)
+			if err != nil {
+				return 0, "", err
+			}
+			*fields = append(*fields, value)
+			line = rest
+
+		case '{':
+			end := strings.IndexByte(line, '}')
+			if end < 0 {
+				return 0, "", protocolError("unterminated literal")
+			}
+			if strings.TrimSpace(line[end+1:]) != "" {
+				return 0, "", protocolError("literal has to be at the end of line")
+			}
+			length, err := strconv.Atoi(strings.TrimSuffix(line[1:end], "+"))
+			if err != nil || length < 0 {
+				return 0, "", protocolError("invalid literal length")
+			}
+			if length > maxLiteralLength {
+				return 0, "", errLiteralTooLong
+			}
+			return length, "", nil
+
+		default:
+			end := strings.IndexAny(line, ` "{`)
+			if end < 0 {
+				end = len(line)
+			}
+			*fields = append(*fields, line[:end])
+			line = line[end:]
+		}
+	}
+}
+
+func parseQuoted(line string) (string, string, error) {
+	var value strings.Builder
+	for i := 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+			if i == len(line) {
+				return "", "", protocolError("unterminated quoted string")
+			}
+			value.WriteByte(line[i])
+		case '"':
+			return value.String(), line[i+1:], nil
+		default:
+			value.WriteByte(line[i])
+		}
+	}
+	return "", "", protocolError("unterminated quoted string")
+}
+
+// quote returns the value as quoted string or literal if the value
+// cannot be quoted.
+func quote(value string) string {
+	if len(value) > 1024 || strings.ContainsAny(value, "\r\n\x00") {
+		return fmt.Sprintf("{%d}\r\n%s", len(value), value)
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// response returns the OK, NO or BYE response line with optional response
+// code and human readable text.
+func response(status, code, text string) string {
+	line := status
+	if code != "" {
+		line += " (" + code + ")"
+	}
+	if text != "" {
+		line += " " + quote(text)
+	}
+	return line + "\r\n"
+}
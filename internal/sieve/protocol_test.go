@@ -0,0 +1,71 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sieve
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCommand(t *testing.T) {
+	tests := []struct {
+		input    string
+		wantName string
+		wantArgs []string
+	}{
+		{"capability\r\n", "CAPABILITY", []string{}},
+		{"GETSCRIPT \"my \\\"script\\\"\"\r\n", "GETSCRIPT", []string{`my "script"`}},
+		{"PUTSCRIPT \"a\" {5+}\r\nkeep;\r\n", "PUTSCRIPT", []string{"a", "keep;"}},
+		{"PUTSCRIPT {1}\r\na {7+}\r\nkeep;\r\n\r\n", "PUTSCRIPT", []string{"a", "keep;\r\n"}},
+		{"HAVESPACE \"a\" 100\r\n", "HAVESPACE", []string{"a", "100"}},
+	}
+	for _, tc := range tests {
+		name, args, err := newProtocolReader(strings.NewReader(tc.input)).readCommand()
+		require.NoError(t, err, tc.input)
+		require.Equal(t, tc.wantName, name, tc.input)
+		require.Equal(t, tc.wantArgs, args, tc.input)
+	}
+}
+
+func TestReadCommandErrors(t *testing.T) {
+	for _, input := range []string{
+		"\r\n",
+		"GETSCRIPT \"unterminated\r\n",
+		"PUTSCRIPT {x+}\r\n",
+		"PUTSCRIPT {5+} \"a\"\r\n",
+	} {
+		_, _, err := newProtocolReader(strings.NewReader(input)).readCommand()
+		require.IsType(t, protocolError(""), err, input)
+	}
+
+	_, _, err := newProtocolReader(strings.NewReader("PUTSCRIPT {99999999+}\r\n")).readCommand()
+	require.Equal(t, errLiteralTooLong, err)
+}
+
+func TestQuote(t *testing.T) {
+	require.Equal(t, `"simple"`, quote("simple"))
+	require.Equal(t, `"with \"quotes\" and \\"`, quote(`with "quotes" and \`))
+	require.Equal(t, "{6}\r\nline\r\n", quote("line\r\n"))
+}
+
+func TestResponse(t *testing.T) {
+	require.Equal(t, "OK\r\n", response("OK", "", ""))
+	require.Equal(t, "NO (NONEXISTENT) \"script does not exist\"\r\n", response("NO", codeNonExistent, "script does not exist"))
+}
@@ -0,0 +1,327 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package sieve manages Sieve scripts of bridge users and applies them on
+// received messages. Scripts can be managed by clients using ManageSieve
+// protocol (RFC5804) served by Server.
+package sieve
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/ProtonMail/proton-bridge/pkg/sieve"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	scriptExtension = ".sieve"
+	activeFileName  = "active"
+
+	maxScriptNameLength = 128
+	maxScriptSize       = 1 << 20
+)
+
+var (
+	log = logrus.WithField("pkg", "sieve") //nolint[gochecknoglobals]
+
+	ErrNoSuchScript      = errors.New("script does not exist")
+	ErrScriptExists      = errors.New("script already exists")
+	ErrActiveScript      = errors.New("active script cannot be deleted")
+	ErrInvalidScriptName = errors.New("invalid script name")
+	ErrScriptTooBig      = errors.New("script is too big")
+)
+
+// ScriptInfo describes one stored script.
+type ScriptInfo struct {
+	Name   string
+	Active bool
+}
+
+// Scripts stores Sieve scripts of users in the directory, one subdirectory
+// per user. Parsed active scripts are cached.
+type Scripts struct {
+	path string
+
+	lock   sync.RWMutex
+	active map[string]*sieve.Script
+}
+
+// NewScripts returns script storage in the given directory.
+func NewScripts(path string) *Scripts {
+	return &Scripts{
+		path:   path,
+		active: map[string]*sieve.Script{},
+	}
+}
+
+// List returns all scripts of the user ordered by name.
+func (s *Scripts) List(userID string) ([]ScriptInfo, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	files, err := ioutil.ReadDir(s.getUserPath(userID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	active := s.getActiveName(userID)
+
+	scripts := []ScriptInfo{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), scriptExtension) {
+			continue
+		}
+		name := strings.TrimSuffix(file.Name(), scriptExtension)
+		scripts = append(scripts, ScriptInfo{Name: name, Active: name == active})
+	}
+
+	sort.Slice(scripts, func(i, j int) bool { return scripts[i].Name < scripts[j].Name })
+
+	return scripts, nil
+}
+
+// Get returns the content of the script.
+func (s *Scripts) Get(userID, name string) (string, error) {
+	if err := checkScriptName(name); err != nil {
+		return "", err
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	content, err := ioutil.ReadFile(s.getScriptPath(userID, name))
+	if os.IsNotExist(err) {
+		return "", ErrNoSuchScript
+	}
+
+	return string(content), err
+}
+
+// Check returns error if the script is not valid.
+func (s *Scripts) Check(content string) error {
+	if len(content) > maxScriptSize {
+		return ErrScriptTooBig
+	}
+
+	_, err := sieve.Parse(content)
+	return err
+}
+
+// Put validates and stores the script. Existing script is replaced.
+func (s *Scripts) Put(userID, name, content string) error {
+	if err := checkScriptName(name); err != nil {
+		return err
+	}
+
+	if err := s.Check(content); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := os.MkdirAll(s.getUserPath(userID), 0700); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(s.getScriptPath(userID, name), []byte(content), 0600); err != nil {
+		return err
+	}
+
+	if s.getActiveName(userID) == name {
+		delete(s.active, userID)
+	}
+
+	return nil
+}
+
+// Delete removes the script. Active script cannot be removed.
+func (s *Scripts) Delete(userID, name string) error {
+	if err := checkScriptName(name); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.getActiveName(userID) == name {
+		return ErrActiveScript
+	}
+
+	if err := os.Remove(s.getScriptPath(userID, name)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNoSuchScript
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Rename changes name of the script. New name must not be used already.
+func (s *Scripts) Rename(userID, oldName, newName string) error {
+	if err := checkScriptName(oldName); err != nil {
+		return err
+	}
+	if err := checkScriptName(newName); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := os.Stat(s.getScriptPath(userID, oldName)); os.IsNotExist(err) {
+		return ErrNoSuchScript
+	}
+	if _, err := os.Stat(s.getScriptPath(userID, newName)); err == nil {
+		return ErrScriptExists
+	}
+
+	if err := os.Rename(s.getScriptPath(userID, oldName), s.getScriptPath(userID, newName)); err != nil {
+		return err
+	}
+
+	if s.getActiveName(userID) == oldName {
+		return s.setActiveName(userID, newName)
+	}
+
+	return nil
+}
+
+// SetActive makes the script active. Empty name deactivates all scripts.
+func (s *Scripts) SetActive(userID, name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if name != "" {
+		if err := checkScriptName(name); err != nil {
+			return err
+		}
+		if _, err := os.Stat(s.getScriptPath(userID, name)); os.IsNotExist(err) {
+			return ErrNoSuchScript
+		}
+	}
+
+	return s.setActiveName(userID, name)
+}
+
+// Active returns the parsed active script of the user or nil if the user
+// has no active script.
+func (s *Scripts) Active(userID string) (*sieve.Script, error) {
+	s.lock.RLock()
+	script, ok := s.active[userID]
+	s.lock.RUnlock()
+	if ok {
+		return script, nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	name := s.getActiveName(userID)
+	if name == "" {
+		s.active[userID] = nil
+		return nil, nil
+	}
+
+	content, err := ioutil.ReadFile(s.getScriptPath(userID, name))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read active script")
+	}
+
+	if script, err = sieve.Parse(string(content)); err != nil {
+		return nil, errors.Wrap(err, "failed to parse active script")
+	}
+
+	s.active[userID] = script
+
+	return script, nil
+}
+
+// ClearUser removes all scripts of the user.
+func (s *Scripts) ClearUser(userID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.active, userID)
+
+	return os.RemoveAll(s.getUserPath(userID))
+}
+
+func (s *Scripts) getActiveName(userID string) string {
+	name, err := ioutil.ReadFile(filepath.Join(s.getUserPath(userID), activeFileName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithError(err).Warn("Failed to read active script name")
+		}
+		return ""
+	}
+	return strings.TrimSpace(string(name))
+}
+
+func (s *Scripts) setActiveName(userID, name string) error {
+	delete(s.active, userID)
+
+	path := filepath.Join(s.getUserPath(userID), activeFileName)
+
+	if name == "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(s.getUserPath(userID), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, []byte(name), 0600)
+}
+
+func (s *Scripts) getUserPath(userID string) string {
+	return filepath.Join(s.path, userID)
+}
+
+func (s *Scripts) getScriptPath(userID, name string) string {
+	return filepath.Join(s.getUserPath(userID), name+scriptExtension)
+}
+
+// checkScriptName refuses names which are not allowed by RFC5804 or which
+// cannot be used as a file name.
+func checkScriptName(name string) error {
+	if name == "" || len(name) > maxScriptNameLength || strings.HasPrefix(name, ".") {
+		return ErrInvalidScriptName
+	}
+
+	for _, r := range name {
+		if unicode.IsControl(r) || r == '/' || r == '\\' || r == ':' || r == unicode.ReplacementChar {
+			return ErrInvalidScriptName
+		}
+	}
+
+	return nil
+}
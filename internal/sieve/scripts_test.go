@@ -0,0 +1,117 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sieve
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestScripts(t *testing.T) (*Scripts, func()) {
+	dir, err := ioutil.TempDir("", "sieve-test")
+	require.NoError(t, err)
+
+	return NewScripts(dir), func() { _ = os.RemoveAll(dir) }
+}
+
+func TestScriptsPutGetList(t *testing.T) {
+	scripts, clear := newTestScripts(t)
+	defer clear()
+
+	list, err := scripts.List("user")
+	require.NoError(t, err)
+	require.Empty(t, list)
+
+	require.NoError(t, scripts.Put("user", "b", "keep;"))
+	require.NoError(t, scripts.Put("user", "a", "discard;"))
+	require.Error(t, scripts.Put("user", "c", "unknown;"))
+
+	content, err := scripts.Get("user", "a")
+	require.NoError(t, err)
+	require.Equal(t, "discard;", content)
+
+	_, err = scripts.Get("user", "c")
+	require.Equal(t, ErrNoSuchScript, err)
+
+	_, err = scripts.Get("other", "a")
+	require.Equal(t, ErrNoSuchScript, err)
+
+	list, err = scripts.List("user")
+	require.NoError(t, err)
+	require.Equal(t, []ScriptInfo{{Name: "a"}, {Name: "b"}}, list)
+}
+
+func TestScriptsActive(t *testing.T) {
+	scripts, clear := newTestScripts(t)
+	defer clear()
+
+	script, err := scripts.Active("user")
+	require.NoError(t, err)
+	require.Nil(t, script)
+
+	require.NoError(t, scripts.Put("user", "a", "discard;"))
+	require.Equal(t, ErrNoSuchScript, scripts.SetActive("user", "b"))
+	require.NoError(t, scripts.SetActive("user", "a"))
+
+	script, err = scripts.Active("user")
+	require.NoError(t, err)
+	require.NotNil(t, script)
+
+	list, err := scripts.List("user")
+	require.NoError(t, err)
+	require.Equal(t, []ScriptInfo{{Name: "a", Active: true}}, list)
+
+	require.Equal(t, ErrActiveScript, scripts.Delete("user", "a"))
+
+	require.NoError(t, scripts.SetActive("user", ""))
+	script, err = scripts.Active("user")
+	require.NoError(t, err)
+	require.Nil(t, script)
+
+	require.NoError(t, scripts.Delete("user", "a"))
+	require.Equal(t, ErrNoSuchScript, scripts.Delete("user", "a"))
+}
+
+func TestScriptsRename(t *testing.T) {
+	scripts, clear := newTestScripts(t)
+	defer clear()
+
+	require.NoError(t, scripts.Put("user", "a", "keep;"))
+	require.NoError(t, scripts.Put("user", "b", "keep;"))
+	require.NoError(t, scripts.SetActive("user", "a"))
+
+	require.Equal(t, ErrScriptExists, scripts.Rename("user", "a", "b"))
+	require.Equal(t, ErrNoSuchScript, scripts.Rename("user", "c", "d"))
+	require.NoError(t, scripts.Rename("user", "a", "c"))
+
+	list, err := scripts.List("user")
+	require.NoError(t, err)
+	require.Equal(t, []ScriptInfo{{Name: "b"}, {Name: "c", Active: true}}, list)
+}
+
+func TestCheckScriptName(t *testing.T) {
+	for _, name := range []string{"a", "My filters", "příliš"} {
+		require.NoError(t, checkScriptName(name), name)
+	}
+	for _, name := range []string{"", ".hidden", "a/b", "a\\b", "a\nb"} {
+		require.Equal(t, ErrInvalidScriptName, checkScriptName(name), name)
+	}
+}
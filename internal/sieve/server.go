@@ -0,0 +1,194 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sieve
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/ProtonMail/proton-bridge/internal/bridge"
+	"github.com/ProtonMail/proton-bridge/internal/serverutil"
+	"github.com/ProtonMail/proton-bridge/pkg/listener"
+	"github.com/pkg/errors"
+)
+
+type panicHandler interface {
+	HandlePanic()
+}
+
+// Server is Bridge ManageSieve server implementation.
+type Server struct {
+	panicHandler panicHandler
+	bridge       bridger
+	scripts      *Scripts
+	debug        bool
+	port         int
+	tls          *tls.Config
+	sessions     *serverutil.Sessions
+
+	lock                    sync.Mutex
+	closed                  bool
+	listeners               []net.Listener
+	conns                   map[*conn]struct{}
+	localDebug, remoteDebug io.Writer
+
+	controller serverutil.Controller
+}
+
+// NewManageSieveServer returns a ManageSieve server configured with the
+// given options.
+func NewManageSieveServer(
+	panicHandler panicHandler,
+	debug bool, port int,
+	tls *tls.Config,
+	bridge *bridge.Bridge,
+	scripts *Scripts,
+	eventListener listener.Listener,
+	sessions *serverutil.Sessions,
+) *Server {
+	return newManageSieveServer(panicHandler, debug, port, tls, newBridgeWrap(bridge), scripts, eventListener, sessions)
+}
+
+func newManageSieveServer(
+	panicHandler panicHandler,
+	debug bool, port int,
+	tls *tls.Config,
+	bridge bridger,
+	scripts *Scripts,
+	eventListener listener.Listener,
+	sessions *serverutil.Sessions,
+) *Server {
+	server := &Server{
+		panicHandler: panicHandler,
+		bridge:       bridge,
+		scripts:      scripts,
+		debug:        debug,
+		port:         port,
+		tls:          tls,
+		sessions:     sessions,
+		conns:        map[*conn]struct{}{},
+	}
+
+	server.controller = serverutil.NewController(server, eventListener)
+	return server
+}
+
+// ListenAndServe will run server and all monitors.
+func (s *Server) ListenAndServe() { s.controller.ListenAndServe() }
+
+// Close turns off server and monitors.
+func (s *Server) Close() { s.controller.Close() }
+
+// Implements servertutil.Server interface.
+
+func (s *Server) Protocol() serverutil.Protocol { return serverutil.ManageSieve }
+func (s *Server) UseSSL() bool                  { return false }
+func (s *Server) Address() string               { return fmt.Sprintf("%s:%d", bridge.Host, s.port) }
+func (s *Server) TLSConfig() *tls.Config        { return s.tls }
+func (s *Server) HandlePanic()                  { s.panicHandler.HandlePanic() }
+
+func (s *Server) DebugServer() bool { return s.debug }
+func (s *Server) DebugClient() bool { return s.debug }
+
+func (s *Server) SetLoggers(localDebug, remoteDebug io.Writer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.localDebug = localDebug
+	s.remoteDebug = remoteDebug
+}
+
+func (s *Server) DisconnectUser(address string) {
+	log.Info("Disconnecting all open ManageSieve connections for ", address)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for c := range s.conns {
+		if strings.EqualFold(c.getUsername(), address) {
+			if err := c.close(); err != nil {
+				log.WithError(err).Error("Failed to close the connection")
+			}
+		}
+	}
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return errors.New("server is closed")
+	}
+	s.listeners = append(s.listeners, l)
+	s.lock.Unlock()
+
+	for {
+		netConn, err := l.Accept()
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			s.lock.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		go s.handleConn(netConn)
+	}
+}
+
+func (s *Server) StopServe() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.closed = true
+
+	var result error
+	for _, l := range s.listeners {
+		if err := l.Close(); err != nil {
+			result = err
+		}
+	}
+	for c := range s.conns {
+		_ = c.close()
+	}
+
+	return result
+}
+
+func (s *Server) handleConn(netConn net.Conn) {
+	defer s.panicHandler.HandlePanic()
+
+	s.lock.Lock()
+	c := newConn(s, netConn, s.localDebug, s.remoteDebug)
+	s.conns[c] = struct{}{}
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, c)
+		s.lock.Unlock()
+	}()
+
+	c.serve()
+}
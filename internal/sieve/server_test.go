@@ -0,0 +1,170 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sieve
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/internal/serverutil"
+	"github.com/stretchr/testify/require"
+)
+
+type testPanicHandler struct{}
+
+func (testPanicHandler) HandlePanic() {}
+
+type testBridge struct{}
+
+func (testBridge) GetUser(query string) (bridgeUser, error) {
+	if query != "user@pm.me" {
+		return nil, errors.New("no such user")
+	}
+	return testUser{}, nil
+}
+
+type testUser struct{}
+
+func (testUser) ID() string { return "user" }
+
+func (testUser) CheckBridgeLogin(password string) error {
+	if password != "pass" {
+		return errors.New("wrong password")
+	}
+	return nil
+}
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newTestClient(t *testing.T) (*testClient, *Server, func()) {
+	scripts, clear := newTestScripts(t)
+	sessions := serverutil.NewSessions()
+	server := newManageSieveServer(testPanicHandler{}, false, 0, nil, testBridge{}, scripts, nil, sessions)
+
+	clientConn, serverConn := net.Pipe()
+	go server.handleConn(serverConn)
+
+	client := &testClient{t: t, conn: clientConn, r: bufio.NewReader(clientConn)}
+	client.expect("OK")
+
+	return client, server, func() {
+		_ = clientConn.Close()
+		clear()
+	}
+}
+
+// expect reads response lines until the final one and checks the final line
+// starts with the prefix. All lines except the final one are returned.
+func (c *testClient) expect(prefix string) []string {
+	lines := []string{}
+	for {
+		line, err := c.r.ReadString('\n')
+		require.NoError(c.t, err)
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, "OK") || strings.HasPrefix(line, "NO") || strings.HasPrefix(line, "BYE") {
+			require.True(c.t, strings.HasPrefix(line, prefix), "expected %q, got %q", prefix, line)
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+func (c *testClient) send(command string) *testClient {
+	_, err := c.conn.Write([]byte(command + "\r\n"))
+	require.NoError(c.t, err)
+	return c
+}
+
+func (c *testClient) login() {
+	auth := base64.StdEncoding.EncodeToString([]byte("\x00user@pm.me\x00pass"))
+	c.send(`AUTHENTICATE "PLAIN" "` + auth + `"`).expect("OK")
+}
+
+func TestServerCapability(t *testing.T) {
+	client, _, clear := newTestClient(t)
+	defer clear()
+
+	capabilities := client.send("CAPABILITY").expect("OK")
+	require.Contains(t, capabilities, `"SASL" "PLAIN"`)
+	require.Contains(t, capabilities, `"SIEVE" "copy fileinto imap4flags"`)
+	require.NotContains(t, capabilities, `"STARTTLS"`)
+}
+
+func TestServerAuthenticate(t *testing.T) {
+	client, server, clear := newTestClient(t)
+	defer clear()
+
+	client.send("LISTSCRIPTS").expect("NO")
+
+	wrong := base64.StdEncoding.EncodeToString([]byte("\x00user@pm.me\x00wrong"))
+	client.send(`AUTHENTICATE "PLAIN" "` + wrong + `"`).expect("NO")
+
+	client.send(`AUTHENTICATE "PLAIN"`)
+	line, err := client.r.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "\"\"\r\n", line)
+	client.send(`"` + base64.StdEncoding.EncodeToString([]byte("\x00user@pm.me\x00pass")) + `"`).expect("OK")
+
+	client.send("LISTSCRIPTS").expect("OK")
+
+	sessions := server.sessions.List()
+	require.Len(t, sessions, 1)
+	require.Equal(t, serverutil.ManageSieve, sessions[0].Protocol)
+	require.Equal(t, "user@pm.me", sessions[0].User)
+}
+
+func TestServerScripts(t *testing.T) {
+	client, server, clear := newTestClient(t)
+	defer clear()
+
+	client.login()
+
+	client.send("PUTSCRIPT \"filters\" {5+}\r\nkeep;").expect("OK")
+	client.send("PUTSCRIPT \"broken\" {8+}\r\nunknown;").expect("NO")
+	client.send("CHECKSCRIPT \"discard;\"").expect("OK")
+	client.send(`SETACTIVE "filters"`).expect("OK")
+	client.send(`PUTSCRIPT "other" "discard;"`).expect("OK")
+
+	require.Equal(t, []string{`"filters" ACTIVE`, `"other"`}, client.send("LISTSCRIPTS").expect("OK"))
+	require.Equal(t, []string{"{5}", "keep;"}, client.send(`GETSCRIPT "filters"`).expect("OK"))
+
+	client.send(`GETSCRIPT "missing"`).expect("NO (NONEXISTENT)")
+	client.send(`DELETESCRIPT "filters"`).expect("NO (ACTIVE)")
+	client.send(`RENAMESCRIPT "filters" "other"`).expect("NO (ALREADYEXISTS)")
+	client.send(`RENAMESCRIPT "filters" "main"`).expect("OK")
+	client.send(`DELETESCRIPT "other"`).expect("OK")
+	client.send(`HAVESPACE "main" 100`).expect("OK")
+	client.send(`HAVESPACE "main" 99999999`).expect("NO (QUOTA/MAXSIZE)")
+
+	require.Equal(t, []string{`"main" ACTIVE`}, client.send("LISTSCRIPTS").expect("OK"))
+
+	script, err := server.scripts.Active("user")
+	require.NoError(t, err)
+	require.NotNil(t, script)
+
+	client.send(`NOOP "tag"`).expect(`OK (TAG "tag")`)
+	client.send("LOGOUT").expect("OK")
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/internal/store"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+)
+
+// resolveSender returns the address of the account which owns the sender
+// identity under the alias policy, or nil when there is none. It returns
+// also the email to use as the sender of the message: the API allows
+// plus-addressed identities, so they are kept including the tag, but other
+// identities are replaced by the owning address.
+func resolveSender(addresses pmapi.AddressList, identity string, policy store.AliasPolicy) (addr *pmapi.Address, sender string) {
+	for _, addr := range addresses {
+		if strings.EqualFold(addr.Email, identity) {
+			return addr, addr.Email
+		}
+	}
+
+	if policy != store.AliasPolicyPlus && policy != store.AliasPolicyCatchAll {
+		return nil, ""
+	}
+
+	local, domain, err := splitEmail(identity)
+	if err != nil {
+		return nil, ""
+	}
+
+	// Addresses can contain plus sign too, the longest matching one wins.
+	var plusAddr *pmapi.Address
+	var plusLocal string
+	for _, addr := range addresses {
+		addrLocal, addrDomain, err := splitEmail(addr.Email)
+		if err != nil || addrDomain != domain || len(addrLocal) <= len(plusLocal) {
+			continue
+		}
+		if len(local) > len(addrLocal)+1 && local[len(addrLocal)] == '+' && strings.EqualFold(local[:len(addrLocal)], addrLocal) {
+			plusAddr, plusLocal = addr, addrLocal
+		}
+	}
+	if plusAddr != nil {
+		return plusAddr, plusLocal + local[len(plusLocal):] + "@" + domain
+	}
+
+	if policy != store.AliasPolicyCatchAll {
+		return nil, ""
+	}
+
+	for _, addr := range addresses {
+		if !addr.CatchAll {
+			continue
+		}
+		if _, addrDomain, err := splitEmail(addr.Email); err == nil && addrDomain == domain {
+			return addr, addr.Email
+		}
+	}
+
+	return nil, ""
+}
+
+// getAliasPolicy returns the alias policy of the user or the default one
+// when it cannot be loaded.
+func getAliasPolicy(storeUser storeUserProvider) store.AliasPolicy {
+	policy, err := storeUser.GetAliasPolicy()
+	if err != nil {
+		log.WithError(err).Warn("Cannot get alias policy")
+		return store.DefaultAliasPolicy
+	}
+	return policy
+}
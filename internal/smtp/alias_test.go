@@ -0,0 +1,72 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/internal/store"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveSender(t *testing.T) {
+	addresses := pmapi.AddressList{
+		{ID: "main", Email: "Me@pm.me"},
+		{ID: "team", Email: "team@pm.me"},
+		{ID: "tagged", Email: "team+ops@pm.me"},
+		{ID: "custom", Email: "me@example.com", CatchAll: true},
+		{ID: "other", Email: "me@example.org"},
+	}
+
+	tests := []struct {
+		identity       string
+		policy         store.AliasPolicy
+		wantAddressID  string
+		wantSenderAddr string
+	}{
+		{"me@pm.me", store.AliasPolicyExact, "main", "Me@pm.me"},
+		{"me+project@pm.me", store.AliasPolicyExact, "", ""},
+		{"team+ops@pm.me", store.AliasPolicyExact, "tagged", "team+ops@pm.me"},
+
+		{"me+project@pm.me", store.AliasPolicyPlus, "main", "Me+project@pm.me"},
+		{"ME+a+b@PM.me", store.AliasPolicyPlus, "main", "Me+a+b@pm.me"},
+		{"team+ops+x@pm.me", store.AliasPolicyPlus, "tagged", "team+ops+x@pm.me"},
+		{"team+dev@pm.me", store.AliasPolicyPlus, "team", "team+dev@pm.me"},
+		{"+project@pm.me", store.AliasPolicyPlus, "", ""},
+		{"sales@example.com", store.AliasPolicyPlus, "", ""},
+
+		{"me+project@pm.me", store.AliasPolicyCatchAll, "main", "Me+project@pm.me"},
+		{"sales@example.com", store.AliasPolicyCatchAll, "custom", "me@example.com"},
+		{"sales@EXAMPLE.com", store.AliasPolicyCatchAll, "custom", "me@example.com"},
+		{"sales@example.org", store.AliasPolicyCatchAll, "", ""},
+		{"sales@pm.me", store.AliasPolicyCatchAll, "", ""},
+	}
+
+	for _, test := range tests {
+		addr, sender := resolveSender(addresses, test.identity, test.policy)
+		if test.wantAddressID == "" {
+			assert.Nil(t, addr, "%v %v", test.policy, test.identity)
+			continue
+		}
+		if assert.NotNil(t, addr, "%v %v", test.policy, test.identity) {
+			assert.Equal(t, test.wantAddressID, addr.ID, "%v %v", test.policy, test.identity)
+			assert.Equal(t, test.wantSenderAddr, sender, "%v %v", test.policy, test.identity)
+		}
+	}
+}
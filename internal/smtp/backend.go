@@ -18,6 +18,7 @@
 package smtp
 
 import (
+	"net/http"
 	"strings"
 	"time"
 
@@ -25,8 +26,8 @@ import (
 	"github.com/ProtonMail/proton-bridge/internal/config/settings"
 	"github.com/ProtonMail/proton-bridge/pkg/confirmer"
 	"github.com/ProtonMail/proton-bridge/pkg/listener"
+	"github.com/ProtonMail/proton-bridge/pkg/message"
 	goSMTPBackend "github.com/emersion/go-smtp"
-	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -35,6 +36,7 @@ type panicHandler interface {
 }
 
 type settingsProvider interface {
+	Get(string) string
 	GetBool(string) bool
 }
 
@@ -45,16 +47,25 @@ type smtpBackend struct {
 	bridge        bridger
 	confirmer     *confirmer.Confirmer
 	sendRecorder  *sendRecorder
+	builder       *message.Builder
+	keysClient    *http.Client
 }
 
-// NewSMTPBackend returns struct implementing go-smtp/backend interface.
+// NewSMTPBackend returns backend logging in users of the SMTP server.
 func NewSMTPBackend(
 	panicHandler panicHandler,
 	eventListener listener.Listener,
 	settings settingsProvider,
 	bridge *bridge.Bridge,
 ) *smtpBackend { //nolint[golint]
-	return newSMTPBackend(panicHandler, eventListener, settings, newBridgeWrap(bridge))
+	backend := newSMTPBackend(panicHandler, eventListener, settings, newBridgeWrap(bridge))
+
+	go func() {
+		defer panicHandler.HandlePanic()
+		backend.watchOutbox()
+	}()
+
+	return backend
 }
 
 func newSMTPBackend(
@@ -70,11 +81,13 @@ func newSMTPBackend(
 		bridge:        bridge,
 		confirmer:     confirmer.New(),
 		sendRecorder:  newSendRecorder(),
+		builder:       message.NewBuilder(redirectFetchWorkers, redirectAttachWorkers, redirectBuildWorkers),
+		keysClient:    &http.Client{Timeout: keyDiscoveryTimeout},
 	}
 }
 
 // Login authenticates a user.
-func (sb *smtpBackend) Login(_ *goSMTPBackend.ConnectionState, username, password string) (goSMTPBackend.Session, error) {
+func (sb *smtpBackend) Login(_ *connectionState, username, password string) (userSession, error) {
 	// Called from go-smtp in goroutines - we need to handle panics for each function.
 	defer sb.panicHandler.HandlePanic()
 	username = strings.ToLower(username)
@@ -91,6 +104,15 @@ func (sb *smtpBackend) Login(_ *goSMTPBackend.ConnectionState, username, passwor
 		time.Sleep(10 * time.Second)
 		return nil, err
 	}
+	session, err := sb.newUserSession(user, username)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// newUserSession returns session of the user logged in with the address.
+func (sb *smtpBackend) newUserSession(user bridgeUser, username string) (*smtpUser, error) {
 	// Client can log in only using address so we can properly close all SMTP connections.
 	addressID, err := user.GetAddressID(username)
 	if err != nil {
@@ -104,11 +126,11 @@ func (sb *smtpBackend) Login(_ *goSMTPBackend.ConnectionState, username, passwor
 	return newSMTPUser(sb.panicHandler, sb.eventListener, sb, user, username, addressID)
 }
 
-func (sb *smtpBackend) AnonymousLogin(_ *goSMTPBackend.ConnectionState) (goSMTPBackend.Session, error) {
+func (sb *smtpBackend) AnonymousLogin(_ *connectionState) (userSession, error) {
 	// Called from go-smtp in goroutines - we need to handle panics for each function.
 	defer sb.panicHandler.HandlePanic()
 
-	return nil, errors.New("anonymous login not supported")
+	return nil, goSMTPBackend.ErrAuthRequired
 }
 
 func (sb *smtpBackend) shouldReportOutgoingNoEnc() bool {
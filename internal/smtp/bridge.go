@@ -25,6 +25,7 @@ import (
 
 type bridger interface {
 	GetUser(query string) (bridgeUser, error)
+	GetUsers() []bridgeUser
 }
 
 type bridgeUser interface {
@@ -54,6 +55,14 @@ func (b *bridgeWrap) GetUser(query string) (bridgeUser, error) {
 	return newBridgeUserWrap(user), nil //nolint[typecheck] missing methods are inherited
 }
 
+func (b *bridgeWrap) GetUsers() []bridgeUser {
+	users := []bridgeUser{}
+	for _, user := range b.Bridge.GetUsers() {
+		users = append(users, newBridgeUserWrap(user)) //nolint[typecheck] missing methods are inherited
+	}
+	return users
+}
+
 type bridgeUserWrap struct {
 	*users.User
 }
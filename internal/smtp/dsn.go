@@ -0,0 +1,261 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/store"
+	"github.com/emersion/go-message"
+	goSMTPBackend "github.com/emersion/go-smtp"
+	"github.com/pkg/errors"
+)
+
+// Actions of the delivery status notification (RFC 3464).
+const (
+	dsnActionFailed    = "failed"
+	dsnActionDelayed   = "delayed"
+	dsnActionDelivered = "delivered"
+)
+
+const reportingMTA = "localhost"
+
+// newDSNEnvelope returns the DSN parameters RET and ENVID of the MAIL
+// command (RFC 3461), already validated by go-smtp.
+func newDSNEnvelope(opts *goSMTPBackend.MailOptions) store.DSNEnvelope {
+	if opts == nil {
+		return store.DSNEnvelope{}
+	}
+
+	return store.DSNEnvelope{
+		Return:     string(opts.Return),
+		EnvelopeID: opts.EnvelopeID,
+	}
+}
+
+// newDSNParams returns the DSN parameters NOTIFY and ORCPT of the RCPT
+// command (RFC 3461), already validated by go-smtp.
+func newDSNParams(opts *goSMTPBackend.RcptOptions) (params store.DSNParams) {
+	if opts == nil {
+		return params
+	}
+
+	for _, notify := range opts.Notify {
+		params.Notify = append(params.Notify, string(notify))
+	}
+
+	if opts.OriginalRecipient != "" {
+		params.OriginalRecipient = strings.ToLower(string(opts.OriginalRecipientType)) + ";" + opts.OriginalRecipient
+	}
+
+	return params
+}
+
+// shouldNotify returns whether the recipient asked for a notification
+// about the action. Without NOTIFY only failures are reported.
+func shouldNotify(params store.DSNParams, action string) bool {
+	if len(params.Notify) == 0 {
+		return action == dsnActionFailed
+	}
+
+	want := map[string]goSMTPBackend.DSNNotify{
+		dsnActionFailed:    goSMTPBackend.DSNNotifyFailure,
+		dsnActionDelayed:   goSMTPBackend.DSNNotifyDelayed,
+		dsnActionDelivered: goSMTPBackend.DSNNotifySuccess,
+	}[action]
+
+	for _, v := range params.Notify {
+		if v == string(want) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// deliveryStatus is the result of delivery to one recipient. Reason is
+// empty for successful delivery.
+type deliveryStatus struct {
+	recipient string
+	reason    string
+}
+
+// deliveryReport describes what happened with the message for the given
+// recipients.
+type deliveryReport struct {
+	action     string
+	returnPath string
+	envelope   store.DSNEnvelope
+	literal    []byte
+	statuses   []deliveryStatus
+}
+
+// importDeliveryReport writes the delivery status notification into INBOX of
+// the sender. Recipients which did not ask for the notification are left out,
+// and nothing is imported when no recipient is left.
+func importDeliveryReport(storeUser storeUserProvider, addressID string, dsn map[string]store.DSNParams, report deliveryReport) error {
+	statuses := []deliveryStatus{}
+	for _, status := range report.statuses {
+		if shouldNotify(dsn[status.recipient], report.action) {
+			statuses = append(statuses, status)
+		}
+	}
+
+	if len(statuses) == 0 {
+		return nil
+	}
+
+	report.statuses = statuses
+
+	literal, err := buildDeliveryReport(report, dsn, time.Now())
+	if err != nil {
+		return errors.Wrap(err, "failed to build delivery report")
+	}
+
+	return storeUser.ImportInboxMessage(addressID, literal)
+}
+
+// buildDeliveryReport returns the delivery status notification in the
+// multipart/report format of RFC 3462 with the human readable part, the
+// machine readable message/delivery-status part and the returned message.
+func buildDeliveryReport(report deliveryReport, dsn map[string]store.DSNParams, now time.Time) ([]byte, error) {
+	subject, intro, status, returned := describeDeliveryAction(report.action)
+
+	var hdr message.Header
+
+	hdr.Set("From", bounceSender)
+	hdr.Set("To", "<"+report.returnPath+">")
+	hdr.Set("Subject", subject)
+	hdr.Set("Date", now.Format(time.RFC1123Z))
+	hdr.Set("Auto-Submitted", "auto-replied")
+	hdr.SetContentType("multipart/report", map[string]string{"report-type": "delivery-status"})
+
+	buf := new(bytes.Buffer)
+
+	w, err := message.CreateWriter(buf, hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	text := new(bytes.Buffer)
+	fmt.Fprintf(text, "%v\r\n\r\n", intro)
+	for _, s := range report.statuses {
+		if s.reason != "" {
+			fmt.Fprintf(text, "<%v>: %v\r\n", s.recipient, s.reason)
+		} else {
+			fmt.Fprintf(text, "<%v>\r\n", s.recipient)
+		}
+	}
+
+	fields := new(bytes.Buffer)
+	if envelopeID := report.envelope.EnvelopeID; envelopeID != "" {
+		fmt.Fprintf(fields, "Original-Envelope-Id: %v\r\n", envelopeID)
+	}
+	fmt.Fprintf(fields, "Reporting-MTA: dns; %v\r\n", reportingMTA)
+	for _, s := range report.statuses {
+		fields.WriteString("\r\n")
+		if orcpt := dsn[s.recipient].OriginalRecipient; orcpt != "" {
+			fmt.Fprintf(fields, "Original-Recipient: %v\r\n", orcpt)
+		}
+		fmt.Fprintf(fields, "Final-Recipient: rfc822; %v\r\n", s.recipient)
+		fmt.Fprintf(fields, "Action: %v\r\n", report.action)
+		fmt.Fprintf(fields, "Status: %v\r\n", status)
+		if s.reason != "" {
+			fmt.Fprintf(fields, "Diagnostic-Code: X-Bridge; %v\r\n", strings.Join(strings.Fields(s.reason), " "))
+		}
+	}
+
+	// The full message is returned only on failure and only when the
+	// client did not ask for headers by RET=HDRS.
+	content := report.literal
+	if report.envelope.Return == string(goSMTPBackend.DSNReturnHeaders) {
+		returned = "text/rfc822-headers"
+	}
+	if returned == "text/rfc822-headers" {
+		content = messageHeader(report.literal)
+	}
+
+	for _, part := range []struct {
+		contentType string
+		params      map[string]string
+		body        []byte
+	}{
+		{"text/plain", map[string]string{"charset": "utf-8"}, text.Bytes()},
+		{"message/delivery-status", nil, fields.Bytes()},
+		{returned, nil, content},
+	} {
+		var partHdr message.Header
+
+		partHdr.SetContentType(part.contentType, part.params)
+
+		pw, err := w.CreatePart(partHdr)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := pw.Write(part.body); err != nil {
+			return nil, err
+		}
+
+		if err := pw.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// describeDeliveryAction returns the subject, the human readable
+// introduction, the status code and the content type of the returned
+// message for the report about the action.
+func describeDeliveryAction(action string) (subject, intro, status, returned string) {
+	switch action {
+	case dsnActionDelivered:
+		return "Successful Mail Delivery Report",
+			"Your message was sent to the following recipients:",
+			"2.0.0", "text/rfc822-headers"
+
+	case dsnActionDelayed:
+		return "Delayed Mail (still being retried)",
+			"Your message could not be sent yet to the following recipients. Bridge will keep trying to send it.",
+			"4.0.0", "text/rfc822-headers"
+	}
+
+	return "Undelivered Mail Returned to Sender",
+		"Your message could not be sent to the following recipients:",
+		"5.0.0", "message/rfc822"
+}
+
+// messageHeader returns the header of the message literal including the
+// empty line separating it from the body.
+func messageHeader(literal []byte) []byte {
+	for _, sep := range [][]byte{[]byte("\r\n\r\n"), []byte("\n\n")} {
+		if i := bytes.Index(literal, sep); i >= 0 {
+			return literal[:i+len(sep)]
+		}
+	}
+
+	return literal
+}
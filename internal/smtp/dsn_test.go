@@ -0,0 +1,180 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/store"
+	"github.com/emersion/go-message"
+	goSMTPBackend "github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDSNParams(t *testing.T) {
+	assert.Equal(t, store.DSNParams{}, newDSNParams(&goSMTPBackend.RcptOptions{}))
+
+	params := newDSNParams(&goSMTPBackend.RcptOptions{
+		Notify:                []goSMTPBackend.DSNNotify{goSMTPBackend.DSNNotifySuccess, goSMTPBackend.DSNNotifyFailure},
+		OriginalRecipientType: goSMTPBackend.DSNAddressTypeRFC822,
+		OriginalRecipient:     "bob+dsn@example.com",
+	})
+	assert.Equal(t, []string{"SUCCESS", "FAILURE"}, params.Notify)
+	assert.Equal(t, "rfc822;bob+dsn@example.com", params.OriginalRecipient)
+}
+
+func TestNewDSNEnvelope(t *testing.T) {
+	assert.Equal(t, store.DSNEnvelope{}, newDSNEnvelope(&goSMTPBackend.MailOptions{}))
+
+	assert.Equal(t, store.DSNEnvelope{Return: "HDRS", EnvelopeID: "QQ314159"}, newDSNEnvelope(&goSMTPBackend.MailOptions{
+		Return:     goSMTPBackend.DSNReturnHeaders,
+		EnvelopeID: "QQ314159",
+	}))
+}
+
+func TestShouldNotify(t *testing.T) {
+	assert.True(t, shouldNotify(store.DSNParams{}, dsnActionFailed))
+	assert.False(t, shouldNotify(store.DSNParams{}, dsnActionDelayed))
+	assert.False(t, shouldNotify(store.DSNParams{}, dsnActionDelivered))
+
+	never := store.DSNParams{Notify: []string{"NEVER"}}
+	assert.False(t, shouldNotify(never, dsnActionFailed))
+
+	all := store.DSNParams{Notify: []string{"SUCCESS", "DELAY", "FAILURE"}}
+	assert.True(t, shouldNotify(all, dsnActionFailed))
+	assert.True(t, shouldNotify(all, dsnActionDelayed))
+	assert.True(t, shouldNotify(all, dsnActionDelivered))
+}
+
+func TestBuildDeliveryReport(t *testing.T) {
+	original := "From: me@pm.me\r\nTo: friend@example.com\r\nSubject: Hello\r\n\r\nWorld\r\n"
+
+	tests := []struct {
+		action, status, returned, content string
+	}{
+		{dsnActionFailed, "5.0.0", "message/rfc822", original},
+		{dsnActionDelayed, "4.0.0", "text/rfc822-headers", "From: me@pm.me\r\nTo: friend@example.com\r\nSubject: Hello\r\n\r\n"},
+		{dsnActionDelivered, "2.0.0", "text/rfc822-headers", "From: me@pm.me\r\nTo: friend@example.com\r\nSubject: Hello\r\n\r\n"},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.action, func(t *testing.T) {
+			literal, err := buildDeliveryReport(deliveryReport{
+				action:     tc.action,
+				returnPath: "me@pm.me",
+				literal:    []byte(original),
+				statuses: []deliveryStatus{
+					{recipient: "friend@example.com", reason: "recipient\r\n rejected"},
+					{recipient: "other@example.com"},
+				},
+			}, map[string]store.DSNParams{
+				"friend@example.com": {OriginalRecipient: "rfc822;Friend@example.com"},
+			}, time.Now())
+			require.NoError(t, err)
+
+			entity, err := message.Read(bytes.NewReader(literal))
+			require.NoError(t, err)
+			assert.Equal(t, bounceSender, entity.Header.Get("From"))
+			assert.Equal(t, "<me@pm.me>", entity.Header.Get("To"))
+
+			contentType, params, err := entity.Header.ContentType()
+			require.NoError(t, err)
+			assert.Equal(t, "multipart/report", contentType)
+			assert.Equal(t, "delivery-status", params["report-type"])
+
+			mr := entity.MultipartReader()
+			require.NotNil(t, mr)
+
+			text, err := mr.NextPart()
+			require.NoError(t, err)
+			body, err := ioutil.ReadAll(text.Body)
+			require.NoError(t, err)
+			assert.Contains(t, string(body), "<friend@example.com>: recipient\r\n rejected")
+			assert.Contains(t, string(body), "<other@example.com>\r\n")
+
+			status, err := mr.NextPart()
+			require.NoError(t, err)
+			body, err = ioutil.ReadAll(status.Body)
+			require.NoError(t, err)
+			assert.Equal(t, "Reporting-MTA: dns; localhost\r\n"+
+				"\r\n"+
+				"Original-Recipient: rfc822;Friend@example.com\r\n"+
+				"Final-Recipient: rfc822; friend@example.com\r\n"+
+				"Action: "+tc.action+"\r\n"+
+				"Status: "+tc.status+"\r\n"+
+				"Diagnostic-Code: X-Bridge; recipient rejected\r\n"+
+				"\r\n"+
+				"Final-Recipient: rfc822; other@example.com\r\n"+
+				"Action: "+tc.action+"\r\n"+
+				"Status: "+tc.status+"\r\n",
+				string(body))
+
+			orig, err := mr.NextPart()
+			require.NoError(t, err)
+			contentType, _, err = orig.Header.ContentType()
+			require.NoError(t, err)
+			assert.Equal(t, tc.returned, contentType)
+			body, err = ioutil.ReadAll(orig.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tc.content, string(body))
+		})
+	}
+}
+
+func TestBuildDeliveryReportWithEnvelope(t *testing.T) {
+	original := "From: me@pm.me\r\nTo: friend@example.com\r\nSubject: Hello\r\n\r\nWorld\r\n"
+
+	literal, err := buildDeliveryReport(deliveryReport{
+		action:     dsnActionFailed,
+		returnPath: "me@pm.me",
+		envelope:   store.DSNEnvelope{Return: "HDRS", EnvelopeID: "QQ314159"},
+		literal:    []byte(original),
+		statuses:   []deliveryStatus{{recipient: "friend@example.com", reason: "rejected"}},
+	}, nil, time.Now())
+	require.NoError(t, err)
+
+	entity, err := message.Read(bytes.NewReader(literal))
+	require.NoError(t, err)
+
+	mr := entity.MultipartReader()
+	require.NotNil(t, mr)
+
+	_, err = mr.NextPart()
+	require.NoError(t, err)
+
+	status, err := mr.NextPart()
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(status.Body)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(body), "Original-Envelope-Id: QQ314159\r\nReporting-MTA: dns; localhost\r\n"))
+
+	orig, err := mr.NextPart()
+	require.NoError(t, err)
+	contentType, _, err := orig.Header.ContentType()
+	require.NoError(t, err)
+	assert.Equal(t, "text/rfc822-headers", contentType)
+	body, err = ioutil.ReadAll(orig.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "From: me@pm.me\r\nTo: friend@example.com\r\nSubject: Hello\r\n\r\n", string(body))
+}
@@ -0,0 +1,422 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1" //nolint[gosec] G505 WKD defines the hash of the local part as SHA-1
+	"encoding/base32"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/gopenpgp/v2/armor"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/ProtonMail/proton-bridge/internal/config/settings"
+	"github.com/ProtonMail/proton-bridge/internal/store"
+	"github.com/pkg/errors"
+)
+
+const (
+	// keyDiscoveryTimeout limits all requests of one key lookup.
+	keyDiscoveryTimeout = 10 * time.Second
+
+	// keyPinCheckInterval is how long the pinned key is used without
+	// asking the key sources again.
+	keyPinCheckInterval = 24 * time.Hour
+
+	// maxDiscoveredKeySize limits the size of the response with keys.
+	maxDiscoveredKeySize = 1 << 20
+)
+
+// zBase32Encoding is the z-base-32 encoding used by WKD for the hashed local part.
+var zBase32Encoding = base32.NewEncoding("ybndrfg8ejkmcpqxot1uwisza345h769").WithPadding(base32.NoPadding) //nolint[gochecknoglobals]
+
+// keyDiscoverer finds public keys of external recipients which are not
+// provided by the API. Only keys which can be used for encryption to the
+// given address are returned. No keys and no error means there is none.
+type keyDiscoverer interface {
+	DiscoverKeys(ctx context.Context, email string) ([]*crypto.Key, error)
+}
+
+// keyDiscoverers asks the key sources in order and returns keys from the
+// first one which has any. Failing sources are skipped.
+type keyDiscoverers []keyDiscoverer
+
+func (d keyDiscoverers) DiscoverKeys(ctx context.Context, email string) ([]*crypto.Key, error) {
+	for _, discoverer := range d {
+		keys, err := discoverer.DiscoverKeys(ctx, email)
+		if err != nil {
+			log.WithError(err).WithField("source", fmt.Sprintf("%T", discoverer)).Warn("Key discovery failed")
+			continue
+		}
+		if len(keys) > 0 {
+			return keys, nil
+		}
+	}
+	return nil, nil
+}
+
+// wkdKeyDiscoverer looks up keys using OpenPGP Web Key Directory. The
+// advanced method is used unless the openpgpkey subdomain does not exist,
+// then the direct method is used.
+type wkdKeyDiscoverer struct {
+	client *http.Client
+}
+
+func newWKDKeyDiscoverer(client *http.Client) *wkdKeyDiscoverer {
+	return &wkdKeyDiscoverer{client: client}
+}
+
+func (d *wkdKeyDiscoverer) DiscoverKeys(ctx context.Context, email string) ([]*crypto.Key, error) {
+	local, domain, err := splitEmail(email)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := wkdHash(local)
+	query := url.Values{"l": {local}}.Encode()
+
+	advancedURL := fmt.Sprintf("https://openpgpkey.%v/.well-known/openpgpkey/%v/hu/%v?%v", domain, domain, hash, query)
+	data, err := fetchKeys(ctx, d.client, advancedURL)
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		directURL := fmt.Sprintf("https://%v/.well-known/openpgpkey/hu/%v?%v", domain, hash, query)
+		data, err = fetchKeys(ctx, d.client, directURL)
+	}
+
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	return parseDiscoveredKeys(data, email)
+}
+
+// wkdHash returns z-base-32 encoded SHA-1 hash of the lower-cased local part.
+func wkdHash(local string) string {
+	hash := sha1.Sum([]byte(strings.ToLower(local))) //nolint[gosec] G401 required by WKD
+	return zBase32Encoding.EncodeToString(hash[:])
+}
+
+// keyserverKeyDiscoverer looks up keys on a keyserver. The hkp and hkps
+// schemes use the HKP protocol, http and https schemes use the VKS API
+// (keys.openpgp.org) which only serves keys with verified address. HKP
+// keyservers serve keys with any claimed address, so they are used only
+// when allowed explicitly.
+type keyserverKeyDiscoverer struct {
+	client  *http.Client
+	baseURL *url.URL
+	hkp     bool
+}
+
+func newKeyserverKeyDiscoverer(client *http.Client, rawURL string) (*keyserverKeyDiscoverer, error) {
+	baseURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &keyserverKeyDiscoverer{client: client, baseURL: baseURL}
+
+	switch baseURL.Scheme {
+	case "hkp":
+		baseURL.Scheme = "http"
+		d.hkp = true
+	case "hkps":
+		baseURL.Scheme = "https"
+		d.hkp = true
+	case "http", "https":
+	default:
+		return nil, errors.Errorf("unsupported keyserver scheme %q", baseURL.Scheme)
+	}
+
+	return d, nil
+}
+
+func (d *keyserverKeyDiscoverer) DiscoverKeys(ctx context.Context, email string) ([]*crypto.Key, error) {
+	lookupURL := *d.baseURL
+
+	if d.hkp {
+		lookupURL.Path = "/pks/lookup"
+		lookupURL.RawQuery = url.Values{
+			"op":      {"get"},
+			"options": {"mr"},
+			"exact":   {"on"},
+			"search":  {email},
+		}.Encode()
+	} else {
+		lookupURL.Path = "/vks/v1/by-email/" + email
+	}
+
+	data, err := fetchKeys(ctx, d.client, lookupURL.String())
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	return parseDiscoveredKeys(data, email)
+}
+
+// dirKeyDiscoverer looks up keys in files of a local directory. Each file
+// can contain armored or binary keys of any addresses.
+type dirKeyDiscoverer struct {
+	dir string
+}
+
+func newDirKeyDiscoverer(dir string) *dirKeyDiscoverer {
+	return &dirKeyDiscoverer{dir: dir}
+}
+
+func (d *dirKeyDiscoverer) DiscoverKeys(_ context.Context, email string) (keys []*crypto.Key, err error) {
+	infos, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		path := filepath.Join(d.dir, info.Name())
+
+		data, err := ioutil.ReadFile(path) //nolint[gosec] G304 the directory is set by the user
+		if err != nil {
+			log.WithError(err).WithField("path", path).Warn("Cannot read key file")
+			continue
+		}
+
+		fileKeys, err := parseDiscoveredKeys(data, email)
+		if err != nil {
+			log.WithError(err).WithField("path", path).Debug("Skipping file without keys")
+			continue
+		}
+
+		keys = append(keys, fileKeys...)
+	}
+
+	return keys, nil
+}
+
+// keyPinStore stores keys pinned by pinningKeyDiscoverer.
+type keyPinStore interface {
+	GetKeyPin(addr string) (*store.KeyPin, error)
+	SetKeyPin(pin *store.KeyPin) error
+}
+
+// pinningKeyDiscoverer caches the first discovered key of each address and
+// pins its fingerprint. While the pinned key can be used for encryption,
+// a different key returned by the sources is not trusted, so a compromised
+// domain or keyserver cannot silently replace it. Once the pinned key is
+// expired or revoked, a newly discovered key is pinned instead.
+type pinningKeyDiscoverer struct {
+	discoverer keyDiscoverer
+	pins       keyPinStore
+}
+
+func newPinningKeyDiscoverer(discoverer keyDiscoverer, pins keyPinStore) *pinningKeyDiscoverer {
+	return &pinningKeyDiscoverer{discoverer: discoverer, pins: pins}
+}
+
+func (d *pinningKeyDiscoverer) DiscoverKeys(ctx context.Context, email string) ([]*crypto.Key, error) {
+	pin, err := d.pins.GetKeyPin(email)
+	if err != nil {
+		return nil, err
+	}
+
+	var pinnedKey *crypto.Key
+	if pin != nil {
+		if pinnedKey, err = crypto.NewKey(pin.PublicKey); err != nil || !isUsableKey(pinnedKey) {
+			pinnedKey = nil
+		} else if time.Since(pin.CheckedAt) < keyPinCheckInterval {
+			return []*crypto.Key{pinnedKey}, nil
+		}
+	}
+
+	keys, err := d.discoverer.DiscoverKeys(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	l := log.WithField("recipient", email)
+
+	switch {
+	case pinnedKey != nil:
+		if !containsFingerprint(keys, pin.Fingerprint) {
+			l.WithField("fingerprint", pin.Fingerprint).Warn("Discovered keys do not match the pinned key")
+		}
+		pin.CheckedAt = time.Now()
+		return []*crypto.Key{pinnedKey}, d.pins.SetKeyPin(pin)
+
+	case len(keys) > 0:
+		data, err := keys[0].GetPublicKey()
+		if err != nil {
+			return nil, err
+		}
+		l.WithField("fingerprint", keys[0].GetFingerprint()).Info("Pinning discovered key")
+		return keys, d.pins.SetKeyPin(&store.KeyPin{
+			Addr:        strings.ToLower(email),
+			Fingerprint: keys[0].GetFingerprint(),
+			PublicKey:   data,
+			CheckedAt:   time.Now(),
+		})
+
+	default:
+		return nil, nil
+	}
+}
+
+func containsFingerprint(keys []*crypto.Key, fingerprint string) bool {
+	for _, key := range keys {
+		if key.GetFingerprint() == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchKeys downloads keys from the URL. It returns nil when there are none.
+func fetchKeys(ctx context.Context, client *http.Client, rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close() //nolint[errcheck]
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, nil
+	case res.StatusCode != http.StatusOK:
+		return nil, errors.Errorf("unexpected response status %v from %v", res.Status, req.URL.Host)
+	}
+
+	return ioutil.ReadAll(io.LimitReader(res.Body, maxDiscoveredKeySize))
+}
+
+// parseDiscoveredKeys reads armored or binary keys and returns the public
+// parts of those which can be used for encryption to the email.
+func parseDiscoveredKeys(data []byte, email string) ([]*crypto.Key, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		var err error
+		if data, err = armor.Unarmor(string(data)); err != nil {
+			return nil, err
+		}
+	}
+
+	entities, err := openpgp.ReadKeyRing(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*crypto.Key
+
+	for _, entity := range entities {
+		if !hasIdentity(entity, email) {
+			continue
+		}
+
+		var b bytes.Buffer
+		if err := entity.Serialize(&b); err != nil {
+			return nil, err
+		}
+
+		key, err := crypto.NewKey(b.Bytes())
+		if err != nil {
+			return nil, err
+		}
+
+		if isUsableKey(key) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// hasIdentity returns whether any user ID of the key is for the email.
+// Keys for other addresses must not be used even if the source returns them.
+func hasIdentity(entity *openpgp.Entity, email string) bool {
+	for _, identity := range entity.Identities {
+		if identity.UserId != nil && strings.EqualFold(identity.UserId.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func isUsableKey(key *crypto.Key) bool {
+	return key.CanEncrypt() && !key.IsExpired()
+}
+
+func splitEmail(email string) (local, domain string, err error) {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return "", "", errors.Errorf("invalid address %q", email)
+	}
+	return email[:at], strings.ToLower(email[at+1:]), nil
+}
+
+// newKeyDiscoverer returns the key discoverer enabled by settings or nil
+// when the key discovery is disabled. Keys from the local directory are
+// managed by the user and take precedence; keys from network sources are
+// pinned in the given store.
+func newKeyDiscoverer(s settingsProvider, client *http.Client, pins keyPinStore) keyDiscoverer {
+	var discoverers, networkDiscoverers keyDiscoverers
+
+	if dir := s.Get(settings.KeyDiscoveryDirKey); dir != "" {
+		discoverers = append(discoverers, newDirKeyDiscoverer(dir))
+	}
+
+	if s.GetBool(settings.KeyDiscoveryWKDKey) {
+		networkDiscoverers = append(networkDiscoverers, newWKDKeyDiscoverer(client))
+	}
+
+	if rawURL := s.Get(settings.KeyDiscoveryServerKey); rawURL != "" {
+		keyserver, err := newKeyserverKeyDiscoverer(client, rawURL)
+		switch {
+		case err != nil:
+			log.WithError(err).Warn("Keyserver cannot be used for key discovery")
+		case keyserver.hkp && !s.GetBool(settings.KeyDiscoveryHKPKey):
+			log.Warn("HKP keyserver does not verify addresses and is not allowed for key discovery")
+		default:
+			networkDiscoverers = append(networkDiscoverers, keyserver)
+		}
+	}
+
+	if len(networkDiscoverers) > 0 {
+		discoverers = append(discoverers, newPinningKeyDiscoverer(networkDiscoverers, pins))
+	}
+
+	if len(discoverers) == 0 {
+		return nil
+	}
+
+	return discoverers
+}
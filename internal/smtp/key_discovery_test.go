@@ -0,0 +1,289 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/ProtonMail/proton-bridge/internal/config/settings"
+	"github.com/ProtonMail/proton-bridge/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newDiscoveryTestKey returns a new public key valid at the time used by
+// crypto package to check keys.
+func newDiscoveryTestKey(t *testing.T, email string) *crypto.Key {
+	entity, err := openpgp.NewEntity("Test", "", email, &packet.Config{
+		Time:      crypto.GetTime,
+		Algorithm: packet.PubKeyAlgoEdDSA,
+	})
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	require.NoError(t, entity.Serialize(&b))
+
+	key, err := crypto.NewKey(b.Bytes())
+	require.NoError(t, err)
+
+	return key
+}
+
+func getDiscoveryTestKeyData(t *testing.T, keys ...*crypto.Key) (data []byte) {
+	for _, key := range keys {
+		keyData, err := key.GetPublicKey()
+		require.NoError(t, err)
+		data = append(data, keyData...)
+	}
+	return data
+}
+
+func getFingerprints(keys []*crypto.Key) (fingerprints []string) {
+	for _, key := range keys {
+		fingerprints = append(fingerprints, key.GetFingerprint())
+	}
+	return fingerprints
+}
+
+// newWKDTestClient returns a client which connects to the server for every
+// host. When withSubdomain is false, the openpgpkey subdomain does not resolve.
+func newWKDTestClient(server *httptest.Server, withSubdomain bool) *http.Client {
+	client := server.Client()
+
+	transport := client.Transport.(*http.Transport)
+	transport.TLSClientConfig.InsecureSkipVerify = true //nolint[gosec] test server has no certificate for the tested domains
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if !withSubdomain && strings.HasPrefix(addr, "openpgpkey.") {
+			return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+
+	return client
+}
+
+func TestWKDHash(t *testing.T) {
+	assert.Equal(t, "iy9q119eutrkn8s1mk4r39qejnbu3n5q", wkdHash("Joe.Doe"))
+}
+
+func TestWKDKeyDiscoverer(t *testing.T) {
+	alice := newDiscoveryTestKey(t, "Alice@example.org")
+	bob := newDiscoveryTestKey(t, "bob@example.org")
+
+	tests := []struct {
+		name          string
+		withSubdomain bool
+		wantHost      string
+		wantPath      string
+	}{
+		{
+			name:          "advanced",
+			withSubdomain: true,
+			wantHost:      "openpgpkey.example.org",
+			wantPath:      "/.well-known/openpgpkey/example.org/hu/" + wkdHash("alice"),
+		},
+		{
+			name:          "direct",
+			withSubdomain: false,
+			wantHost:      "example.org",
+			wantPath:      "/.well-known/openpgpkey/hu/" + wkdHash("alice"),
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Host != test.wantHost || r.URL.Path != test.wantPath || r.URL.Query().Get("l") != "Alice" {
+					http.NotFound(w, r)
+					return
+				}
+				// Key of other address must be ignored.
+				_, _ = w.Write(getDiscoveryTestKeyData(t, bob, alice))
+			}))
+			defer server.Close()
+
+			keys, err := newWKDKeyDiscoverer(newWKDTestClient(server, test.withSubdomain)).DiscoverKeys(context.Background(), "Alice@Example.org")
+			require.NoError(t, err)
+			assert.Equal(t, []string{alice.GetFingerprint()}, getFingerprints(keys))
+		})
+	}
+}
+
+func TestWKDKeyDiscovererNotFound(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	keys, err := newWKDKeyDiscoverer(newWKDTestClient(server, true)).DiscoverKeys(context.Background(), "alice@example.org")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestKeyserverKeyDiscoverer(t *testing.T) {
+	alice := newDiscoveryTestKey(t, "alice@example.org")
+
+	armored, err := alice.GetArmoredPublicKey()
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/pks/lookup" && r.URL.Query().Get("op") == "get" && r.URL.Query().Get("search") == "alice@example.org":
+		case r.URL.Path == "/vks/v1/by-email/alice@example.org":
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(armored))
+	}))
+	defer server.Close()
+
+	for _, rawURL := range []string{
+		strings.Replace(server.URL, "http://", "hkp://", 1),
+		server.URL,
+	} {
+		d, err := newKeyserverKeyDiscoverer(server.Client(), rawURL)
+		require.NoError(t, err)
+
+		keys, err := d.DiscoverKeys(context.Background(), "alice@example.org")
+		require.NoError(t, err)
+		assert.Equal(t, []string{alice.GetFingerprint()}, getFingerprints(keys), rawURL)
+
+		keys, err = d.DiscoverKeys(context.Background(), "bob@example.org")
+		require.NoError(t, err)
+		assert.Empty(t, keys, rawURL)
+	}
+
+	_, err = newKeyserverKeyDiscoverer(server.Client(), "ldap://example.org")
+	require.Error(t, err)
+}
+
+func TestDirKeyDiscoverer(t *testing.T) {
+	alice := newDiscoveryTestKey(t, "alice@example.org")
+	alice2 := newDiscoveryTestKey(t, "alice@example.org")
+	bob := newDiscoveryTestKey(t, "bob@example.org")
+
+	dir, err := ioutil.TempDir("", "keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	armored, err := alice.GetArmoredPublicKey()
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "alice.asc"), []byte(armored), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "keyring.gpg"), getDiscoveryTestKeyData(t, bob, alice2), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a key"), 0600))
+
+	keys, err := newDirKeyDiscoverer(dir).DiscoverKeys(context.Background(), "alice@example.org")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{alice.GetFingerprint(), alice2.GetFingerprint()}, getFingerprints(keys))
+}
+
+type testKeyPinStore map[string]*store.KeyPin
+
+func (s testKeyPinStore) GetKeyPin(addr string) (*store.KeyPin, error) {
+	return s[strings.ToLower(addr)], nil
+}
+
+func (s testKeyPinStore) SetKeyPin(pin *store.KeyPin) error {
+	s[strings.ToLower(pin.Addr)] = pin
+	return nil
+}
+
+type testKeyDiscoverer struct {
+	keys  []*crypto.Key
+	calls int
+}
+
+func (d *testKeyDiscoverer) DiscoverKeys(context.Context, string) ([]*crypto.Key, error) {
+	d.calls++
+	return d.keys, nil
+}
+
+func TestPinningKeyDiscoverer(t *testing.T) {
+	key1 := newDiscoveryTestKey(t, "alice@example.org")
+	key2 := newDiscoveryTestKey(t, "alice@example.org")
+
+	pins := testKeyPinStore{}
+	source := &testKeyDiscoverer{keys: []*crypto.Key{key1}}
+	d := newPinningKeyDiscoverer(source, pins)
+
+	// First discovered key is pinned.
+	keys, err := d.DiscoverKeys(context.Background(), "Alice@example.org")
+	require.NoError(t, err)
+	assert.Equal(t, []string{key1.GetFingerprint()}, getFingerprints(keys))
+	require.NotNil(t, pins["alice@example.org"])
+	assert.Equal(t, key1.GetFingerprint(), pins["alice@example.org"].Fingerprint)
+
+	// Recently checked pin is used without asking the source.
+	source.keys = []*crypto.Key{key2}
+	keys, err = d.DiscoverKeys(context.Background(), "alice@example.org")
+	require.NoError(t, err)
+	assert.Equal(t, []string{key1.GetFingerprint()}, getFingerprints(keys))
+	assert.Equal(t, 1, source.calls)
+
+	// Different key from the source does not replace the pinned one.
+	pins["alice@example.org"].CheckedAt = time.Now().Add(-2 * keyPinCheckInterval)
+	keys, err = d.DiscoverKeys(context.Background(), "alice@example.org")
+	require.NoError(t, err)
+	assert.Equal(t, []string{key1.GetFingerprint()}, getFingerprints(keys))
+	assert.Equal(t, 2, source.calls)
+	assert.WithinDuration(t, time.Now(), pins["alice@example.org"].CheckedAt, time.Minute)
+
+	// Unusable pinned key is replaced.
+	pins["alice@example.org"].PublicKey = []byte("broken")
+	keys, err = d.DiscoverKeys(context.Background(), "alice@example.org")
+	require.NoError(t, err)
+	assert.Equal(t, []string{key2.GetFingerprint()}, getFingerprints(keys))
+	assert.Equal(t, key2.GetFingerprint(), pins["alice@example.org"].Fingerprint)
+
+	// Nothing is pinned for address without keys.
+	source.keys = nil
+	keys, err = d.DiscoverKeys(context.Background(), "bob@example.org")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+	assert.Nil(t, pins["bob@example.org"])
+}
+
+type testDiscoverySettings map[string]string
+
+func (s testDiscoverySettings) Get(key string) string   { return s[key] }
+func (s testDiscoverySettings) GetBool(key string) bool { return s[key] == "true" }
+
+func TestNewKeyDiscovererRequiresHKPOptIn(t *testing.T) {
+	s := testDiscoverySettings{settings.KeyDiscoveryServerKey: "hkps://keyserver.example.com"}
+	assert.Nil(t, newKeyDiscoverer(s, http.DefaultClient, nil))
+
+	s[settings.KeyDiscoveryHKPKey] = "true"
+	assert.NotNil(t, newKeyDiscoverer(s, http.DefaultClient, nil))
+
+	s = testDiscoverySettings{settings.KeyDiscoveryServerKey: "https://keys.openpgp.org"}
+	assert.NotNil(t, newKeyDiscoverer(s, http.DefaultClient, nil))
+}
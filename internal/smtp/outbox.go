@@ -0,0 +1,268 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/ProtonMail/proton-bridge/internal/store"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	outboxCheckInterval = time.Minute
+	outboxMinBackoff    = time.Minute
+	outboxMaxBackoff    = time.Hour
+	outboxMaxAttempts   = 5
+	outboxMaxAge        = 3 * 24 * time.Hour
+
+	bounceSender = "Mail Delivery System <MAILER-DAEMON@localhost>"
+)
+
+var errSendingCanceled = errors.New("sending was canceled by user") //nolint[gochecknoglobals]
+
+// isOfflineError returns whether sending failed only because the API
+// could not be reached, so it makes sense to send the message later.
+func isOfflineError(err error) bool {
+	return errors.Is(err, pmapi.ErrNoConnection)
+}
+
+// isPermanentSendError returns whether the API refused the message and
+// another attempt would fail the same way.
+func isPermanentSendError(err error) bool {
+	var unprocessable pmapi.ErrUnprocessableEntity
+	return errors.As(err, &unprocessable)
+}
+
+// outboxBackoff returns how long to wait before the next attempt to send
+// the message which already failed the given number of times.
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxMinBackoff
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > outboxMaxBackoff {
+		backoff = outboxMaxBackoff
+	}
+	return backoff
+}
+
+// queueMessage stores the message in the outbox of the user to be sent once
+// the connection is back. When the message cannot be queued, the original
+// error of sending is returned to the client.
+func (su *smtpUser) queueMessage(literal []byte, sendErr error) error {
+	for _, email := range su.to {
+		if !looksLikeEmail(email) {
+			return errors.New(`"` + email + `" is not a valid recipient.`)
+		}
+	}
+
+	msg := &store.OutboxMessage{
+		Username:   su.username,
+		AddressID:  su.addressID,
+		ReturnPath: su.returnPath,
+		To:         su.to,
+		Envelope:   su.envelope,
+		DSN:        su.dsn,
+		Literal:    literal,
+
+		DeliveryTime:   su.options.deliveryTime,
+		ExpirationTime: su.options.expirationTime,
+	}
+
+	if err := su.storeUser.QueueOutboxMessage(msg); err != nil {
+		log.WithError(err).Error("Message could not be queued in outbox")
+		return sendErr
+	}
+
+	log.WithField("outboxID", msg.ID).Info("API is not reachable, message was queued in outbox")
+	return nil
+}
+
+// watchOutbox sends queued messages of all users whenever the connection
+// is restored and regularly retries messages which failed to be sent.
+func (sb *smtpBackend) watchOutbox() {
+	internetOnCh := sb.eventListener.ProvideChannel(events.InternetOnEvent)
+
+	ticker := time.NewTicker(outboxCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-internetOnCh:
+			sb.processOutboxes(true)
+		case <-ticker.C:
+			sb.processOutboxes(false)
+		}
+	}
+}
+
+// processOutboxes tries to send queued messages whose time of the next
+// attempt has come, or all of them when force is set.
+func (sb *smtpBackend) processOutboxes(force bool) {
+	for _, user := range sb.bridge.GetUsers() {
+		storeUser := user.GetStore()
+		if storeUser == nil {
+			continue
+		}
+
+		msgs, err := storeUser.ListOutboxMessages()
+		if err != nil {
+			if err != store.ErrOutboxNotEnabled {
+				log.WithError(err).Error("Cannot list outbox messages")
+			}
+			continue
+		}
+
+		for _, msg := range msgs {
+			if !force && time.Now().Before(msg.NextAttempt) {
+				continue
+			}
+
+			// There is no point to continue with other messages of
+			// the user when the API is not reachable.
+			if isOffline := sb.processOutboxMessage(user, storeUser, msg); isOffline {
+				break
+			}
+		}
+	}
+}
+
+// processOutboxMessage sends the queued message or, when it failed too many
+// times or waited too long, bounces it back to the sender. It returns true
+// when the API could not be reached.
+func (sb *smtpBackend) processOutboxMessage(user bridgeUser, storeUser storeUserProvider, msg *store.OutboxMessage) bool {
+	l := log.WithFields(logrus.Fields{
+		"outboxID": msg.ID,
+		"attempts": msg.Attempts,
+	})
+
+	if msg.Attempts >= outboxMaxAttempts {
+		return sb.bounceOutboxMessage(user, storeUser, msg, msg.LastError)
+	}
+	if time.Since(msg.QueuedAt) > outboxMaxAge {
+		return sb.bounceOutboxMessage(user, storeUser, msg, "the message could not be sent in time")
+	}
+
+	session := &smtpUser{
+		panicHandler:  sb.panicHandler,
+		eventListener: sb.eventListener,
+		backend:       sb,
+		user:          user,
+		storeUser:     storeUser,
+		username:      msg.Username,
+		addressID:     msg.AddressID,
+		envelope:      msg.Envelope,
+		dsn:           msg.DSN,
+		queued:        msg,
+	}
+
+	err := session.Send(msg.ReturnPath, msg.To, bytes.NewReader(msg.Literal), new(bytes.Buffer))
+	switch {
+	case err == nil:
+		l.Info("Queued message was sent")
+		sb.removeOutboxMessage(storeUser, msg)
+		return false
+
+	case isOfflineError(err):
+		l.Debug("API is still not reachable, keeping message in outbox")
+		return true
+
+	// The client was already told the message was accepted and nobody may
+	// be around to confirm sending it unencrypted, so it is bounced back.
+	case errors.Is(err, errSendingCanceled):
+		l.Info("Sending of queued message without encryption was not confirmed")
+		return sb.bounceOutboxMessage(user, storeUser, msg, "sending the message without encryption was not confirmed")
+	}
+
+	l.WithError(err).Warn("Queued message could not be sent")
+
+	msg.Attempts++
+	msg.LastError = err.Error()
+	msg.NextAttempt = time.Now().Add(outboxBackoff(msg.Attempts))
+	if isPermanentSendError(err) {
+		msg.Attempts = outboxMaxAttempts
+	}
+
+	if err := storeUser.UpdateOutboxMessage(msg); err != nil {
+		l.WithError(err).Error("Cannot update outbox message")
+	}
+
+	if msg.Attempts >= outboxMaxAttempts {
+		return sb.bounceOutboxMessage(user, storeUser, msg, msg.LastError)
+	}
+
+	// Recipients asking for it are told about the delay only once.
+	if msg.Attempts == 1 {
+		sb.reportOutboxMessage(user, storeUser, msg, dsnActionDelayed, msg.LastError) //nolint[errcheck] failure is logged
+	}
+
+	return false
+}
+
+// bounceOutboxMessage reports the failure together with the original message
+// to INBOX of the sender and removes the message from outbox. It returns true
+// when the API could not be reached.
+func (sb *smtpBackend) bounceOutboxMessage(user bridgeUser, storeUser storeUserProvider, msg *store.OutboxMessage, reason string) bool {
+	if err := sb.reportOutboxMessage(user, storeUser, msg, dsnActionFailed, reason); err != nil {
+		return isOfflineError(err)
+	}
+
+	log.WithField("outboxID", msg.ID).Warn("Queued message was bounced")
+	sb.removeOutboxMessage(storeUser, msg)
+	return false
+}
+
+// reportOutboxMessage imports the delivery status notification about the
+// queued message for all its recipients which asked for it.
+func (sb *smtpBackend) reportOutboxMessage(user bridgeUser, storeUser storeUserProvider, msg *store.OutboxMessage, action, reason string) error {
+	l := log.WithField("outboxID", msg.ID)
+
+	addr, _ := resolveSender(user.GetClient().Addresses(), msg.ReturnPath, getAliasPolicy(storeUser))
+	if addr == nil {
+		l.Error("Address of queued message not found, report is not sent")
+		return nil
+	}
+
+	report := deliveryReport{
+		action:     action,
+		returnPath: msg.ReturnPath,
+		envelope:   msg.Envelope,
+		literal:    msg.Literal,
+	}
+	for _, to := range msg.To {
+		report.statuses = append(report.statuses, deliveryStatus{recipient: to, reason: reason})
+	}
+
+	if err := importDeliveryReport(storeUser, addr.ID, msg.DSN, report); err != nil {
+		l.WithError(err).Error("Cannot import delivery report")
+		return err
+	}
+
+	return nil
+}
+
+func (sb *smtpBackend) removeOutboxMessage(storeUser storeUserProvider, msg *store.OutboxMessage) {
+	if err := storeUser.RemoveOutboxMessage(msg.ID); err != nil {
+		log.WithError(err).WithField("outboxID", msg.ID).Error("Cannot remove message from outbox")
+	}
+}
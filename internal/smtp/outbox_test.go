@@ -0,0 +1,44 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	assert.Equal(t, time.Minute, outboxBackoff(1))
+	assert.Equal(t, 2*time.Minute, outboxBackoff(2))
+	assert.Equal(t, 16*time.Minute, outboxBackoff(5))
+	assert.Equal(t, time.Hour, outboxBackoff(10))
+	assert.Equal(t, time.Hour, outboxBackoff(100))
+}
+
+func TestOutboxErrors(t *testing.T) {
+	assert.True(t, isOfflineError(pmapi.ErrNoConnection))
+	assert.True(t, isOfflineError(errors.Wrap(pmapi.ErrNoConnection, "failed to create draft")))
+	assert.False(t, isOfflineError(errors.New("other")))
+
+	assert.True(t, isPermanentSendError(errors.Wrap(pmapi.ErrUnprocessableEntity{}, "failed to send")))
+	assert.False(t, isPermanentSendError(pmapi.ErrNoConnection))
+}
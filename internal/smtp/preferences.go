@@ -453,6 +453,38 @@ func (b *sendPreferencesBuilder) setExternalPGPSettingsWithoutWKDKeys(
 	return nil
 }
 
+// setAutocryptPGPSettings sets encryption to the key learned from Autocrypt
+// header of an external recipient which has no other key. Autocrypt always
+// uses PGP/MIME.
+func (b *sendPreferencesBuilder) setAutocryptPGPSettings(kr *crypto.KeyRing) {
+	b.withEncrypt(true)
+	b.withSign(true)
+	b.withScheme(pgpMIME)
+	b.removeMIMEType()
+	b.withPublicKey(kr)
+}
+
+// setMessageOverrides sets the per-message preferences requested by custom
+// headers of the message. They take precedence over the contact settings,
+// but the scheme cannot be changed for internal recipients and signing is
+// still enforced for encrypted messages by setEncryptionPreferences.
+func (b *sendPreferencesBuilder) setMessageOverrides(opts sendOptions) {
+	if opts.sign != nil {
+		b.withSign(*opts.sign)
+	}
+
+	if opts.scheme != "" && !b.isInternal() {
+		b.withScheme(opts.scheme)
+
+		switch opts.scheme {
+		case pgpMIME:
+			b.removeMIMEType()
+		case pgpInline:
+			b.withMIMEType("text/plain")
+		}
+	}
+}
+
 // setEncryptionPreferences sets the undefined values in the SendPreferences
 // determined thus far using using the (global) user mail settings.
 // The object we extract has the following possible value types:
@@ -373,6 +373,113 @@ func TestPreferencesBuilder(t *testing.T) {
 	}
 }
 
+func TestPreferencesBuilderMessageOverrides(t *testing.T) {
+	yes, no := true, false
+	mailSettings := pmapi.MailSettings{PGPScheme: pmapi.PGPMIMEPackage, DraftMIMEType: "text/html"}
+
+	tests := []struct {
+		name string
+
+		receivedKeys []pmapi.PublicKey
+		isInternal   bool
+		options      sendOptions
+
+		wantErr    bool
+		wantSign   bool
+		wantScheme pmapi.PackageFlag
+	}{
+		{
+			name:       "external with sign override",
+			options:    sendOptions{sign: &yes},
+			wantSign:   true,
+			wantScheme: pmapi.ClearMIMEPackage,
+		},
+		{
+			name:       "external with sign and inline override",
+			options:    sendOptions{sign: &yes, scheme: pgpInline},
+			wantSign:   true,
+			wantScheme: pmapi.ClearPackage,
+		},
+		{
+			name:       "external with required encryption",
+			options:    sendOptions{requireEncryption: true},
+			wantErr:    true,
+			wantScheme: pmapi.ClearPackage,
+		},
+		{
+			name:         "wkd-external with required encryption and inline override",
+			receivedKeys: []pmapi.PublicKey{{PublicKey: testPublicKey}},
+			options:      sendOptions{requireEncryption: true, scheme: pgpInline},
+			wantSign:     true,
+			wantScheme:   pmapi.PGPInlinePackage,
+		},
+		{
+			name:         "wkd-external with no sign override",
+			receivedKeys: []pmapi.PublicKey{{PublicKey: testPublicKey}},
+			options:      sendOptions{sign: &no},
+			wantErr:      true,
+			wantSign:     true,
+			wantScheme:   pmapi.PGPMIMEPackage,
+		},
+		{
+			name:         "internal with inline override",
+			receivedKeys: []pmapi.PublicKey{{PublicKey: testPublicKey}},
+			isInternal:   true,
+			options:      sendOptions{requireEncryption: true, scheme: pgpInline},
+			wantSign:     true,
+			wantScheme:   pmapi.InternalPackage,
+		},
+	}
+
+	for _, test := range tests {
+		test := test // Avoid using range scope test inside function literal.
+
+		t.Run(test.name, func(t *testing.T) {
+			b := &sendPreferencesBuilder{}
+
+			require.NoError(t, b.setPGPSettings(&ContactMetadata{}, test.receivedKeys, test.isInternal))
+			b.setMessageOverrides(test.options)
+			b.setEncryptionPreferences(mailSettings)
+			b.setMIMEPreferences("text/html")
+
+			prefs := b.build()
+
+			assert.Equal(t, test.wantSign, prefs.Sign)
+			assert.Equal(t, test.wantScheme, prefs.Scheme)
+
+			err := test.options.checkPreferences("bob@example.com", prefs)
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPreferencesBuilderAutocrypt(t *testing.T) {
+	key, err := crypto.NewKeyFromArmored(testPublicKey)
+	require.NoError(t, err)
+
+	kr, err := crypto.NewKeyRing(key)
+	require.NoError(t, err)
+
+	b := &sendPreferencesBuilder{}
+
+	require.NoError(t, b.setPGPSettings(&ContactMetadata{MIMEType: "text/plain"}, nil, false))
+	b.setAutocryptPGPSettings(kr)
+	b.setEncryptionPreferences(pmapi.MailSettings{PGPScheme: pmapi.PGPInlinePackage, DraftMIMEType: "text/html"})
+	b.setMIMEPreferences("text/html")
+
+	prefs := b.build()
+
+	assert.True(t, prefs.Encrypt)
+	assert.True(t, prefs.Sign)
+	assert.Equal(t, pmapi.PGPMIMEPackage, prefs.Scheme)
+	assert.Equal(t, "multipart/mixed", prefs.MIMEType)
+	assert.Equal(t, kr, prefs.PublicKey)
+}
+
 func loadContactKey(t *testing.T, key string) string {
 	ck, err := crypto.NewKeyFromArmored(key)
 	require.NoError(t, err)
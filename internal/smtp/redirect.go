@@ -0,0 +1,142 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/pkg/message"
+	"github.com/emersion/go-message/textproto"
+	goSMTPBackend "github.com/emersion/go-smtp"
+	"github.com/pkg/errors"
+)
+
+// redirectLoopHeader carries addresses which redirected the message, so the
+// message is not redirected again by the same account.
+const redirectLoopHeader = "X-Loop"
+
+//nolint:gochecknoglobals // Used like a constant
+var errRedirectLoop = errors.New("message was already redirected by the account")
+
+const (
+	redirectFetchWorkers  = 1
+	redirectAttachWorkers = 2
+	redirectBuildWorkers  = 1
+)
+
+// RedirectMessage sends the existing message of the user to given recipients.
+// The message is sent from the address which received it; the original
+// sender is kept in the Reply-To header, because the bridge can send only
+// from addresses owned by the user. That makes it a resend, so Resent-*
+// headers are added. Messages already redirected by any address of the user
+// are refused to not loop between accounts or with external forwarding.
+func (sb *smtpBackend) RedirectMessage(userID, addressID, messageID string, to []string) error {
+	user, err := sb.bridge.GetUser(userID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get user")
+	}
+
+	address := user.GetClient().Addresses().ByID(addressID)
+	if address == nil {
+		return errors.New("address of the message not found")
+	}
+
+	literal, err := sb.builder.NewJobWithOptions(
+		context.Background(),
+		user.GetClient(),
+		messageID,
+		message.JobOptions{SanitizeDate: true},
+	).GetResult()
+	if err != nil {
+		return errors.Wrap(err, "failed to build message")
+	}
+
+	redirected, err := rewriteRedirectHeader(literal, address.Email, user.GetClient().Addresses().AllEmails(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	smtpAddressID := addressID
+	if user.IsCombinedAddressMode() {
+		smtpAddressID = ""
+	}
+
+	session, err := newSMTPUser(sb.panicHandler, sb.eventListener, sb, user, address.Email, smtpAddressID)
+	if err != nil {
+		return err
+	}
+	defer session.Logout() //nolint[errcheck]
+
+	if err := session.Mail(address.Email, &goSMTPBackend.MailOptions{}); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err := session.Rcpt(recipient, &goSMTPBackend.RcptOptions{}); err != nil {
+			return err
+		}
+	}
+
+	return session.Data(redirected)
+}
+
+// rewriteRedirectHeader changes the sender of the literal to the address
+// and removes headers which would make the redirected message look like
+// the original one. It fails with errRedirectLoop when the message was
+// already redirected by any of the own addresses.
+func rewriteRedirectHeader(literal []byte, address string, ownAddresses []string, now time.Time) (io.Reader, error) {
+	bufReader := bufio.NewReader(bytes.NewReader(literal))
+
+	header, err := textproto.ReadHeader(bufReader)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read header")
+	}
+
+	for _, loop := range header.Values(redirectLoopHeader) {
+		for _, ownAddress := range ownAddresses {
+			if strings.EqualFold(strings.Trim(strings.TrimSpace(loop), "<>"), ownAddress) {
+				return nil, errRedirectLoop
+			}
+		}
+	}
+
+	if !header.Has("Reply-To") && header.Has("From") {
+		header.Set("Reply-To", header.Get("From"))
+	}
+	header.Set("From", "<"+address+">")
+
+	for _, key := range []string{"Message-Id", "Bcc", "Return-Path", "X-Pm-Internal-Id", "X-Pm-External-Id", "X-Pm-Date"} {
+		header.Del(key)
+	}
+
+	// Headers are prepended, so the newest resent block is on the top.
+	header.Add("Resent-Date", now.Format(time.RFC1123Z))
+	header.Add("Resent-From", "<"+address+">")
+	header.Add(redirectLoopHeader, address)
+
+	buf := new(bytes.Buffer)
+	if err := textproto.WriteHeader(buf, header); err != nil {
+		return nil, errors.Wrap(err, "failed to write header")
+	}
+
+	return io.MultiReader(buf, bufReader), nil
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteRedirectHeader(t *testing.T) {
+	literal := "From: Sender <sender@example.com>\r\n" +
+		"To: me@pm.me\r\n" +
+		"Bcc: hidden@pm.me\r\n" +
+		"Message-Id: <id@example.com>\r\n" +
+		"X-Pm-Internal-Id: internal\r\n" +
+		"Subject: Hello\r\n" +
+		"\r\n" +
+		"Body\r\n"
+
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	r, err := rewriteRedirectHeader([]byte(literal), "me@pm.me", []string{"me@pm.me", "other@pm.me"}, now)
+	require.NoError(t, err)
+
+	redirected, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+
+	require.Equal(t, "X-Loop: me@pm.me\r\n"+
+		"Resent-From: <me@pm.me>\r\n"+
+		"Resent-Date: Sat, 01 May 2021 12:00:00 +0000\r\n"+
+		"From: <me@pm.me>\r\n"+
+		"Reply-To: Sender <sender@example.com>\r\n"+
+		"To: me@pm.me\r\n"+
+		"Subject: Hello\r\n"+
+		"\r\n"+
+		"Body\r\n", string(redirected))
+}
+
+func TestRewriteRedirectHeaderLoop(t *testing.T) {
+	literal := "X-Loop: Other@pm.me\r\n" +
+		"From: Sender <sender@example.com>\r\n" +
+		"\r\n" +
+		"Body\r\n"
+
+	_, err := rewriteRedirectHeader([]byte(literal), "me@pm.me", []string{"me@pm.me", "other@pm.me"}, time.Now())
+	require.Equal(t, errRedirectLoop, err)
+
+	_, err = rewriteRedirectHeader([]byte(literal), "me@pm.me", []string{"me@pm.me"}, time.Now())
+	require.NoError(t, err)
+}
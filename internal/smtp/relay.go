@@ -0,0 +1,253 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+	goSMTPBackend "github.com/emersion/go-smtp"
+	"github.com/pkg/errors"
+)
+
+var (
+	errRelayTLSRequired = &goSMTPBackend.SMTPError{ //nolint[gochecknoglobals]
+		Code:         530,
+		EnhancedCode: goSMTPBackend.EnhancedCode{5, 7, 0},
+		Message:      "Must issue a STARTTLS command first",
+	}
+	errRelayDenied = &goSMTPBackend.SMTPError{ //nolint[gochecknoglobals]
+		Code:         550,
+		EnhancedCode: goSMTPBackend.EnhancedCode{5, 7, 1},
+		Message:      "Relaying denied",
+	}
+)
+
+// RelayRule allows hosts of the source network to send mail through the
+// relay without the bridge password. The host has to present TLS client
+// certificate with the fingerprint or log in with the token as password.
+// Messages are always sent from the address of the rule.
+type RelayRule struct {
+	Source          string // CIDR, e.g. 172.17.0.0/16
+	Address         string
+	CertFingerprint string // SHA-256 of DER encoded certificate in hex
+	Token           string
+
+	network *net.IPNet
+}
+
+// LoadRelayRules reads the relay rules from JSON file with list of rules.
+func LoadRelayRules(path string) ([]*RelayRule, error) {
+	if path == "" {
+		return nil, errors.New("no file with relay rules")
+	}
+
+	raw, err := ioutil.ReadFile(path) //nolint[gosec] path is set by the user
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []*RelayRule
+	if err := json.Unmarshal(raw, &rules); err != nil {
+		return nil, errors.Wrap(err, "failed to parse relay rules")
+	}
+
+	if len(rules) == 0 {
+		return nil, errors.New("no relay rules")
+	}
+
+	for i, rule := range rules {
+		if err := rule.init(); err != nil {
+			return nil, errors.Wrapf(err, "invalid relay rule %d", i+1)
+		}
+	}
+
+	return rules, nil
+}
+
+func (rule *RelayRule) init() error {
+	_, network, err := net.ParseCIDR(rule.Source)
+	if err != nil {
+		return err
+	}
+	rule.network = network
+
+	rule.Address = strings.ToLower(strings.TrimSpace(rule.Address))
+	if rule.Address == "" {
+		return errors.New("address is required")
+	}
+
+	rule.CertFingerprint = strings.ToLower(strings.ReplaceAll(rule.CertFingerprint, ":", ""))
+	if rule.CertFingerprint == "" && rule.Token == "" {
+		return errors.New("client certificate or token is required")
+	}
+
+	return nil
+}
+
+func (rule *RelayRule) checkToken(token string) bool {
+	if rule.Token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(rule.Token), []byte(token)) == 1
+}
+
+// checkCertificate checks the fingerprint of the client certificate. The chain
+// is not verified, the TLS handshake only proves the client has the key.
+func (rule *RelayRule) checkCertificate(state *tls.ConnectionState) bool {
+	if rule.CertFingerprint == "" || len(state.PeerCertificates) == 0 {
+		return false
+	}
+	fingerprint := sha256.Sum256(state.PeerCertificates[0].Raw)
+	return subtle.ConstantTimeCompare([]byte(rule.CertFingerprint), []byte(hex.EncodeToString(fingerprint[:]))) == 1
+}
+
+// relayBackend logs in hosts allowed by the rules instead of users with
+// the bridge password. The first rule matching the host is used.
+type relayBackend struct {
+	*smtpBackend
+
+	rules []*RelayRule
+}
+
+func (rb *relayBackend) getRule(state *connectionState) (*RelayRule, error) {
+	if state == nil || !state.TLS.HandshakeComplete {
+		return nil, errRelayTLSRequired
+	}
+
+	ip := remoteIP(state.RemoteAddr)
+	if ip == nil {
+		return nil, errRelayDenied
+	}
+
+	for _, rule := range rb.rules {
+		if rule.network.Contains(ip) {
+			return rule, nil
+		}
+	}
+
+	log.WithField("remote", ip).Warn("Relaying denied for host without rule")
+	return nil, errRelayDenied
+}
+
+// Login authenticates the host by the token of the rule. The username is
+// not checked because the address is given by the rule.
+func (rb *relayBackend) Login(state *connectionState, username, password string) (userSession, error) {
+	// Called from go-smtp in goroutines - we need to handle panics for each function.
+	defer rb.panicHandler.HandlePanic()
+
+	rule, err := rb.getRule(state)
+	if err != nil {
+		return nil, err
+	}
+
+	if !rule.checkToken(password) {
+		log.WithField("remote", state.RemoteAddr).Warn("Relaying denied for wrong token")
+		// The same timeout as after bad login to slow down guessing.
+		time.Sleep(10 * time.Second)
+		return nil, errRelayDenied
+	}
+
+	return rb.newRelaySession(rule)
+}
+
+// AnonymousLogin authenticates the host by the TLS client certificate.
+func (rb *relayBackend) AnonymousLogin(state *connectionState) (userSession, error) {
+	// Called from go-smtp in goroutines - we need to handle panics for each function.
+	defer rb.panicHandler.HandlePanic()
+
+	rule, err := rb.getRule(state)
+	if err != nil {
+		return nil, err
+	}
+
+	if !rule.checkCertificate(&state.TLS) {
+		log.WithField("remote", state.RemoteAddr).Warn("Relaying denied for unknown client certificate")
+		return nil, errRelayDenied
+	}
+
+	return rb.newRelaySession(rule)
+}
+
+func (rb *relayBackend) newRelaySession(rule *RelayRule) (userSession, error) {
+	user, err := rb.bridge.GetUser(rule.Address)
+	if err != nil {
+		log.WithError(err).WithField("address", rule.Address).Error("Cannot get user of relay rule")
+		return nil, err
+	}
+
+	session, err := rb.newUserSession(user, rule.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	session.relayAddress = rule.Address
+	return session, nil
+}
+
+func remoteIP(addr net.Addr) net.IP {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+	if addr == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// rewriteRelaySender changes the sender of the relayed message to the address
+// of the relay rule. Services usually send from local addresses which cannot
+// be used, but their display name is kept.
+func rewriteRelaySender(r io.Reader, address string) (io.Reader, error) {
+	bufReader := bufio.NewReader(r)
+
+	header, err := textproto.ReadHeader(bufReader)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read header")
+	}
+
+	sender := &mail.Address{Address: address}
+	if from, err := mail.ParseAddress(header.Get("From")); err == nil {
+		sender.Name = from.Name
+	}
+	header.Set("From", sender.String())
+	header.Del("Sender")
+
+	buf := new(bytes.Buffer)
+	if err := textproto.WriteHeader(buf, header); err != nil {
+		return nil, errors.Wrap(err, "failed to write header")
+	}
+
+	return io.MultiReader(buf, bufReader), nil
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeRelayRules(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "relay")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "rules.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadRelayRules(t *testing.T) {
+	rules, err := LoadRelayRules(writeRelayRules(t, `[
+		{"Source": "172.17.0.0/16", "Address": "CI@pm.me", "Token": "secret"},
+		{"Source": "192.168.1.10/32", "Address": "alerts@pm.me", "CertFingerprint": "AB:CD"}
+	]`))
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, "ci@pm.me", rules[0].Address)
+	assert.Equal(t, "abcd", rules[1].CertFingerprint)
+
+	for _, content := range []string{
+		`[]`,
+		`not json`,
+		`[{"Source": "172.17.0.0", "Address": "ci@pm.me", "Token": "secret"}]`,
+		`[{"Source": "172.17.0.0/16", "Token": "secret"}]`,
+		`[{"Source": "172.17.0.0/16", "Address": "ci@pm.me"}]`,
+	} {
+		_, err := LoadRelayRules(writeRelayRules(t, content))
+		assert.Error(t, err, content)
+	}
+
+	_, err = LoadRelayRules("")
+	assert.Error(t, err)
+}
+
+func TestRelayGetRule(t *testing.T) {
+	rules, err := LoadRelayRules(writeRelayRules(t, `[
+		{"Source": "172.17.0.0/16", "Address": "ci@pm.me", "Token": "secret"},
+		{"Source": "fd00::/8", "Address": "lab@pm.me", "Token": "secret"}
+	]`))
+	require.NoError(t, err)
+
+	rb := &relayBackend{rules: rules}
+
+	newState := func(ip string, tlsDone bool) *connectionState {
+		return &connectionState{
+			RemoteAddr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 12345},
+			TLS:        tls.ConnectionState{HandshakeComplete: tlsDone},
+		}
+	}
+
+	rule, err := rb.getRule(newState("172.17.0.5", true))
+	require.NoError(t, err)
+	assert.Equal(t, "ci@pm.me", rule.Address)
+
+	rule, err = rb.getRule(newState("fd00::5", true))
+	require.NoError(t, err)
+	assert.Equal(t, "lab@pm.me", rule.Address)
+
+	_, err = rb.getRule(newState("10.0.0.5", true))
+	assert.Equal(t, errRelayDenied, err)
+
+	_, err = rb.getRule(newState("172.17.0.5", false))
+	assert.Equal(t, errRelayTLSRequired, err)
+
+	_, err = rb.getRule(nil)
+	assert.Equal(t, errRelayTLSRequired, err)
+}
+
+func TestRelayRuleCheckToken(t *testing.T) {
+	rule := &RelayRule{Token: "secret"}
+	assert.True(t, rule.checkToken("secret"))
+	assert.False(t, rule.checkToken("Secret"))
+	assert.False(t, rule.checkToken(""))
+
+	rule = &RelayRule{CertFingerprint: "abcd"}
+	assert.False(t, rule.checkToken(""))
+}
+
+func TestRelayRuleCheckCertificate(t *testing.T) {
+	cert := &x509.Certificate{Raw: []byte("certificate")}
+	fingerprint := sha256.Sum256(cert.Raw)
+
+	rule := &RelayRule{CertFingerprint: hex.EncodeToString(fingerprint[:])}
+	assert.True(t, rule.checkCertificate(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}))
+	assert.False(t, rule.checkCertificate(&tls.ConnectionState{}))
+
+	other := &x509.Certificate{Raw: []byte("other")}
+	assert.False(t, rule.checkCertificate(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{other}}))
+
+	rule = &RelayRule{Token: "secret"}
+	assert.False(t, rule.checkCertificate(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}))
+}
+
+func TestRewriteRelaySender(t *testing.T) {
+	literal := "From: Grafana <root@localhost>\r\n" +
+		"Sender: root@localhost\r\n" +
+		"To: me@pm.me\r\n" +
+		"Subject: Alert\r\n" +
+		"\r\n" +
+		"Body\r\n"
+
+	r, err := rewriteRelaySender(strings.NewReader(literal), "alerts@pm.me")
+	require.NoError(t, err)
+
+	relayed, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+
+	require.Equal(t, "From: \"Grafana\" <alerts@pm.me>\r\n"+
+		"To: me@pm.me\r\n"+
+		"Subject: Alert\r\n"+
+		"\r\n"+
+		"Body\r\n", string(relayed))
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/emersion/go-message"
+	"github.com/pkg/errors"
+)
+
+// Custom headers to request features of sending which are not part of
+// the standard SMTP submission. They are never part of the sent message.
+const (
+	expiresInHeader = "X-Pm-Expires-In"
+	deliverAtHeader = "X-Pm-Deliver-At"
+	encryptHeader   = "X-Pm-Encrypt"
+	signHeader      = "X-Pm-Sign"
+	schemeHeader    = "X-Pm-Scheme"
+
+	encryptRequired = "required"
+	encryptAuto     = "auto"
+
+	// maxExpiresIn is the longest expiration allowed by the API.
+	maxExpiresIn = 28 * 24 * time.Hour
+)
+
+// sendOptions holds options of sending requested by custom headers.
+type sendOptions struct {
+	expirationTime time.Time
+	deliveryTime   time.Time
+
+	// Per-message overrides of send preferences of all recipients.
+	requireEncryption bool
+	sign              *bool
+	scheme            string
+}
+
+// takeSendOptions parses the custom headers and removes them from the header
+// so they are not part of the draft nor of the sent message.
+//
+// X-Pm-Expires-In is either a number of seconds or a duration such as 36h;
+// the time is counted from the delivery. X-Pm-Deliver-At is either a Unix
+// time or a date in the format of the Date header and must be in the future.
+//
+// X-Pm-Encrypt (required or auto), X-Pm-Sign (yes or no) and X-Pm-Scheme
+// (pgp-mime or pgp-inline) override send preferences of all recipients.
+func takeSendOptions(header *message.Header, now time.Time) (opts sendOptions, err error) {
+	deliverAt := header.Get(deliverAtHeader)
+	expiresIn := header.Get(expiresInHeader)
+	encrypt := header.Get(encryptHeader)
+	sign := header.Get(signHeader)
+	scheme := header.Get(schemeHeader)
+
+	for _, key := range []string{deliverAtHeader, expiresInHeader, encryptHeader, signHeader, schemeHeader} {
+		header.Del(key)
+	}
+
+	if err := opts.setOverrides(encrypt, sign, scheme); err != nil {
+		return sendOptions{}, err
+	}
+
+	if deliverAt != "" {
+		if opts.deliveryTime, err = parseDeliverAt(deliverAt); err != nil {
+			return sendOptions{}, err
+		}
+		if !opts.deliveryTime.After(now) {
+			return sendOptions{}, errors.New(deliverAtHeader + " must be in the future")
+		}
+	}
+
+	if expiresIn != "" {
+		duration, err := parseExpiresIn(expiresIn)
+		if err != nil {
+			return sendOptions{}, err
+		}
+
+		deliveryTime := now
+		if !opts.deliveryTime.IsZero() {
+			deliveryTime = opts.deliveryTime
+		}
+		opts.expirationTime = deliveryTime.Add(duration)
+	}
+
+	return opts, nil
+}
+
+// takeSendOptions parses the custom headers of the message. Times of the
+// queued message are taken from the outbox, because they were counted from
+// the time when the message was queued. Delivery time which passed while
+// the message was waiting in the outbox is dropped and message is sent now.
+func (su *smtpUser) takeSendOptions(header *message.Header) (sendOptions, error) {
+	if su.queued == nil {
+		opts, err := takeSendOptions(header, time.Now())
+		su.options = opts
+		return opts, err
+	}
+
+	opts, err := takeSendOptions(header, su.queued.QueuedAt)
+	if err != nil {
+		return opts, err
+	}
+
+	opts.deliveryTime = su.queued.DeliveryTime
+	opts.expirationTime = su.queued.ExpirationTime
+	if !opts.deliveryTime.After(time.Now()) {
+		opts.deliveryTime = time.Time{}
+	}
+
+	return opts, nil
+}
+
+func (opts *sendOptions) setOverrides(encrypt, sign, scheme string) error {
+	switch strings.ToLower(strings.TrimSpace(encrypt)) {
+	case "", encryptAuto:
+	case encryptRequired:
+		opts.requireEncryption = true
+	default:
+		return errors.Errorf("invalid %v: %v", encryptHeader, encrypt)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(sign)) {
+	case "":
+	case "yes":
+		v := true
+		opts.sign = &v
+	case "no":
+		v := false
+		opts.sign = &v
+	default:
+		return errors.Errorf("invalid %v: %v", signHeader, sign)
+	}
+
+	switch scheme = strings.ToLower(strings.TrimSpace(scheme)); scheme {
+	case "", pgpMIME, pgpInline:
+		opts.scheme = scheme
+	default:
+		return errors.Errorf("invalid %v: %v", schemeHeader, scheme)
+	}
+
+	return nil
+}
+
+// checkPreferences returns an error when the send preferences of the
+// recipient do not satisfy the per-message overrides. Overrides cannot turn
+// off encryption of recipients which must be encrypted to, for example.
+func (opts sendOptions) checkPreferences(recipient string, preferences SendPreferences) error {
+	if opts.requireEncryption && !preferences.Encrypt {
+		return errors.Errorf("encryption is required but message to %v cannot be encrypted", recipient)
+	}
+
+	if opts.sign != nil && *opts.sign != preferences.Sign {
+		return errors.Errorf("message to %v must be signed because it is encrypted", recipient)
+	}
+
+	return nil
+}
+
+func parseDeliverAt(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+
+	date, err := mail.ParseDate(value)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid %v: %v", deliverAtHeader, value)
+	}
+
+	return date, nil
+}
+
+func parseExpiresIn(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, convErr := strconv.ParseInt(value, 10, 64)
+		if convErr != nil {
+			return 0, errors.Errorf("invalid %v: %v", expiresInHeader, value)
+		}
+		duration = time.Duration(seconds) * time.Second
+	}
+
+	if duration <= 0 || duration > maxExpiresIn {
+		return 0, errors.Errorf("%v must be positive and at most %v", expiresInHeader, maxExpiresIn)
+	}
+
+	return duration, nil
+}
+
+// apply sets the requested options to the send request.
+func (opts sendOptions) apply(req *pmapi.SendMessageReq) {
+	if !opts.expirationTime.IsZero() {
+		req.ExpirationTime = opts.expirationTime.Unix()
+	}
+	if !opts.deliveryTime.IsZero() {
+		req.DeliveryTime = opts.deliveryTime.Unix()
+	}
+}
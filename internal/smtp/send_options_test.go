@@ -0,0 +1,177 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/store"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/emersion/go-message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSendOptionsHeader(values map[string]string) *message.Header {
+	header := &message.Header{}
+	header.Set("Subject", "Hello")
+	for key, value := range values {
+		header.Set(key, value)
+	}
+	return header
+}
+
+func TestTakeSendOptionsNone(t *testing.T) {
+	header := newTestSendOptionsHeader(nil)
+
+	opts, err := takeSendOptions(header, time.Now())
+	require.NoError(t, err)
+
+	req := &pmapi.SendMessageReq{}
+	opts.apply(req)
+	assert.Zero(t, req.ExpirationTime)
+	assert.Zero(t, req.DeliveryTime)
+	assert.Equal(t, "Hello", header.Get("Subject"))
+}
+
+func TestTakeSendOptions(t *testing.T) {
+	now := time.Date(2021, 5, 4, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name                         string
+		expiresIn, deliverAt         string
+		wantExpiration, wantDelivery time.Time
+	}{
+		{"expires in seconds", "3600", "", now.Add(time.Hour), time.Time{}},
+		{"expires in duration", "36h", "", now.Add(36 * time.Hour), time.Time{}},
+		{"deliver at unix", "", "1620136800", time.Time{}, time.Unix(1620136800, 0)},
+		{"deliver at date", "", "Tue, 04 May 2021 14:00:00 +0000", time.Time{}, now.Add(4 * time.Hour)},
+		{"expires after delivery", "1h", "Tue, 04 May 2021 14:00:00 +0000", now.Add(5 * time.Hour), now.Add(4 * time.Hour)},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			header := newTestSendOptionsHeader(map[string]string{
+				expiresInHeader: tc.expiresIn,
+				deliverAtHeader: tc.deliverAt,
+			})
+
+			opts, err := takeSendOptions(header, now)
+			require.NoError(t, err)
+			assert.True(t, tc.wantExpiration.Equal(opts.expirationTime), "expiration %v", opts.expirationTime)
+			assert.True(t, tc.wantDelivery.Equal(opts.deliveryTime), "delivery %v", opts.deliveryTime)
+
+			// Custom headers must not be sent.
+			assert.False(t, header.Has(expiresInHeader))
+			assert.False(t, header.Has(deliverAtHeader))
+
+			req := &pmapi.SendMessageReq{}
+			opts.apply(req)
+			if !tc.wantExpiration.IsZero() {
+				assert.Equal(t, tc.wantExpiration.Unix(), req.ExpirationTime)
+			}
+			if !tc.wantDelivery.IsZero() {
+				assert.Equal(t, tc.wantDelivery.Unix(), req.DeliveryTime)
+			}
+		})
+	}
+}
+
+func TestTakeSendOptionsInvalid(t *testing.T) {
+	now := time.Date(2021, 5, 4, 10, 0, 0, 0, time.UTC)
+
+	for _, values := range []map[string]string{
+		{expiresInHeader: "soon"},
+		{expiresInHeader: "0"},
+		{expiresInHeader: "-1h"},
+		{expiresInHeader: "700h"},
+		{deliverAtHeader: "tomorrow"},
+		{deliverAtHeader: "Mon, 03 May 2021 10:00:00 +0000"},
+	} {
+		_, err := takeSendOptions(newTestSendOptionsHeader(values), now)
+		assert.Error(t, err, "%v", values)
+	}
+}
+
+func TestTakeSendOptionsOverrides(t *testing.T) {
+	header := newTestSendOptionsHeader(map[string]string{
+		encryptHeader: "Required",
+		signHeader:    "no",
+		schemeHeader:  "pgp-inline",
+	})
+
+	opts, err := takeSendOptions(header, time.Now())
+	require.NoError(t, err)
+	assert.True(t, opts.requireEncryption)
+	require.NotNil(t, opts.sign)
+	assert.False(t, *opts.sign)
+	assert.Equal(t, pgpInline, opts.scheme)
+
+	assert.False(t, header.Has(encryptHeader))
+	assert.False(t, header.Has(signHeader))
+	assert.False(t, header.Has(schemeHeader))
+}
+
+func TestTakeSendOptionsInvalidOverrides(t *testing.T) {
+	for _, values := range []map[string]string{
+		{encryptHeader: "always"},
+		{signHeader: "maybe"},
+		{schemeHeader: "smime"},
+	} {
+		_, err := takeSendOptions(newTestSendOptionsHeader(values), time.Now())
+		assert.Error(t, err, "%v", values)
+	}
+}
+
+func TestTakeSendOptionsOfQueuedMessage(t *testing.T) {
+	queuedAt := time.Now().Add(-2 * time.Hour)
+	deliverAt := queuedAt.Add(time.Hour)
+
+	headers := map[string]string{
+		deliverAtHeader: strconv.FormatInt(deliverAt.Unix(), 10),
+		expiresInHeader: "24h",
+	}
+
+	su := &smtpUser{}
+	opts, err := su.takeSendOptions(newTestSendOptionsHeader(headers))
+	require.Error(t, err, "delivery time in the past")
+	assert.Zero(t, opts.deliveryTime)
+
+	// Queued message keeps the expiration counted when it was queued and
+	// it is sent right away once its delivery time passed.
+	su.queued = &store.OutboxMessage{
+		QueuedAt:       queuedAt,
+		DeliveryTime:   deliverAt,
+		ExpirationTime: deliverAt.Add(24 * time.Hour),
+	}
+	header := newTestSendOptionsHeader(headers)
+	opts, err = su.takeSendOptions(header)
+	require.NoError(t, err)
+	assert.Zero(t, opts.deliveryTime)
+	assert.Equal(t, deliverAt.Add(24*time.Hour), opts.expirationTime)
+	assert.Empty(t, header.Get(deliverAtHeader))
+
+	// Delivery time still in the future is kept.
+	su.queued.DeliveryTime = time.Now().Add(time.Hour)
+	opts, err = su.takeSendOptions(newTestSendOptionsHeader(headers))
+	require.NoError(t, err)
+	assert.Equal(t, su.queued.DeliveryTime, opts.deliveryTime)
+}
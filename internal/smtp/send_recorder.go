@@ -28,6 +28,8 @@ import (
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 )
 
+const sendRecorderExpiration = 30 * time.Minute
+
 type messageGetter interface {
 	GetMessage(context.Context, string) (*pmapi.Message, error)
 }
@@ -108,6 +110,22 @@ func (q *sendRecorder) setMessageID(hash, messageID string) {
 	}
 }
 
+// restoreMessage seeds the recorder with the message sent before restart so
+// that resends of it are still recognised.
+func (q *sendRecorder) restoreMessage(hash, messageID string, sentTime time.Time) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if _, ok := q.hashes[hash]; ok || hash == "" || time.Since(sentTime) > sendRecorderExpiration {
+		return
+	}
+
+	q.hashes[hash] = sendRecorderValue{
+		messageID: messageID,
+		time:      sentTime,
+	}
+}
+
 func (q *sendRecorder) isSendingOrSent(client messageGetter, hash string) (isSending bool, wasSent bool) {
 	q.lock.Lock()
 	defer q.lock.Unlock()
@@ -156,7 +174,7 @@ func (q *sendRecorder) deleteExpiredKeys() {
 		// On the the other, a user could put the device into sleep mode while sending.
 		// Changing the expiration time will always make one of the edge cases worse.
 		// But both edge cases are something we don't care much about. Important thing is we don't send the same message many times.
-		if time.Since(value.time) > 30*time.Minute {
+		if time.Since(value.time) > sendRecorderExpiration {
 			delete(q.hashes, key)
 		}
 	}
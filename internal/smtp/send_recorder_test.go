@@ -441,3 +441,22 @@ func TestSendRecorder_deleteExpiredKeys(t *testing.T) {
 	_, ok = q.hashes["hash2"]
 	assert.False(t, ok)
 }
+
+func TestSendRecorder_restoreMessage(t *testing.T) {
+	q := newSendRecorder()
+	q.addMessage("sending")
+
+	q.restoreMessage("sending", "msg1", time.Now())
+	q.restoreMessage("sent", "msg2", time.Now().Add(-time.Minute))
+	q.restoreMessage("expired", "msg3", time.Now().Add(-31*time.Minute))
+
+	assert.Equal(t, "", q.hashes["sending"].messageID)
+	assert.Equal(t, "msg2", q.hashes["sent"].messageID)
+	_, ok := q.hashes["expired"]
+	assert.False(t, ok)
+
+	messageGetter := &testSendRecorderGetMessageMock{message: &pmapi.Message{Type: pmapi.MessageTypeSent}}
+	isSending, wasSent := q.isSendingOrSent(messageGetter, "sent")
+	assert.False(t, isSending)
+	assert.True(t, wasSent)
+}
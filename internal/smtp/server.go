@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sync"
 
 	"github.com/ProtonMail/proton-bridge/internal/bridge"
 	"github.com/ProtonMail/proton-bridge/internal/serverutil"
@@ -30,14 +31,26 @@ import (
 	goSMTP "github.com/emersion/go-smtp"
 )
 
+// maxMessageSize is the largest message accepted by the server. It is
+// advertised by SIZE before the client logs in and the API limit of the user
+// is known; the limit of the user is checked by the session. The API limit
+// applies to decoded content, so it is raised by the overhead of transfer
+// encoding to not refuse messages which the API would accept.
+var maxMessageSize = getEncodedSizeLimit(25 * 1024 * 1024) //nolint[gochecknoglobals]
+
 // Server is Bridge SMTP server implementation.
 type Server struct {
 	panicHandler panicHandler
-	backend      goSMTP.Backend
+	backend      loginBackend
 	debug        bool
 	useSSL       bool
-	port         int
+	address      string
 	tls          *tls.Config
+	sessions     *serverutil.Sessions
+
+	// connSessions are sessions of open connections.
+	connSessions     map[*connSession]struct{}
+	connSessionsLock sync.Mutex
 
 	server     *goSMTP.Server
 	controller serverutil.Controller
@@ -48,46 +61,98 @@ func NewSMTPServer(
 	panicHandler panicHandler,
 	debug bool, port int, useSSL bool,
 	tls *tls.Config,
-	smtpBackend goSMTP.Backend,
+	smtpBackend *smtpBackend,
 	eventListener listener.Listener,
-) *Server {
+	sessions *serverutil.Sessions,
+) *Server { //nolint[golint]
 	server := &Server{
 		panicHandler: panicHandler,
 		backend:      smtpBackend,
 		debug:        debug,
 		useSSL:       useSSL,
-		port:         port,
+		address:      fmt.Sprintf("%s:%d", bridge.Host, port),
 		tls:          tls,
+		sessions:     sessions,
+	}
+
+	server.server = newGoSMTPServer(server)
+	server.controller = serverutil.NewController(server, eventListener)
+	return server
+}
+
+// NewSMTPRelayServer returns an SMTP server listening on the address for
+// hosts allowed by the relay rules. TLS is mandatory: login is possible
+// only after STARTTLS and client certificates are requested.
+func NewSMTPRelayServer(
+	panicHandler panicHandler,
+	debug bool, address string,
+	tlsConfig *tls.Config,
+	smtpBackend *smtpBackend,
+	rules []*RelayRule,
+	eventListener listener.Listener,
+	sessions *serverutil.Sessions,
+) *Server { //nolint[golint]
+	relayTLS := tlsConfig.Clone()
+	relayTLS.ClientAuth = tls.RequestClientCert
+
+	server := &Server{
+		panicHandler: panicHandler,
+		backend:      &relayBackend{smtpBackend: smtpBackend, rules: rules},
+		debug:        debug,
+		address:      address,
+		tls:          relayTLS,
+		sessions:     sessions,
 	}
 
 	server.server = newGoSMTPServer(server)
+	server.server.AllowInsecureAuth = false
 	server.controller = serverutil.NewController(server, eventListener)
 	return server
 }
 
 func newGoSMTPServer(s *Server) *goSMTP.Server {
-	newSMTP := goSMTP.NewServer(s.backend)
+	newSMTP := goSMTP.NewServer(s)
 	newSMTP.Addr = s.Address()
 	newSMTP.TLSConfig = s.tls
 	newSMTP.Domain = bridge.Host
 	newSMTP.ErrorLog = serverutil.NewServerErrorLogger(serverutil.SMTP)
 	newSMTP.AllowInsecureAuth = true
 	newSMTP.MaxLineLength = 1 << 16
+	newSMTP.MaxMessageBytes = maxMessageSize
+	newSMTP.EnableSMTPUTF8 = true
+	newSMTP.EnableDSN = true
 
 	newSMTP.EnableAuth(sasl.Login, func(conn *goSMTP.Conn) sasl.Server {
 		return sasl.NewLoginServer(func(address, password string) error {
-			user, err := conn.Server().Backend.Login(nil, address, password)
-			if err != nil {
-				return err
-			}
-
-			conn.SetSession(user)
-			return nil
+			return conn.Session().AuthPlain(address, password)
 		})
 	})
 	return newSMTP
 }
 
+// NewSession implements go-smtp Backend. The session is created when the
+// client greets and it has no user until the client logs in.
+func (s *Server) NewSession(conn *goSMTP.Conn) (goSMTP.Session, error) {
+	session := &connSession{server: s, conn: conn}
+
+	s.connSessionsLock.Lock()
+	defer s.connSessionsLock.Unlock()
+
+	if s.connSessions == nil {
+		s.connSessions = map[*connSession]struct{}{}
+	}
+	s.connSessions[session] = struct{}{}
+
+	return session, nil
+}
+
+func (s *Server) removeSession(session *connSession) {
+	s.connSessionsLock.Lock()
+	defer s.connSessionsLock.Unlock()
+
+	delete(s.connSessions, session)
+}
+
 // ListenAndServe will run server and all monitors.
 func (s *Server) ListenAndServe() { s.controller.ListenAndServe() }
 
@@ -96,11 +161,11 @@ func (s *Server) Close() { s.controller.Close() }
 
 // Implements servertutil.Server interface.
 
-func (Server) Protocol() serverutil.Protocol { return serverutil.SMTP }
-func (s *Server) UseSSL() bool               { return s.useSSL }
-func (s *Server) Address() string            { return fmt.Sprintf("%s:%d", bridge.Host, s.port) }
-func (s *Server) TLSConfig() *tls.Config     { return s.tls }
-func (s *Server) HandlePanic()               { s.panicHandler.HandlePanic() }
+func (*Server) Protocol() serverutil.Protocol { return serverutil.SMTP }
+func (s *Server) UseSSL() bool                { return s.useSSL }
+func (s *Server) Address() string             { return s.address }
+func (s *Server) TLSConfig() *tls.Config      { return s.tls }
+func (s *Server) HandlePanic()                { s.panicHandler.HandlePanic() }
 
 func (s *Server) DebugServer() bool { return s.debug }
 func (s *Server) DebugClient() bool { return s.debug }
@@ -109,14 +174,22 @@ func (s *Server) SetLoggers(localDebug, remoteDebug io.Writer) { s.server.Debug
 
 func (s *Server) DisconnectUser(address string) {
 	log.Info("Disconnecting all open SMTP connections for ", address)
-	s.server.ForEachConn(func(conn *goSMTP.Conn) {
-		connUser := conn.Session()
-		if connUser != nil {
-			if err := conn.Close(); err != nil {
-				log.WithError(err).Error("Failed to close the connection")
-			}
+
+	// Closing the connection logs the session out, which removes it.
+	s.connSessionsLock.Lock()
+	var conns []*goSMTP.Conn
+	for session := range s.connSessions {
+		if session.isLoggedIn() {
+			conns = append(conns, session.conn)
 		}
-	})
+	}
+	s.connSessionsLock.Unlock()
+
+	for _, conn := range conns {
+		if err := conn.Close(); err != nil {
+			log.WithError(err).Error("Failed to close the connection")
+		}
+	}
 }
 
 func (s *Server) Serve(l net.Listener) error { return s.server.Serve(l) }
@@ -0,0 +1,203 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/serverutil"
+	goSMTP "github.com/emersion/go-smtp"
+)
+
+// connectionState is the state of the connection the client logs in from.
+type connectionState struct {
+	Hostname   string
+	RemoteAddr net.Addr
+	TLS        tls.ConnectionState
+}
+
+func newConnectionState(conn *goSMTP.Conn) *connectionState {
+	state := &connectionState{
+		Hostname:   conn.Hostname(),
+		RemoteAddr: conn.Conn().RemoteAddr(),
+	}
+	state.TLS, _ = conn.TLSConnectionState()
+	return state
+}
+
+// userSession is the session of the user logged in by the client.
+type userSession interface {
+	Reset()
+	Logout() error
+	Mail(from string, opts *goSMTP.MailOptions) error
+	Rcpt(to string, opts *goSMTP.RcptOptions) error
+	Data(r io.Reader) error
+}
+
+// loginBackend returns the session of the user logged in by the client.
+type loginBackend interface {
+	Login(state *connectionState, username, password string) (userSession, error)
+	AnonymousLogin(state *connectionState) (userSession, error)
+}
+
+// connSession is the session of the connection. go-smtp creates it when the
+// client greets, before the client logs in, so commands are passed to the
+// session of the user once there is one.
+type connSession struct {
+	server *Server
+	conn   *goSMTP.Conn
+
+	lock sync.Mutex
+	user userSession
+}
+
+func (s *connSession) isLoggedIn() bool {
+	return s.getUser() != nil
+}
+
+func (s *connSession) getUser() userSession {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.user
+}
+
+func (s *connSession) setUser(user userSession) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.user = user
+}
+
+func (s *connSession) AuthPlain(username, password string) error {
+	user, err := s.server.backend.Login(newConnectionState(s.conn), username, password)
+	if err != nil {
+		return err
+	}
+
+	if s.server.sessions != nil {
+		user = newTrackedSession(s.server.sessions, newConnectionState(s.conn), username, user)
+	}
+
+	s.setUser(user)
+	return nil
+}
+
+func (s *connSession) Reset() {
+	if user := s.getUser(); user != nil {
+		user.Reset()
+	}
+}
+
+func (s *connSession) Logout() error {
+	s.server.removeSession(s)
+
+	user := s.getUser()
+	if user == nil {
+		return nil
+	}
+	return user.Logout()
+}
+
+// Mail logs in the client anonymously when it did not log in yet.
+func (s *connSession) Mail(from string, opts *goSMTP.MailOptions) error {
+	user := s.getUser()
+	if user == nil {
+		var err error
+		if user, err = s.server.backend.AnonymousLogin(newConnectionState(s.conn)); err != nil {
+			return err
+		}
+		s.setUser(user)
+	}
+	return user.Mail(from, opts)
+}
+
+func (s *connSession) Rcpt(to string, opts *goSMTP.RcptOptions) error {
+	user := s.getUser()
+	if user == nil {
+		return goSMTP.ErrAuthRequired
+	}
+	return user.Rcpt(to, opts)
+}
+
+func (s *connSession) Data(r io.Reader) error {
+	user := s.getUser()
+	if user == nil {
+		return goSMTP.ErrAuthRequired
+	}
+	return user.Data(r)
+}
+
+// trackedSession records the session and its commands to sessions. Only
+// authenticated connections are tracked.
+type trackedSession struct {
+	userSession
+
+	sessions *serverutil.Sessions
+	id       int
+}
+
+func newTrackedSession(sessions *serverutil.Sessions, state *connectionState, username string, session userSession) *trackedSession {
+	remoteAddress := ""
+	if state.RemoteAddr != nil {
+		remoteAddress = state.RemoteAddr.String()
+	}
+
+	id := sessions.Add(serverutil.SMTP, remoteAddress)
+	sessions.SetUser(id, username)
+	sessions.SetClient(id, state.Hostname)
+
+	return &trackedSession{
+		userSession: session,
+		sessions:    sessions,
+		id:          id,
+	}
+}
+
+func (s *trackedSession) record(name string, start time.Time) {
+	s.sessions.AddCommand(s.id, name, time.Since(start))
+}
+
+func (s *trackedSession) Reset() {
+	defer s.record("RSET", time.Now())
+	s.userSession.Reset()
+}
+
+func (s *trackedSession) Logout() error {
+	s.sessions.Remove(s.id)
+	return s.userSession.Logout()
+}
+
+func (s *trackedSession) Mail(from string, opts *goSMTP.MailOptions) error {
+	defer s.record("MAIL", time.Now())
+	return s.userSession.Mail(from, opts)
+}
+
+func (s *trackedSession) Rcpt(to string, opts *goSMTP.RcptOptions) error {
+	defer s.record("RCPT", time.Now())
+	return s.userSession.Rcpt(to, opts)
+}
+
+func (s *trackedSession) Data(r io.Reader) error {
+	defer s.record("DATA", time.Now())
+	return s.userSession.Data(r)
+}
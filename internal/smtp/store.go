@@ -21,6 +21,7 @@ import (
 	"io"
 
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/ProtonMail/proton-bridge/internal/store"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 )
 
@@ -34,4 +35,18 @@ type storeUserProvider interface {
 		parentID string) (*pmapi.Message, []*pmapi.Attachment, error)
 	SendMessage(messageID string, req *pmapi.SendMessageReq) error
 	GetMaxUpload() (int64, error)
+
+	QueueOutboxMessage(msg *store.OutboxMessage) error
+	ListOutboxMessages() ([]*store.OutboxMessage, error)
+	UpdateOutboxMessage(msg *store.OutboxMessage) error
+	RemoveOutboxMessage(id string) error
+	ImportInboxMessage(addressID string, literal []byte) error
+
+	GetAutocryptPeer(addr string) (*store.AutocryptPeer, error)
+	GetAutocryptPreferEncrypt() (bool, error)
+	GetKeyPin(addr string) (*store.KeyPin, error)
+	SetKeyPin(pin *store.KeyPin) error
+	GetAliasPolicy() (store.AliasPolicy, error)
+	RecordSentMessage(msg *store.SentMessage) error
+	GetSentMessageByHash(hash string) (*store.SentMessage, error)
 }
@@ -32,6 +32,7 @@ import (
 
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
 	"github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/ProtonMail/proton-bridge/internal/store"
 	"github.com/ProtonMail/proton-bridge/pkg/listener"
 	pkgMsg "github.com/ProtonMail/proton-bridge/pkg/message"
 	"github.com/ProtonMail/proton-bridge/pkg/message/parser"
@@ -50,8 +51,19 @@ type smtpUser struct {
 	username      string
 	addressID     string
 
+	// relayAddress is the only sender of the relay session, see RelayRule.
+	relayAddress string
+
 	returnPath string
 	to         []string
+	envelope   store.DSNEnvelope
+	dsn        map[string]store.DSNParams
+
+	// options of the last sent message, kept to be queued with it.
+	options sendOptions
+
+	// queued is set when the message is sent from the outbox.
+	queued *store.OutboxMessage
 }
 
 // newSMTPUser returns struct implementing go-smtp/session interface.
@@ -62,7 +74,7 @@ func newSMTPUser(
 	user bridgeUser,
 	username string,
 	addressID string,
-) (goSMTPBackend.Session, error) {
+) (*smtpUser, error) {
 	storeUser := user.GetStore()
 	if storeUser == nil {
 		return nil, errors.New("user database is not initialized")
@@ -88,6 +100,7 @@ func (su *smtpUser) client() pmapi.Client {
 func (su *smtpUser) getSendPreferences(
 	recipient, messageMIMEType string,
 	mailSettings pmapi.MailSettings,
+	options sendOptions,
 ) (preferences SendPreferences, err error) {
 	b := &sendPreferencesBuilder{}
 
@@ -103,11 +116,27 @@ func (su *smtpUser) getSendPreferences(
 		return
 	}
 
+	// 2 + keys of external recipient without any other key discovered
+	// outside of the API. They are used the same way as WKD keys from API.
+	if !isInternal && len(apiKeys) == 0 && (vCardData == nil || len(vCardData.Keys) == 0) {
+		apiKeys = su.discoverKeys(recipient)
+	}
+
 	// 1 + 2 -> 3. advanced PGP settings
 	if err = b.setPGPSettings(vCardData, apiKeys, isInternal); err != nil {
 		return
 	}
 
+	// 3 + Autocrypt key of external recipient without any other key.
+	if !isInternal && len(apiKeys) == 0 && (vCardData == nil || len(vCardData.Keys) == 0) {
+		if kr := su.getAutocryptKey(recipient); kr != nil {
+			b.setAutocryptPGPSettings(kr)
+		}
+	}
+
+	// 3 + per-message overrides from custom headers of the message.
+	b.setMessageOverrides(options)
+
 	// 4. mail settings
 	// Passed in from su.client().GetMailSettings()
 
@@ -119,7 +148,13 @@ func (su *smtpUser) getSendPreferences(
 	// 5 + 6 -> 7. send preferences
 	b.setMIMEPreferences(messageMIMEType)
 
-	return b.build(), nil
+	preferences = b.build()
+
+	if err = options.checkPreferences(recipient, preferences); err != nil {
+		return
+	}
+
+	return preferences, nil
 }
 
 func (su *smtpUser) getContactVCardData(recipient string) (meta *ContactMetadata, err error) {
@@ -154,47 +189,129 @@ func (su *smtpUser) getAPIKeyData(recipient string) (apiKeys []pmapi.PublicKey,
 	return su.client().GetPublicKeysForEmail(context.TODO(), recipient)
 }
 
+// discoverKeys returns keys of the recipient found by the key discovery
+// enabled in settings.
+func (su *smtpUser) discoverKeys(recipient string) (apiKeys []pmapi.PublicKey) {
+	discoverer := newKeyDiscoverer(su.backend.settings, su.backend.keysClient, su.storeUser)
+	if discoverer == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), keyDiscoveryTimeout)
+	defer cancel()
+
+	keys, err := discoverer.DiscoverKeys(ctx, recipient)
+	if err != nil {
+		log.WithError(err).WithField("recipient", recipient).Warn("Cannot discover keys")
+		return nil
+	}
+
+	for _, key := range keys {
+		armored, err := key.GetArmoredPublicKey()
+		if err != nil {
+			log.WithError(err).Warn("Cannot armor discovered key")
+			continue
+		}
+		apiKeys = append(apiKeys, pmapi.PublicKey{
+			Flags:     pmapi.UseToVerifyFlag | pmapi.UseToEncryptFlag,
+			PublicKey: armored,
+		})
+	}
+
+	return apiKeys
+}
+
+// getAutocryptKey returns the key learned from Autocrypt header of the
+// recipient or nil when there is no usable one. As recommended by Autocrypt,
+// messages are encrypted automatically only when both the user and the
+// recipient prefer encryption.
+func (su *smtpUser) getAutocryptKey(recipient string) *crypto.KeyRing {
+	if !getAutocryptPreferEncrypt(su.storeUser) {
+		return nil
+	}
+
+	peer, err := su.storeUser.GetAutocryptPeer(recipient)
+	if err != nil {
+		log.WithError(err).Warn("Cannot get autocrypt peer")
+		return nil
+	}
+	if peer == nil || peer.IsStale() || !peer.PreferEncrypt {
+		return nil
+	}
+
+	key, err := crypto.NewKey(peer.PublicKey)
+	if err != nil || !key.CanEncrypt() || key.IsExpired() {
+		log.WithError(err).WithField("recipient", recipient).Warn("Autocrypt key cannot be used")
+		return nil
+	}
+
+	kr, err := crypto.NewKeyRing(key)
+	if err != nil {
+		return nil
+	}
+
+	return kr
+}
+
 // Discard currently processed message.
 func (su *smtpUser) Reset() {
 	log.Trace("Resetting the session")
 	su.returnPath = ""
 	su.to = []string{}
+	su.envelope = store.DSNEnvelope{}
+	su.dsn = nil
 }
 
 // Set return path for currently processed message.
-func (su *smtpUser) Mail(returnPath string, opts goSMTPBackend.MailOptions) error {
+func (su *smtpUser) Mail(returnPath string, opts *goSMTPBackend.MailOptions) error {
 	log.WithField("returnPath", returnPath).WithField("opts", opts).Trace("Setting mail from")
 
-	// REQUIRETLS and SMTPUTF8 have to be announced to be used by client.
-	// Bridge does not use those extensions so this should not happen.
+	// REQUIRETLS has to be announced to be used by client.
+	// Bridge does not use this extension so this should not happen.
 	if opts.RequireTLS {
 		return errors.New("REQUIRETLS extension is not supported")
 	}
-	if opts.UTF8 {
-		return errors.New("SMTPUTF8 extension is not supported")
+
+	if maxSize := su.getMaxMessageSize(); maxSize > 0 && opts.Size > maxSize {
+		return errMessageTooLarge
 	}
 
 	if opts.Auth != nil && *opts.Auth != "" && *opts.Auth != su.username {
 		return errors.New("changing identity is not supported")
 	}
 
+	// Relayed messages are always sent from the address of the relay rule.
+	if su.relayAddress != "" {
+		returnPath = su.relayAddress
+	}
+
 	if returnPath != "" {
-		addr := su.client().Addresses().ByEmail(returnPath)
+		addr, _ := resolveSender(su.client().Addresses(), returnPath, getAliasPolicy(su.storeUser))
 		if addr == nil {
 			return errors.New("backend: invalid return path: not owned by user")
 		}
 	}
 
 	su.returnPath = returnPath
+	su.envelope = newDSNEnvelope(opts)
 	return nil
 }
 
 // Add recipient for currently processed message.
-func (su *smtpUser) Rcpt(to string) error {
+func (su *smtpUser) Rcpt(to string, opts *goSMTPBackend.RcptOptions) error {
 	log.WithField("to", to).Trace("Adding recipient")
+
 	if to != "" {
 		su.to = append(su.to, to)
 	}
+
+	if params := newDSNParams(opts); len(params.Notify) != 0 || params.OriginalRecipient != "" {
+		if su.dsn == nil {
+			su.dsn = map[string]store.DSNParams{}
+		}
+		su.dsn[to] = params
+	}
+
 	return nil
 }
 
@@ -207,24 +324,66 @@ func (su *smtpUser) Data(r io.Reader) error {
 	if len(su.to) == 0 {
 		return errors.New("missing recipient")
 	}
-	return su.Send(su.returnPath, su.to, r)
+
+	// The message read by the parser is kept to be able to queue it when the
+	// API is not reachable, so the client does not have to retry sending.
+	limited := &sizeLimitedReader{r: r, limit: su.getMaxMessageSize()}
+	var source io.Reader = limited
+
+	// The sender is rewritten before the message is kept, so the queued
+	// message is sent from the relay address as well.
+	if su.relayAddress != "" {
+		rewritten, err := rewriteRelaySender(limited, su.relayAddress)
+		if err != nil {
+			if limited.exceeded {
+				return errMessageTooLarge
+			}
+			return err
+		}
+		source = rewritten
+	}
+
+	literal := new(bytes.Buffer)
+
+	if err := su.Send(su.returnPath, su.to, source, literal); err != nil {
+		if limited.exceeded {
+			return errMessageTooLarge
+		}
+		if isOfflineError(err) {
+			if _, err := io.Copy(literal, source); err != nil {
+				return err
+			}
+			return su.queueMessage(literal.Bytes(), err)
+		}
+		return err
+	}
+	return nil
+}
+
+// getMaxMessageSize returns the largest message the user can send or zero
+// when the limit is not known, for example when the API is not reachable.
+// The limit is only a guard against clearly oversized messages; the exact
+// size of the decoded content is checked by the store when creating draft.
+func (su *smtpUser) getMaxMessageSize() int64 {
+	maxUpload, err := su.storeUser.GetMaxUpload()
+	if err != nil {
+		log.WithError(err).Warn("Cannot get max upload size")
+		return 0
+	}
+	return getEncodedSizeLimit(maxUpload)
 }
 
 // Send sends an email from the given address to the given addresses with the given body.
-func (su *smtpUser) Send(returnPath string, to []string, messageReader io.Reader) (err error) { //nolint[funlen]
+// The part of the body read so far is written to literal, also when sending fails.
+func (su *smtpUser) Send(returnPath string, to []string, messageReader io.Reader, literal *bytes.Buffer) (err error) { //nolint[funlen]
 	// Called from go-smtp in goroutines - we need to handle panics for each function.
 	defer su.panicHandler.HandlePanic()
 
-	b := new(bytes.Buffer)
+	messageReader = io.TeeReader(messageReader, literal)
 
-	messageReader = io.TeeReader(messageReader, b)
+	aliasPolicy := getAliasPolicy(su.storeUser)
 
-	mailSettings, err := su.client().GetMailSettings(context.TODO())
-	if err != nil {
-		return err
-	}
-
-	returnPathAddr := su.client().Addresses().ByEmail(returnPath)
+	returnPathAddr, returnPathSender := resolveSender(su.client().Addresses(), returnPath, aliasPolicy)
 	if returnPathAddr == nil {
 		err = errors.New("backend: invalid return path: not owned by user")
 		return
@@ -235,6 +394,19 @@ func (su *smtpUser) Send(returnPath string, to []string, messageReader io.Reader
 		err = errors.Wrap(err, "failed to create new parser")
 		return
 	}
+
+	// Options are parsed before any API call to refuse invalid ones
+	// right away even when the message would be queued in the outbox.
+	sendOptions, err := su.takeSendOptions(&parser.Root().Header)
+	if err != nil {
+		return err
+	}
+
+	mailSettings, err := su.client().GetMailSettings(context.TODO())
+	if err != nil {
+		return err
+	}
+
 	message, plainBody, attReaders, err := pkgMsg.ParserWithParser(parser)
 	if err != nil {
 		log.WithError(err).Error("Failed to parse message")
@@ -247,23 +419,27 @@ func (su *smtpUser) Send(returnPath string, to []string, messageReader io.Reader
 
 	draftID, parentID := su.handleReferencesHeader(message)
 
-	if err = su.handleSenderAndRecipients(message, returnPathAddr, returnPath, to); err != nil {
+	if err = su.handleSenderAndRecipients(message, returnPathSender, to); err != nil {
 		return err
 	}
 
-	addr := su.client().Addresses().ByEmail(message.Sender.Address)
+	addr, sender := resolveSender(su.client().Addresses(), message.Sender.Address, aliasPolicy)
 	if addr == nil {
 		err = errors.New("backend: invalid email address: not owned by user")
 		return
 	}
 
-	message.Sender.Address = pmapi.ConstructAddress(message.Sender.Address, addr.Email)
+	message.Sender.Address = sender
 
 	kr, err := su.client().KeyRingForAddressID(addr.ID)
 	if err != nil {
 		return
 	}
 
+	if err := setAutocryptHeader(parser, message, addr.Email, kr, getAutocryptPreferEncrypt(su.storeUser)); err != nil {
+		return err
+	}
+
 	var attachedPublicKey string
 	var attachedPublicKeyName string
 	if mailSettings.AttachPublicKey > 0 {
@@ -308,6 +484,7 @@ func (su *smtpUser) Send(returnPath string, to []string, messageReader io.Reader
 	// but it's better than sending the message many times. If the message was sent, we simply return
 	// nil to indicate it's OK.
 	sendRecorderMessageHash := su.backend.sendRecorder.getMessageHash(message)
+	su.restoreSentMessage(sendRecorderMessageHash)
 	isSending, wasSent := su.backend.sendRecorder.isSendingOrSent(su.client(), sendRecorderMessageHash)
 
 	startTime := time.Now()
@@ -360,20 +537,32 @@ func (su *smtpUser) Send(returnPath string, to []string, messageReader io.Reader
 	}
 
 	req := pmapi.NewSendMessageReq(kr, mimeBody, plainBody, richBody, attkeys)
+	sendOptions.apply(req)
 	containsUnencryptedRecipients := false
 
+	// Recipients refused by the API are reported back to the sender, unless
+	// there is no recipient left to send the message to. Other errors, such
+	// as preferences not satisfying the options of the message, fail the
+	// whole message.
+	var sent, failed []deliveryStatus
+
 	for _, email := range to {
 		if !looksLikeEmail(email) {
 			return errors.New(`"` + email + `" is not a valid recipient.`)
 		}
 
-		sendPreferences, err := su.getSendPreferences(email, message.MIMEType, mailSettings)
+		sendPreferences, err := su.getSendPreferences(email, message.MIMEType, mailSettings, sendOptions)
+		if err != nil {
+			if !isPermanentSendError(err) || len(failed) == len(to)-1 {
+				return err
+			}
+			log.WithError(err).WithField("recipient", email).Warn("Message cannot be sent to recipient")
+			failed = append(failed, deliveryStatus{recipient: email, reason: err.Error()})
+			continue
+		}
 		if !sendPreferences.Encrypt {
 			containsUnencryptedRecipients = true
 		}
-		if err != nil {
-			return err
-		}
 
 		var signature pmapi.SignatureFlag
 		if sendPreferences.Sign {
@@ -385,6 +574,8 @@ func (su *smtpUser) Send(returnPath string, to []string, messageReader io.Reader
 		if err := req.AddRecipient(email, sendPreferences.Scheme, sendPreferences.PublicKey, signature, sendPreferences.MIMEType, sendPreferences.Encrypt); err != nil {
 			return errors.Wrap(err, "failed to add recipient")
 		}
+
+		sent = append(sent, deliveryStatus{recipient: email})
 	}
 
 	if containsUnencryptedRecipients {
@@ -397,15 +588,118 @@ func (su *smtpUser) Send(returnPath string, to []string, messageReader io.Reader
 			if err := su.client().DeleteMessages(context.TODO(), []string{message.ID}); err != nil {
 				log.WithError(err).Warn("Failed to delete canceled messages")
 			}
-			return errors.New("sending was canceled by user")
+			return errSendingCanceled
 		}
 	}
 
 	req.PreparePackages()
 
-	dumpMessageData(b.Bytes(), message.Subject)
+	dumpMessageData(literal.Bytes(), message.Subject)
+
+	if err := su.storeUser.SendMessage(message.ID, req); err != nil {
+		// The message will be sent again from the outbox and the send
+		// recorder would block it as still being sent.
+		if isOfflineError(err) {
+			su.backend.sendRecorder.removeMessage(sendRecorderMessageHash)
+		}
+		return err
+	}
+
+	su.recordSentMessage(&store.SentMessage{
+		MessageID:  message.ID,
+		AddressID:  message.AddressID,
+		ExternalID: externalID,
+		Hash:       sendRecorderMessageHash,
+		Time:       time.Now(),
+	})
+
+	su.reportDelivery(returnPathAddr.ID, returnPath, literal.Bytes(), dsnActionFailed, failed)
+	su.reportDelivery(returnPathAddr.ID, returnPath, literal.Bytes(), dsnActionDelivered, sent)
+
+	return nil
+}
+
+// restoreSentMessage lets the send recorder know about the message sent
+// before restart.
+func (su *smtpUser) restoreSentMessage(hash string) {
+	if hash == "" {
+		return
+	}
+
+	sent, err := su.storeUser.GetSentMessageByHash(hash)
+	if err != nil {
+		log.WithError(err).Warn("Cannot get sent message record")
+		return
+	}
+
+	if sent != nil {
+		su.backend.sendRecorder.restoreMessage(hash, sent.MessageID, sent.Time)
+	}
+}
 
-	return su.storeUser.SendMessage(message.ID, req)
+// recordSentMessage remembers the sent message so that its resends and
+// copies appended by IMAP clients are recognised even after restart.
+// The message is already sent, so failure to record it is only logged.
+func (su *smtpUser) recordSentMessage(msg *store.SentMessage) {
+	if err := su.storeUser.RecordSentMessage(msg); err != nil {
+		log.WithError(err).WithField("messageID", msg.MessageID).Error("Cannot record sent message")
+	}
+}
+
+// reportDelivery imports the delivery status notification for the sender.
+// The message is already sent, so failure to report it is only logged.
+func (su *smtpUser) reportDelivery(addressID, returnPath string, literal []byte, action string, statuses []deliveryStatus) {
+	report := deliveryReport{
+		action:     action,
+		returnPath: returnPath,
+		envelope:   su.envelope,
+		literal:    literal,
+		statuses:   statuses,
+	}
+
+	if err := importDeliveryReport(su.storeUser, addressID, su.dsn, report); err != nil {
+		log.WithError(err).WithField("action", action).Error("Cannot import delivery report")
+	}
+}
+
+// getAutocryptPreferEncrypt returns whether the user prefers encryption or
+// false when it cannot be determined.
+func getAutocryptPreferEncrypt(storeUser storeUserProvider) bool {
+	preferEncrypt, err := storeUser.GetAutocryptPreferEncrypt()
+	if err != nil {
+		log.WithError(err).Warn("Cannot get autocrypt preference")
+		return false
+	}
+	return preferEncrypt
+}
+
+// setAutocryptHeader announces the key of the sender's address in Autocrypt
+// header, unless the client already did so.
+func setAutocryptHeader(p *parser.Parser, m *pmapi.Message, addr string, kr *crypto.KeyRing, preferEncrypt bool) error {
+	if p.Root().Header.Has(pkgMsg.AutocryptHeader) {
+		return nil
+	}
+
+	firstKey, err := kr.GetKey(0)
+	if err != nil {
+		return err
+	}
+
+	keyData, err := firstKey.GetPublicKey()
+	if err != nil {
+		return err
+	}
+
+	value := (&pkgMsg.Autocrypt{Addr: addr, PreferEncrypt: preferEncrypt, KeyData: keyData}).String()
+
+	p.Root().Header.Set(pkgMsg.AutocryptHeader, value)
+
+	if m.Header == nil {
+		m.Header = make(mail.Header)
+	}
+	m.Header[pkgMsg.AutocryptHeader] = []string{value}
+
+	return nil
 }
 
 func (su *smtpUser) handleReferencesHeader(m *pmapi.Message) (draftID, parentID string) {
@@ -454,9 +748,7 @@ func (su *smtpUser) handleReferencesHeader(m *pmapi.Message) (draftID, parentID
 	return draftID, parentID
 }
 
-func (su *smtpUser) handleSenderAndRecipients(m *pmapi.Message, returnPathAddr *pmapi.Address, returnPath string, to []string) (err error) {
-	returnPath = pmapi.ConstructAddress(returnPath, returnPathAddr.Email)
-
+func (su *smtpUser) handleSenderAndRecipients(m *pmapi.Message, returnPath string, to []string) (err error) {
 	// Check sender.
 	if m.Sender == nil {
 		m.Sender = &mail.Address{Address: returnPath}
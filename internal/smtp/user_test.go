@@ -0,0 +1,90 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	pkgMsg "github.com/ProtonMail/proton-bridge/pkg/message"
+	"github.com/ProtonMail/proton-bridge/pkg/message/parser"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetAutocryptHeader(t *testing.T) {
+	key, err := crypto.NewKeyFromArmored(testPublicKey)
+	require.NoError(t, err)
+
+	kr, err := crypto.NewKeyRing(key)
+	require.NoError(t, err)
+
+	p, err := parser.New(strings.NewReader("From: me@pm.me\r\nSubject: Hello\r\n\r\nWorld\r\n"))
+	require.NoError(t, err)
+
+	m := &pmapi.Message{}
+	require.NoError(t, setAutocryptHeader(p, m, "me@pm.me", kr, false))
+
+	value := p.Root().Header.Get(pkgMsg.AutocryptHeader)
+	assert.Equal(t, []string{value}, m.Header[pkgMsg.AutocryptHeader])
+
+	autocrypt, err := pkgMsg.ParseAutocrypt(value)
+	require.NoError(t, err)
+	assert.Equal(t, "me@pm.me", autocrypt.Addr)
+	assert.False(t, autocrypt.PreferEncrypt)
+
+	wantKeyData, err := key.GetPublicKey()
+	require.NoError(t, err)
+	assert.Equal(t, wantKeyData, autocrypt.KeyData)
+}
+
+func TestSetAutocryptHeaderPreferEncrypt(t *testing.T) {
+	key, err := crypto.NewKeyFromArmored(testPublicKey)
+	require.NoError(t, err)
+
+	kr, err := crypto.NewKeyRing(key)
+	require.NoError(t, err)
+
+	p, err := parser.New(strings.NewReader("From: me@pm.me\r\nSubject: Hello\r\n\r\nWorld\r\n"))
+	require.NoError(t, err)
+
+	require.NoError(t, setAutocryptHeader(p, &pmapi.Message{}, "me@pm.me", kr, true))
+
+	autocrypt, err := pkgMsg.ParseAutocrypt(p.Root().Header.Get(pkgMsg.AutocryptHeader))
+	require.NoError(t, err)
+	assert.True(t, autocrypt.PreferEncrypt)
+}
+
+func TestSetAutocryptHeaderKeepsClientHeader(t *testing.T) {
+	key, err := crypto.NewKeyFromArmored(testPublicKey)
+	require.NoError(t, err)
+
+	kr, err := crypto.NewKeyRing(key)
+	require.NoError(t, err)
+
+	p, err := parser.New(strings.NewReader("From: me@pm.me\r\nAutocrypt: addr=me@pm.me; keydata=a2V5\r\n\r\nWorld\r\n"))
+	require.NoError(t, err)
+
+	m := &pmapi.Message{}
+	require.NoError(t, setAutocryptHeader(p, m, "me@pm.me", kr, true))
+
+	assert.Equal(t, "addr=me@pm.me; keydata=a2V5", p.Root().Header.Get(pkgMsg.AutocryptHeader))
+	assert.Empty(t, m.Header)
+}
@@ -18,12 +18,22 @@
 package smtp
 
 import (
+	"io"
 	"regexp"
+
+	goSMTPBackend "github.com/emersion/go-smtp"
 )
 
 //nolint:gochecknoglobals // Used like a constant
 var mailFormat = regexp.MustCompile(`.+@.+\..+`)
 
+//nolint:gochecknoglobals // Used like a constant
+var errMessageTooLarge = &goSMTPBackend.SMTPError{
+	Code:         552,
+	EnhancedCode: goSMTPBackend.EnhancedCode{5, 3, 4},
+	Message:      "Message size exceeds the limit of the account",
+}
+
 // looksLikeEmail validates whether the string resembles an email.
 //
 // Notice that it does this naively by simply checking for the existence
@@ -31,3 +41,37 @@ var mailFormat = regexp.MustCompile(`.+@.+\..+`)
 func looksLikeEmail(e string) bool {
 	return mailFormat.MatchString(e)
 }
+
+// maxHeadersSize is the room left for headers and MIME structure of the
+// message on top of the encoded content.
+const maxHeadersSize = 1024 * 1024
+
+// getEncodedSizeLimit returns the size of the message which can carry size
+// bytes of content. The content is expected to be encoded by base64, which
+// encodes every 57 bytes into 76 characters followed by CRLF. Zero size
+// means no limit.
+func getEncodedSizeLimit(size int64) int64 {
+	if size <= 0 {
+		return 0
+	}
+	return (size+56)/57*78 + maxHeadersSize
+}
+
+// sizeLimitedReader fails with errMessageTooLarge once more than limit bytes
+// are read. Zero limit means no limit.
+type sizeLimitedReader struct {
+	r        io.Reader
+	limit    int64
+	read     int64
+	exceeded bool
+}
+
+func (lr *sizeLimitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.read += int64(n)
+	if lr.limit > 0 && lr.read > lr.limit {
+		lr.exceeded = true
+		return n, errMessageTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSizeLimitedReader(t *testing.T) {
+	lr := &sizeLimitedReader{r: strings.NewReader("Hello"), limit: 5}
+	b, err := ioutil.ReadAll(lr)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", string(b))
+	assert.False(t, lr.exceeded)
+
+	lr = &sizeLimitedReader{r: strings.NewReader("Hello!"), limit: 5}
+	_, err = ioutil.ReadAll(lr)
+	assert.Equal(t, errMessageTooLarge, err)
+	assert.True(t, lr.exceeded)
+
+	lr = &sizeLimitedReader{r: strings.NewReader("Hello!")}
+	b, err = ioutil.ReadAll(lr)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello!", string(b))
+}
+
+func TestGetEncodedSizeLimit(t *testing.T) {
+	assert.Equal(t, int64(0), getEncodedSizeLimit(0))
+
+	content := bytes.Repeat([]byte{0xff}, 1000*57+1)
+	encoded := base64.StdEncoding.EncodeToString(content)
+
+	body := new(bytes.Buffer)
+	for len(encoded) > 76 {
+		body.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	body.WriteString(encoded + "\r\n")
+
+	limit := getEncodedSizeLimit(int64(len(content)))
+	assert.GreaterOrEqual(t, limit, int64(body.Len()))
+	assert.Less(t, limit, int64(body.Len())+maxHeadersSize+78)
+}
@@ -79,6 +79,19 @@ func (storeAddress *Address) init(foldersAndLabels []*pmapi.Label) (err error) {
 
 			storeAddress.mailboxes[label.ID] = mailbox
 		}
+
+		for _, search := range storeAddress.store.savedSearches {
+			mailbox, err := storeAddress.txNewSavedSearchMailbox(tx, search)
+			if err != nil {
+				storeAddress.log.
+					WithError(err).
+					WithField("name", search.Name).
+					Error("Could not init mailbox for saved search")
+				return err
+			}
+
+			storeAddress.mailboxes[mailbox.labelID] = mailbox
+		}
 		return nil
 	})
 
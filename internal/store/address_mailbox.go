@@ -21,6 +21,7 @@ import (
 	"fmt"
 
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	bolt "go.etcd.io/bbolt"
 )
 
 // ListMailboxes returns all mailboxes.
@@ -86,6 +87,17 @@ func (storeAddress *Address) createOrUpdateMailboxEvent(label *pmapi.Label) erro
 	return nil
 }
 
+// txNewSavedSearchMailbox creates the virtual mailbox of the saved search.
+// It is not added to the structure, that is up to the caller.
+func (storeAddress *Address) txNewSavedSearchMailbox(tx *bolt.Tx, search *SavedSearch) (*Mailbox, error) {
+	mailbox, err := txNewMailbox(tx, storeAddress, search.labelID(), SavedSearchesPrefix, search.Name, "")
+	if err != nil {
+		return nil, err
+	}
+	mailbox.search = search
+	return mailbox, nil
+}
+
 // deleteMailboxEvent deletes the mailbox in the structure.
 // This is called from the event loop.
 func (storeAddress *Address) deleteMailboxEvent(labelID string) error {
@@ -0,0 +1,72 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// AliasPolicy determines which sender identities other than the addresses
+// of the account itself can be used to send messages over SMTP.
+type AliasPolicy string
+
+const (
+	// AliasPolicyExact allows only the addresses of the account.
+	AliasPolicyExact AliasPolicy = "exact"
+
+	// AliasPolicyPlus allows also plus-addressed identities of the addresses,
+	// e.g. me+project@pm.me for me@pm.me.
+	AliasPolicyPlus AliasPolicy = "plus"
+
+	// AliasPolicyCatchAll allows also any identity on a custom domain which
+	// has a catch-all address of the account.
+	AliasPolicyCatchAll AliasPolicy = "catch-all"
+
+	// DefaultAliasPolicy is used until the user changes it.
+	DefaultAliasPolicy = AliasPolicyPlus
+
+	aliasPolicyKey = "alias_policy"
+)
+
+// GetAliasPolicy returns the alias policy set by the user.
+func (store *Store) GetAliasPolicy() (policy AliasPolicy, err error) {
+	policy = DefaultAliasPolicy
+
+	err = store.db.View(func(tx *bolt.Tx) error {
+		if raw := tx.Bucket(userSettingsBucket).Get([]byte(aliasPolicyKey)); raw != nil {
+			policy = AliasPolicy(raw)
+		}
+		return nil
+	})
+
+	return
+}
+
+// SetAliasPolicy sets the alias policy used for messages sent over SMTP.
+func (store *Store) SetAliasPolicy(policy AliasPolicy) error {
+	switch policy {
+	case AliasPolicyExact, AliasPolicyPlus, AliasPolicyCatchAll:
+	default:
+		return errors.Errorf("unknown alias policy %q", policy)
+	}
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(userSettingsBucket).Put([]byte(aliasPolicyKey), []byte(policy))
+	})
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAliasPolicy(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	policy, err := m.store.GetAliasPolicy()
+	require.NoError(t, err)
+	assert.Equal(t, DefaultAliasPolicy, policy)
+
+	require.NoError(t, m.store.SetAliasPolicy(AliasPolicyCatchAll))
+
+	policy, err = m.store.GetAliasPolicy()
+	require.NoError(t, err)
+	assert.Equal(t, AliasPolicyCatchAll, policy)
+
+	require.Error(t, m.store.SetAliasPolicy("everything"))
+
+	policy, err = m.store.GetAliasPolicy()
+	require.NoError(t, err)
+	assert.Equal(t, AliasPolicyCatchAll, policy)
+}
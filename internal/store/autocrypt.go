@@ -0,0 +1,165 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	pkgMsg "github.com/ProtonMail/proton-bridge/pkg/message"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	bolt "go.etcd.io/bbolt"
+)
+
+// autocryptStaleAfter is how long the peer can send messages without
+// Autocrypt header before its key is no longer recommended for encryption.
+const autocryptStaleAfter = 35 * 24 * time.Hour
+
+const autocryptPreferEncryptKey = "autocrypt_prefer_encrypt"
+
+// AutocryptPeer is the state of a peer learned from Autocrypt headers of
+// received messages as defined by Autocrypt Level 1.
+type AutocryptPeer struct {
+	Addr               string
+	LastSeen           time.Time
+	AutocryptTimestamp time.Time
+	PublicKey          []byte
+	PreferEncrypt      bool
+}
+
+// IsStale returns whether the peer has been sending messages without
+// Autocrypt header for too long, which means it might not be able to
+// decrypt messages anymore.
+func (peer *AutocryptPeer) IsStale() bool {
+	return peer.LastSeen.Sub(peer.AutocryptTimestamp) > autocryptStaleAfter
+}
+
+// GetAutocryptPeer returns the state of the peer with the given address or
+// nil when no Autocrypt header was received from the address.
+func (store *Store) GetAutocryptPeer(addr string) (peer *AutocryptPeer, err error) {
+	err = store.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(autocryptBucket).Get([]byte(strings.ToLower(addr)))
+		if raw == nil {
+			return nil
+		}
+		peer = &AutocryptPeer{}
+		return json.Unmarshal(raw, peer)
+	})
+	return
+}
+
+// GetAutocryptPreferEncrypt returns whether the user prefers encryption,
+// which is announced by prefer-encrypt=mutual in Autocrypt headers of sent
+// messages. Messages are encrypted to Autocrypt keys only when both the user
+// and the peer prefer encryption.
+func (store *Store) GetAutocryptPreferEncrypt() (preferEncrypt bool, err error) {
+	err = store.db.View(func(tx *bolt.Tx) error {
+		preferEncrypt = string(tx.Bucket(userSettingsBucket).Get([]byte(autocryptPreferEncryptKey))) == "mutual"
+		return nil
+	})
+	return
+}
+
+// SetAutocryptPreferEncrypt sets whether the user prefers encryption.
+func (store *Store) SetAutocryptPreferEncrypt(preferEncrypt bool) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		if !preferEncrypt {
+			return tx.Bucket(userSettingsBucket).Delete([]byte(autocryptPreferEncryptKey))
+		}
+		return tx.Bucket(userSettingsBucket).Put([]byte(autocryptPreferEncryptKey), []byte("mutual"))
+	})
+}
+
+// updateAutocryptPeer updates the state of the sender of the received
+// message. Only messages newer than the last Autocrypt header change the
+// state; messages without the header only mark the peer as seen.
+func (store *Store) updateAutocryptPeer(msg *pmapi.Message) error {
+	if msg.Flags&pmapi.FlagReceived == 0 || msg.Sender == nil || msg.Sender.Address == "" {
+		return nil
+	}
+
+	// Anyone can send spam on behalf of the peer, so its keys are not learned.
+	if msg.HasLabelID(pmapi.SpamLabel) {
+		return nil
+	}
+
+	// Delivery reports and similar are not sent by the peer's client.
+	if strings.HasPrefix(strings.ToLower(msg.Header.Get("Content-Type")), "multipart/report") {
+		return nil
+	}
+
+	addr := strings.ToLower(msg.Sender.Address)
+
+	date := time.Unix(msg.Time, 0)
+	if now := time.Now(); date.After(now) {
+		date = now
+	}
+
+	autocrypt := getAutocryptHeader(msg, addr)
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(autocryptBucket)
+
+		peer := &AutocryptPeer{Addr: addr}
+		if raw := b.Get([]byte(addr)); raw != nil {
+			if err := json.Unmarshal(raw, peer); err != nil {
+				return err
+			}
+		} else if autocrypt == nil {
+			return nil
+		}
+
+		if date.Before(peer.AutocryptTimestamp) {
+			return nil
+		}
+
+		if date.After(peer.LastSeen) {
+			peer.LastSeen = date
+		}
+
+		if autocrypt != nil {
+			peer.AutocryptTimestamp = date
+			peer.PublicKey = autocrypt.KeyData
+			peer.PreferEncrypt = autocrypt.PreferEncrypt
+		}
+
+		raw, err := json.Marshal(peer)
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(addr), raw)
+	})
+}
+
+// getAutocryptHeader returns the only valid Autocrypt header of the message
+// for the sender's address. When there are more of them, none is used.
+func getAutocryptHeader(msg *pmapi.Message, addr string) (autocrypt *pkgMsg.Autocrypt) {
+	for _, value := range msg.Header[pkgMsg.AutocryptHeader] {
+		parsed, err := pkgMsg.ParseAutocrypt(value)
+		if err != nil || !strings.EqualFold(parsed.Addr, addr) {
+			continue
+		}
+		if autocrypt != nil {
+			return nil
+		}
+		autocrypt = parsed
+	}
+	return autocrypt
+}
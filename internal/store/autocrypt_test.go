@@ -0,0 +1,138 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"net/mail"
+	"testing"
+	"time"
+
+	pkgMsg "github.com/ProtonMail/proton-bridge/pkg/message"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newAutocryptMessage(from string, date time.Time, headers ...string) *pmapi.Message {
+	return &pmapi.Message{
+		Sender: &mail.Address{Address: from},
+		Flags:  pmapi.FlagReceived,
+		Time:   date.Unix(),
+		Header: mail.Header{pkgMsg.AutocryptHeader: headers},
+	}
+}
+
+func autocryptHeader(addr, keyData string) string {
+	return (&pkgMsg.Autocrypt{Addr: addr, KeyData: []byte(keyData)}).String()
+}
+
+func TestAutocryptPeer(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	day := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	// Unknown peer without header is not stored.
+	require.NoError(t, m.store.updateAutocryptPeer(newAutocryptMessage("alice@example.com", day)))
+	peer, err := m.store.GetAutocryptPeer("alice@example.com")
+	require.NoError(t, err)
+	assert.Nil(t, peer)
+
+	require.NoError(t, m.store.updateAutocryptPeer(newAutocryptMessage("Alice@example.com", day, autocryptHeader("alice@example.com", "key1"))))
+	peer, err = m.store.GetAutocryptPeer("ALICE@example.com")
+	require.NoError(t, err)
+	require.NotNil(t, peer)
+	assert.Equal(t, []byte("key1"), peer.PublicKey)
+	assert.False(t, peer.IsStale())
+
+	// Older message does not change the key.
+	require.NoError(t, m.store.updateAutocryptPeer(newAutocryptMessage("alice@example.com", day.Add(-time.Hour), autocryptHeader("alice@example.com", "key0"))))
+	peer, err = m.store.GetAutocryptPeer("alice@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("key1"), peer.PublicKey)
+
+	// Newer message without header makes the key stale eventually.
+	require.NoError(t, m.store.updateAutocryptPeer(newAutocryptMessage("alice@example.com", day.Add(40*24*time.Hour))))
+	peer, err = m.store.GetAutocryptPeer("alice@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("key1"), peer.PublicKey)
+	assert.True(t, peer.IsStale())
+
+	// Newer header replaces the key.
+	require.NoError(t, m.store.updateAutocryptPeer(newAutocryptMessage("alice@example.com", day.Add(41*24*time.Hour), autocryptHeader("alice@example.com", "key2"))))
+	peer, err = m.store.GetAutocryptPeer("alice@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("key2"), peer.PublicKey)
+	assert.False(t, peer.IsStale())
+}
+
+func TestAutocryptPeerIgnoredHeaders(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	day := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	sent := newAutocryptMessage("bob@example.com", day, autocryptHeader("bob@example.com", "key"))
+	sent.Flags = pmapi.FlagSent
+
+	spam := newAutocryptMessage("bob@example.com", day, autocryptHeader("bob@example.com", "key"))
+	spam.LabelIDs = []string{pmapi.SpamLabel}
+
+	report := newAutocryptMessage("bob@example.com", day, autocryptHeader("bob@example.com", "key"))
+	report.Header["Content-Type"] = []string{"multipart/report; report-type=delivery-status"}
+
+	for _, msg := range []*pmapi.Message{
+		sent,
+		spam,
+		report,
+		newAutocryptMessage("bob@example.com", day, autocryptHeader("mallory@example.com", "key")),
+		newAutocryptMessage("bob@example.com", day, autocryptHeader("bob@example.com", "key1"), autocryptHeader("bob@example.com", "key2")),
+		newAutocryptMessage("bob@example.com", day, "addr=bob@example.com; critical=yes; keydata=a2V5"),
+	} {
+		require.NoError(t, m.store.updateAutocryptPeer(msg))
+	}
+
+	peer, err := m.store.GetAutocryptPeer("bob@example.com")
+	require.NoError(t, err)
+	assert.Nil(t, peer)
+}
+
+func TestAutocryptPreferEncrypt(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	preferEncrypt, err := m.store.GetAutocryptPreferEncrypt()
+	require.NoError(t, err)
+	assert.False(t, preferEncrypt)
+
+	require.NoError(t, m.store.SetAutocryptPreferEncrypt(true))
+	preferEncrypt, err = m.store.GetAutocryptPreferEncrypt()
+	require.NoError(t, err)
+	assert.True(t, preferEncrypt)
+
+	require.NoError(t, m.store.SetAutocryptPreferEncrypt(false))
+	preferEncrypt, err = m.store.GetAutocryptPreferEncrypt()
+	require.NoError(t, err)
+	assert.False(t, preferEncrypt)
+}
@@ -25,9 +25,9 @@ type ChangeNotifier interface {
 	Notice(address, notice string)
 	UpdateMessage(
 		address, mailboxName string,
-		uid, sequenceNumber uint32,
+		uid, sequenceNumber uint32, modSeq uint64,
 		msg *pmapi.Message, hasDeletedFlag bool)
-	DeleteMessage(address, mailboxName string, sequenceNumber uint32)
+	DeleteMessage(address, mailboxName string, uid, sequenceNumber uint32)
 	MailboxCreated(address, mailboxName string)
 	MailboxStatus(address, mailboxName string, total, unread, unreadSeqNum uint32)
 
@@ -46,18 +46,18 @@ func (store *Store) notifyNotice(address, notice string) {
 	store.notifier.Notice(address, notice)
 }
 
-func (store *Store) notifyUpdateMessage(address, mailboxName string, uid, sequenceNumber uint32, msg *pmapi.Message, hasDeletedFlag bool) {
+func (store *Store) notifyUpdateMessage(address, mailboxName string, uid, sequenceNumber uint32, modSeq uint64, msg *pmapi.Message, hasDeletedFlag bool) {
 	if store.notifier == nil {
 		return
 	}
-	store.notifier.UpdateMessage(address, mailboxName, uid, sequenceNumber, msg, hasDeletedFlag)
+	store.notifier.UpdateMessage(address, mailboxName, uid, sequenceNumber, modSeq, msg, hasDeletedFlag)
 }
 
-func (store *Store) notifyDeleteMessage(address, mailboxName string, sequenceNumber uint32) {
+func (store *Store) notifyDeleteMessage(address, mailboxName string, uid, sequenceNumber uint32) {
 	if store.notifier == nil {
 		return
 	}
-	store.notifier.DeleteMessage(address, mailboxName, sequenceNumber)
+	store.notifier.DeleteMessage(address, mailboxName, uid, sequenceNumber)
 }
 
 func (store *Store) notifyMailboxCreated(address, mailboxName string) {
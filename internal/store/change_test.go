@@ -31,8 +31,8 @@ func TestNotifyChangeCreateOrUpdateMessage(t *testing.T) {
 
 	m.changeNotifier.EXPECT().MailboxStatus(addr1, "All Mail", uint32(1), uint32(0), uint32(0))
 	m.changeNotifier.EXPECT().MailboxStatus(addr1, "All Mail", uint32(2), uint32(0), uint32(0))
-	m.changeNotifier.EXPECT().UpdateMessage(addr1, "All Mail", uint32(1), uint32(1), gomock.Any(), false)
-	m.changeNotifier.EXPECT().UpdateMessage(addr1, "All Mail", uint32(2), uint32(2), gomock.Any(), false)
+	m.changeNotifier.EXPECT().UpdateMessage(addr1, "All Mail", uint32(1), uint32(1), gomock.Any(), gomock.Any(), false)
+	m.changeNotifier.EXPECT().UpdateMessage(addr1, "All Mail", uint32(2), uint32(2), gomock.Any(), gomock.Any(), false)
 
 	m.newStoreNoEvents(true)
 	m.store.SetChangeNotifier(m.changeNotifier)
@@ -46,8 +46,8 @@ func TestNotifyChangeCreateOrUpdateMessages(t *testing.T) {
 	defer clear()
 
 	m.changeNotifier.EXPECT().MailboxStatus(addr1, "All Mail", uint32(2), uint32(0), uint32(0))
-	m.changeNotifier.EXPECT().UpdateMessage(addr1, "All Mail", uint32(1), uint32(1), gomock.Any(), false)
-	m.changeNotifier.EXPECT().UpdateMessage(addr1, "All Mail", uint32(2), uint32(2), gomock.Any(), false)
+	m.changeNotifier.EXPECT().UpdateMessage(addr1, "All Mail", uint32(1), uint32(1), gomock.Any(), gomock.Any(), false)
+	m.changeNotifier.EXPECT().UpdateMessage(addr1, "All Mail", uint32(2), uint32(2), gomock.Any(), gomock.Any(), false)
 
 	m.newStoreNoEvents(true)
 	m.store.SetChangeNotifier(m.changeNotifier)
@@ -66,8 +66,8 @@ func TestNotifyChangeDeleteMessage(t *testing.T) {
 	insertMessage(t, m, "msg1", "Test message 1", addrID1, false, []string{pmapi.AllMailLabel})
 	insertMessage(t, m, "msg2", "Test message 2", addrID1, false, []string{pmapi.AllMailLabel})
 
-	m.changeNotifier.EXPECT().DeleteMessage(addr1, "All Mail", uint32(2))
-	m.changeNotifier.EXPECT().DeleteMessage(addr1, "All Mail", uint32(1))
+	m.changeNotifier.EXPECT().DeleteMessage(addr1, "All Mail", uint32(2), uint32(2))
+	m.changeNotifier.EXPECT().DeleteMessage(addr1, "All Mail", uint32(1), uint32(1))
 
 	m.store.SetChangeNotifier(m.changeNotifier)
 	require.Nil(t, m.store.deleteMessageEvent("msg2"))
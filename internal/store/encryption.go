@@ -0,0 +1,97 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/pkg/errors"
+)
+
+// localKeyLength is the length of random keys used to encrypt local data.
+const localKeyLength = 32
+
+// generateLocalKey returns a new random key together with its copy encrypted
+// by the keyring which is safe to be stored on disk.
+func generateLocalKey(kr *crypto.KeyRing) (key, encKey []byte, err error) {
+	key = make([]byte, localKeyLength)
+	if _, err = io.ReadFull(rand.Reader, key); err != nil {
+		return nil, nil, err
+	}
+
+	msg, err := kr.Encrypt(crypto.NewPlainMessage(key), nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to encrypt local key")
+	}
+
+	return key, msg.GetBinary(), nil
+}
+
+// decryptLocalKey decrypts the key generated by generateLocalKey.
+func decryptLocalKey(kr *crypto.KeyRing, encKey []byte) ([]byte, error) {
+	msg, err := kr.Decrypt(crypto.NewPGPMessage(encKey), nil, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt local key")
+	}
+
+	if len(msg.GetBinary()) != localKeyLength {
+		return nil, errors.New("wrong local key length")
+	}
+
+	return msg.GetBinary(), nil
+}
+
+// deriveLocalKey derives a key for the given purpose so the same local key
+// is never used for two different things.
+func deriveLocalKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func newLocalAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealLocalData encrypts the data and prepends the random nonce.
+func sealLocalData(aead cipher.AEAD, plain []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+// openLocalData decrypts the data encrypted by sealLocalData.
+func openLocalData(aead cipher.AEAD, enc []byte) ([]byte, error) {
+	nonceSize := aead.NonceSize()
+	if len(enc) < nonceSize {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, enc[:nonceSize], enc[nonceSize:], nil)
+}
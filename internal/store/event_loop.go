@@ -428,6 +428,8 @@ func (loop *eventLoop) processLabels(eventLog *logrus.Entry, labels []*pmapi.Eve
 func (loop *eventLoop) processMessages(eventLog *logrus.Entry, messages []*pmapi.EventMessage) (err error) { // nolint[funlen]
 	eventLog.Debug("Processing message change event")
 
+	var filterable []*pmapi.Message
+
 	for _, message := range messages {
 		msgLog := eventLog.WithField("msgID", message.ID)
 
@@ -444,6 +446,14 @@ func (loop *eventLoop) processMessages(eventLog *logrus.Entry, messages []*pmapi
 				return errors.Wrap(err, "failed to put message into DB")
 			}
 
+			if err := loop.store.updateAutocryptPeer(message.Created); err != nil {
+				msgLog.WithError(err).Warn("Failed to update autocrypt peer")
+			}
+
+			if isFilterable(message.Created) {
+				filterable = append(filterable, message.Created)
+			}
+
 		case pmapi.EventUpdate, pmapi.EventUpdateFlags:
 			msgLog.Debug("Processing EventUpdate(Flags) for message")
 
@@ -479,6 +489,12 @@ func (loop *eventLoop) processMessages(eventLog *logrus.Entry, messages []*pmapi
 				return errors.Wrap(err, "failed to update message in DB")
 			}
 
+			// Content of the message (draft) could be changed as well.
+			if message.Action == pmapi.EventUpdate {
+				loop.store.reindexMessage(message.ID)
+				loop.store.uncacheMessages([]string{message.ID})
+			}
+
 		case pmapi.EventDelete:
 			msgLog.Debug("Processing EventDelete for message")
 
@@ -489,6 +505,10 @@ func (loop *eventLoop) processMessages(eventLog *logrus.Entry, messages []*pmapi
 		}
 	}
 
+	if len(filterable) != 0 && loop.store.getMessageFilter() != nil {
+		go loop.store.filterMessages(filterable)
+	}
+
 	return err
 }
 
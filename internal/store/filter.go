@@ -0,0 +1,139 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/pkg/errors"
+)
+
+// MessageFilter decides what to do with newly received messages.
+type MessageFilter interface {
+	FilterMessage(userID string, message *pmapi.Message) (*FilterActions, error)
+}
+
+// FilterActions are actions to be applied on a received message.
+// Mailboxes are IMAP names of the mailboxes the message should be put to.
+// If Keep is false and the message is not put to INBOX, it is removed from
+// INBOX, or moved to Trash in case it was not put anywhere else.
+type FilterActions struct {
+	Mailboxes []string
+	Keep      bool
+	MarkRead  bool
+	Star      bool
+}
+
+// SetMessageFilter sets the filter applied on new messages in INBOX.
+func (store *Store) SetMessageFilter(filter MessageFilter) {
+	store.filterLock.Lock()
+	defer store.filterLock.Unlock()
+
+	store.filter = filter
+}
+
+func (store *Store) getMessageFilter() MessageFilter {
+	store.filterLock.RLock()
+	defer store.filterLock.RUnlock()
+
+	return store.filter
+}
+
+// isFilterable returns whether the message was received to INBOX, i.e.,
+// it was neither imported (e.g., by IMAP APPEND) nor sent.
+func isFilterable(message *pmapi.Message) bool {
+	return message.Flags&pmapi.FlagReceived != 0 &&
+		message.Flags&pmapi.FlagImported == 0 &&
+		message.HasLabelID(pmapi.InboxLabel)
+}
+
+// filterMessages applies the filter on the messages. It has to be called
+// outside of the event loop because it waits for the events it causes.
+func (store *Store) filterMessages(messages []*pmapi.Message) {
+	defer store.panicHandler.HandlePanic()
+
+	filter := store.getMessageFilter()
+	if filter == nil {
+		return
+	}
+
+	for _, message := range messages {
+		if err := store.filterMessage(filter, message); err != nil {
+			store.log.WithError(err).WithField("msgID", message.ID).Warn("Failed to filter message")
+		}
+	}
+
+	store.eventLoop.pollNow()
+}
+
+func (store *Store) filterMessage(filter MessageFilter, message *pmapi.Message) error {
+	actions, err := filter.FilterMessage(store.UserID(), message)
+	if err != nil {
+		return errors.Wrap(err, "failed to run filter")
+	}
+	if actions == nil {
+		return nil
+	}
+
+	ctx := context.Background()
+	ids := []string{message.ID}
+	keepInInbox := actions.Keep
+
+	for _, name := range actions.Mailboxes {
+		if strings.EqualFold(name, "INBOX") {
+			keepInInbox = true
+			continue
+		}
+		mailbox, err := store.getMailbox(name)
+		if err != nil {
+			store.log.WithError(err).Warn("Filter target mailbox not found, keeping message in INBOX")
+			keepInInbox = true
+			continue
+		}
+		if mailbox.labelID == pmapi.AllMailLabel {
+			continue
+		}
+		if err := store.client().LabelMessages(ctx, ids, mailbox.labelID); err != nil {
+			return errors.Wrap(err, "failed to label message")
+		}
+	}
+
+	if actions.MarkRead {
+		if err := store.client().MarkMessagesRead(ctx, ids); err != nil {
+			return errors.Wrap(err, "failed to mark message as read")
+		}
+	}
+
+	if actions.Star {
+		if err := store.client().LabelMessages(ctx, ids, pmapi.StarredLabel); err != nil {
+			return errors.Wrap(err, "failed to star message")
+		}
+	}
+
+	if keepInInbox {
+		return nil
+	}
+
+	if len(actions.Mailboxes) == 0 {
+		return errors.Wrap(store.client().LabelMessages(ctx, ids, pmapi.TrashLabel), "failed to move message to trash")
+	}
+
+	return errors.Wrap(store.client().UnlabelMessages(ctx, ids, pmapi.InboxLabel), "failed to remove message from inbox")
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+type testMessageFilter struct {
+	actions *FilterActions
+}
+
+func (f *testMessageFilter) FilterMessage(userID string, message *pmapi.Message) (*FilterActions, error) {
+	return f.actions, nil
+}
+
+func TestIsFilterable(t *testing.T) {
+	tests := []struct {
+		message *pmapi.Message
+		want    bool
+	}{
+		{&pmapi.Message{Flags: pmapi.FlagReceived, LabelIDs: []string{pmapi.InboxLabel}}, true},
+		{&pmapi.Message{Flags: pmapi.FlagReceived, LabelIDs: []string{pmapi.ArchiveLabel}}, false},
+		{&pmapi.Message{Flags: pmapi.FlagReceived | pmapi.FlagImported, LabelIDs: []string{pmapi.InboxLabel}}, false},
+		{&pmapi.Message{Flags: pmapi.FlagSent, LabelIDs: []string{pmapi.InboxLabel}}, false},
+	}
+	for _, tc := range tests {
+		require.Equal(t, tc.want, isFilterable(tc.message), "flags %d labels %v", tc.message.Flags, tc.message.LabelIDs)
+	}
+}
+
+func TestFilterMessageFileInto(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	ids := []string{"msg1"}
+	m.client.EXPECT().LabelMessages(gomock.Any(), ids, pmapi.ArchiveLabel)
+	m.client.EXPECT().MarkMessagesRead(gomock.Any(), ids)
+	m.client.EXPECT().LabelMessages(gomock.Any(), ids, pmapi.StarredLabel)
+	m.client.EXPECT().UnlabelMessages(gomock.Any(), ids, pmapi.InboxLabel)
+
+	filter := &testMessageFilter{&FilterActions{Mailboxes: []string{"Archive"}, MarkRead: true, Star: true}}
+	require.NoError(t, m.store.filterMessage(filter, &pmapi.Message{ID: "msg1"}))
+}
+
+func TestFilterMessageFileIntoAndKeep(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	m.client.EXPECT().LabelMessages(gomock.Any(), []string{"msg1"}, pmapi.ArchiveLabel)
+
+	filter := &testMessageFilter{&FilterActions{Mailboxes: []string{"Archive"}, Keep: true}}
+	require.NoError(t, m.store.filterMessage(filter, &pmapi.Message{ID: "msg1"}))
+}
+
+func TestFilterMessageFileIntoInbox(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	filter := &testMessageFilter{&FilterActions{Mailboxes: []string{"inbox"}}}
+	require.NoError(t, m.store.filterMessage(filter, &pmapi.Message{ID: "msg1"}))
+}
+
+func TestFilterMessageDiscard(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	m.client.EXPECT().LabelMessages(gomock.Any(), []string{"msg1"}, pmapi.TrashLabel)
+
+	filter := &testMessageFilter{&FilterActions{}}
+	require.NoError(t, m.store.filterMessage(filter, &pmapi.Message{ID: "msg1"}))
+}
+
+func TestFilterMessageUnknownMailbox(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	filter := &testMessageFilter{&FilterActions{Mailboxes: []string{"Unknown"}}}
+	require.NoError(t, m.store.filterMessage(filter, &pmapi.Message{ID: "msg1"}))
+}
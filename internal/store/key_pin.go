@@ -0,0 +1,62 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// KeyPin is the key of an external address discovered outside of the API
+// (WKD, keyserver, ...). Its fingerprint is pinned so that a different key
+// returned by a later lookup is not trusted while the pinned one is valid.
+type KeyPin struct {
+	Addr        string
+	Fingerprint string
+	PublicKey   []byte
+	CheckedAt   time.Time
+}
+
+// GetKeyPin returns the key pinned for the given address or nil when there
+// is none.
+func (store *Store) GetKeyPin(addr string) (pin *KeyPin, err error) {
+	err = store.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(keyPinsBucket).Get([]byte(strings.ToLower(addr)))
+		if raw == nil {
+			return nil
+		}
+		pin = &KeyPin{}
+		return json.Unmarshal(raw, pin)
+	})
+	return
+}
+
+// SetKeyPin stores the pinned key for its address.
+func (store *Store) SetKeyPin(pin *KeyPin) error {
+	raw, err := json.Marshal(pin)
+	if err != nil {
+		return err
+	}
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(keyPinsBucket).Put([]byte(strings.ToLower(pin.Addr)), raw)
+	})
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyPin(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	pin, err := m.store.GetKeyPin("alice@example.com")
+	require.NoError(t, err)
+	assert.Nil(t, pin)
+
+	checkedAt := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, m.store.SetKeyPin(&KeyPin{
+		Addr:        "Alice@example.com",
+		Fingerprint: "abcd",
+		PublicKey:   []byte("key"),
+		CheckedAt:   checkedAt,
+	}))
+
+	pin, err = m.store.GetKeyPin("ALICE@example.com")
+	require.NoError(t, err)
+	require.NotNil(t, pin)
+	assert.Equal(t, "abcd", pin.Fingerprint)
+	assert.Equal(t, []byte("key"), pin.PublicKey)
+	assert.True(t, checkedAt.Equal(pin.CheckedAt))
+}
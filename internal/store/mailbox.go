@@ -20,7 +20,9 @@ package store
 import (
 	"encoding/json"
 	"fmt"
+	"regexp"
 	"strings"
+	"sync"
 	"sync/atomic"
 
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
@@ -28,6 +30,8 @@ import (
 	bolt "go.etcd.io/bbolt"
 )
 
+var colorRegexp = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`) //nolint[gochecknoglobals]
+
 // Mailbox is mailbox for specific address and mailbox.
 type Mailbox struct {
 	store        *Store
@@ -38,9 +42,16 @@ type Mailbox struct {
 	labelName   string
 	color       string
 
+	// search is set for virtual mailboxes of saved searches.
+	search *SavedSearch
+
 	log *logrus.Entry
 
 	isDeleting atomic.Value
+
+	// flagsLock serialises changes of flags which need to be atomic
+	// with a check of mod-sequences.
+	flagsLock sync.Mutex
 }
 
 func newMailbox(storeAddress *Address, labelID, labelPrefix, labelName, color string) (mb *Mailbox, err error) {
@@ -149,6 +160,12 @@ func initMailboxBucket(tx *bolt.Tx, bucketName []byte) error {
 	if _, err := bucket.CreateBucketIfNotExists(deletedIDsBucket); err != nil {
 		return err
 	}
+	if _, err := bucket.CreateBucketIfNotExists(modSeqsBucket); err != nil {
+		return err
+	}
+	if _, err := bucket.CreateBucketIfNotExists(vanishedUIDsBucket); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -183,6 +200,11 @@ func (storeMailbox *Mailbox) IsLabel() bool {
 	return storeMailbox.labelPrefix == UserLabelsPrefix
 }
 
+// IsSearch returns whether the mailbox is a saved search (has "Searches/" prefix).
+func (storeMailbox *Mailbox) IsSearch() bool {
+	return storeMailbox.labelPrefix == SavedSearchesPrefix
+}
+
 // IsSystem returns whether the mailbox is one of the specific system mailboxes (has no prefix).
 func (storeMailbox *Mailbox) IsSystem() bool {
 	return storeMailbox.labelPrefix == ""
@@ -196,6 +218,10 @@ func (storeMailbox *Mailbox) Rename(newName string) error {
 		return fmt.Errorf("cannot rename system mailboxes")
 	}
 
+	if storeMailbox.IsSearch() {
+		return ErrSavedSearchOpNotAllowed
+	}
+
 	if storeMailbox.IsFolder() {
 		if !strings.HasPrefix(newName, UserFoldersPrefix) {
 			return fmt.Errorf("cannot rename folder to non-folder")
@@ -215,11 +241,35 @@ func (storeMailbox *Mailbox) Rename(newName string) error {
 	return storeMailbox.storeAddress.updateMailbox(storeMailbox.labelID, newName, storeMailbox.color)
 }
 
+// SetColor updates the color of the mailbox by calling an API.
+// Change has to be propagated to all the same mailboxes in all addresses.
+// The propagation is processed by the event loop.
+func (storeMailbox *Mailbox) SetColor(color string) error {
+	if storeMailbox.IsSystem() {
+		return fmt.Errorf("cannot set color of system mailboxes")
+	}
+
+	if storeMailbox.IsSearch() {
+		return ErrSavedSearchOpNotAllowed
+	}
+
+	if !colorRegexp.MatchString(color) {
+		return fmt.Errorf("color must be in the form #rrggbb")
+	}
+
+	name := strings.TrimPrefix(storeMailbox.labelName, storeMailbox.labelPrefix)
+	return storeMailbox.storeAddress.updateMailbox(storeMailbox.labelID, name, color)
+}
+
 // Delete deletes the mailbox by calling an API.
 // Deletion has to be propagated to all the same mailboxes in all addresses.
 // The propagation is processed by the event loop.
+// Saved searches exist only locally and are removed right away.
 func (storeMailbox *Mailbox) Delete() error {
 	storeMailbox.isDeleting.Store(true)
+	if storeMailbox.IsSearch() {
+		return storeMailbox.store.RemoveSavedSearch(storeMailbox.search.Name)
+	}
 	return storeMailbox.storeAddress.deleteMailbox(storeMailbox.labelID)
 }
 
@@ -259,6 +309,16 @@ func (storeMailbox *Mailbox) txGetDeletedIDsBucket(tx *bolt.Tx) *bolt.Bucket {
 	return storeMailbox.txGetBucket(tx).Bucket(deletedIDsBucket)
 }
 
+// txGetModSeqsBucket returns the bucket mapping API ID to mod-sequence.
+func (storeMailbox *Mailbox) txGetModSeqsBucket(tx *bolt.Tx) *bolt.Bucket {
+	return storeMailbox.txGetBucket(tx).Bucket(modSeqsBucket)
+}
+
+// txGetVanishedUIDsBucket returns the bucket mapping mod-sequence of expunge to IMAP UID.
+func (storeMailbox *Mailbox) txGetVanishedUIDsBucket(tx *bolt.Tx) *bolt.Bucket {
+	return storeMailbox.txGetBucket(tx).Bucket(vanishedUIDsBucket)
+}
+
 // txGetBucket returns the bucket of mailbox containing mapping buckets.
 func (storeMailbox *Mailbox) txGetBucket(tx *bolt.Tx) *bolt.Bucket {
 	return tx.Bucket(mailboxesBucket).Bucket(storeMailbox.getBucketName())
@@ -49,6 +49,10 @@ func (storeMailbox *Mailbox) FetchMessage(apiID string) (*Message, error) {
 }
 
 func (storeMailbox *Mailbox) ImportMessage(enc []byte, seen bool, labelIDs []string, flags, time int64) (string, error) {
+	if storeMailbox.IsSearch() {
+		return "", ErrSavedSearchOpNotAllowed
+	}
+
 	defer storeMailbox.pollNow()
 
 	if storeMailbox.labelID != pmapi.AllMailLabel {
@@ -95,6 +99,9 @@ func (storeMailbox *Mailbox) LabelMessages(apiIDs []string) error {
 	if storeMailbox.labelID == pmapi.AllMailLabel {
 		return ErrAllMailOpNotAllowed
 	}
+	if storeMailbox.IsSearch() {
+		return ErrSavedSearchOpNotAllowed
+	}
 	defer storeMailbox.pollNow()
 	return storeMailbox.client().LabelMessages(exposeContextForIMAP(), apiIDs, storeMailbox.labelID)
 }
@@ -108,6 +115,9 @@ func (storeMailbox *Mailbox) UnlabelMessages(apiIDs []string) error {
 	if storeMailbox.labelID == pmapi.AllMailLabel {
 		return ErrAllMailOpNotAllowed
 	}
+	if storeMailbox.IsSearch() {
+		return ErrSavedSearchOpNotAllowed
+	}
 	defer storeMailbox.pollNow()
 	return storeMailbox.client().UnlabelMessages(exposeContextForIMAP(), apiIDs, storeMailbox.labelID)
 }
@@ -188,6 +198,9 @@ func (storeMailbox *Mailbox) MarkMessagesDeleted(apiIDs []string) error {
 	if storeMailbox.labelID == pmapi.AllMailLabel {
 		return ErrAllMailOpNotAllowed
 	}
+	if storeMailbox.IsSearch() {
+		return ErrSavedSearchOpNotAllowed
+	}
 	return storeMailbox.store.db.Update(func(tx *bolt.Tx) error {
 		return storeMailbox.txMarkMessagesAsDeleted(tx, apiIDs, true)
 	})
@@ -204,6 +217,9 @@ func (storeMailbox *Mailbox) MarkMessagesUndeleted(apiIDs []string) error {
 	if storeMailbox.labelID == pmapi.AllMailLabel {
 		return ErrAllMailOpNotAllowed
 	}
+	if storeMailbox.IsSearch() {
+		return ErrSavedSearchOpNotAllowed
+	}
 	return storeMailbox.store.db.Update(func(tx *bolt.Tx) error {
 		return storeMailbox.txMarkMessagesAsDeleted(tx, apiIDs, false)
 	})
@@ -336,6 +352,12 @@ func (storeMailbox *Mailbox) txSkipAndRemoveFromMailbox(tx *bolt.Tx, msg *pmapi.
 		return
 	}
 
+	// Saved search decides by its criteria instead of labels.
+	if storeMailbox.search != nil {
+		skipAndRemove = !storeMailbox.search.matches(msg)
+		return
+	}
+
 	// If the message belongs in this mailbox, don't skip/remove it.
 	for _, labelID := range msg.LabelIDs {
 		if labelID == storeMailbox.labelID {
@@ -385,12 +407,17 @@ func (storeMailbox *Mailbox) txCreateOrUpdateMessages(tx *bolt.Tx, msgs []*pmapi
 					deletedBucket = storeMailbox.txGetDeletedIDsBucket(tx)
 				}
 				isMarkedAsDeleted := deletedBucket.Get([]byte(msg.ID)) != nil
+				modSeq, err := storeMailbox.txBumpModSeq(tx, msg)
+				if err != nil {
+					return err
+				}
 				if seqErr == nil {
 					storeMailbox.store.notifyUpdateMessage(
 						storeMailbox.storeAddress.address,
 						storeMailbox.labelName,
 						btoi(uidb),
 						seqNum,
+						modSeq,
 						msg,
 						isMarkedAsDeleted,
 					)
@@ -415,6 +442,10 @@ func (storeMailbox *Mailbox) txCreateOrUpdateMessages(tx *bolt.Tx, msgs []*pmapi
 		if err = apiBucket.Put([]byte(msg.ID), uidb); err != nil {
 			return errors.Wrap(err, "cannot add to API bucket")
 		}
+		modSeq, err := storeMailbox.txBumpModSeq(tx, msg)
+		if err != nil {
+			return err
+		}
 
 		seqNum, err := storeMailbox.txGetSequenceNumberOfUID(imapBucket, uidb)
 		if err != nil {
@@ -427,6 +458,7 @@ func (storeMailbox *Mailbox) txCreateOrUpdateMessages(tx *bolt.Tx, msgs []*pmapi
 				storeMailbox.labelName,
 				uid,
 				seqNum,
+				modSeq,
 				msg,
 				false, // new message is never marked as deleted
 			)
@@ -478,10 +510,15 @@ func (storeMailbox *Mailbox) txDeleteMessage(tx *bolt.Tx, apiID string) error {
 		return errors.Wrap(err, "cannot delete from mark-as-deleted bucket")
 	}
 
+	if err := storeMailbox.txVanishModSeq(tx, apiID, uidb); err != nil {
+		return errors.Wrap(err, "cannot record expunge in mod-sequences")
+	}
+
 	if seqNumErr == nil {
 		storeMailbox.store.notifyDeleteMessage(
 			storeMailbox.storeAddress.address,
 			storeMailbox.labelName,
+			btoi(uidb),
 			seqNum,
 		)
 		// Outlook for Mac has problems with sending an EXISTS after deleting
@@ -534,6 +571,11 @@ func (storeMailbox *Mailbox) txMarkMessagesAsDeleted(tx *bolt.Tx, apiIDs []strin
 			return err
 		}
 
+		modSeq, err := storeMailbox.txBumpModSeq(tx, msg)
+		if err != nil {
+			return err
+		}
+
 		seqNum, err := storeMailbox.txGetSequenceNumberOfUID(uidBucket, itob(uid))
 		if err != nil {
 			return err
@@ -546,6 +588,7 @@ func (storeMailbox *Mailbox) txMarkMessagesAsDeleted(tx *bolt.Tx, apiIDs []strin
 			storeMailbox.labelName,
 			uid,
 			seqNum,
+			modSeq,
 			msg,
 			markAsDeleted,
 		)
@@ -0,0 +1,222 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// initialModSeq is the mod-sequence of messages which were not changed since
+// mod-sequences are tracked. Mod-sequences must be positive (RFC 7162).
+const initialModSeq = uint64(1)
+
+// vanishedModSeqWindow is how many mod-sequences back expunges are
+// remembered. Older expunges are forgotten to not grow the database forever.
+const vanishedModSeqWindow = uint64(100000)
+
+// GetHighestModSeq returns the highest mod-sequence of the mailbox.
+func (storeMailbox *Mailbox) GetHighestModSeq() (modSeq uint64, err error) {
+	err = storeMailbox.db().View(func(tx *bolt.Tx) error {
+		modSeq = storeMailbox.txGetHighestModSeq(tx)
+		return nil
+	})
+	return
+}
+
+// GetModSeqs returns the mod-sequence of the last change of the messages.
+func (storeMailbox *Mailbox) GetModSeqs(apiIDs []string) (modSeqs map[string]uint64, err error) {
+	modSeqs = make(map[string]uint64, len(apiIDs))
+	err = storeMailbox.db().View(func(tx *bolt.Tx) error {
+		b := storeMailbox.txGetModSeqsBucket(tx)
+		for _, apiID := range apiIDs {
+			modSeqs[apiID] = initialModSeq
+			if v := b.Get([]byte(apiID)); v != nil {
+				modSeqs[apiID] = btoi64(v)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// LockFlags blocks other callers of LockFlags until the returned function
+// is called. IMAP uses it to check mod-sequences of messages and change their
+// flags atomically. Flags are changed by the API and mod-sequences by events,
+// which are processed before the flag change returns.
+func (storeMailbox *Mailbox) LockFlags() (unlock func()) {
+	storeMailbox.flagsLock.Lock()
+	return storeMailbox.flagsLock.Unlock
+}
+
+// GetVanishedUIDs returns UIDs of messages which were expunged from
+// the mailbox after the given mod-sequence. When expunges after it were
+// already forgotten, all UIDs which are not in the mailbox are returned.
+func (storeMailbox *Mailbox) GetVanishedUIDs(modSeq uint64) (uids []uint32, err error) {
+	err = storeMailbox.db().View(func(tx *bolt.Tx) error {
+		b := storeMailbox.txGetVanishedUIDsBucket(tx)
+		if modSeq < b.Sequence() {
+			uids = storeMailbox.txGetMissingUIDs(tx)
+			return nil
+		}
+
+		c := b.Cursor()
+		for k, v := c.Seek(itob64(modSeq + 1)); k != nil; k, v = c.Next() {
+			uids = append(uids, btoi(v))
+		}
+		return nil
+	})
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return
+}
+
+// txGetMissingUIDs returns all UIDs ever assigned in the mailbox which do
+// not belong to any message anymore.
+func (storeMailbox *Mailbox) txGetMissingUIDs(tx *bolt.Tx) (uids []uint32) {
+	b := storeMailbox.txGetIMAPIDsBucket(tx)
+
+	next := uint32(1)
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		for uid := btoi(k); next < uid; next++ {
+			uids = append(uids, next)
+		}
+		next = btoi(k) + 1
+	}
+	for ; uint64(next) <= b.Sequence(); next++ {
+		uids = append(uids, next)
+	}
+
+	return uids
+}
+
+func (storeMailbox *Mailbox) txGetHighestModSeq(tx *bolt.Tx) uint64 {
+	if modSeq := storeMailbox.txGetModSeqsBucket(tx).Sequence(); modSeq > initialModSeq {
+		return modSeq
+	}
+	return initialModSeq
+}
+
+// txNextModSeq returns a new mod-sequence which is higher than any other
+// mod-sequence in the mailbox.
+func (storeMailbox *Mailbox) txNextModSeq(tx *bolt.Tx) (uint64, error) {
+	b := storeMailbox.txGetModSeqsBucket(tx)
+
+	// Skip the initial value which is used for messages without any record.
+	if b.Sequence() < initialModSeq {
+		if err := b.SetSequence(initialModSeq); err != nil {
+			return 0, err
+		}
+	}
+
+	return b.NextSequence()
+}
+
+// txBumpModSeq assigns a new mod-sequence to the message when its flags or
+// labels differ from the ones of its last mod-sequence. Messages are updated
+// with the same state during every sync, which must not mark them as changed.
+// The current mod-sequence of the message is returned.
+func (storeMailbox *Mailbox) txBumpModSeq(tx *bolt.Tx, msg *pmapi.Message) (uint64, error) {
+	b := storeMailbox.txGetModSeqsBucket(tx)
+
+	state := storeMailbox.txGetFlagsState(tx, msg)
+	if v := b.Get([]byte(msg.ID)); len(v) > 8 && bytes.Equal(v[8:], state) {
+		return btoi64(v), nil
+	}
+
+	modSeq, err := storeMailbox.txNextModSeq(tx)
+	if err != nil {
+		return 0, errors.Wrap(err, "cannot generate new mod-sequence")
+	}
+
+	return modSeq, b.Put([]byte(msg.ID), append(itob64(modSeq), state...))
+}
+
+// txGetFlagsState returns the hash of everything the IMAP flags of
+// the message are derived from.
+func (storeMailbox *Mailbox) txGetFlagsState(tx *bolt.Tx, msg *pmapi.Message) []byte {
+	hash := sha256.New()
+
+	isMarkedAsDeleted := storeMailbox.txGetDeletedIDsBucket(tx).Get([]byte(msg.ID)) != nil
+	for _, v := range []bool{bool(msg.Unread), isMarkedAsDeleted} {
+		_ = binary.Write(hash, binary.BigEndian, v)
+	}
+	_ = binary.Write(hash, binary.BigEndian, msg.Flags)
+
+	labelIDs := append([]string{}, msg.LabelIDs...)
+	sort.Strings(labelIDs)
+	for _, labelID := range labelIDs {
+		hash.Write([]byte(labelID))
+		hash.Write([]byte{0})
+	}
+
+	return hash.Sum(nil)
+}
+
+// txVanishModSeq records the expunge of the message with the given UID
+// so it can be reported to clients resynchronising the mailbox.
+func (storeMailbox *Mailbox) txVanishModSeq(tx *bolt.Tx, apiID string, uidb []byte) error {
+	modSeq, err := storeMailbox.txNextModSeq(tx)
+	if err != nil {
+		return errors.Wrap(err, "cannot generate new mod-sequence")
+	}
+
+	if err := storeMailbox.txGetModSeqsBucket(tx).Delete([]byte(apiID)); err != nil {
+		return err
+	}
+
+	b := storeMailbox.txGetVanishedUIDsBucket(tx)
+	if err := b.Put(itob64(modSeq), uidb); err != nil {
+		return err
+	}
+
+	if modSeq <= vanishedModSeqWindow {
+		return nil
+	}
+	return txPruneVanishedUIDs(b, modSeq-vanishedModSeqWindow)
+}
+
+// txPruneVanishedUIDs forgets expunges up to the given mod-sequence. The
+// sequence of the bucket keeps the highest forgotten mod-sequence so older
+// mod-sequences are known to not have the full list of expunges anymore.
+func txPruneVanishedUIDs(b *bolt.Bucket, modSeq uint64) error {
+	var keys [][]byte
+
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil && btoi64(k) <= modSeq; k, _ = c.Next() {
+		keys = append(keys, k)
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return b.SetSequence(btoi64(keys[len(keys)-1]))
+}
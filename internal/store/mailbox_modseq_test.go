@@ -0,0 +1,178 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	a "github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestModSeqIsBumpedOnChange(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	inbox := m.store.addresses[addrID1].mailboxes[pmapi.InboxLabel]
+
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, false, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	insertMessage(t, m, "msg2", "Test message 2", addrID1, false, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+
+	afterInsert, err := inbox.GetHighestModSeq()
+	require.NoError(t, err)
+
+	modSeqs, err := inbox.GetModSeqs([]string{"msg1", "msg2"})
+	require.NoError(t, err)
+	a.True(t, modSeqs["msg1"] < modSeqs["msg2"])
+	a.Equal(t, afterInsert, modSeqs["msg2"])
+
+	// Flag change of msg1 (e.g. from the event loop).
+	msg1 := getTestMessage("msg1", "Test message 1", addrID1, true, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	require.NoError(t, m.store.createOrUpdateMessageEvent(msg1))
+
+	afterUpdate, err := inbox.GetHighestModSeq()
+	require.NoError(t, err)
+	a.True(t, afterUpdate > afterInsert)
+
+	modSeqs, err = inbox.GetModSeqs([]string{"msg1", "msg2"})
+	require.NoError(t, err)
+	a.Equal(t, afterUpdate, modSeqs["msg1"])
+	a.Equal(t, afterInsert, modSeqs["msg2"])
+
+	require.NoError(t, inbox.MarkMessagesDeleted([]string{"msg2"}))
+
+	modSeqs, err = inbox.GetModSeqs([]string{"msg2"})
+	require.NoError(t, err)
+	a.True(t, modSeqs["msg2"] > afterUpdate)
+}
+
+func TestModSeqIsNotBumpedWithoutChange(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	inbox := m.store.addresses[addrID1].mailboxes[pmapi.InboxLabel]
+
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, false, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	require.NoError(t, inbox.MarkMessagesDeleted([]string{"msg1"}))
+
+	before, err := inbox.GetHighestModSeq()
+	require.NoError(t, err)
+
+	// The same state is received again, e.g. during full sync.
+	msg1 := getTestMessage("msg1", "Test message 1", addrID1, false, []string{pmapi.InboxLabel, pmapi.AllMailLabel})
+	require.NoError(t, m.store.createOrUpdateMessageEvent(msg1))
+	require.NoError(t, inbox.MarkMessagesDeleted([]string{"msg1"}))
+
+	after, err := inbox.GetHighestModSeq()
+	require.NoError(t, err)
+	a.Equal(t, before, after)
+
+	modSeqs, err := inbox.GetModSeqs([]string{"msg1"})
+	require.NoError(t, err)
+	a.Equal(t, before, modSeqs["msg1"])
+}
+
+func TestModSeqVanishedUIDs(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	inbox := m.store.addresses[addrID1].mailboxes[pmapi.InboxLabel]
+
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, false, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	insertMessage(t, m, "msg2", "Test message 2", addrID1, false, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	insertMessage(t, m, "msg3", "Test message 3", addrID1, false, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+
+	require.NoError(t, m.store.deleteMessageEvent("msg1"))
+
+	beforeDelete, err := inbox.GetHighestModSeq()
+	require.NoError(t, err)
+
+	require.NoError(t, m.store.deleteMessageEvent("msg3"))
+
+	uids, err := inbox.GetVanishedUIDs(0)
+	require.NoError(t, err)
+	a.Equal(t, []uint32{1, 3}, uids)
+
+	uids, err = inbox.GetVanishedUIDs(beforeDelete)
+	require.NoError(t, err)
+	a.Equal(t, []uint32{3}, uids)
+
+	// Vanished messages do not keep their mod-sequences.
+	modSeqs, err := inbox.GetModSeqs([]string{"msg3"})
+	require.NoError(t, err)
+	a.Equal(t, initialModSeq, modSeqs["msg3"])
+}
+
+func TestModSeqVanishedUIDsArePruned(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	inbox := m.store.addresses[addrID1].mailboxes[pmapi.InboxLabel]
+
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, false, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	insertMessage(t, m, "msg2", "Test message 2", addrID1, false, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	insertMessage(t, m, "msg3", "Test message 3", addrID1, false, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+
+	beforeDelete, err := inbox.GetHighestModSeq()
+	require.NoError(t, err)
+
+	require.NoError(t, m.store.deleteMessageEvent("msg1"))
+
+	afterFirstDelete, err := inbox.GetHighestModSeq()
+	require.NoError(t, err)
+
+	require.NoError(t, m.store.deleteMessageEvent("msg2"))
+
+	require.NoError(t, m.store.db.Update(func(tx *bolt.Tx) error {
+		return txPruneVanishedUIDs(inbox.txGetVanishedUIDsBucket(tx), afterFirstDelete)
+	}))
+
+	// Expunges after the forgotten ones are still known exactly.
+	uids, err := inbox.GetVanishedUIDs(afterFirstDelete)
+	require.NoError(t, err)
+	a.Equal(t, []uint32{2}, uids)
+
+	// Older mod-sequence gets all UIDs not in the mailbox.
+	insertMessage(t, m, "msg4", "Test message 4", addrID1, false, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	require.NoError(t, m.store.deleteMessageEvent("msg4"))
+
+	uids, err = inbox.GetVanishedUIDs(beforeDelete)
+	require.NoError(t, err)
+	a.Equal(t, []uint32{1, 2, 4}, uids)
+}
+
+func TestModSeqOfEmptyMailbox(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	modSeq, err := m.store.addresses[addrID1].mailboxes[pmapi.SpamLabel].GetHighestModSeq()
+	require.NoError(t, err)
+	a.Equal(t, initialModSeq, modSeq)
+}
@@ -62,6 +62,15 @@ func (message *Message) SequenceNumber() (uint32, error) {
 	return message.storeMailbox.getSequenceNumber(message.ID())
 }
 
+// ModSeq returns the mod-sequence of the last change of the message in used mailbox.
+func (message *Message) ModSeq() (uint64, error) {
+	modSeqs, err := message.storeMailbox.GetModSeqs([]string{message.ID()})
+	if err != nil {
+		return 0, err
+	}
+	return modSeqs[message.ID()], nil
+}
+
 // Message returns message struct from pmapi.
 func (message *Message) Message() *pmapi.Message {
 	return message.msg
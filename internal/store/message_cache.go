@@ -0,0 +1,449 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	pkgMsg "github.com/ProtonMail/proton-bridge/pkg/message"
+	"github.com/pkg/errors"
+)
+
+const (
+	messageCacheKeyFile   = "cache.key"
+	messageCacheExtension = ".msg"
+)
+
+// messageCache is a disk cache of built messages (RFC822 literals together
+// with their body structures). Each message is stored in its own file named
+// by a keyed hash of the message ID and encrypted by a random key, which is
+// stored next to the messages encrypted by the user's keyring.
+//
+// The cache is limited by its total size and by the time since the last use
+// of each message; the least recently used messages are evicted first.
+//
+// The cache lock guards only the entries and the total size. Files are read
+// and written under the lock of their entry, so loading of one message does
+// not block other messages. The lock of a published entry can be acquired
+// while holding the cache lock, but not the other way around.
+type messageCache struct {
+	dir       string
+	sizeLimit int64
+	ageLimit  time.Duration
+
+	nameKey []byte
+	aead    cipher.AEAD
+
+	lock    sync.Mutex
+	entries map[string]*messageCacheEntry
+	size    int64
+}
+
+type messageCacheEntry struct {
+	lock     sync.RWMutex
+	name     string
+	size     int64
+	lastUsed time.Time
+}
+
+// openMessageCache opens or creates the message cache in the given directory.
+// When the key cannot be decrypted (for example, the keys were changed), all
+// cached messages are dropped.
+func openMessageCache(dir string, kr *crypto.KeyRing, sizeLimit int64, ageLimit time.Duration) (*messageCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create message cache dir")
+	}
+
+	key, err := loadMessageCacheKey(dir, kr)
+	if err != nil {
+		log.WithError(err).Warn("Cannot use message cache key, dropping message cache")
+
+		if key, err = resetMessageCache(dir, kr); err != nil {
+			return nil, err
+		}
+	}
+
+	aead, err := newLocalAEAD(deriveLocalKey(key, "message"))
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &messageCache{
+		dir:       dir,
+		sizeLimit: sizeLimit,
+		ageLimit:  ageLimit,
+		nameKey:   deriveLocalKey(key, "name"),
+		aead:      aead,
+		entries:   make(map[string]*messageCacheEntry),
+	}
+
+	if err := cache.loadEntries(); err != nil {
+		return nil, err
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	cache.evict(0)
+
+	return cache, nil
+}
+
+func loadMessageCacheKey(dir string, kr *crypto.KeyRing) ([]byte, error) {
+	encKey, err := ioutil.ReadFile(filepath.Join(dir, messageCacheKeyFile)) //nolint[gosec]
+	if err != nil {
+		return nil, err
+	}
+	return decryptLocalKey(kr, encKey)
+}
+
+// resetMessageCache removes all cached messages and generates a new key.
+func resetMessageCache(dir string, kr *crypto.KeyRing) ([]byte, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, errors.Wrap(err, "failed to remove message cache")
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create message cache dir")
+	}
+
+	key, encKey, err := generateLocalKey(kr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, messageCacheKeyFile), encKey, 0600); err != nil {
+		return nil, errors.Wrap(err, "failed to write message cache key")
+	}
+
+	return key, nil
+}
+
+// loadEntries reads sizes and times of last use of all cached messages.
+func (cache *messageCache) loadEntries() error {
+	files, err := ioutil.ReadDir(cache.dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to read message cache dir")
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != messageCacheExtension {
+			continue
+		}
+
+		cache.entries[file.Name()] = &messageCacheEntry{
+			name:     file.Name(),
+			size:     file.Size(),
+			lastUsed: file.ModTime(),
+		}
+		cache.size += file.Size()
+	}
+
+	return nil
+}
+
+func (cache *messageCache) getFileName(apiID string) string {
+	mac := hmac.New(sha256.New, cache.nameKey)
+	_, _ = mac.Write([]byte(apiID))
+	return hex.EncodeToString(mac.Sum(nil)) + messageCacheExtension
+}
+
+// load returns the cached literal and body structure of the message.
+func (cache *messageCache) load(apiID string) ([]byte, *pkgMsg.BodyStructure, bool) {
+	name := cache.getFileName(apiID)
+
+	// Update time of last use to keep messages which are used often.
+	lastUsed := time.Now()
+
+	cache.lock.Lock()
+	entry, ok := cache.entries[name]
+	if ok {
+		entry.lastUsed = lastUsed
+	}
+	cache.lock.Unlock()
+
+	if !ok {
+		return nil, nil, false
+	}
+
+	entry.lock.RLock()
+	literal, structure, err := cache.readFile(name)
+	if err == nil {
+		if err := os.Chtimes(filepath.Join(cache.dir, name), lastUsed, lastUsed); err != nil {
+			log.WithError(err).Debug("Cannot update time of cached message")
+		}
+	}
+	entry.lock.RUnlock()
+
+	if err != nil {
+		log.WithError(err).WithField("msgID", apiID).Warn("Cannot read cached message")
+		cache.removeEntry(entry)
+		return nil, nil, false
+	}
+
+	return literal, structure, true
+}
+
+// save stores the literal and body structure of the message.
+func (cache *messageCache) save(apiID string, literal []byte, structure *pkgMsg.BodyStructure) error {
+	rawStructure, err := structure.Serialize()
+	if err != nil {
+		return err
+	}
+
+	plain := make([]byte, 4, 4+len(rawStructure)+len(literal))
+	binary.BigEndian.PutUint32(plain, uint32(len(rawStructure)))
+	plain = append(plain, rawStructure...)
+	plain = append(plain, literal...)
+
+	enc, err := sealLocalData(cache.aead, plain)
+	if err != nil {
+		return err
+	}
+
+	size := int64(len(enc))
+	if size > cache.sizeLimit {
+		return nil
+	}
+
+	name := cache.getFileName(apiID)
+	entry := &messageCacheEntry{name: name, size: size, lastUsed: time.Now()}
+
+	// The space is reserved before the file is written, so concurrent saves
+	// do not grow the cache over its limit. The new entry is locked before
+	// it is published, so readers wait until the file is written.
+	entry.lock.Lock()
+
+	cache.lock.Lock()
+	cache.remove(name)
+	cache.evict(size)
+	cache.entries[name] = entry
+	cache.size += size
+	cache.lock.Unlock()
+
+	err = ioutil.WriteFile(filepath.Join(cache.dir, name), enc, 0600)
+
+	entry.lock.Unlock()
+
+	if err != nil {
+		cache.removeEntry(entry)
+		return errors.Wrap(err, "failed to write cached message")
+	}
+
+	return nil
+}
+
+// delete removes the messages from the cache.
+func (cache *messageCache) delete(apiIDs []string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	for _, apiID := range apiIDs {
+		cache.remove(cache.getFileName(apiID))
+	}
+}
+
+func (cache *messageCache) readFile(name string) ([]byte, *pkgMsg.BodyStructure, error) {
+	enc, err := ioutil.ReadFile(filepath.Join(cache.dir, name)) //nolint[gosec]
+	if err != nil {
+		return nil, nil, err
+	}
+
+	plain, err := openLocalData(cache.aead, enc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(plain) < 4 {
+		return nil, nil, errors.New("cached message too short")
+	}
+
+	structureSize := int(binary.BigEndian.Uint32(plain))
+	if len(plain) < 4+structureSize {
+		return nil, nil, errors.New("cached message too short")
+	}
+
+	structure, err := pkgMsg.DeserializeBodyStructure(plain[4 : 4+structureSize])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return plain[4+structureSize:], structure, nil
+}
+
+// removeEntry deletes the file of the cached message unless the entry was
+// already replaced by a newer one.
+func (cache *messageCache) removeEntry(entry *messageCacheEntry) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if cache.entries[entry.name] == entry {
+		cache.remove(entry.name)
+	}
+}
+
+// remove deletes the file of the cached message. Cache lock must be held.
+// It waits for the readers of the message to finish.
+func (cache *messageCache) remove(name string) {
+	entry, ok := cache.entries[name]
+	if !ok {
+		return
+	}
+
+	entry.lock.Lock()
+	if err := os.Remove(filepath.Join(cache.dir, name)); err != nil && !os.IsNotExist(err) {
+		log.WithError(err).Warn("Cannot remove cached message")
+	}
+	entry.lock.Unlock()
+
+	delete(cache.entries, name)
+	cache.size -= entry.size
+}
+
+// evict removes messages not used for longer than the age limit and then
+// the least recently used messages until there is enough space for the new
+// message of the given size. Cache lock must be held.
+func (cache *messageCache) evict(newSize int64) {
+	entries := make([]*messageCacheEntry, 0, len(cache.entries))
+	for _, entry := range cache.entries {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastUsed.Before(entries[j].lastUsed)
+	})
+
+	for _, entry := range entries {
+		isTooOld := cache.ageLimit > 0 && time.Since(entry.lastUsed) > cache.ageLimit
+		isTooBig := cache.size+newSize > cache.sizeLimit
+		if !isTooOld && !isTooBig {
+			break
+		}
+		cache.remove(entry.name)
+	}
+}
+
+// clear removes all cached messages together with the key.
+func (cache *messageCache) clear() error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	cache.entries = make(map[string]*messageCacheEntry)
+	cache.size = 0
+
+	return os.RemoveAll(cache.dir)
+}
+
+// EnableMessageCache opens the disk cache of built messages in the given
+// directory. The user's keys must be unlocked, because the cache is encrypted
+// by the primary address keyring. Messages are evicted when the cache grows
+// over sizeLimit bytes or when they are not used for longer than ageLimit.
+func (store *Store) EnableMessageCache(dir string, sizeLimit int64, ageLimit time.Duration) error {
+	addressID, err := store.user.GetAddressID(store.user.GetPrimaryAddress())
+	if err != nil {
+		return errors.Wrap(err, "failed to get primary address")
+	}
+
+	kr, err := store.client().KeyRingForAddressID(addressID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get primary address keyring")
+	}
+
+	cache, err := openMessageCache(dir, kr, sizeLimit, ageLimit)
+	if err != nil {
+		return err
+	}
+
+	store.messageCacheLock.Lock()
+	defer store.messageCacheLock.Unlock()
+
+	store.messageCache = cache
+
+	return nil
+}
+
+// LoadCachedMessage returns the literal and body structure of the message
+// from the disk cache.
+func (store *Store) LoadCachedMessage(apiID string) ([]byte, *pkgMsg.BodyStructure, bool) {
+	cache := store.getMessageCache()
+	if cache == nil {
+		return nil, nil, false
+	}
+	return cache.load(apiID)
+}
+
+// SaveCachedMessage stores the literal and body structure of the message
+// in the disk cache.
+func (store *Store) SaveCachedMessage(apiID string, literal []byte, structure *pkgMsg.BodyStructure) {
+	cache := store.getMessageCache()
+	if cache == nil {
+		return
+	}
+
+	if err := cache.save(apiID, literal, structure); err != nil {
+		store.log.WithError(err).WithField("msgID", apiID).Warn("Cannot save message to cache")
+	}
+}
+
+// uncacheMessages removes the messages from the disk cache, for example
+// because they were deleted or their content was changed.
+func (store *Store) uncacheMessages(apiIDs []string) {
+	if cache := store.getMessageCache(); cache != nil {
+		cache.delete(apiIDs)
+	}
+}
+
+func (store *Store) getMessageCache() *messageCache {
+	store.messageCacheLock.RLock()
+	defer store.messageCacheLock.RUnlock()
+
+	return store.messageCache
+}
+
+// closeMessageCache disables the disk cache. When remove is set, all cached
+// messages are removed as well.
+func (store *Store) closeMessageCache(remove bool) error {
+	store.messageCacheLock.Lock()
+	defer store.messageCacheLock.Unlock()
+
+	if store.messageCache == nil {
+		return nil
+	}
+
+	var err error
+	if remove {
+		err = store.messageCache.clear()
+	}
+	store.messageCache = nil
+
+	return err
+}
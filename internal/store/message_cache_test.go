@@ -0,0 +1,185 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	pkgMsg "github.com/ProtonMail/proton-bridge/pkg/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCachedLiteral = "Subject: Secret subject\r\nContent-Type: text/plain\r\n\r\nSecret body\r\n"
+
+func newTestMessageCache(t *testing.T, sizeLimit int64, ageLimit time.Duration) (*messageCache, string, func()) {
+	dir, err := ioutil.TempDir("", "message-cache-test")
+	require.NoError(t, err)
+
+	cacheDir := filepath.Join(dir, "user")
+
+	cache, err := openMessageCache(cacheDir, testPrivateKeyRing, sizeLimit, ageLimit)
+	require.NoError(t, err)
+
+	return cache, cacheDir, func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}
+}
+
+func newTestCachedStructure(t *testing.T) *pkgMsg.BodyStructure {
+	structure, err := pkgMsg.NewBodyStructure(bytes.NewReader([]byte(testCachedLiteral)))
+	require.NoError(t, err)
+	return structure
+}
+
+func TestMessageCacheSaveAndLoad(t *testing.T) {
+	cache, dir, cleanup := newTestMessageCache(t, 1<<20, 0)
+	defer cleanup()
+
+	structure := newTestCachedStructure(t)
+	require.NoError(t, cache.save("msg1", []byte(testCachedLiteral), structure))
+
+	literal, loadedStructure, ok := cache.load("msg1")
+	require.True(t, ok)
+	assert.Equal(t, testCachedLiteral, string(literal))
+	assert.Equal(t, structure, loadedStructure)
+
+	_, _, ok = cache.load("msg2")
+	assert.False(t, ok)
+
+	// Neither the message ID nor its content can be seen on disk.
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	for _, file := range files {
+		assert.NotContains(t, file.Name(), "msg1")
+
+		content, err := ioutil.ReadFile(filepath.Join(dir, file.Name())) //nolint[gosec]
+		require.NoError(t, err)
+		assert.NotContains(t, string(content), "Secret")
+	}
+}
+
+func TestMessageCacheDelete(t *testing.T) {
+	cache, _, cleanup := newTestMessageCache(t, 1<<20, 0)
+	defer cleanup()
+
+	require.NoError(t, cache.save("msg1", []byte(testCachedLiteral), newTestCachedStructure(t)))
+	cache.delete([]string{"msg1", "unknown"})
+
+	_, _, ok := cache.load("msg1")
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), cache.size)
+}
+
+func TestMessageCacheConcurrentAccess(t *testing.T) {
+	cache, _, cleanup := newTestMessageCache(t, 1<<20, 0)
+	defer cleanup()
+
+	structure := newTestCachedStructure(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		apiID := fmt.Sprintf("msg%d", i%2)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 20; j++ {
+				assert.NoError(t, cache.save(apiID, []byte(testCachedLiteral), structure))
+				if literal, _, ok := cache.load(apiID); ok {
+					assert.Equal(t, testCachedLiteral, string(literal))
+				}
+				cache.delete([]string{apiID})
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Empty(t, cache.entries)
+	assert.Equal(t, int64(0), cache.size)
+}
+
+func TestMessageCacheEvictBySize(t *testing.T) {
+	cache, _, cleanup := newTestMessageCache(t, 1<<20, 0)
+	defer cleanup()
+
+	structure := newTestCachedStructure(t)
+	require.NoError(t, cache.save("msg1", []byte(testCachedLiteral), structure))
+
+	// Allow only two messages in the cache.
+	cache.sizeLimit = 2*cache.size + 1
+
+	require.NoError(t, cache.save("msg2", []byte(testCachedLiteral), structure))
+
+	// Make msg1 used more recently than msg2.
+	time.Sleep(10 * time.Millisecond)
+	_, _, ok := cache.load("msg1")
+	require.True(t, ok)
+
+	require.NoError(t, cache.save("msg3", []byte(testCachedLiteral), structure))
+
+	_, _, ok = cache.load("msg1")
+	assert.True(t, ok)
+	_, _, ok = cache.load("msg2")
+	assert.False(t, ok)
+	_, _, ok = cache.load("msg3")
+	assert.True(t, ok)
+}
+
+func TestMessageCacheEvictByAgeOnOpen(t *testing.T) {
+	cache, dir, cleanup := newTestMessageCache(t, 1<<20, time.Hour)
+	defer cleanup()
+
+	structure := newTestCachedStructure(t)
+	require.NoError(t, cache.save("old", []byte(testCachedLiteral), structure))
+	require.NoError(t, cache.save("new", []byte(testCachedLiteral), structure))
+
+	oldTime := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, cache.getFileName("old")), oldTime, oldTime))
+
+	cache, err := openMessageCache(dir, testPrivateKeyRing, 1<<20, time.Hour)
+	require.NoError(t, err)
+
+	_, _, ok := cache.load("old")
+	assert.False(t, ok)
+	_, _, ok = cache.load("new")
+	assert.True(t, ok)
+}
+
+func TestMessageCacheWrongKey(t *testing.T) {
+	cache, dir, cleanup := newTestMessageCache(t, 1<<20, 0)
+	defer cleanup()
+
+	require.NoError(t, cache.save("msg1", []byte(testCachedLiteral), newTestCachedStructure(t)))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, messageCacheKeyFile), []byte("broken"), 0600))
+
+	cache, err := openMessageCache(dir, testPrivateKeyRing, 1<<20, 0)
+	require.NoError(t, err)
+
+	_, _, ok := cache.load("msg1")
+	assert.False(t, ok)
+	assert.Empty(t, cache.entries)
+}
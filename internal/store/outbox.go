@@ -0,0 +1,392 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	pkgMsg "github.com/ProtonMail/proton-bridge/pkg/message"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/pkg/errors"
+)
+
+const (
+	outboxKeyFile   = "outbox.key"
+	outboxExtension = ".out"
+)
+
+// ErrOutboxNotEnabled is returned when the outbox of the user is not opened.
+var ErrOutboxNotEnabled = errors.New("outbox is not enabled") //nolint[gochecknoglobals]
+
+// OutboxMessage is a message accepted by SMTP which could not be sent yet,
+// for example because there was no internet connection. It holds everything
+// needed to replay the original SMTP transaction.
+type OutboxMessage struct {
+	ID         string `json:"-"`
+	Username   string
+	AddressID  string
+	ReturnPath string
+	To         []string
+	Envelope   DSNEnvelope
+	DSN        map[string]DSNParams `json:",omitempty"`
+	Literal    []byte
+
+	// Times requested by custom headers of the message. They are fixed
+	// when the message is queued, so retries do not shift them.
+	DeliveryTime   time.Time
+	ExpirationTime time.Time
+
+	QueuedAt    time.Time
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+}
+
+// DSNEnvelope are delivery status notification parameters of the message
+// given by the client in the MAIL command (RFC 3461).
+type DSNEnvelope struct {
+	Return     string `json:",omitempty"`
+	EnvelopeID string `json:",omitempty"`
+}
+
+// DSNParams are delivery status notification parameters of a recipient
+// given by the client in the RCPT command (RFC 3461).
+type DSNParams struct {
+	Notify            []string `json:",omitempty"`
+	OriginalRecipient string   `json:",omitempty"`
+}
+
+// outbox is a disk queue of messages waiting to be sent. Each message is
+// stored in its own file encrypted by a random key, which is stored next to
+// the messages encrypted by the user's keyring.
+//
+// Unlike the message cache, the outbox is never dropped when the key cannot
+// be decrypted, because it holds messages which exist nowhere else.
+type outbox struct {
+	dir  string
+	aead cipher.AEAD
+	lock sync.Mutex
+}
+
+// openOutbox opens or creates the outbox in the given directory.
+func openOutbox(dir string, kr *crypto.KeyRing) (*outbox, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create outbox dir")
+	}
+
+	key, err := loadOutboxKey(dir, kr)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newLocalAEAD(deriveLocalKey(key, "outbox"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &outbox{dir: dir, aead: aead}, nil
+}
+
+// loadOutboxKey decrypts the outbox key or generates a new one if there is
+// none yet.
+func loadOutboxKey(dir string, kr *crypto.KeyRing) ([]byte, error) {
+	keyPath := filepath.Join(dir, outboxKeyFile)
+
+	encKey, err := ioutil.ReadFile(keyPath) //nolint[gosec]
+	if err == nil {
+		return decryptLocalKey(kr, encKey)
+	}
+	if !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to read outbox key")
+	}
+
+	key, encKey, err := generateLocalKey(kr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(keyPath, encKey, 0600); err != nil {
+		return nil, errors.Wrap(err, "failed to write outbox key")
+	}
+
+	return key, nil
+}
+
+func newOutboxMessageID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func (box *outbox) path(id string) string {
+	return filepath.Join(box.dir, id+outboxExtension)
+}
+
+// write stores the message. The file is replaced atomically so a crash
+// never leaves a half-written message behind.
+func (box *outbox) write(msg *OutboxMessage) error {
+	plain, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	enc, err := sealLocalData(box.aead, plain)
+	if err != nil {
+		return errors.Wrap(err, "failed to encrypt outbox message")
+	}
+
+	tmpPath := box.path(msg.ID) + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, enc, 0600); err != nil {
+		return errors.Wrap(err, "failed to write outbox message")
+	}
+
+	return os.Rename(tmpPath, box.path(msg.ID))
+}
+
+func (box *outbox) read(id string) (*OutboxMessage, error) {
+	enc, err := ioutil.ReadFile(box.path(id)) //nolint[gosec]
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := openLocalData(box.aead, enc)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt outbox message")
+	}
+
+	msg := &OutboxMessage{}
+	if err := json.Unmarshal(plain, msg); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal outbox message")
+	}
+	msg.ID = id
+
+	return msg, nil
+}
+
+func (box *outbox) queue(msg *OutboxMessage) error {
+	box.lock.Lock()
+	defer box.lock.Unlock()
+
+	id, err := newOutboxMessageID()
+	if err != nil {
+		return err
+	}
+
+	msg.ID = id
+	msg.QueuedAt = time.Now()
+
+	return box.write(msg)
+}
+
+// list returns all queued messages, the oldest first. Messages which cannot
+// be read are skipped and kept on disk.
+func (box *outbox) list() ([]*OutboxMessage, error) {
+	box.lock.Lock()
+	defer box.lock.Unlock()
+
+	files, err := ioutil.ReadDir(box.dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read outbox dir")
+	}
+
+	msgs := []*OutboxMessage{}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != outboxExtension {
+			continue
+		}
+
+		msg, err := box.read(strings.TrimSuffix(file.Name(), outboxExtension))
+		if err != nil {
+			log.WithError(err).WithField("file", file.Name()).Warn("Cannot read outbox message")
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].QueuedAt.Before(msgs[j].QueuedAt)
+	})
+
+	return msgs, nil
+}
+
+func (box *outbox) update(msg *OutboxMessage) error {
+	box.lock.Lock()
+	defer box.lock.Unlock()
+
+	if _, err := os.Stat(box.path(msg.ID)); err != nil {
+		return errors.Wrap(err, "outbox message not found")
+	}
+
+	return box.write(msg)
+}
+
+func (box *outbox) remove(id string) error {
+	box.lock.Lock()
+	defer box.lock.Unlock()
+
+	if err := os.Remove(box.path(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// EnableOutbox opens the outbox of messages waiting to be sent in the given
+// directory. The user's keys must be unlocked, because the outbox is
+// encrypted by the primary address keyring.
+func (store *Store) EnableOutbox(dir string) error {
+	addressID, err := store.user.GetAddressID(store.user.GetPrimaryAddress())
+	if err != nil {
+		return errors.Wrap(err, "failed to get primary address")
+	}
+
+	kr, err := store.client().KeyRingForAddressID(addressID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get primary address keyring")
+	}
+
+	box, err := openOutbox(dir, kr)
+	if err != nil {
+		return err
+	}
+
+	store.outboxLock.Lock()
+	defer store.outboxLock.Unlock()
+
+	store.outbox = box
+
+	return nil
+}
+
+// closeOutbox disables the outbox. When remove is set, all queued messages
+// are removed as well.
+func (store *Store) closeOutbox(remove bool) error {
+	store.outboxLock.Lock()
+	defer store.outboxLock.Unlock()
+
+	if store.outbox == nil {
+		return nil
+	}
+
+	var err error
+	if remove {
+		err = os.RemoveAll(store.outbox.dir)
+	}
+	store.outbox = nil
+
+	return err
+}
+
+func (store *Store) getOutbox() (*outbox, error) {
+	store.outboxLock.RLock()
+	defer store.outboxLock.RUnlock()
+
+	if store.outbox == nil {
+		return nil, ErrOutboxNotEnabled
+	}
+	return store.outbox, nil
+}
+
+// QueueOutboxMessage stores the message in the outbox to be sent later.
+// The ID and the time of queueing are set by the outbox.
+func (store *Store) QueueOutboxMessage(msg *OutboxMessage) error {
+	box, err := store.getOutbox()
+	if err != nil {
+		return err
+	}
+	return box.queue(msg)
+}
+
+// ListOutboxMessages returns all messages waiting to be sent, the oldest first.
+func (store *Store) ListOutboxMessages() ([]*OutboxMessage, error) {
+	box, err := store.getOutbox()
+	if err != nil {
+		return nil, err
+	}
+	return box.list()
+}
+
+// UpdateOutboxMessage stores the changed state of the queued message,
+// for example the number of attempts.
+func (store *Store) UpdateOutboxMessage(msg *OutboxMessage) error {
+	box, err := store.getOutbox()
+	if err != nil {
+		return err
+	}
+	return box.update(msg)
+}
+
+// RemoveOutboxMessage removes the message from the outbox once it was sent
+// or given up.
+func (store *Store) RemoveOutboxMessage(id string) error {
+	box, err := store.getOutbox()
+	if err != nil {
+		return err
+	}
+	return box.remove(id)
+}
+
+// ImportInboxMessage encrypts the literal by the keyring of the given address
+// and imports it to the INBOX as a new unread message. It is used to deliver
+// local notices such as bounces of messages which could not be sent.
+func (store *Store) ImportInboxMessage(addressID string, literal []byte) error {
+	kr, err := store.client().KeyRingForAddressID(addressID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get address keyring")
+	}
+
+	enc, err := pkgMsg.EncryptRFC822(kr, bytes.NewReader(literal))
+	if err != nil {
+		return errors.Wrap(err, "failed to encrypt message")
+	}
+
+	res, err := store.client().Import(exposeContextForSMTP(), pmapi.ImportMsgReqs{{
+		Metadata: &pmapi.ImportMetadata{
+			AddressID: addressID,
+			Unread:    pmapi.Boolean(true),
+			Flags:     pmapi.FlagReceived,
+			Time:      time.Now().Unix(),
+			LabelIDs:  []string{pmapi.InboxLabel},
+		},
+		Message: append(enc, "\r\n"...),
+	}})
+	if err != nil {
+		return err
+	}
+
+	if len(res) == 0 {
+		return errors.New("no import response")
+	}
+
+	return res[0].Error
+}
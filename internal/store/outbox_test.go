@@ -0,0 +1,135 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestOutbox(t *testing.T) (*outbox, string, func()) {
+	dir, err := ioutil.TempDir("", "outbox-test")
+	require.NoError(t, err)
+
+	outboxDir := filepath.Join(dir, "user")
+
+	box, err := openOutbox(outboxDir, testPrivateKeyRing)
+	require.NoError(t, err)
+
+	return box, outboxDir, func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}
+}
+
+func newTestOutboxMessage() *OutboxMessage {
+	return &OutboxMessage{
+		Username:   "user@pm.me",
+		ReturnPath: "user@pm.me",
+		To:         []string{"friend@example.com"},
+		Literal:    []byte(testCachedLiteral),
+	}
+}
+
+func TestOutboxQueueAndList(t *testing.T) {
+	box, dir, cleanup := newTestOutbox(t)
+	defer cleanup()
+
+	first, second := newTestOutboxMessage(), newTestOutboxMessage()
+	require.NoError(t, box.queue(first))
+	require.NoError(t, box.queue(second))
+	assert.NotEmpty(t, first.ID)
+	assert.NotEqual(t, first.ID, second.ID)
+
+	msgs, err := box.list()
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, first.ID, msgs[0].ID)
+	assert.Equal(t, second.ID, msgs[1].ID)
+	assert.Equal(t, []string{"friend@example.com"}, msgs[0].To)
+	assert.Equal(t, testCachedLiteral, string(msgs[0].Literal))
+
+	// Neither the recipients nor the content can be seen on disk.
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	for _, file := range files {
+		content, err := ioutil.ReadFile(filepath.Join(dir, file.Name())) //nolint[gosec]
+		require.NoError(t, err)
+		assert.NotContains(t, string(content), "Secret")
+		assert.NotContains(t, string(content), "friend@example.com")
+	}
+}
+
+func TestOutboxUpdateAndRemove(t *testing.T) {
+	box, _, cleanup := newTestOutbox(t)
+	defer cleanup()
+
+	msg := newTestOutboxMessage()
+	require.NoError(t, box.queue(msg))
+
+	msg.Attempts = 2
+	msg.LastError = "failure"
+	require.NoError(t, box.update(msg))
+
+	msgs, err := box.list()
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, 2, msgs[0].Attempts)
+	assert.Equal(t, "failure", msgs[0].LastError)
+
+	require.NoError(t, box.remove(msg.ID))
+	require.Error(t, box.update(msg))
+
+	msgs, err = box.list()
+	require.NoError(t, err)
+	assert.Empty(t, msgs)
+}
+
+func TestOutboxIsKeptAfterReopen(t *testing.T) {
+	box, dir, cleanup := newTestOutbox(t)
+	defer cleanup()
+
+	require.NoError(t, box.queue(newTestOutboxMessage()))
+
+	box, err := openOutbox(dir, testPrivateKeyRing)
+	require.NoError(t, err)
+
+	msgs, err := box.list()
+	require.NoError(t, err)
+	assert.Len(t, msgs, 1)
+}
+
+func TestOutboxWrongKey(t *testing.T) {
+	box, dir, cleanup := newTestOutbox(t)
+	defer cleanup()
+
+	msg := newTestOutboxMessage()
+	require.NoError(t, box.queue(msg))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, outboxKeyFile), []byte("broken"), 0600))
+
+	_, err := openOutbox(dir, testPrivateKeyRing)
+	require.Error(t, err)
+
+	// Queued messages must not be dropped.
+	_, err = os.Stat(box.path(msg.ID))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,428 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	pkgMsg "github.com/ProtonMail/proton-bridge/pkg/message"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/emersion/go-imap"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// savedSearchLabelIDPrefix distinguishes mailboxes of saved searches from
+// mailboxes of API labels. It cannot collide with any API label ID.
+const savedSearchLabelIDPrefix = "saved-search:"
+
+// ErrSavedSearchOpNotAllowed is returned when user tries to change content
+// of a saved search mailbox, which is read-only.
+var ErrSavedSearchOpNotAllowed = errors.New("operation not allowed for saved search mailbox")
+
+// SavedSearch is a search exposed over IMAP as read-only virtual mailbox
+// under the Searches root. Its content is computed from local metadata and
+// kept up to date by the event loop the same way as any other mailbox.
+//
+// Messages are matched by Filter, by Query being IMAP SEARCH expression
+// (e.g. `UNSEEN FROM boss@pm.me`), or by both. Local metadata contains no
+// message content, therefore full-text criteria (Keyword of the filter,
+// BODY and TEXT of the query) are not supported. Paging and sorting fields
+// of the filter are ignored.
+type SavedSearch struct {
+	Name   string
+	Filter *pmapi.MessagesFilter `json:",omitempty"`
+	Query  string                `json:",omitempty"`
+
+	criteria *imap.SearchCriteria
+}
+
+// init validates the saved search and parses its query.
+func (search *SavedSearch) init() error {
+	if search.Name == "" {
+		return errors.New("saved search name cannot be empty")
+	}
+	if strings.Contains(search.Name, PathDelimiter) {
+		return fmt.Errorf("saved search name cannot contain %q", PathDelimiter)
+	}
+	if search.Filter == nil && search.Query == "" {
+		return errors.New("saved search needs filter or query")
+	}
+
+	if search.Filter != nil && search.Filter.Keyword != "" {
+		return errors.New("keyword is not supported by saved searches")
+	}
+
+	if search.Query == "" {
+		search.criteria = nil
+		return nil
+	}
+
+	criteria, err := parseSearchQuery(search.Query)
+	if err != nil {
+		return errors.Wrap(err, "invalid search query")
+	}
+	if err := validateSearchCriteria(criteria); err != nil {
+		return err
+	}
+	search.criteria = criteria
+	return nil
+}
+
+// labelID returns ID used for mailboxes of the saved search.
+func (search *SavedSearch) labelID() string {
+	return savedSearchLabelIDPrefix + search.Name
+}
+
+// matches returns whether the message belongs to the saved search.
+func (search *SavedSearch) matches(msg *pmapi.Message) bool {
+	if search.Filter != nil && !matchMessagesFilter(search.Filter, msg) {
+		return false
+	}
+	if search.criteria != nil && !matchSearchCriteria(search.criteria, msg) {
+		return false
+	}
+	return true
+}
+
+// parseSearchQuery parses IMAP SEARCH expression, i.e., arguments of SEARCH
+// command without CHARSET.
+func parseSearchQuery(query string) (*imap.SearchCriteria, error) {
+	r := imap.NewReader(bufio.NewReader(strings.NewReader(query + "\r\n")))
+	fields, err := r.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+
+	criteria := imap.NewSearchCriteria()
+	if err := criteria.ParseWithCharset(fields, nil); err != nil {
+		return nil, err
+	}
+	return criteria, nil
+}
+
+// validateSearchCriteria returns error for criteria which cannot be
+// evaluated from local metadata or without selected mailbox.
+func validateSearchCriteria(criteria *imap.SearchCriteria) error {
+	if criteria.SeqNum != nil || criteria.Uid != nil {
+		return errors.New("sequence sets are not supported by saved searches")
+	}
+	if len(criteria.Body) != 0 || len(criteria.Text) != 0 {
+		return errors.New("BODY and TEXT are not supported by saved searches")
+	}
+	for _, flag := range append(criteria.WithFlags, criteria.WithoutFlags...) {
+		if flag == imap.DeletedFlag || flag == imap.RecentFlag {
+			return fmt.Errorf("flag %v is not supported by saved searches", flag)
+		}
+	}
+	for _, not := range criteria.Not {
+		if err := validateSearchCriteria(not); err != nil {
+			return err
+		}
+	}
+	for _, or := range criteria.Or {
+		for _, c := range or {
+			if err := validateSearchCriteria(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// matchMessagesFilter evaluates the filter the same way as API would do,
+// only without full-text search. To matches any recipient.
+func matchMessagesFilter(filter *pmapi.MessagesFilter, msg *pmapi.Message) bool { //nolint[gocyclo]
+	if filter.LabelID != "" && !msg.HasLabelID(filter.LabelID) {
+		return false
+	}
+	if filter.AddressID != "" && msg.AddressID != filter.AddressID {
+		return false
+	}
+	if filter.ConversationID != "" && msg.ConversationID != filter.ConversationID {
+		return false
+	}
+	if filter.ExternalID != "" && msg.ExternalID != filter.ExternalID {
+		return false
+	}
+	if len(filter.ID) != 0 && !hasID(filter.ID, msg.ID) {
+		return false
+	}
+	if filter.Begin != 0 && msg.Time < filter.Begin {
+		return false
+	}
+	if filter.End != 0 && msg.Time > filter.End {
+		return false
+	}
+	if filter.Unread != nil && bool(msg.Unread) != *filter.Unread {
+		return false
+	}
+	if filter.Attachments != nil && (msg.NumAttachments > 0) != *filter.Attachments {
+		return false
+	}
+	if filter.Subject != "" && !containsFold(msg.Subject, filter.Subject) {
+		return false
+	}
+	if filter.From != "" && !matchAddresses([]*mail.Address{msg.Sender}, filter.From) {
+		return false
+	}
+	if filter.To != "" && !matchAddresses(append(append(msg.ToList, msg.CCList...), msg.BCCList...), filter.To) {
+		return false
+	}
+	return true
+}
+
+// matchSearchCriteria evaluates IMAP search criteria against message
+// metadata. Criteria are validated by validateSearchCriteria before.
+func matchSearchCriteria(criteria *imap.SearchCriteria, msg *pmapi.Message) bool { //nolint[gocyclo,funlen]
+	if !criteria.Before.IsZero() && msg.Time > criteria.Before.Truncate(24*time.Hour).Unix() {
+		return false
+	}
+	if !criteria.Since.IsZero() && msg.Time < criteria.Since.Truncate(24*time.Hour).Unix() {
+		return false
+	}
+
+	if !criteria.SentBefore.IsZero() || !criteria.SentSince.IsZero() {
+		t, err := msg.Header.Date()
+		if err != nil || t.IsZero() {
+			t = time.Unix(msg.Time, 0)
+		}
+		if !criteria.SentBefore.IsZero() && t.Unix() > criteria.SentBefore.Truncate(24*time.Hour).Unix() {
+			return false
+		}
+		if !criteria.SentSince.IsZero() && t.Unix() < criteria.SentSince.Truncate(24*time.Hour).Unix() {
+			return false
+		}
+	}
+
+	for key, values := range criteria.Header {
+		for _, value := range values {
+			if value != "" && !matchSearchHeader(msg, key, value) {
+				return false
+			}
+		}
+	}
+
+	flags := map[string]bool{}
+	for _, flag := range pkgMsg.GetFlags(msg) {
+		flags[flag] = true
+	}
+	for _, flag := range criteria.WithFlags {
+		if !flags[flag] {
+			return false
+		}
+	}
+	for _, flag := range criteria.WithoutFlags {
+		if flags[flag] {
+			return false
+		}
+	}
+
+	// Size is known only once the message was built.
+	if msg.Size > 0 {
+		if criteria.Larger != 0 && msg.Size <= int64(criteria.Larger) {
+			return false
+		}
+		if criteria.Smaller != 0 && msg.Size >= int64(criteria.Smaller) {
+			return false
+		}
+	}
+
+	for _, not := range criteria.Not {
+		if matchSearchCriteria(not, msg) {
+			return false
+		}
+	}
+	for _, or := range criteria.Or {
+		if !matchSearchCriteria(or[0], msg) && !matchSearchCriteria(or[1], msg) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchSearchHeader(msg *pmapi.Message, key, value string) bool {
+	switch key {
+	case "Subject":
+		return containsFold(msg.Subject, value)
+	case "From":
+		return matchAddresses([]*mail.Address{msg.Sender}, value)
+	case "To":
+		return matchAddresses(msg.ToList, value)
+	case "Cc":
+		return matchAddresses(msg.CCList, value)
+	case "Bcc":
+		return matchAddresses(msg.BCCList, value)
+	default:
+		headerValue := msg.Header.Get(key)
+		return headerValue != "" && containsFold(headerValue, value)
+	}
+}
+
+func hasID(ids []string, id string) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAddresses(addresses []*mail.Address, value string) bool {
+	for _, address := range addresses {
+		if address == nil {
+			continue
+		}
+		if containsFold(address.Address, value) || containsFold(address.Name, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// loadSavedSearches loads saved searches from the database. It has to be
+// called before addresses are initialised to create their mailboxes.
+func (store *Store) loadSavedSearches() error {
+	store.savedSearches = make(map[string]*SavedSearch)
+
+	return store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(savedSearchesBucket).ForEach(func(k, v []byte) error {
+			search := &SavedSearch{}
+			if err := json.Unmarshal(v, search); err != nil {
+				return err
+			}
+			if err := search.init(); err != nil {
+				store.log.WithError(err).WithField("name", search.Name).Warn("Skipping invalid saved search")
+				return nil
+			}
+			store.savedSearches[search.Name] = search
+			return nil
+		})
+	})
+}
+
+// ListSavedSearches returns all saved searches ordered by name.
+func (store *Store) ListSavedSearches() []SavedSearch {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	searches := make([]SavedSearch, 0, len(store.savedSearches))
+	for _, search := range store.savedSearches {
+		searches = append(searches, *search)
+	}
+	sort.Slice(searches, func(i, j int) bool {
+		return searches[i].Name < searches[j].Name
+	})
+	return searches
+}
+
+// AddSavedSearch stores the search and creates its mailbox for every
+// address with already synced messages matching the search.
+func (store *Store) AddSavedSearch(search SavedSearch) error {
+	if err := search.init(); err != nil {
+		return err
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if _, ok := store.savedSearches[search.Name]; ok {
+		return fmt.Errorf("saved search %q already exists", search.Name)
+	}
+
+	mailboxes := map[*Address]*Mailbox{}
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		raw, err := json.Marshal(&search)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(savedSearchesBucket).Put([]byte(search.Name), raw); err != nil {
+			return err
+		}
+
+		for _, a := range store.addresses {
+			mailbox, err := a.txNewSavedSearchMailbox(tx, &search)
+			if err != nil {
+				return err
+			}
+			mailboxes[a] = mailbox
+		}
+
+		return tx.Bucket(metadataBucket).ForEach(func(k, v []byte) error {
+			msg := &pmapi.Message{}
+			if err := json.Unmarshal(v, msg); err != nil {
+				return err
+			}
+			if !search.matches(msg) {
+				return nil
+			}
+			for _, mailbox := range mailboxes {
+				if err := mailbox.txCreateOrUpdateMessages(tx, []*pmapi.Message{msg}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return errors.Wrap(err, "cannot create saved search")
+	}
+
+	store.savedSearches[search.Name] = &search
+	for a, mailbox := range mailboxes {
+		a.mailboxes[mailbox.labelID] = mailbox
+		store.notifyMailboxCreated(a.address, mailbox.labelName)
+	}
+	return nil
+}
+
+// RemoveSavedSearch removes the search together with its mailboxes.
+func (store *Store) RemoveSavedSearch(name string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	search, ok := store.savedSearches[name]
+	if !ok {
+		return fmt.Errorf("saved search %q does not exist", name)
+	}
+
+	if err := store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(savedSearchesBucket).Delete([]byte(name))
+	}); err != nil {
+		return err
+	}
+
+	delete(store.savedSearches, name)
+	for _, a := range store.addresses {
+		if err := a.deleteMailboxEvent(search.labelID()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
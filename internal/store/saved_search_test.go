@@ -0,0 +1,200 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSavedSearchInit(t *testing.T) {
+	unread := true
+	tests := []struct {
+		search  SavedSearch
+		wantErr bool
+	}{
+		{SavedSearch{Name: "Unread", Query: "UNSEEN"}, false},
+		{SavedSearch{Name: "Unread", Filter: &pmapi.MessagesFilter{Unread: &unread}}, false},
+		{SavedSearch{Name: "Both", Filter: &pmapi.MessagesFilter{From: "boss"}, Query: "UNSEEN"}, false},
+		{SavedSearch{Name: "Nested", Query: `OR FROM boss NOT SUBJECT "re:"`}, false},
+		{SavedSearch{Name: "", Query: "UNSEEN"}, true},
+		{SavedSearch{Name: "a/b", Query: "UNSEEN"}, true},
+		{SavedSearch{Name: "Empty"}, true},
+		{SavedSearch{Name: "Keyword", Filter: &pmapi.MessagesFilter{Keyword: "invoice"}}, true},
+		{SavedSearch{Name: "Invalid", Query: "UNKNOWN"}, true},
+		{SavedSearch{Name: "Body", Query: "BODY invoice"}, true},
+		{SavedSearch{Name: "Text", Query: "NOT TEXT invoice"}, true},
+		{SavedSearch{Name: "UID", Query: "UID 1:5"}, true},
+		{SavedSearch{Name: "Deleted", Query: "DELETED"}, true},
+	}
+	for _, tc := range tests {
+		search := tc.search
+		err := search.init()
+		if tc.wantErr {
+			require.Error(t, err, "%+v", tc.search)
+		} else {
+			require.NoError(t, err, "%+v", tc.search)
+		}
+	}
+}
+
+func TestSavedSearchMatches(t *testing.T) { //nolint[funlen]
+	now := time.Date(2021, 6, 15, 12, 0, 0, 0, time.UTC)
+	msg := &pmapi.Message{
+		ID:             "msg1",
+		AddressID:      "addr1",
+		ConversationID: "conv1",
+		Subject:        "Quarterly report",
+		Sender:         &mail.Address{Name: "The Boss", Address: "boss@example.com"},
+		ToList:         []*mail.Address{{Address: "me@pm.me"}},
+		CCList:         []*mail.Address{{Address: "team@example.com"}},
+		Unread:         true,
+		Flags:          pmapi.FlagReceived,
+		LabelIDs:       []string{pmapi.InboxLabel, pmapi.AllMailLabel, pmapi.StarredLabel},
+		Time:           now.Unix(),
+		Size:           2000,
+		NumAttachments: 1,
+		Header:         mail.Header{"X-Priority": []string{"1"}},
+	}
+
+	yes, no := true, false
+	filters := []struct {
+		filter *pmapi.MessagesFilter
+		want   bool
+	}{
+		{&pmapi.MessagesFilter{}, true},
+		{&pmapi.MessagesFilter{LabelID: pmapi.InboxLabel}, true},
+		{&pmapi.MessagesFilter{LabelID: pmapi.ArchiveLabel}, false},
+		{&pmapi.MessagesFilter{AddressID: "addr2"}, false},
+		{&pmapi.MessagesFilter{ConversationID: "conv1"}, true},
+		{&pmapi.MessagesFilter{ID: []string{"msg2", "msg1"}}, true},
+		{&pmapi.MessagesFilter{From: "BOSS"}, true},
+		{&pmapi.MessagesFilter{From: "me@pm.me"}, false},
+		{&pmapi.MessagesFilter{To: "team@"}, true},
+		{&pmapi.MessagesFilter{Subject: "report"}, true},
+		{&pmapi.MessagesFilter{Unread: &yes}, true},
+		{&pmapi.MessagesFilter{Unread: &no}, false},
+		{&pmapi.MessagesFilter{Attachments: &no}, false},
+		{&pmapi.MessagesFilter{Begin: now.Add(-time.Hour).Unix(), End: now.Add(time.Hour).Unix()}, true},
+		{&pmapi.MessagesFilter{Begin: now.Add(time.Hour).Unix()}, false},
+	}
+	for _, tc := range filters {
+		search := SavedSearch{Name: "test", Filter: tc.filter}
+		require.NoError(t, search.init())
+		require.Equal(t, tc.want, search.matches(msg), "%+v", tc.filter)
+	}
+
+	queries := []struct {
+		query string
+		want  bool
+	}{
+		{"ALL", true},
+		{"UNSEEN", true},
+		{"SEEN", false},
+		{"FLAGGED UNANSWERED", true},
+		{"DRAFT", false},
+		{"FROM boss", true},
+		{"FROM boss TO other", false},
+		{"CC team", true},
+		{`SUBJECT "quarterly"`, true},
+		{"HEADER X-Priority 1", true},
+		{"HEADER X-Mailer foo", false},
+		{"SINCE 1-Jun-2021 BEFORE 1-Jul-2021", true},
+		{"SINCE 1-Jul-2021", false},
+		{"LARGER 1000", true},
+		{"SMALLER 1000", false},
+		{"NOT FROM boss", false},
+		{"OR FROM nobody SUBJECT report", true},
+		{"OR FROM nobody SUBJECT invoice", false},
+	}
+	for _, tc := range queries {
+		search := SavedSearch{Name: "test", Query: tc.query}
+		require.NoError(t, search.init(), tc.query)
+		require.Equal(t, tc.want, search.matches(msg), tc.query)
+	}
+}
+
+func TestSavedSearchMailbox(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	insertMessage(t, m, "msg1", "Test message 1", "boss@example.com", true, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	insertMessage(t, m, "msg2", "Test message 2", "boss@example.com", false, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	insertMessage(t, m, "msg3", "Test message 3", "other@example.com", true, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+
+	require.NoError(t, m.store.AddSavedSearch(SavedSearch{Name: "Unread from boss", Query: "UNSEEN FROM boss"}))
+	require.Error(t, m.store.AddSavedSearch(SavedSearch{Name: "Unread from boss", Query: "UNSEEN"}))
+
+	labelID := savedSearchLabelIDPrefix + "Unread from boss"
+	checkMailboxMessageIDs(t, m, labelID, []wantID{{"msg1", 1}})
+
+	// New messages are matched as they arrive.
+	insertMessage(t, m, "msg4", "Test message 4", "boss@example.com", true, []string{pmapi.AllMailLabel, pmapi.ArchiveLabel})
+	checkMailboxMessageIDs(t, m, labelID, []wantID{{"msg1", 1}, {"msg4", 2}})
+
+	// Messages no longer matching are removed.
+	insertMessage(t, m, "msg1", "Test message 1", "boss@example.com", false, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	checkMailboxMessageIDs(t, m, labelID, []wantID{{"msg4", 2}})
+
+	mailbox, err := m.store.addresses[addrID1].GetMailbox("Searches/Unread from boss")
+	require.NoError(t, err)
+	require.True(t, mailbox.IsSearch())
+	require.Equal(t, ErrSavedSearchOpNotAllowed, mailbox.LabelMessages([]string{"msg3"}))
+	require.Equal(t, ErrSavedSearchOpNotAllowed, mailbox.MarkMessagesDeleted([]string{"msg4"}))
+	require.Equal(t, ErrSavedSearchOpNotAllowed, mailbox.Rename("Searches/Other"))
+
+	require.Equal(t, []SavedSearch{{Name: "Unread from boss", Query: "UNSEEN FROM boss"}}, withoutCriteria(m.store.ListSavedSearches()))
+
+	require.NoError(t, mailbox.Delete())
+	require.Empty(t, m.store.ListSavedSearches())
+	_, err = m.store.addresses[addrID1].GetMailbox("Searches/Unread from boss")
+	require.Error(t, err)
+}
+
+func TestSavedSearchIsLoaded(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	insertMessage(t, m, "msg1", "Test message 1", "boss@example.com", true, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	require.NoError(t, m.store.AddSavedSearch(SavedSearch{Name: "Unread", Query: "UNSEEN"}))
+
+	// Reload saved searches and addresses the same way as on start.
+	m.client.EXPECT().CountMessages(gomock.Any(), "")
+	m.client.EXPECT().Addresses().Return(pmapi.AddressList{
+		{ID: addrID1, Email: addr1, Type: pmapi.OriginalAddress, Receive: true},
+	})
+	require.NoError(t, m.store.RebuildMailboxes())
+
+	checkMailboxMessageIDs(t, m, savedSearchLabelIDPrefix+"Unread", []wantID{{"msg1", 1}})
+}
+
+func withoutCriteria(searches []SavedSearch) []SavedSearch {
+	for i := range searches {
+		searches[i].criteria = nil
+	}
+	return searches
+}
@@ -0,0 +1,618 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	searchTermHashLength = 16
+	searchDocNumLength   = 4
+
+	minSearchTokenLength = 2
+	maxSearchTokenLength = 64
+
+	searchTermKindHeader = 'h'
+	searchTermKindBody   = 'b'
+
+	searchDocHeaderOnly = 'h'
+
+	searchGramLength       = 3
+	searchGramHashCount    = 7
+	searchGramBitsPerGram  = 10
+	minSearchGramsBitCount = 512
+)
+
+var (
+	// Search index database structure:
+	// * index_info
+	//   * key -> random index key encrypted by the user's primary address keyring
+	// * docs
+	//   * {messageID} -> uint32 document number, followed by 'h' if only
+	//     the header of the message is indexed
+	// * doc_ids
+	//   * {document number} -> string messageID
+	// * doc_terms
+	//   * {document number} -> encrypted list of term hashes of the document
+	// * postings
+	//   * {term hash}{document number} -> empty value
+	// * doc_grams
+	//   * {document number} -> bloom filter of trigrams of the document words
+	searchIndexInfoBucket = []byte("index_info") //nolint[gochecknoglobals]
+	searchDocsBucket      = []byte("docs")       //nolint[gochecknoglobals]
+	searchDocIDsBucket    = []byte("doc_ids")    //nolint[gochecknoglobals]
+	searchDocTermsBucket  = []byte("doc_terms")  //nolint[gochecknoglobals]
+	searchPostingsBucket  = []byte("postings")   //nolint[gochecknoglobals]
+	searchDocGramsBucket  = []byte("doc_grams")  //nolint[gochecknoglobals]
+
+	searchIndexKeyKey = []byte("key") //nolint[gochecknoglobals]
+
+	// ErrSearchIndexUnavailable is returned when the query cannot be answered
+	// by the search index, either because it is disabled or because the query
+	// does not contain any word long enough to be looked up.
+	ErrSearchIndexUnavailable = errors.New("search index unavailable") //nolint[gochecknoglobals]
+)
+
+// searchIndex is an inverted index of message words stored in its own bolt
+// database next to the store database. Words are stored only as keyed hashes
+// and the list of words of each message is encrypted, so the index does not
+// reveal the content of messages without the user's keys. Words are looked
+// up in postings; for parts of words, each message also has a bloom filter of
+// trigrams of its words, which are hashed by the same key.
+type searchIndex struct {
+	db      *bolt.DB
+	termKey []byte
+	docAEAD cipher.AEAD
+}
+
+// getSearchIndexPath returns the path of the search index database which
+// belongs to the store database at the given path.
+func getSearchIndexPath(storePath string) string {
+	return strings.TrimSuffix(storePath, filepath.Ext(storePath)) + "-search.db"
+}
+
+// openSearchIndex opens or creates the search index at the given path. The
+// index key is encrypted by the keyring. When the key cannot be decrypted
+// (for example, the keys were changed) or the index was created by an older
+// version without trigrams, the index is dropped and a new one is started.
+func openSearchIndex(path string, kr *crypto.KeyRing) (*searchIndex, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open search index database")
+	}
+
+	var key []byte
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if key, err = txGetSearchIndexKey(tx, kr); err == nil {
+			if tx.Bucket(searchDocGramsBucket) != nil {
+				return nil
+			}
+			err = errors.New("missing document grams")
+		}
+
+		log.WithError(err).Warn("Cannot use search index, dropping search index")
+
+		key, err = txResetSearchIndex(tx, kr)
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	docAEAD, err := newLocalAEAD(deriveLocalKey(key, "doc"))
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &searchIndex{
+		db:      db,
+		termKey: deriveLocalKey(key, "term"),
+		docAEAD: docAEAD,
+	}, nil
+}
+
+func txGetSearchIndexKey(tx *bolt.Tx, kr *crypto.KeyRing) ([]byte, error) {
+	b := tx.Bucket(searchIndexInfoBucket)
+	if b == nil {
+		return nil, errors.New("missing index info")
+	}
+
+	encKey := b.Get(searchIndexKeyKey)
+	if encKey == nil {
+		return nil, errors.New("missing index key")
+	}
+
+	return decryptLocalKey(kr, encKey)
+}
+
+// txResetSearchIndex removes all indexed data and generates a new index key.
+func txResetSearchIndex(tx *bolt.Tx, kr *crypto.KeyRing) ([]byte, error) {
+	buckets := [][]byte{
+		searchIndexInfoBucket,
+		searchDocsBucket,
+		searchDocIDsBucket,
+		searchDocTermsBucket,
+		searchPostingsBucket,
+		searchDocGramsBucket,
+	}
+
+	for _, bucket := range buckets {
+		if err := tx.DeleteBucket(bucket); err != nil && err != bolt.ErrBucketNotFound {
+			return nil, errors.Wrap(err, string(bucket))
+		}
+		if _, err := tx.CreateBucket(bucket); err != nil {
+			return nil, errors.Wrap(err, string(bucket))
+		}
+	}
+
+	key, encKey, err := generateLocalKey(kr)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, tx.Bucket(searchIndexInfoBucket).Put(searchIndexKeyKey, encKey)
+}
+
+func (index *searchIndex) close() error {
+	return index.db.Close()
+}
+
+// termHash returns the keyed hash under which the term is stored.
+func (index *searchIndex) termHash(kind byte, term string) []byte {
+	mac := hmac.New(sha256.New, index.termKey)
+	_, _ = mac.Write([]byte{kind})
+	_, _ = mac.Write([]byte(term))
+	return mac.Sum(nil)[:searchTermHashLength]
+}
+
+// gramHash returns the keyed hash of the trigram from which positions of its
+// bits in the bloom filter are derived.
+func (index *searchIndex) gramHash(kind byte, gram string) searchGramHash {
+	mac := hmac.New(sha256.New, index.termKey)
+	_, _ = mac.Write([]byte{'g', kind})
+	_, _ = mac.Write([]byte(gram))
+	sum := mac.Sum(nil)
+	return searchGramHash{
+		h1: binary.BigEndian.Uint32(sum[0:4]),
+		h2: binary.BigEndian.Uint32(sum[4:8]) | 1,
+	}
+}
+
+// isIndexed returns whether the message is present in the index.
+func (index *searchIndex) isIndexed(apiID string) (isIndexed bool) {
+	_ = index.db.View(func(tx *bolt.Tx) error {
+		isIndexed = tx.Bucket(searchDocsBucket).Get([]byte(apiID)) != nil
+		return nil
+	})
+	return
+}
+
+// isSearchable returns whether both header and body of the message are
+// present in the index. Messages indexed only by their header (because they
+// could not be decrypted or have text attachments) cannot be filtered out by
+// the index.
+func (index *searchIndex) isSearchable(apiID string) (isSearchable bool) {
+	_ = index.db.View(func(tx *bolt.Tx) error {
+		isSearchable = len(tx.Bucket(searchDocsBucket).Get([]byte(apiID))) == searchDocNumLength
+		return nil
+	})
+	return
+}
+
+// getIndexedIDs returns IDs of all messages present in the index.
+func (index *searchIndex) getIndexedIDs() (apiIDs map[string]bool, err error) {
+	apiIDs = make(map[string]bool)
+	err = index.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(searchDocsBucket).ForEach(func(k, _ []byte) error {
+			apiIDs[string(k)] = true
+			return nil
+		})
+	})
+	return
+}
+
+// addMessage indexes words of the message header and body. Previously
+// indexed words of the same message are replaced. If isHeaderOnly is set,
+// the body is not known and the message is not searchable by its content.
+func (index *searchIndex) addMessage(apiID, header, body string, isHeaderOnly bool) error {
+	var hashes [][]byte
+	for _, term := range tokenizeSearchText(header) {
+		hashes = append(hashes, index.termHash(searchTermKindHeader, term))
+	}
+	for _, term := range tokenizeSearchText(body) {
+		hashes = append(hashes, index.termHash(searchTermKindBody, term))
+	}
+
+	headerGrams := getSearchGrams(header)
+	bodyGrams := getSearchGrams(body)
+
+	grams := newSearchGramFilter(len(headerGrams) + len(bodyGrams))
+	for _, gram := range headerGrams {
+		grams.add(index.gramHash(searchTermKindHeader, gram))
+	}
+	for _, gram := range bodyGrams {
+		grams.add(index.gramHash(searchTermKindBody, gram))
+	}
+
+	encHashes, err := sealLocalData(index.docAEAD, bytes.Join(hashes, nil))
+	if err != nil {
+		return err
+	}
+
+	return index.db.Update(func(tx *bolt.Tx) error {
+		if err := index.txRemoveMessage(tx, apiID); err != nil {
+			return err
+		}
+
+		docIDsBucket := tx.Bucket(searchDocIDsBucket)
+		seq, err := docIDsBucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		docNum := itob(uint32(seq))
+
+		docValue := docNum
+		if isHeaderOnly {
+			docValue = append(append([]byte{}, docNum...), searchDocHeaderOnly)
+		}
+
+		if err := tx.Bucket(searchDocsBucket).Put([]byte(apiID), docValue); err != nil {
+			return err
+		}
+		if err := docIDsBucket.Put(docNum, []byte(apiID)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(searchDocTermsBucket).Put(docNum, encHashes); err != nil {
+			return err
+		}
+		if err := tx.Bucket(searchDocGramsBucket).Put(docNum, grams); err != nil {
+			return err
+		}
+
+		postingsBucket := tx.Bucket(searchPostingsBucket)
+		for _, hash := range hashes {
+			if err := postingsBucket.Put(append(hash, docNum...), []byte{}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// removeMessages removes messages from the index.
+func (index *searchIndex) removeMessages(apiIDs []string) error {
+	return index.db.Update(func(tx *bolt.Tx) error {
+		for _, apiID := range apiIDs {
+			if err := index.txRemoveMessage(tx, apiID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (index *searchIndex) txRemoveMessage(tx *bolt.Tx, apiID string) error {
+	docsBucket := tx.Bucket(searchDocsBucket)
+
+	docValue := docsBucket.Get([]byte(apiID))
+	if len(docValue) < searchDocNumLength {
+		return nil
+	}
+	docNum := append([]byte{}, docValue[:searchDocNumLength]...)
+
+	docTermsBucket := tx.Bucket(searchDocTermsBucket)
+	if encHashes := docTermsBucket.Get(docNum); encHashes != nil {
+		hashes, err := openLocalData(index.docAEAD, encHashes)
+		if err != nil {
+			return errors.Wrap(err, "failed to decrypt message terms")
+		}
+
+		postingsBucket := tx.Bucket(searchPostingsBucket)
+		for i := 0; i+searchTermHashLength <= len(hashes); i += searchTermHashLength {
+			key := append(append([]byte{}, hashes[i:i+searchTermHashLength]...), docNum...)
+			if err := postingsBucket.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := docTermsBucket.Delete(docNum); err != nil {
+		return err
+	}
+	if err := tx.Bucket(searchDocGramsBucket).Delete(docNum); err != nil {
+		return err
+	}
+	if err := tx.Bucket(searchDocIDsBucket).Delete(docNum); err != nil {
+		return err
+	}
+	return docsBucket.Delete([]byte(apiID))
+}
+
+// search returns IDs of messages which contain all complete words of the
+// query in their body or, if withHeader is set, in their body or header.
+// Parts of words at the edges of the query narrow the result down further
+// by trigrams of the message words. The result is only a set of candidates;
+// the query still needs to be matched against the content of each of them.
+func (index *searchIndex) search(query string, withHeader bool) (apiIDs map[string]bool, err error) {
+	terms, fragments, ok := tokenizeSearchQuery(query)
+	if !ok {
+		return nil, ErrSearchIndexUnavailable
+	}
+
+	kinds := []byte{searchTermKindBody}
+	if withHeader {
+		kinds = append(kinds, searchTermKindHeader)
+	}
+
+	// Hashes of trigrams of each fragment for each kind of terms.
+	fragmentGrams := make([][][]searchGramHash, len(fragments))
+	for i, fragment := range fragments {
+		for _, kind := range kinds {
+			var hashes []searchGramHash
+			for _, gram := range getSearchWordGrams(fragment) {
+				hashes = append(hashes, index.gramHash(kind, gram))
+			}
+			fragmentGrams[i] = append(fragmentGrams[i], hashes)
+		}
+	}
+
+	err = index.db.View(func(tx *bolt.Tx) error {
+		var docNums map[string]bool
+		if len(terms) > 0 {
+			docNums = index.txSearchTerms(tx, terms, kinds)
+		} else {
+			docNums = txGetAllDocNums(tx)
+		}
+
+		docGramsBucket := tx.Bucket(searchDocGramsBucket)
+		for docNum := range docNums {
+			grams := searchGramFilter(docGramsBucket.Get([]byte(docNum)))
+			if grams != nil && !grams.containsAll(fragmentGrams) {
+				delete(docNums, docNum)
+			}
+		}
+
+		apiIDs = make(map[string]bool, len(docNums))
+		docIDsBucket := tx.Bucket(searchDocIDsBucket)
+		for docNum := range docNums {
+			if apiID := docIDsBucket.Get([]byte(docNum)); apiID != nil {
+				apiIDs[string(apiID)] = true
+			}
+		}
+
+		return nil
+	})
+
+	return apiIDs, err
+}
+
+// txSearchTerms returns document numbers of documents which contain all
+// the terms as any of the kinds.
+func (index *searchIndex) txSearchTerms(tx *bolt.Tx, terms []string, kinds []byte) (docNums map[string]bool) {
+	for _, term := range terms {
+		var termDocNums map[string]bool
+		for _, kind := range kinds {
+			termDocNums = index.txGetPostings(tx, kind, term, termDocNums)
+		}
+
+		if docNums == nil {
+			docNums = termDocNums
+			continue
+		}
+		for docNum := range docNums {
+			if !termDocNums[docNum] {
+				delete(docNums, docNum)
+			}
+		}
+	}
+
+	return docNums
+}
+
+func txGetAllDocNums(tx *bolt.Tx) map[string]bool {
+	docNums := make(map[string]bool)
+	_ = tx.Bucket(searchDocIDsBucket).ForEach(func(k, _ []byte) error {
+		docNums[string(k)] = true
+		return nil
+	})
+	return docNums
+}
+
+func (index *searchIndex) txGetPostings(tx *bolt.Tx, kind byte, term string, docNums map[string]bool) map[string]bool {
+	if docNums == nil {
+		docNums = make(map[string]bool)
+	}
+
+	prefix := index.termHash(kind, term)
+	c := tx.Bucket(searchPostingsBucket).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		if len(k) == searchTermHashLength+searchDocNumLength {
+			docNums[string(k[searchTermHashLength:])] = true
+		}
+	}
+
+	return docNums
+}
+
+// tokenizeSearchText splits the text into unique lower-cased words. Words
+// which are too short are skipped and too long ones are truncated.
+func tokenizeSearchText(text string) (terms []string) {
+	seen := make(map[string]bool)
+	for _, term := range splitSearchText(text) {
+		if utf8.RuneCountInString(term) < minSearchTokenLength {
+			continue
+		}
+		term = truncateSearchToken(term)
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return
+}
+
+// tokenizeSearchQuery splits the query into words in the same way as the
+// indexed text. Complete words, i.e., words which cannot be a part of a longer
+// word of a matching message, are returned as terms. The query is matched as
+// a substring, so the first and the last word are complete only when the
+// query starts or ends with a separator; otherwise they are returned as
+// fragments which can be looked up only by their trigrams. Words too short to
+// be looked up are skipped. It returns false if there is nothing to look up.
+func tokenizeSearchQuery(query string) (terms, fragments []string, ok bool) {
+	words := splitSearchText(query)
+
+	first, _ := utf8.DecodeRuneInString(query)
+	last, _ := utf8.DecodeLastRuneInString(query)
+
+	for i, term := range words {
+		isFragment := (i == 0 && !isSearchSeparator(first)) || (i == len(words)-1 && !isSearchSeparator(last))
+
+		if isFragment {
+			if utf8.RuneCountInString(term) >= searchGramLength {
+				fragments = append(fragments, term)
+			}
+			continue
+		}
+
+		if utf8.RuneCountInString(term) >= minSearchTokenLength {
+			terms = append(terms, truncateSearchToken(term))
+		}
+	}
+
+	return terms, fragments, len(terms) > 0 || len(fragments) > 0
+}
+
+func splitSearchText(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), isSearchSeparator)
+}
+
+func isSearchSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+func truncateSearchToken(term string) string {
+	if utf8.RuneCountInString(term) <= maxSearchTokenLength {
+		return term
+	}
+	return string([]rune(term)[:maxSearchTokenLength])
+}
+
+// getSearchGrams returns unique trigrams of all words of the text. Unlike
+// terms, words are not truncated, so that any part of a long word can be
+// looked up.
+func getSearchGrams(text string) (grams []string) {
+	seen := make(map[string]bool)
+	for _, word := range splitSearchText(text) {
+		for _, gram := range getSearchWordGrams(word) {
+			if !seen[gram] {
+				seen[gram] = true
+				grams = append(grams, gram)
+			}
+		}
+	}
+	return
+}
+
+func getSearchWordGrams(word string) (grams []string) {
+	runes := []rune(word)
+	for i := 0; i+searchGramLength <= len(runes); i++ {
+		grams = append(grams, string(runes[i:i+searchGramLength]))
+	}
+	return
+}
+
+type searchGramHash struct {
+	h1, h2 uint32
+}
+
+// searchGramFilter is a bloom filter of trigram hashes. Its size is chosen
+// by the number of trigrams so that false positives stay around one percent.
+type searchGramFilter []byte
+
+func newSearchGramFilter(count int) searchGramFilter {
+	bitCount := count * searchGramBitsPerGram
+	if bitCount < minSearchGramsBitCount {
+		bitCount = minSearchGramsBitCount
+	}
+	return make(searchGramFilter, (bitCount+7)/8)
+}
+
+func (filter searchGramFilter) bit(hash searchGramHash, i int) (int, byte) {
+	pos := (hash.h1 + uint32(i)*hash.h2) % uint32(len(filter)*8)
+	return int(pos / 8), 1 << (pos % 8)
+}
+
+func (filter searchGramFilter) add(hash searchGramHash) {
+	for i := 0; i < searchGramHashCount; i++ {
+		idx, mask := filter.bit(hash, i)
+		filter[idx] |= mask
+	}
+}
+
+func (filter searchGramFilter) contains(hash searchGramHash) bool {
+	for i := 0; i < searchGramHashCount; i++ {
+		if idx, mask := filter.bit(hash, i); filter[idx]&mask == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// containsAll returns whether, for every fragment, all its trigram hashes of
+// at least one kind are in the filter.
+func (filter searchGramFilter) containsAll(fragmentGrams [][][]searchGramHash) bool {
+	for _, kindGrams := range fragmentGrams {
+		found := false
+		for _, hashes := range kindGrams {
+			if filter.containsHashes(hashes) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (filter searchGramFilter) containsHashes(hashes []searchGramHash) bool {
+	for _, hash := range hashes {
+		if !filter.contains(hash) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,249 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestSearchIndex(t *testing.T) (*searchIndex, string, func()) {
+	dir, err := ioutil.TempDir("", "search-index-test")
+	require.NoError(t, err)
+
+	path := getSearchIndexPath(filepath.Join(dir, "mailbox-test.db"))
+
+	index, err := openSearchIndex(path, testPrivateKeyRing)
+	require.NoError(t, err)
+
+	return index, path, func() {
+		_ = index.close()
+		require.NoError(t, os.RemoveAll(dir))
+	}
+}
+
+func TestSearchIndexPath(t *testing.T) {
+	assert.Equal(t, filepath.Join("dir", "mailbox-user-search.db"), getSearchIndexPath(filepath.Join("dir", "mailbox-user.db")))
+}
+
+func TestSearchIndexSearch(t *testing.T) {
+	index, _, cleanup := newTestSearchIndex(t)
+	defer cleanup()
+
+	require.NoError(t, index.addMessage("msg1", "Hello\njohn@pm.me", "Lorem ipsum dolor sit amet", false))
+	require.NoError(t, index.addMessage("msg2", "Invoice\njane@pm.me", "Ipsum is paid. Žluťoučký kůň", false))
+
+	testData := []struct {
+		query      string
+		withHeader bool
+		wantIDs    map[string]bool
+	}{
+		{" ipsum ", false, map[string]bool{"msg1": true, "msg2": true}},
+		{" IPSUM ", false, map[string]bool{"msg1": true, "msg2": true}},
+		{"rem ipsum dol", false, map[string]bool{"msg1": true}},
+		{" lorem ipsum ", false, map[string]bool{"msg1": true}},
+		{"ipsum is paid!", false, map[string]bool{"msg2": true}},
+		{" žluťoučký ", false, map[string]bool{"msg2": true}},
+		{" hello ", false, map[string]bool{}},
+		{" hello ", true, map[string]bool{"msg1": true}},
+		{"<jane@pm.me>", true, map[string]bool{"msg2": true}},
+		{" hello ipsum ", true, map[string]bool{"msg1": true}},
+		{" lor ", false, map[string]bool{}},
+		{"ipsum", false, map[string]bool{"msg1": true, "msg2": true}},
+		{"lore", false, map[string]bool{"msg1": true}},
+		{"orem", false, map[string]bool{"msg1": true}},
+		{"ore", false, map[string]bool{"msg1": true}},
+		{"ouč", false, map[string]bool{"msg2": true}},
+		{"hello", false, map[string]bool{}},
+		{"hello", true, map[string]bool{"msg1": true}},
+		{"voic", true, map[string]bool{"msg2": true}},
+		{"unknown", true, map[string]bool{}},
+		{"rem ipsum", false, map[string]bool{"msg1": true}},
+		{"sum is pa", false, map[string]bool{"msg2": true}},
+		{"psum is pa", false, map[string]bool{"msg2": true}},
+	}
+
+	for _, test := range testData {
+		test := test
+		t.Run(test.query, func(t *testing.T) {
+			apiIDs, err := index.search(test.query, test.withHeader)
+			require.NoError(t, err)
+			assert.Equal(t, test.wantIDs, apiIDs)
+		})
+	}
+}
+
+func TestSearchIndexUnavailableQuery(t *testing.T) {
+	index, _, cleanup := newTestSearchIndex(t)
+	defer cleanup()
+
+	for _, query := range []string{"", " ", "a", " a ", "ab", "a b", " a-b "} {
+		_, err := index.search(query, true)
+		assert.Equal(t, ErrSearchIndexUnavailable, err, query)
+	}
+}
+
+func TestSearchIndexUpdateAndRemove(t *testing.T) {
+	index, _, cleanup := newTestSearchIndex(t)
+	defer cleanup()
+
+	require.NoError(t, index.addMessage("msg1", "", "first draft", false))
+	require.NoError(t, index.addMessage("msg1", "", "second draft", false))
+	assert.True(t, index.isIndexed("msg1"))
+
+	apiIDs, err := index.search(" first ", false)
+	require.NoError(t, err)
+	assert.Empty(t, apiIDs)
+
+	apiIDs, err = index.search(" second ", false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"msg1": true}, apiIDs)
+
+	require.NoError(t, index.removeMessages([]string{"msg1", "unknown"}))
+	assert.False(t, index.isIndexed("msg1"))
+
+	apiIDs, err = index.search(" draft ", false)
+	require.NoError(t, err)
+	assert.Empty(t, apiIDs)
+
+	indexedIDs, err := index.getIndexedIDs()
+	require.NoError(t, err)
+	assert.Empty(t, indexedIDs)
+}
+
+func TestSearchIndexHeaderOnly(t *testing.T) {
+	index, _, cleanup := newTestSearchIndex(t)
+	defer cleanup()
+
+	require.NoError(t, index.addMessage("msg1", "Subject", "", true))
+	require.NoError(t, index.addMessage("msg2", "Subject", "body", false))
+
+	assert.True(t, index.isIndexed("msg1"))
+	assert.False(t, index.isSearchable("msg1"))
+	assert.True(t, index.isSearchable("msg2"))
+
+	apiIDs, err := index.search(" subject ", true)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"msg1": true, "msg2": true}, apiIDs)
+
+	require.NoError(t, index.removeMessages([]string{"msg1"}))
+	assert.False(t, index.isIndexed("msg1"))
+}
+
+func TestTokenizeSearchQuery(t *testing.T) {
+	testData := []struct {
+		query         string
+		wantTerms     []string
+		wantFragments []string
+	}{
+		{"word", nil, []string{"word"}},
+		{"wo", nil, nil},
+		{" word ", []string{"word"}, nil},
+		{"part of words", []string{"of"}, []string{"part", "words"}},
+		{"part of a longer text", []string{"of", "longer"}, []string{"part", "text"}},
+		{"(Word)", []string{"word"}, nil},
+		{"<john@pm.me>", []string{"john", "pm", "me"}, nil},
+		{"john@pm.me", []string{"pm"}, []string{"john"}},
+	}
+
+	for _, test := range testData {
+		terms, fragments, ok := tokenizeSearchQuery(test.query)
+		assert.Equal(t, test.wantTerms, terms, test.query)
+		assert.Equal(t, test.wantFragments, fragments, test.query)
+		assert.Equal(t, test.wantTerms != nil || test.wantFragments != nil, ok, test.query)
+	}
+}
+
+func TestSearchIndexReopen(t *testing.T) {
+	index, path, cleanup := newTestSearchIndex(t)
+	defer cleanup()
+
+	require.NoError(t, index.addMessage("msg1", "", "persistent content", false))
+	require.NoError(t, index.close())
+
+	var err error
+	index, err = openSearchIndex(path, testPrivateKeyRing)
+	require.NoError(t, err)
+	defer func() { _ = index.close() }()
+
+	apiIDs, err := index.search(" persistent ", false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"msg1": true}, apiIDs)
+
+	apiIDs, err = index.search("sist", false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"msg1": true}, apiIDs)
+}
+
+func TestSearchIndexWithoutGramsIsDropped(t *testing.T) {
+	index, path, cleanup := newTestSearchIndex(t)
+	defer cleanup()
+
+	require.NoError(t, index.addMessage("msg1", "", "content", false))
+	require.NoError(t, index.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(searchDocGramsBucket)
+	}))
+	require.NoError(t, index.close())
+
+	var err error
+	index, err = openSearchIndex(path, testPrivateKeyRing)
+	require.NoError(t, err)
+	defer func() { _ = index.close() }()
+
+	assert.False(t, index.isIndexed("msg1"))
+}
+
+func TestSearchGramFilter(t *testing.T) {
+	index, _, cleanup := newTestSearchIndex(t)
+	defer cleanup()
+
+	grams := getSearchGrams("Lorem ipsum dolor sit amet")
+	assert.Equal(t, []string{"lor", "ore", "rem", "ips", "psu", "sum", "dol", "olo", "sit", "ame", "met"}, grams)
+
+	filter := newSearchGramFilter(len(grams))
+	for _, gram := range grams {
+		filter.add(index.gramHash(searchTermKindBody, gram))
+	}
+
+	for _, gram := range grams {
+		assert.True(t, filter.contains(index.gramHash(searchTermKindBody, gram)), gram)
+	}
+	assert.False(t, filter.contains(index.gramHash(searchTermKindHeader, "lor")))
+	assert.False(t, filter.contains(index.gramHash(searchTermKindBody, "xyz")))
+}
+
+func TestSearchableText(t *testing.T) {
+	assert.Equal(t, "plain text", getSearchableText("text/plain", []byte("plain text")))
+	assert.Equal(t, "<p>html text</p>", getSearchableText("text/html", []byte("<p>html text</p>")))
+
+	mime := "Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nfirst part\r\n" +
+		"--b\r\nContent-Type: application/octet-stream\r\n\r\nbinary\r\n" +
+		"--b\r\nContent-Type: text/plain\r\nContent-Transfer-Encoding: base64\r\n\r\nc2Vjb25kIHBhcnQ=\r\n" +
+		"--b--\r\n"
+	text := getSearchableText("multipart/mixed", []byte(mime))
+	assert.Contains(t, text, "first part")
+	assert.Contains(t, text, "second part")
+	assert.NotContains(t, text, "binary")
+}
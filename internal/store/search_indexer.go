@@ -0,0 +1,387 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"bytes"
+	"context"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/pkg/message/parser"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const searchIndexRetryWait = time.Minute
+
+// searchIndexer keeps the search index up to date. Messages are queued by
+// events and sync and are downloaded and indexed one by one in background.
+type searchIndexer struct {
+	store *Store
+	index *searchIndex
+	log   *logrus.Entry
+
+	lock    sync.Mutex
+	pending []string
+	queued  map[string]bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wakeCh chan struct{}
+	doneCh chan struct{}
+}
+
+func newSearchIndexer(store *Store, index *searchIndex) *searchIndexer {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &searchIndexer{
+		store:  store,
+		index:  index,
+		log:    store.log.WithField("pkg", "store/search"),
+		queued: make(map[string]bool),
+		ctx:    ctx,
+		cancel: cancel,
+		wakeCh: make(chan struct{}, 1),
+		doneCh: make(chan struct{}),
+	}
+}
+
+// EnableSearchIndex opens the local full-text search index of the user and
+// starts indexing messages which are not indexed yet. The user's keys must
+// be unlocked, because the index is encrypted by the primary address keyring.
+func (store *Store) EnableSearchIndex() error {
+	addressID, err := store.user.GetAddressID(store.user.GetPrimaryAddress())
+	if err != nil {
+		return errors.Wrap(err, "failed to get primary address")
+	}
+
+	kr, err := store.client().KeyRingForAddressID(addressID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get primary address keyring")
+	}
+
+	index, err := openSearchIndex(getSearchIndexPath(store.filePath), kr)
+	if err != nil {
+		return err
+	}
+
+	indexer := newSearchIndexer(store, index)
+
+	store.searchIndexLock.Lock()
+	defer store.searchIndexLock.Unlock()
+
+	if store.searchIndexer != nil {
+		_ = index.close()
+		return errors.New("search index is already enabled")
+	}
+	store.searchIndexer = indexer
+
+	go func() {
+		defer store.panicHandler.HandlePanic()
+		indexer.start()
+	}()
+
+	return nil
+}
+
+// IsMessageIndexed returns whether the content of the message can be
+// searched by the search index.
+func (store *Store) IsMessageIndexed(apiID string) bool {
+	indexer := store.getSearchIndexer()
+	if indexer == nil {
+		return false
+	}
+	return indexer.index.isSearchable(apiID)
+}
+
+// SearchIndex returns IDs of indexed messages which contain all words of the
+// query (parts of words at its edges are looked up by trigrams) in their body
+// or, if withHeader is set, in their body or header. Messages not returned do
+// not contain the query, returned ones still need to be checked.
+// ErrSearchIndexUnavailable is returned when the index is not enabled or the
+// query cannot be answered by the index.
+func (store *Store) SearchIndex(query string, withHeader bool) (map[string]bool, error) {
+	indexer := store.getSearchIndexer()
+	if indexer == nil {
+		return nil, ErrSearchIndexUnavailable
+	}
+	return indexer.index.search(query, withHeader)
+}
+
+func (store *Store) getSearchIndexer() *searchIndexer {
+	store.searchIndexLock.RLock()
+	defer store.searchIndexLock.RUnlock()
+
+	return store.searchIndexer
+}
+
+// closeSearchIndex stops the indexer and closes the index database.
+func (store *Store) closeSearchIndex() error {
+	store.searchIndexLock.Lock()
+	defer store.searchIndexLock.Unlock()
+
+	if store.searchIndexer == nil {
+		return nil
+	}
+
+	store.searchIndexer.stop()
+	err := store.searchIndexer.index.close()
+	store.searchIndexer = nil
+
+	return err
+}
+
+// indexMessages queues the messages to be indexed if they are not yet.
+func (store *Store) indexMessages(apiIDs []string) {
+	if indexer := store.getSearchIndexer(); indexer != nil {
+		indexer.queue(apiIDs, false)
+	}
+}
+
+// reindexMessage queues the message to be indexed again, for example because
+// the content of the draft was changed.
+func (store *Store) reindexMessage(apiID string) {
+	if indexer := store.getSearchIndexer(); indexer != nil {
+		indexer.queue([]string{apiID}, true)
+	}
+}
+
+// unindexMessages removes the messages from the search index.
+func (store *Store) unindexMessages(apiIDs []string) {
+	indexer := store.getSearchIndexer()
+	if indexer == nil {
+		return
+	}
+
+	indexer.dequeue(apiIDs)
+
+	if err := indexer.index.removeMessages(apiIDs); err != nil {
+		indexer.log.WithError(err).Warn("Cannot remove messages from search index")
+	}
+}
+
+func (indexer *searchIndexer) start() {
+	defer close(indexer.doneCh)
+
+	if err := indexer.queueMissing(); err != nil {
+		indexer.log.WithError(err).Error("Cannot find messages missing in search index")
+	}
+
+	for {
+		apiID, ok := indexer.next()
+		if !ok {
+			select {
+			case <-indexer.wakeCh:
+				continue
+			case <-indexer.ctx.Done():
+				return
+			}
+		}
+
+		if err := indexer.indexMessage(apiID); err != nil {
+			indexer.log.WithError(err).WithField("msgID", apiID).Warn("Cannot index message, will retry later")
+			indexer.queue([]string{apiID}, false)
+
+			select {
+			case <-time.After(searchIndexRetryWait):
+			case <-indexer.ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-indexer.ctx.Done():
+			return
+		default:
+		}
+	}
+}
+
+func (indexer *searchIndexer) stop() {
+	indexer.cancel()
+	<-indexer.doneCh
+}
+
+// queueMissing queues all messages from the store which are not indexed.
+func (indexer *searchIndexer) queueMissing() error {
+	apiIDs, err := indexer.store.getAllMessageIDs()
+	if err != nil {
+		return err
+	}
+
+	indexedIDs, err := indexer.index.getIndexedIDs()
+	if err != nil {
+		return err
+	}
+
+	missingIDs := []string{}
+	for _, apiID := range apiIDs {
+		if !indexedIDs[apiID] {
+			missingIDs = append(missingIDs, apiID)
+		}
+	}
+
+	indexer.log.WithField("count", len(missingIDs)).Info("Indexing messages")
+	indexer.queue(missingIDs, true)
+
+	return nil
+}
+
+// queue adds messages to the queue. Messages which are already indexed are
+// skipped unless force is set.
+func (indexer *searchIndexer) queue(apiIDs []string, force bool) {
+	indexer.lock.Lock()
+	defer indexer.lock.Unlock()
+
+	for _, apiID := range apiIDs {
+		if indexer.queued[apiID] || (!force && indexer.index.isIndexed(apiID)) {
+			continue
+		}
+		indexer.queued[apiID] = true
+		indexer.pending = append(indexer.pending, apiID)
+	}
+
+	select {
+	case indexer.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (indexer *searchIndexer) dequeue(apiIDs []string) {
+	indexer.lock.Lock()
+	defer indexer.lock.Unlock()
+
+	for _, apiID := range apiIDs {
+		delete(indexer.queued, apiID)
+	}
+}
+
+func (indexer *searchIndexer) next() (string, bool) {
+	indexer.lock.Lock()
+	defer indexer.lock.Unlock()
+
+	for len(indexer.pending) > 0 {
+		apiID := indexer.pending[0]
+		indexer.pending = indexer.pending[1:]
+
+		// Removed messages stay in pending but not in queued.
+		if indexer.queued[apiID] {
+			delete(indexer.queued, apiID)
+			return apiID, true
+		}
+	}
+
+	return "", false
+}
+
+// indexMessage downloads and decrypts the message and adds it to the index.
+// Messages which cannot be decrypted are indexed only by their header. So are
+// messages with text attachments, which IMAP SEARCH matches as text parts of
+// the built message but which are not part of the message body.
+func (indexer *searchIndexer) indexMessage(apiID string) error {
+	client := indexer.store.client()
+
+	msg, err := client.GetMessage(indexer.ctx, apiID)
+	if err != nil {
+		if _, ok := err.(pmapi.ErrUnprocessableEntity); ok {
+			indexer.log.WithField("msgID", apiID).Debug("Message does not exist anymore, skipping indexing")
+			return nil
+		}
+		return err
+	}
+
+	var body string
+	isHeaderOnly := true
+
+	if hasTextAttachment(msg) {
+		indexer.log.WithField("msgID", apiID).Debug("Message has text attachment, indexing header only")
+	} else if kr, err := client.KeyRingForAddressID(msg.AddressID); err != nil {
+		indexer.log.WithError(err).WithField("msgID", apiID).Warn("Cannot get keyring, indexing header only")
+	} else if dec, err := msg.Decrypt(kr); err != nil {
+		indexer.log.WithError(err).WithField("msgID", apiID).Warn("Cannot decrypt message, indexing header only")
+	} else {
+		body = getSearchableText(msg.MIMEType, dec)
+		isHeaderOnly = false
+	}
+
+	// Message could be deleted in the meantime.
+	if _, err := indexer.store.getMessageFromDB(apiID); err != nil {
+		return nil
+	}
+
+	return indexer.index.addMessage(apiID, getSearchableHeader(msg), body, isHeaderOnly)
+}
+
+func hasTextAttachment(msg *pmapi.Message) bool {
+	for _, att := range msg.Attachments {
+		if strings.HasPrefix(strings.ToLower(att.MIMEType), "text/") {
+			return true
+		}
+	}
+	return false
+}
+
+func getSearchableHeader(msg *pmapi.Message) string {
+	fields := []string{msg.Subject}
+
+	addresses := append([]*mail.Address{msg.Sender}, msg.ToList...)
+	addresses = append(addresses, msg.CCList...)
+	addresses = append(addresses, msg.BCCList...)
+	for _, address := range addresses {
+		if address != nil {
+			fields = append(fields, address.Name, address.Address)
+		}
+	}
+
+	return strings.Join(fields, "\n")
+}
+
+// getSearchableText returns text content of the decrypted message body.
+// PGP/MIME bodies are parsed and only their text parts are used. The text is
+// not converted in any way (for example, HTML is kept as it is) so that the
+// index contains every word which can be found in the text parts of the built
+// message by IMAP SEARCH.
+func getSearchableText(mimeType string, body []byte) string {
+	if !strings.HasPrefix(mimeType, "multipart/") {
+		return string(body)
+	}
+
+	p, err := parser.New(bytes.NewReader(body))
+	if err != nil {
+		log.WithError(err).Warn("Cannot parse message body for indexing")
+		return ""
+	}
+
+	var text strings.Builder
+	if err := p.NewWalker().
+		RegisterContentTypeHandler("text/.*", func(p *parser.Part) error {
+			_ = p.ConvertToUTF8()
+			text.Write(p.Body)
+			text.WriteString("\n")
+			return nil
+		}).
+		Walk(); err != nil {
+		log.WithError(err).Warn("Cannot walk message body for indexing")
+	}
+
+	return text.String()
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/golang/mock/gomock"
+	a "github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func enableTestSearchIndex(t *testing.T, m *mocksForStore, msgs ...*pmapi.Message) {
+	m.user.EXPECT().GetPrimaryAddress().Return(addr1)
+	m.user.EXPECT().GetAddressID(addr1).Return(addrID1, nil)
+	m.client.EXPECT().KeyRingForAddressID(addrID1).Return(testPrivateKeyRing, nil).AnyTimes()
+
+	// Each message is downloaded only once, when it is indexed.
+	for _, msg := range msgs {
+		msg.AddressID = addrID1
+		m.client.EXPECT().GetMessage(gomock.Any(), msg.ID).Return(msg, nil).Times(1)
+	}
+
+	require.NoError(t, m.store.EnableSearchIndex())
+
+	require.Eventually(t, func() bool {
+		indexedIDs, err := m.store.getSearchIndexer().index.getIndexedIDs()
+		return err == nil && len(indexedIDs) == len(msgs)
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestSearchIndexAnswersOneWordQuery(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, false, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	insertMessage(t, m, "msg2", "Test message 2", addrID1, false, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+
+	msg1 := getTestMessage("msg1", "Test message 1", addrID1, false, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	msg1.Body = "Lorem ipsum dolor sit amet"
+	msg2 := getTestMessage("msg2", "Test message 2", addrID1, false, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	msg2.Body = "Invoice is paid"
+
+	enableTestSearchIndex(t, m, msg1, msg2)
+
+	a.True(t, m.store.IsMessageIndexed("msg1"))
+	a.True(t, m.store.IsMessageIndexed("msg2"))
+
+	for query, wantIDs := range map[string]map[string]bool{
+		"ipsu":    {"msg1": true},
+		"VOICE":   {"msg2": true},
+		"unknown": {},
+	} {
+		apiIDs, err := m.store.SearchIndex(query, false)
+		require.NoError(t, err)
+		a.Equal(t, wantIDs, apiIDs, query)
+	}
+}
+
+func TestSearchIndexMessageWithTextAttachment(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, false, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+
+	msg1 := getTestMessage("msg1", "Test message 1", addrID1, false, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	msg1.Attachments[0].MIMEType = "text/plain"
+
+	enableTestSearchIndex(t, m, msg1)
+
+	// Text attachments are not indexed, so the message is always checked.
+	a.True(t, m.store.getSearchIndexer().index.isIndexed("msg1"))
+	a.False(t, m.store.IsMessageIndexed("msg1"))
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// sentMessagesMaxAge is how long the sent messages are recorded. Clients
+// append their copy of the sent message right after sending, or once they
+// are online again.
+const sentMessagesMaxAge = 7 * 24 * time.Hour
+
+// SentMessage is a record of the message sent over SMTP. It is used to
+// recognise resends by SMTP clients and copies of the message appended by
+// IMAP clients even after restart.
+type SentMessage struct {
+	MessageID  string
+	AddressID  string
+	ExternalID string
+	Hash       string
+	Time       time.Time
+}
+
+// RecordSentMessage records the sent message and forgets too old ones.
+func (store *Store) RecordSentMessage(msg *SentMessage) error {
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sentMessagesBucket)
+
+		if err := txDeleteOldSentMessages(b); err != nil {
+			return err
+		}
+
+		return b.Put([]byte(msg.MessageID), raw)
+	})
+}
+
+// GetSentMessageByHash returns the recorded message with the given hash
+// or nil when there is none.
+func (store *Store) GetSentMessageByHash(hash string) (*SentMessage, error) {
+	if hash == "" {
+		return nil, nil
+	}
+
+	return store.findSentMessage(func(msg *SentMessage) bool {
+		return msg.Hash == hash
+	})
+}
+
+// GetSentMessageByExternalID returns the recorded message with the given
+// Message-Id or nil when there is none.
+func (store *Store) GetSentMessageByExternalID(externalID string) (*SentMessage, error) {
+	externalID = strings.Trim(externalID, "<> ")
+	if externalID == "" {
+		return nil, nil
+	}
+
+	return store.findSentMessage(func(msg *SentMessage) bool {
+		return msg.ExternalID == externalID
+	})
+}
+
+// findSentMessage returns the latest recorded message matching the filter.
+func (store *Store) findSentMessage(filter func(*SentMessage) bool) (found *SentMessage, err error) {
+	err = store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sentMessagesBucket).ForEach(func(_, raw []byte) error {
+			msg := &SentMessage{}
+			if err := json.Unmarshal(raw, msg); err != nil {
+				return err
+			}
+			if time.Since(msg.Time) > sentMessagesMaxAge || !filter(msg) {
+				return nil
+			}
+			if found == nil || msg.Time.After(found.Time) {
+				found = msg
+			}
+			return nil
+		})
+	})
+	return
+}
+
+func txDeleteOldSentMessages(b *bolt.Bucket) error {
+	var oldIDs [][]byte
+
+	if err := b.ForEach(func(messageID, raw []byte) error {
+		msg := &SentMessage{}
+		if err := json.Unmarshal(raw, msg); err != nil || time.Since(msg.Time) > sentMessagesMaxAge {
+			oldIDs = append(oldIDs, messageID)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, messageID := range oldIDs {
+		if err := b.Delete(messageID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSentMessages(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	require.NoError(t, m.store.RecordSentMessage(&SentMessage{
+		MessageID:  "old",
+		ExternalID: "old@example.com",
+		Hash:       "oldhash",
+		Time:       time.Now().Add(-2 * sentMessagesMaxAge),
+	}))
+	require.NoError(t, m.store.RecordSentMessage(&SentMessage{
+		MessageID:  "first",
+		ExternalID: "msg@example.com",
+		Hash:       "hash",
+		Time:       time.Now().Add(-time.Hour),
+	}))
+	require.NoError(t, m.store.RecordSentMessage(&SentMessage{
+		MessageID:  "second",
+		ExternalID: "msg@example.com",
+		Time:       time.Now(),
+	}))
+
+	msg, err := m.store.GetSentMessageByHash("hash")
+	require.NoError(t, err)
+	require.NotNil(t, msg)
+	assert.Equal(t, "first", msg.MessageID)
+
+	// The latest one wins.
+	msg, err = m.store.GetSentMessageByExternalID("<msg@example.com>")
+	require.NoError(t, err)
+	require.NotNil(t, msg)
+	assert.Equal(t, "second", msg.MessageID)
+
+	// Old records are forgotten.
+	msg, err = m.store.GetSentMessageByExternalID("old@example.com")
+	require.NoError(t, err)
+	assert.Nil(t, msg)
+
+	msg, err = m.store.GetSentMessageByHash("")
+	require.NoError(t, err)
+	assert.Nil(t, msg)
+}
@@ -45,6 +45,10 @@ const (
 	UserFoldersMailboxName = "Folders"
 	// UserFoldersPrefix contains name with delimiter for IMAP.
 	UserFoldersPrefix = UserFoldersMailboxName + PathDelimiter
+	// SavedSearchesMailboxName for IMAP.
+	SavedSearchesMailboxName = "Searches"
+	// SavedSearchesPrefix contains name with delimiter for IMAP.
+	SavedSearchesPrefix = SavedSearchesMailboxName + PathDelimiter
 )
 
 var (
@@ -67,6 +71,16 @@ var (
 	//   * mode -> string split or combined
 	// * mailboxes_version
 	//     * version -> uint32 value
+	// * saved_searches
+	//   * {name} -> saved search: name, filter or IMAP search query
+	// * autocrypt
+	//   * {address} -> Autocrypt peer state: last seen, key and its timestamp
+	// * key_pins
+	//   * {address} -> key pinned by key discovery: fingerprint, key and time of last check
+	// * user_settings
+	//   * alias_policy -> string policy of sender identities allowed over SMTP
+	// * sent_messages
+	//   * {messageID} -> record of message sent over SMTP: hash, external ID and time
 	// * sync_state
 	//   * sync_state -> string timestamp when it was last synced (when missing, sync should be ongoing)
 	//   * ids_ranges -> json array of groups with start and end message ID (when missing, there is no ongoing sync)
@@ -79,6 +93,11 @@ var (
 	//       * {messageID} -> uint32 imapUID
 	//     * deleted_ids (can be missing or have no keys)
 	//       * {messageID} -> true
+	//     * mod_seqs (sequence is the highest mod-sequence of the mailbox)
+	//       * {messageID} -> uint64 mod-sequence of the last change followed
+	//         by the hash of flags and labels at that change
+	//     * vanished_mod_seqs (sequence is the highest forgotten mod-sequence)
+	//       * {uint64 mod-sequence of the expunge} -> imapUID
 	metadataBucket      = []byte("metadata")          //nolint[gochecknoglobals]
 	headersBucket       = []byte("headers")           //nolint[gochecknoglobals]
 	bodystructureBucket = []byte("bodystructure")     //nolint[gochecknoglobals]
@@ -91,7 +110,14 @@ var (
 	imapIDsBucket       = []byte("imap_ids")          //nolint[gochecknoglobals]
 	apiIDsBucket        = []byte("api_ids")           //nolint[gochecknoglobals]
 	deletedIDsBucket    = []byte("deleted_ids")       //nolint[gochecknoglobals]
+	modSeqsBucket       = []byte("mod_seqs")          //nolint[gochecknoglobals]
+	vanishedUIDsBucket  = []byte("vanished_mod_seqs") //nolint[gochecknoglobals]
 	mboxVersionBucket   = []byte("mailboxes_version") //nolint[gochecknoglobals]
+	savedSearchesBucket = []byte("saved_searches")    //nolint[gochecknoglobals]
+	autocryptBucket     = []byte("autocrypt")         //nolint[gochecknoglobals]
+	keyPinsBucket       = []byte("key_pins")          //nolint[gochecknoglobals]
+	userSettingsBucket  = []byte("user_settings")     //nolint[gochecknoglobals]
+	sentMessagesBucket  = []byte("sent_messages")     //nolint[gochecknoglobals]
 
 	// ErrNoSuchAPIID when mailbox does not have API ID.
 	ErrNoSuchAPIID = errors.New("no such api id") //nolint[gochecknoglobals]
@@ -129,6 +155,20 @@ type Store struct {
 	addresses map[string]*Address
 	notifier  ChangeNotifier
 
+	searchIndexLock sync.RWMutex
+	searchIndexer   *searchIndexer
+
+	messageCacheLock sync.RWMutex
+	messageCache     *messageCache
+
+	outboxLock sync.RWMutex
+	outbox     *outbox
+
+	filterLock sync.RWMutex
+	filter     MessageFilter
+
+	savedSearches map[string]*SavedSearch
+
 	isSyncRunning bool
 	syncCooldown  cooldown
 	addressMode   addressMode
@@ -223,6 +263,11 @@ func openBoltDatabase(filePath string) (db *bolt.DB, err error) {
 			syncStateBucket,
 			mailboxesBucket,
 			mboxVersionBucket,
+			savedSearchesBucket,
+			autocryptBucket,
+			keyPinsBucket,
+			userSettingsBucket,
+			sentMessagesBucket,
 		}
 
 		for _, bucket := range buckets {
@@ -275,6 +320,11 @@ func (store *Store) init(firstInit bool) (err error) {
 		return
 	}
 
+	if err = store.loadSavedSearches(); err != nil {
+		store.log.WithError(err).Error("Could not load saved searches")
+		return
+	}
+
 	if err = store.initAddresses(labels); err != nil {
 		store.log.WithError(err).Error("Could not initialise store addresses")
 		return
@@ -382,6 +432,15 @@ func (store *Store) CloseEventLoop() {
 
 func (store *Store) close() error {
 	store.CloseEventLoop()
+	if err := store.closeSearchIndex(); err != nil {
+		store.log.WithError(err).Warn("Could not close search index")
+	}
+	if err := store.closeMessageCache(false); err != nil {
+		store.log.WithError(err).Warn("Could not close message cache")
+	}
+	if err := store.closeOutbox(false); err != nil {
+		store.log.WithError(err).Warn("Could not close outbox")
+	}
 	return store.db.Close()
 }
 
@@ -394,6 +453,14 @@ func (store *Store) Remove() (err error) {
 
 	var result *multierror.Error
 
+	if err = store.closeMessageCache(true); err != nil {
+		result = multierror.Append(result, errors.Wrap(err, "failed to remove message cache"))
+	}
+
+	if err = store.closeOutbox(true); err != nil {
+		result = multierror.Append(result, errors.Wrap(err, "failed to remove outbox"))
+	}
+
 	if err = store.close(); err != nil {
 		result = multierror.Append(result, errors.Wrap(err, "failed to close store"))
 	}
@@ -405,7 +472,7 @@ func (store *Store) Remove() (err error) {
 	return result.ErrorOrNil()
 }
 
-// RemoveStore removes the database file and clears the cache file.
+// RemoveStore removes the database file, the search index file and clears the cache file.
 func RemoveStore(cache *Cache, path, userID string) error {
 	var result *multierror.Error
 
@@ -418,5 +485,9 @@ func RemoveStore(cache *Cache, path, userID string) error {
 		result = multierror.Append(result, errors.Wrap(err, "failed to remove database file"))
 	}
 
+	if err := os.RemoveAll(getSearchIndexPath(path)); err != nil {
+		result = multierror.Append(result, errors.Wrap(err, "failed to remove search index file"))
+	}
+
 	return result.ErrorOrNil()
 }
@@ -185,7 +185,16 @@ func (store *Store) truncateMailboxesBucket() (err error) {
 				return
 			}
 
-			return
+			// UIDs are generated again so old expunged UIDs are meaningless.
+			if err = addr.DeleteBucket(vanishedUIDsBucket); err != nil && err != bolt.ErrBucketNotFound {
+				return
+			}
+
+			if _, err = addr.CreateBucketIfNotExists(vanishedUIDsBucket); err != nil {
+				return
+			}
+
+			return nil
 		})
 	}
 
@@ -46,6 +46,8 @@ func (store *Store) createMailbox(name string) error {
 	case strings.HasPrefix(name, UserFoldersPrefix):
 		name = strings.TrimPrefix(name, UserFoldersPrefix)
 		exclusive = true
+	case strings.HasPrefix(name, SavedSearchesPrefix):
+		return ErrSavedSearchOpNotAllowed
 	default:
 		// Ideally we would throw an error here, but then Outlook for
 		// macOS keeps trying to make an IMAP Drafts folder and popping
@@ -341,6 +341,12 @@ func (store *Store) createOrUpdateMessagesEvent(msgs []*pmapi.Message) error { /
 		return err
 	}
 
+	apiIDs := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		apiIDs = append(apiIDs, msg.ID)
+	}
+	store.indexMessages(apiIDs)
+
 	return nil
 }
 
@@ -401,6 +407,9 @@ func (store *Store) deleteMessageEvent(apiID string) error {
 
 // deleteMessagesEvent deletes the message from metadata and all mailbox buckets.
 func (store *Store) deleteMessagesEvent(apiIDs []string) error {
+	defer store.unindexMessages(apiIDs)
+	defer store.uncacheMessages(apiIDs)
+
 	return store.db.Update(func(tx *bolt.Tx) error {
 		for _, apiID := range apiIDs {
 			if err := tx.Bucket(metadataBucket).Delete([]byte(apiID)); err != nil {
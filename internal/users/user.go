@@ -412,6 +412,92 @@ func (u *User) SwitchAddressMode() error {
 	return nil
 }
 
+// ListSavedSearches returns saved searches exposed over IMAP as mailboxes.
+func (u *User) ListSavedSearches() ([]store.SavedSearch, error) {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	if u.store == nil {
+		return nil, errors.New("store is not initialised")
+	}
+
+	return u.store.ListSavedSearches(), nil
+}
+
+// AddSavedSearch adds the saved search exposed over IMAP as read-only mailbox.
+func (u *User) AddSavedSearch(search store.SavedSearch) error {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	if u.store == nil {
+		return errors.New("store is not initialised")
+	}
+
+	return u.store.AddSavedSearch(search)
+}
+
+// RemoveSavedSearch removes the saved search together with its mailbox.
+func (u *User) RemoveSavedSearch(name string) error {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	if u.store == nil {
+		return errors.New("store is not initialised")
+	}
+
+	return u.store.RemoveSavedSearch(name)
+}
+
+// GetAliasPolicy returns which sender identities can be used over SMTP.
+func (u *User) GetAliasPolicy() (store.AliasPolicy, error) {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	if u.store == nil {
+		return "", errors.New("store is not initialised")
+	}
+
+	return u.store.GetAliasPolicy()
+}
+
+// SetAliasPolicy sets which sender identities can be used over SMTP.
+func (u *User) SetAliasPolicy(policy store.AliasPolicy) error {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	if u.store == nil {
+		return errors.New("store is not initialised")
+	}
+
+	return u.store.SetAliasPolicy(policy)
+}
+
+// GetAutocryptPreferEncrypt returns whether the user prefers encryption
+// with peers which announced their keys by Autocrypt.
+func (u *User) GetAutocryptPreferEncrypt() (bool, error) {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	if u.store == nil {
+		return false, errors.New("store is not initialised")
+	}
+
+	return u.store.GetAutocryptPreferEncrypt()
+}
+
+// SetAutocryptPreferEncrypt sets whether the user prefers encryption with
+// peers which announced their keys by Autocrypt.
+func (u *User) SetAutocryptPreferEncrypt(preferEncrypt bool) error {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	if u.store == nil {
+		return errors.New("store is not initialised")
+	}
+
+	return u.store.SetAutocryptPreferEncrypt(preferEncrypt)
+}
+
 // logout is the same as Logout, but for internal purposes (logged out from
 // the server) which emits LogoutEvent to notify other parts of the app.
 func (u *User) logout() error {
@@ -0,0 +1,106 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package message
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// AutocryptHeader is the name of the header carrying the sender's key.
+const AutocryptHeader = "Autocrypt"
+
+// autocryptKeyDataLineLen is the length of keydata chunks separated by spaces
+// so the header can be folded.
+const autocryptKeyDataLineLen = 76
+
+// Autocrypt is the content of Autocrypt header as defined by Autocrypt Level 1.
+type Autocrypt struct {
+	Addr          string
+	PreferEncrypt bool
+	KeyData       []byte
+}
+
+// ParseAutocrypt parses value of Autocrypt header. Headers with unknown
+// critical attributes, i.e. those not starting with underscore, are invalid.
+func ParseAutocrypt(value string) (*Autocrypt, error) {
+	autocrypt := &Autocrypt{}
+
+	for _, attr := range strings.Split(value, ";") {
+		attr = strings.TrimSpace(attr)
+		if attr == "" {
+			continue
+		}
+
+		kv := strings.SplitN(attr, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("invalid autocrypt attribute %q", attr)
+		}
+
+		switch key, val := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]); key {
+		case "addr":
+			autocrypt.Addr = val
+
+		case "prefer-encrypt":
+			autocrypt.PreferEncrypt = val == "mutual"
+
+		case "keydata":
+			keyData, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(val), ""))
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid autocrypt keydata")
+			}
+			autocrypt.KeyData = keyData
+
+		default:
+			if !strings.HasPrefix(key, "_") {
+				return nil, errors.Errorf("unknown critical autocrypt attribute %q", key)
+			}
+		}
+	}
+
+	if autocrypt.Addr == "" || len(autocrypt.KeyData) == 0 {
+		return nil, errors.New("autocrypt header must have addr and keydata")
+	}
+
+	return autocrypt, nil
+}
+
+// String returns the value of Autocrypt header. The keydata is split by
+// spaces so the header can be folded.
+func (autocrypt *Autocrypt) String() string {
+	attrs := []string{"addr=" + autocrypt.Addr}
+
+	if autocrypt.PreferEncrypt {
+		attrs = append(attrs, "prefer-encrypt=mutual")
+	}
+
+	keyData := base64.StdEncoding.EncodeToString(autocrypt.KeyData)
+
+	var chunks []string
+	for len(keyData) > autocryptKeyDataLineLen {
+		chunks = append(chunks, keyData[:autocryptKeyDataLineLen])
+		keyData = keyData[autocryptKeyDataLineLen:]
+	}
+	chunks = append(chunks, keyData)
+
+	attrs = append(attrs, "keydata="+strings.Join(chunks, " "))
+
+	return strings.Join(attrs, "; ")
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package message
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutocrypt(t *testing.T) {
+	keyData := []byte(strings.Repeat("key", 100))
+
+	value := (&Autocrypt{Addr: "alice@example.com", PreferEncrypt: true, KeyData: keyData}).String()
+	assert.True(t, strings.HasPrefix(value, "addr=alice@example.com; prefer-encrypt=mutual; keydata="))
+	for _, chunk := range strings.Fields(value) {
+		assert.LessOrEqual(t, len(chunk), autocryptKeyDataLineLen+len("keydata="))
+	}
+
+	autocrypt, err := ParseAutocrypt(value)
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", autocrypt.Addr)
+	assert.True(t, autocrypt.PreferEncrypt)
+	assert.Equal(t, keyData, autocrypt.KeyData)
+}
+
+func TestParseAutocrypt(t *testing.T) {
+	autocrypt, err := ParseAutocrypt("addr=bob@example.com; _ignored=yes; keydata=a2V5\r\n ZGF0YQ==")
+	require.NoError(t, err)
+	assert.Equal(t, "bob@example.com", autocrypt.Addr)
+	assert.False(t, autocrypt.PreferEncrypt)
+	assert.Equal(t, []byte("keydata"), autocrypt.KeyData)
+
+	for _, value := range []string{
+		"",
+		"addr=bob@example.com",
+		"keydata=a2V5ZGF0YQ==",
+		"addr=bob@example.com; keydata=%%%",
+		"addr=bob@example.com; critical=yes; keydata=a2V5ZGF0YQ==",
+		"addr=bob@example.com; keydata",
+	} {
+		_, err := ParseAutocrypt(value)
+		assert.Error(t, err, value)
+	}
+}
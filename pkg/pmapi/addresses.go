@@ -61,6 +61,7 @@ type Address struct {
 	Email       string
 	Send        int
 	Receive     Boolean
+	CatchAll    Boolean
 	Status      int
 	Order       int `json:",omitempty"`
 	Type        int
@@ -150,7 +150,8 @@ type sendData struct {
 }
 
 type SendMessageReq struct {
-	ExpirationTime int64 `json:",omitempty"`
+	ExpirationTime int64 `json:",omitempty"` // Unix time when the message expires.
+	DeliveryTime   int64 `json:",omitempty"` // Unix time when the scheduled message is delivered.
 	// AutoSaveContacts int `json:",omitempty"`
 
 	// Data for encrypted recipients.
@@ -0,0 +1,621 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sieve
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	errStop             = errors.New("stop")               //nolint[gochecknoglobals]
+	errTooManyRedirects = errors.New("too many redirects") //nolint[gochecknoglobals]
+)
+
+// instruction is a compiled command. Execution stops when it returns error.
+type instruction func(*executor) error
+
+// condition is a compiled test.
+type condition func(*executor) bool
+
+// compiler checks the semantics of generic commands and converts them
+// to instructions.
+type compiler struct {
+	required map[string]bool
+}
+
+func newCompiler() *compiler {
+	return &compiler{required: map[string]bool{}}
+}
+
+func errorAt(line int, format string, args ...interface{}) error {
+	return errors.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (c *compiler) compile(commands []*command) ([]instruction, error) {
+	for len(commands) > 0 && commands[0].name == "require" {
+		if err := c.require(commands[0]); err != nil {
+			return nil, err
+		}
+		commands = commands[1:]
+	}
+
+	return c.compileBlock(commands)
+}
+
+func (c *compiler) require(cmd *command) error {
+	if cmd.hasBlock || len(cmd.tests) != 0 || len(cmd.args) != 1 || !cmd.args[0].isStringList() {
+		return errorAt(cmd.line, "require expects list of extensions")
+	}
+
+	for _, extension := range cmd.args[0].strings {
+		switch extension {
+		case extensionFileInto, extensionIMAP4Flags, extensionCopy,
+			"comparator-" + comparatorOctet, "comparator-" + comparatorASCIICasemap:
+			c.required[extension] = true
+		default:
+			return errorAt(cmd.line, "unsupported extension %s", extension)
+		}
+	}
+
+	return nil
+}
+
+func (c *compiler) checkRequired(line int, extension string) error {
+	if !c.required[extension] {
+		return errorAt(line, "missing require of %s", extension)
+	}
+	return nil
+}
+
+func (c *compiler) compileBlock(commands []*command) ([]instruction, error) {
+	instructions := []instruction{}
+
+	for i := 0; i < len(commands); i++ {
+		if commands[i].name == "if" {
+			n, instr, err := c.compileIf(commands[i:])
+			if err != nil {
+				return nil, err
+			}
+			instructions = append(instructions, instr)
+			i += n - 1
+			continue
+		}
+
+		instr, err := c.compileCommand(commands[i])
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instr)
+	}
+
+	return instructions, nil
+}
+
+// compileIf compiles the if command with all following elsif and else
+// commands and returns the number of used commands.
+func (c *compiler) compileIf(commands []*command) (int, instruction, error) {
+	conditions := []condition{}
+	blocks := [][]instruction{}
+	var elseBlock []instruction
+
+	n := 0
+	for ; n < len(commands); n++ {
+		cmd := commands[n]
+		if (n == 0) != (cmd.name == "if") || (n > 0 && cmd.name != "elsif" && cmd.name != "else") {
+			break
+		}
+
+		if !cmd.hasBlock {
+			return 0, nil, errorAt(cmd.line, "%s expects block", cmd.name)
+		}
+
+		if cmd.name == "else" {
+			if len(cmd.args) != 0 || len(cmd.tests) != 0 {
+				return 0, nil, errorAt(cmd.line, "else expects no arguments")
+			}
+			block, err := c.compileBlock(cmd.block)
+			if err != nil {
+				return 0, nil, err
+			}
+			elseBlock = block
+			n++
+			break
+		}
+
+		if len(cmd.args) != 0 || len(cmd.tests) != 1 {
+			return 0, nil, errorAt(cmd.line, "%s expects one test", cmd.name)
+		}
+		cond, err := c.compileTest(cmd.tests[0])
+		if err != nil {
+			return 0, nil, err
+		}
+		block, err := c.compileBlock(cmd.block)
+		if err != nil {
+			return 0, nil, err
+		}
+		conditions = append(conditions, cond)
+		blocks = append(blocks, block)
+	}
+
+	return n, func(e *executor) error {
+		for i, cond := range conditions {
+			if cond(e) {
+				return e.run(blocks[i])
+			}
+		}
+		return e.run(elseBlock)
+	}, nil
+}
+
+func (c *compiler) compileCommand(cmd *command) (instruction, error) { //nolint[funlen]
+	switch cmd.name {
+	case "require":
+		return nil, errorAt(cmd.line, "require must be at the beginning of the script")
+	case "elsif", "else":
+		return nil, errorAt(cmd.line, "%s without if", cmd.name)
+	}
+
+	if cmd.hasBlock {
+		return nil, errorAt(cmd.line, "%s does not expect block", cmd.name)
+	}
+	if len(cmd.tests) != 0 {
+		return nil, errorAt(cmd.line, "%s does not expect test", cmd.name)
+	}
+
+	switch cmd.name {
+	case "stop":
+		if _, _, err := c.splitArguments(cmd.line, cmd.args, nil, nil, 0); err != nil {
+			return nil, err
+		}
+		return func(*executor) error { return errStop }, nil
+
+	case "discard":
+		if _, _, err := c.splitArguments(cmd.line, cmd.args, nil, nil, 0); err != nil {
+			return nil, err
+		}
+		return func(e *executor) error {
+			e.implicitKeep = false
+			return nil
+		}, nil
+
+	case "keep":
+		tags, _, err := c.splitArguments(cmd.line, cmd.args, nil, []string{"flags"}, 0)
+		if err != nil {
+			return nil, err
+		}
+		flags, err := c.flagsFromTags(cmd.line, tags)
+		if err != nil {
+			return nil, err
+		}
+		return func(e *executor) error {
+			e.keep(e.flagsOrCurrent(flags))
+			return nil
+		}, nil
+
+	case "fileinto":
+		if err := c.checkRequired(cmd.line, extensionFileInto); err != nil {
+			return nil, err
+		}
+		tags, args, err := c.splitArguments(cmd.line, cmd.args, []string{"copy"}, []string{"flags"}, 1)
+		if err != nil {
+			return nil, err
+		}
+		mailbox, err := singleString(cmd.line, args[0])
+		if err != nil {
+			return nil, err
+		}
+		flags, err := c.flagsFromTags(cmd.line, tags)
+		if err != nil {
+			return nil, err
+		}
+		isCopy, err := c.copyFromTags(cmd.line, tags)
+		if err != nil {
+			return nil, err
+		}
+		return func(e *executor) error {
+			e.fileInto(mailbox, e.flagsOrCurrent(flags))
+			e.implicitKeep = e.implicitKeep && isCopy
+			return nil
+		}, nil
+
+	case "redirect":
+		tags, args, err := c.splitArguments(cmd.line, cmd.args, []string{"copy"}, nil, 1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := singleString(cmd.line, args[0])
+		if err != nil {
+			return nil, err
+		}
+		address, err := mail.ParseAddress(value)
+		if err != nil {
+			return nil, errorAt(cmd.line, "invalid redirect address %s", value)
+		}
+		isCopy, err := c.copyFromTags(cmd.line, tags)
+		if err != nil {
+			return nil, err
+		}
+		return func(e *executor) error {
+			e.implicitKeep = e.implicitKeep && isCopy
+			return e.redirect(address.Address)
+		}, nil
+
+	case "addflag", "setflag", "removeflag":
+		return c.compileFlagCommand(cmd)
+	}
+
+	return nil, errorAt(cmd.line, "unknown command %s", cmd.name)
+}
+
+func (c *compiler) compileFlagCommand(cmd *command) (instruction, error) {
+	if err := c.checkRequired(cmd.line, extensionIMAP4Flags); err != nil {
+		return nil, err
+	}
+	if len(cmd.args) == 2 {
+		return nil, errorAt(cmd.line, "variables are not supported")
+	}
+	_, args, err := c.splitArguments(cmd.line, cmd.args, nil, nil, 1)
+	if err != nil {
+		return nil, err
+	}
+	if !args[0].isStringList() {
+		return nil, errorAt(cmd.line, "%s expects list of flags", cmd.name)
+	}
+	flags := splitFlags(args[0].strings)
+
+	switch cmd.name {
+	case "addflag":
+		return func(e *executor) error {
+			e.flags = addFlags(e.flags, flags)
+			return nil
+		}, nil
+	case "setflag":
+		return func(e *executor) error {
+			e.flags = addFlags(nil, flags)
+			return nil
+		}, nil
+	default:
+		return func(e *executor) error {
+			e.flags = removeFlags(e.flags, flags)
+			return nil
+		}, nil
+	}
+}
+
+func (c *compiler) compileTest(t *test) (condition, error) { //nolint[funlen]
+	switch t.name {
+	case "not", "allof", "anyof":
+		return c.compileTestList(t)
+	}
+
+	if len(t.tests) != 0 {
+		return nil, errorAt(t.line, "%s does not expect tests", t.name)
+	}
+
+	switch t.name {
+	case "true", "false":
+		if _, _, err := c.splitArguments(t.line, t.args, nil, nil, 0); err != nil {
+			return nil, err
+		}
+		value := t.name == "true"
+		return func(*executor) bool { return value }, nil
+
+	case "exists":
+		_, args, err := c.splitArguments(t.line, t.args, nil, nil, 1)
+		if err != nil {
+			return nil, err
+		}
+		if !args[0].isStringList() {
+			return nil, errorAt(t.line, "exists expects list of headers")
+		}
+		headers := args[0].strings
+		return func(e *executor) bool {
+			for _, header := range headers {
+				if len(e.message.Header(header)) == 0 {
+					return false
+				}
+			}
+			return true
+		}, nil
+
+	case "size":
+		tags, args, err := c.splitArguments(t.line, t.args, []string{"over", "under"}, nil, 1)
+		if err != nil {
+			return nil, err
+		}
+		_, isOver := tags["over"]
+		if _, isUnder := tags["under"]; isOver == isUnder || !args[0].isNumber() {
+			return nil, errorAt(t.line, "size expects :over or :under with number")
+		}
+		limit := *args[0].number
+		return func(e *executor) bool {
+			if isOver {
+				return e.message.Size() > limit
+			}
+			return e.message.Size() < limit
+		}, nil
+
+	case "header", "address":
+		return c.compileHeaderTest(t)
+
+	case "hasflag":
+		if err := c.checkRequired(t.line, extensionIMAP4Flags); err != nil {
+			return nil, err
+		}
+		tags, args, err := c.splitArguments(t.line, t.args, matchTypes(), []string{"comparator"}, 1)
+		if err != nil {
+			return nil, err
+		}
+		m, err := c.matcherFromTags(t.line, tags)
+		if err != nil {
+			return nil, err
+		}
+		if !args[0].isStringList() {
+			return nil, errorAt(t.line, "hasflag expects list of flags")
+		}
+		keys := args[0].strings
+		return func(e *executor) bool {
+			return m.matchAny(e.flags, keys)
+		}, nil
+	}
+
+	return nil, errorAt(t.line, "unknown test %s", t.name)
+}
+
+func (c *compiler) compileTestList(t *test) (condition, error) {
+	if len(t.args) != 0 {
+		return nil, errorAt(t.line, "%s expects only tests", t.name)
+	}
+	if len(t.tests) == 0 || (t.name == "not" && len(t.tests) != 1) {
+		return nil, errorAt(t.line, "%s expects tests", t.name)
+	}
+
+	conditions := []condition{}
+	for _, subtest := range t.tests {
+		cond, err := c.compileTest(subtest)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, cond)
+	}
+
+	switch t.name {
+	case "not":
+		return func(e *executor) bool { return !conditions[0](e) }, nil
+	case "allof":
+		return func(e *executor) bool {
+			for _, cond := range conditions {
+				if !cond(e) {
+					return false
+				}
+			}
+			return true
+		}, nil
+	default:
+		return func(e *executor) bool {
+			for _, cond := range conditions {
+				if cond(e) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+}
+
+func (c *compiler) compileHeaderTest(t *test) (condition, error) {
+	tagsWithoutValue := matchTypes()
+	if t.name == "address" {
+		tagsWithoutValue = append(tagsWithoutValue, addressPartAll, addressPartLocalpart, addressPartDomain)
+	}
+
+	tags, args, err := c.splitArguments(t.line, t.args, tagsWithoutValue, []string{"comparator"}, 2)
+	if err != nil {
+		return nil, err
+	}
+	if !args[0].isStringList() || !args[1].isStringList() {
+		return nil, errorAt(t.line, "%s expects list of headers and keys", t.name)
+	}
+	headers, keys := args[0].strings, args[1].strings
+
+	m, err := c.matcherFromTags(t.line, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.name == "header" {
+		return func(e *executor) bool {
+			for _, header := range headers {
+				if m.matchAny(e.message.Header(header), keys) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	part, err := exclusiveTag(t.line, tags, defaultAddressPart, addressPartAll, addressPartLocalpart, addressPartDomain)
+	if err != nil {
+		return nil, err
+	}
+	return func(e *executor) bool {
+		for _, header := range headers {
+			if m.matchAny(getAddressParts(e.message.Header(header), part), keys) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// splitArguments returns tagged arguments and checks there is the expected
+// number of positional arguments after them. Tags withoutValue are only
+// flags, tags withValue are followed by string list.
+func (c *compiler) splitArguments(
+	line int,
+	args []argument,
+	withoutValue, withValue []string,
+	positional int,
+) (map[string][]string, []argument, error) {
+	tags := map[string][]string{}
+
+	for len(args) > 0 && args[0].isTag() {
+		tag := args[0].tag
+		if _, ok := tags[tag]; ok {
+			return nil, nil, errorAt(line, "duplicate tag :%s", tag)
+		}
+
+		switch {
+		case containsString(withoutValue, tag):
+			tags[tag] = nil
+			args = args[1:]
+		case containsString(withValue, tag):
+			if len(args) < 2 || !args[1].isStringList() {
+				return nil, nil, errorAt(line, "tag :%s expects value", tag)
+			}
+			tags[tag] = args[1].strings
+			args = args[2:]
+		default:
+			return nil, nil, errorAt(line, "unexpected tag :%s", tag)
+		}
+	}
+
+	if len(args) != positional {
+		return nil, nil, errorAt(line, "expected %d arguments, got %d", positional, len(args))
+	}
+	for _, arg := range args {
+		if arg.isTag() {
+			return nil, nil, errorAt(line, "unexpected tag :%s", arg.tag)
+		}
+	}
+
+	return tags, args, nil
+}
+
+func (c *compiler) matcherFromTags(line int, tags map[string][]string) (matcher, error) {
+	m := matcher{comparator: defaultComparator}
+
+	if comparator, ok := tags["comparator"]; ok {
+		if len(comparator) != 1 || (comparator[0] != comparatorOctet && comparator[0] != comparatorASCIICasemap) {
+			return m, errorAt(line, "unsupported comparator %v", comparator)
+		}
+		m.comparator = comparator[0]
+	}
+
+	var err error
+	m.matchType, err = exclusiveTag(line, tags, defaultMatchType, matchTypes()...)
+	return m, err
+}
+
+// flagsFromTags returns flags of :flags tag or nil if the tag is not used.
+func (c *compiler) flagsFromTags(line int, tags map[string][]string) ([]string, error) {
+	flags, ok := tags["flags"]
+	if !ok {
+		return nil, nil
+	}
+	if err := c.checkRequired(line, extensionIMAP4Flags); err != nil {
+		return nil, err
+	}
+	return splitFlags(flags), nil
+}
+
+func (c *compiler) copyFromTags(line int, tags map[string][]string) (bool, error) {
+	if _, ok := tags["copy"]; !ok {
+		return false, nil
+	}
+	return true, c.checkRequired(line, extensionCopy)
+}
+
+func (e *executor) flagsOrCurrent(flags []string) []string {
+	if flags == nil {
+		return e.flags
+	}
+	return flags
+}
+
+// exclusiveTag returns the only used tag from options or default value.
+func exclusiveTag(line int, tags map[string][]string, defaultValue string, options ...string) (string, error) {
+	value := ""
+	for _, option := range options {
+		if _, ok := tags[option]; !ok {
+			continue
+		}
+		if value != "" {
+			return "", errorAt(line, "tags :%s and :%s cannot be used together", value, option)
+		}
+		value = option
+	}
+	if value == "" {
+		return defaultValue, nil
+	}
+	return value, nil
+}
+
+func matchTypes() []string {
+	return []string{matchTypeIs, matchTypeContains, matchTypeMatches}
+}
+
+func singleString(line int, arg argument) (string, error) {
+	if !arg.isStringList() || len(arg.strings) != 1 {
+		return "", errorAt(line, "expected string")
+	}
+	return arg.strings[0], nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// getAddressParts returns requested part of all addresses in the header
+// values. Values which are not valid address lists are used as they are.
+func getAddressParts(values []string, part string) []string {
+	parts := []string{}
+	for _, value := range values {
+		addresses := []string{}
+		if list, err := mail.ParseAddressList(value); err == nil {
+			for _, address := range list {
+				addresses = append(addresses, address.Address)
+			}
+		} else {
+			addresses = append(addresses, strings.Trim(strings.TrimSpace(value), "<>"))
+		}
+
+		for _, address := range addresses {
+			at := strings.LastIndex(address, "@")
+			switch {
+			case part == addressPartLocalpart && at >= 0:
+				address = address[:at]
+			case part == addressPartDomain && at >= 0:
+				address = address[at+1:]
+			case part == addressPartDomain:
+				address = ""
+			}
+			parts = append(parts, address)
+		}
+	}
+	return parts
+}
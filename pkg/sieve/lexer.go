@@ -0,0 +1,247 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sieve
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenIdentifier
+	tokenTag
+	tokenNumber
+	tokenString
+	tokenSpecial
+)
+
+type token struct {
+	typ    tokenType
+	value  string
+	number int64
+	line   int
+}
+
+// lexer splits the script into tokens as defined by RFC5228 section 8.1.
+type lexer struct {
+	input string
+	pos   int
+	line  int
+}
+
+func newLexer(input string) *lexer {
+	return &lexer{input: input, line: 1}
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) error {
+	return errors.Errorf("line %d: %s", l.line, fmt.Sprintf(format, args...))
+}
+
+func (l *lexer) peekByte() byte {
+	if l.pos >= len(l.input) {
+		return 0
+	}
+	return l.input[l.pos]
+}
+
+func (l *lexer) skipWhitespaceAndComments() error {
+	for l.pos < len(l.input) {
+		switch c := l.input[l.pos]; {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.input) && l.input[l.pos] != '\n' {
+				l.pos++
+			}
+		case strings.HasPrefix(l.input[l.pos:], "/*"):
+			end := strings.Index(l.input[l.pos+2:], "*/")
+			if end < 0 {
+				return l.errorf("unterminated comment")
+			}
+			comment := l.input[l.pos : l.pos+2+end+2]
+			l.line += strings.Count(comment, "\n")
+			l.pos += len(comment)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func isAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (l *lexer) readIdentifier() string {
+	start := l.pos
+	for l.pos < len(l.input) && (isAlpha(l.input[l.pos]) || isDigit(l.input[l.pos])) {
+		l.pos++
+	}
+	return l.input[start:l.pos]
+}
+
+// next returns the next token or tokenEOF at the end of the script.
+func (l *lexer) next() (token, error) {
+	if err := l.skipWhitespaceAndComments(); err != nil {
+		return token{}, err
+	}
+
+	tok := token{line: l.line}
+	if l.pos >= len(l.input) {
+		tok.typ = tokenEOF
+		return tok, nil
+	}
+
+	switch c := l.input[l.pos]; {
+	case isAlpha(c):
+		tok.typ = tokenIdentifier
+		tok.value = strings.ToLower(l.readIdentifier())
+		if tok.value == "text" && l.peekByte() == ':' {
+			l.pos++
+			return l.readMultiline(tok)
+		}
+
+	case c == ':':
+		l.pos++
+		if !isAlpha(l.peekByte()) {
+			return tok, l.errorf("invalid tag")
+		}
+		tok.typ = tokenTag
+		tok.value = strings.ToLower(l.readIdentifier())
+
+	case isDigit(c):
+		return l.readNumber(tok)
+
+	case c == '"':
+		l.pos++
+		return l.readQuoted(tok)
+
+	case strings.IndexByte("[](),;{}", c) >= 0:
+		l.pos++
+		tok.typ = tokenSpecial
+		tok.value = string(c)
+
+	default:
+		return tok, l.errorf("unexpected character %q", c)
+	}
+
+	return tok, nil
+}
+
+func (l *lexer) readNumber(tok token) (token, error) {
+	start := l.pos
+	for l.pos < len(l.input) && isDigit(l.input[l.pos]) {
+		l.pos++
+	}
+
+	number, err := strconv.ParseInt(l.input[start:l.pos], 10, 64)
+	if err != nil {
+		return tok, l.errorf("invalid number")
+	}
+
+	switch l.peekByte() {
+	case 'K', 'k':
+		number <<= 10
+		l.pos++
+	case 'M', 'm':
+		number <<= 20
+		l.pos++
+	case 'G', 'g':
+		number <<= 30
+		l.pos++
+	}
+
+	tok.typ = tokenNumber
+	tok.number = number
+	return tok, nil
+}
+
+func (l *lexer) readQuoted(tok token) (token, error) {
+	var value strings.Builder
+	for {
+		if l.pos >= len(l.input) {
+			return tok, l.errorf("unterminated string")
+		}
+
+		c := l.input[l.pos]
+		l.pos++
+
+		switch c {
+		case '"':
+			tok.typ = tokenString
+			tok.value = value.String()
+			return tok, nil
+		case '\\':
+			if l.pos >= len(l.input) {
+				return tok, l.errorf("unterminated string")
+			}
+			c = l.input[l.pos]
+			l.pos++
+		case '\n':
+			l.line++
+		}
+
+		value.WriteByte(c)
+	}
+}
+
+// readMultiline reads the string after "text:" up to the line with a single
+// dot. Lines starting with dot have the leading dot removed.
+func (l *lexer) readMultiline(tok token) (token, error) {
+	end := strings.IndexByte(l.input[l.pos:], '\n')
+	if end < 0 {
+		return tok, l.errorf("unterminated multi-line string")
+	}
+	if rest := strings.TrimSpace(l.input[l.pos : l.pos+end]); rest != "" && !strings.HasPrefix(rest, "#") {
+		return tok, l.errorf("unexpected characters after text:")
+	}
+	l.pos += end + 1
+	l.line++
+
+	var value strings.Builder
+	for {
+		end := strings.IndexByte(l.input[l.pos:], '\n')
+		if end < 0 {
+			return tok, l.errorf("unterminated multi-line string")
+		}
+		line := l.input[l.pos : l.pos+end+1]
+		l.pos += end + 1
+		l.line++
+
+		if strings.TrimRight(line, "\r\n") == "." {
+			tok.typ = tokenString
+			tok.value = value.String()
+			return tok, nil
+		}
+
+		value.WriteString(strings.TrimPrefix(line, "."))
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sieve
+
+import (
+	"strings"
+)
+
+const (
+	comparatorOctet         = "i;octet"
+	comparatorASCIICasemap  = "i;ascii-casemap"
+	matchTypeIs             = "is"
+	matchTypeContains       = "contains"
+	matchTypeMatches        = "matches"
+	addressPartAll          = "all"
+	addressPartLocalpart    = "localpart"
+	addressPartDomain       = "domain"
+	defaultComparator       = comparatorASCIICasemap
+	defaultMatchType        = matchTypeIs
+	defaultAddressPart      = addressPartAll
+	wildcardAny             = '*'
+	wildcardSingle          = '?'
+	wildcardEscapeCharacter = '\\'
+)
+
+// matcher compares values using the comparator and match type.
+type matcher struct {
+	comparator string
+	matchType  string
+}
+
+// matchAny returns whether any value matches any key.
+func (m matcher) matchAny(values, keys []string) bool {
+	for _, value := range values {
+		for _, key := range keys {
+			if m.match(value, key) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m matcher) match(value, key string) bool {
+	if m.comparator == comparatorASCIICasemap {
+		value = asciiToLower(value)
+		key = asciiToLower(key)
+	}
+
+	switch m.matchType {
+	case matchTypeContains:
+		return strings.Contains(value, key)
+	case matchTypeMatches:
+		return matchWildcard([]rune(value), []rune(key))
+	default:
+		return value == key
+	}
+}
+
+// asciiToLower is i;ascii-casemap which changes only ASCII letters.
+func asciiToLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, s)
+}
+
+// matchWildcard implements :matches where "*" matches zero or more
+// characters, "?" matches exactly one character, and backslash escapes
+// the next character.
+func matchWildcard(value, pattern []rune) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case wildcardAny:
+			for len(pattern) > 0 && pattern[0] == wildcardAny {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(value); i++ {
+				if matchWildcard(value[i:], pattern) {
+					return true
+				}
+			}
+			return false
+
+		case wildcardSingle:
+			if len(value) == 0 {
+				return false
+			}
+
+		case wildcardEscapeCharacter:
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+
+		default:
+			if len(value) == 0 || value[0] != pattern[0] {
+				return false
+			}
+		}
+
+		value = value[1:]
+		pattern = pattern[1:]
+	}
+
+	return len(value) == 0
+}
@@ -0,0 +1,264 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sieve
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// argument is a tag, number, or string list (single string is a string
+// list with one item).
+type argument struct {
+	tag     string
+	number  *int64
+	strings []string
+	line    int
+}
+
+func (arg argument) isTag() bool {
+	return arg.tag != ""
+}
+
+func (arg argument) isNumber() bool {
+	return arg.number != nil
+}
+
+func (arg argument) isStringList() bool {
+	return arg.tag == "" && arg.number == nil
+}
+
+// test is a generic test of RFC5228 grammar, e.g., `header :is "From" "x"`
+// or `anyof (true, false)`.
+type test struct {
+	name  string
+	args  []argument
+	tests []*test
+	line  int
+}
+
+// command is a generic command of RFC5228 grammar with optional block.
+type command struct {
+	name     string
+	args     []argument
+	tests    []*test
+	block    []*command
+	hasBlock bool
+	line     int
+}
+
+type parser struct {
+	lexer *lexer
+	tok   token
+}
+
+// parse returns commands of the script without any semantic checks.
+func parse(script string) ([]*command, error) {
+	p := &parser{lexer: newLexer(script)}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	commands, err := p.parseCommands()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.typ != tokenEOF {
+		return nil, p.errorf("unexpected %s", p.describe())
+	}
+
+	return commands, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return errors.Errorf("line %d: %s", p.tok.line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) describe() string {
+	switch p.tok.typ {
+	case tokenEOF:
+		return "end of script"
+	case tokenIdentifier:
+		return "identifier " + p.tok.value
+	case tokenTag:
+		return "tag :" + p.tok.value
+	case tokenNumber:
+		return "number"
+	case tokenString:
+		return "string"
+	}
+	return fmt.Sprintf("%q", p.tok.value)
+}
+
+func (p *parser) advance() (err error) {
+	p.tok, err = p.lexer.next()
+	return
+}
+
+func (p *parser) isSpecial(value string) bool {
+	return p.tok.typ == tokenSpecial && p.tok.value == value
+}
+
+func (p *parser) expectSpecial(value string) error {
+	if !p.isSpecial(value) {
+		return p.errorf("expected %q, got %s", value, p.describe())
+	}
+	return p.advance()
+}
+
+func (p *parser) parseCommands() ([]*command, error) {
+	commands := []*command{}
+	for p.tok.typ == tokenIdentifier {
+		cmd, err := p.parseCommand()
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, cmd)
+	}
+	return commands, nil
+}
+
+func (p *parser) parseCommand() (*command, error) {
+	cmd := &command{name: p.tok.value, line: p.tok.line}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if cmd.args, cmd.tests, err = p.parseArguments(); err != nil {
+		return nil, err
+	}
+
+	if p.isSpecial(";") {
+		return cmd, p.advance()
+	}
+
+	if err := p.expectSpecial("{"); err != nil {
+		return nil, err
+	}
+	if cmd.block, err = p.parseCommands(); err != nil {
+		return nil, err
+	}
+	cmd.hasBlock = true
+	return cmd, p.expectSpecial("}")
+}
+
+// parseArguments returns arguments followed by optional test or test list.
+func (p *parser) parseArguments() ([]argument, []*test, error) {
+	args := []argument{}
+	for {
+		arg := argument{line: p.tok.line}
+
+		switch {
+		case p.tok.typ == tokenTag:
+			arg.tag = p.tok.value
+		case p.tok.typ == tokenNumber:
+			number := p.tok.number
+			arg.number = &number
+		case p.tok.typ == tokenString:
+			arg.strings = []string{p.tok.value}
+		case p.isSpecial("["):
+			list, err := p.parseStringList()
+			if err != nil {
+				return nil, nil, err
+			}
+			arg.strings = list
+			args = append(args, arg)
+			continue
+		case p.tok.typ == tokenIdentifier:
+			t, err := p.parseTest()
+			if err != nil {
+				return nil, nil, err
+			}
+			return args, []*test{t}, nil
+		case p.isSpecial("("):
+			tests, err := p.parseTestList()
+			return args, tests, err
+		default:
+			return args, nil, nil
+		}
+
+		args = append(args, arg)
+		if err := p.advance(); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+func (p *parser) parseStringList() ([]string, error) {
+	if err := p.expectSpecial("["); err != nil {
+		return nil, err
+	}
+
+	list := []string{}
+	for {
+		if p.tok.typ != tokenString {
+			return nil, p.errorf("expected string, got %s", p.describe())
+		}
+		list = append(list, p.tok.value)
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		if p.isSpecial("]") {
+			return list, p.advance()
+		}
+		if err := p.expectSpecial(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseTest() (*test, error) {
+	if p.tok.typ != tokenIdentifier {
+		return nil, p.errorf("expected test, got %s", p.describe())
+	}
+
+	t := &test{name: p.tok.value, line: p.tok.line}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	t.args, t.tests, err = p.parseArguments()
+	return t, err
+}
+
+func (p *parser) parseTestList() ([]*test, error) {
+	if err := p.expectSpecial("("); err != nil {
+		return nil, err
+	}
+
+	tests := []*test{}
+	for {
+		t, err := p.parseTest()
+		if err != nil {
+			return nil, err
+		}
+		tests = append(tests, t)
+
+		if p.isSpecial(")") {
+			return tests, p.advance()
+		}
+		if err := p.expectSpecial(","); err != nil {
+			return nil, err
+		}
+	}
+}
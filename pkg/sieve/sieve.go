@@ -0,0 +1,207 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package sieve implements interpreter of Sieve mail filtering language
+// (RFC5228) with the following extensions:
+// * fileinto (RFC5228 section 4.1)
+// * imap4flags (RFC5232) without variables
+// * copy (RFC3894)
+//
+// The interpreter only decides what should happen with the message, it is up
+// to the caller to perform the actions. Envelope, body, or other extensions
+// are not supported and scripts requiring them are refused.
+package sieve
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	extensionFileInto   = "fileinto"
+	extensionIMAP4Flags = "imap4flags"
+	extensionCopy       = "copy"
+
+	// maxRedirects limits the number of redirects done by one execution
+	// to not make the mail loops worse.
+	maxRedirects = 5
+)
+
+// Extensions returns the list of supported extensions as advertised,
+// for example, by ManageSieve.
+func Extensions() []string {
+	return []string{extensionCopy, extensionFileInto, extensionIMAP4Flags}
+}
+
+// Message is the message the script is executed for.
+type Message interface {
+	// Header returns decoded values of all header fields with the name.
+	// The name is case-insensitive.
+	Header(name string) []string
+
+	// Size returns the size of the message in octets.
+	Size() int64
+}
+
+// Result contains actions requested by the script.
+type Result struct {
+	// Keep is set when the message should stay in the default mailbox,
+	// either by implicit or explicit keep.
+	Keep bool
+
+	// FileInto contains the mailboxes the message should be filed into.
+	FileInto []string
+
+	// Redirect contains the addresses the message should be redirected to.
+	Redirect []string
+
+	// Flags contains IMAP flags which should be set to the kept or filed
+	// message.
+	Flags []string
+}
+
+// IsDiscarded returns whether the message should not be stored anywhere.
+func (r *Result) IsDiscarded() bool {
+	return !r.Keep && len(r.FileInto) == 0
+}
+
+// Script is a parsed and validated Sieve script.
+type Script struct {
+	instructions []instruction
+}
+
+// Parse returns the script or error when the script is not valid or uses
+// not supported extension.
+func Parse(script string) (*Script, error) {
+	commands, err := parse(script)
+	if err != nil {
+		return nil, err
+	}
+
+	instructions, err := newCompiler().compile(commands)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Script{instructions: instructions}, nil
+}
+
+// Execute runs the script for the message. Error is returned only when
+// the script hits any limit; in such case the message should be kept.
+func (s *Script) Execute(message Message) (*Result, error) {
+	e := &executor{
+		message:      message,
+		implicitKeep: true,
+		result:       &Result{},
+	}
+
+	if err := e.run(s.instructions); err != nil && err != errStop {
+		return nil, err
+	}
+
+	if e.implicitKeep {
+		e.keep(e.flags)
+	}
+
+	return e.result, nil
+}
+
+// executor holds the state of one execution of the script.
+type executor struct {
+	message      Message
+	implicitKeep bool
+	flags        []string
+	result       *Result
+}
+
+func (e *executor) run(instructions []instruction) error {
+	for _, instruction := range instructions {
+		if err := instruction(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *executor) keep(flags []string) {
+	e.result.Keep = true
+	e.result.Flags = addFlags(e.result.Flags, flags)
+}
+
+func (e *executor) fileInto(mailbox string, flags []string) {
+	for _, existing := range e.result.FileInto {
+		if existing == mailbox {
+			e.result.Flags = addFlags(e.result.Flags, flags)
+			return
+		}
+	}
+	e.result.FileInto = append(e.result.FileInto, mailbox)
+	e.result.Flags = addFlags(e.result.Flags, flags)
+}
+
+func (e *executor) redirect(address string) error {
+	for _, existing := range e.result.Redirect {
+		if strings.EqualFold(existing, address) {
+			return nil
+		}
+	}
+	if len(e.result.Redirect) >= maxRedirects {
+		return errTooManyRedirects
+	}
+	e.result.Redirect = append(e.result.Redirect, address)
+	return nil
+}
+
+// splitFlags returns flags from the list where every item can contain
+// more flags separated by space.
+func splitFlags(list []string) []string {
+	flags := []string{}
+	for _, item := range list {
+		flags = addFlags(flags, strings.Fields(item))
+	}
+	return flags
+}
+
+// addFlags returns flags with added new ones; flags are case-insensitive.
+func addFlags(flags, newFlags []string) []string {
+	for _, flag := range newFlags {
+		if !hasFlag(flags, flag) {
+			flags = append(flags, flag)
+		}
+	}
+	sort.Strings(flags)
+	return flags
+}
+
+func removeFlags(flags, removedFlags []string) []string {
+	result := []string{}
+	for _, flag := range flags {
+		if !hasFlag(removedFlags, flag) {
+			result = append(result, flag)
+		}
+	}
+	return result
+}
+
+func hasFlag(flags []string, flag string) bool {
+	for _, existing := range flags {
+		if strings.EqualFold(existing, flag) {
+			return true
+		}
+	}
+	return false
+}
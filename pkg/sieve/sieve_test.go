@@ -0,0 +1,168 @@
+// Copyright (c) 2021 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sieve
+
+import (
+	"net/textproto"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testMessage struct {
+	header textproto.MIMEHeader
+	size   int64
+}
+
+func newTestMessage(size int64, fields ...string) *testMessage {
+	msg := &testMessage{header: textproto.MIMEHeader{}, size: size}
+	for i := 0; i < len(fields); i += 2 {
+		msg.header.Add(fields[i], fields[i+1])
+	}
+	return msg
+}
+
+func (msg *testMessage) Header(name string) []string {
+	return msg.header.Values(name)
+}
+
+func (msg *testMessage) Size() int64 {
+	return msg.size
+}
+
+func execute(t *testing.T, script string, msg Message) *Result {
+	s, err := Parse(script)
+	require.NoError(t, err)
+
+	result, err := s.Execute(msg)
+	require.NoError(t, err)
+
+	return result
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		`keep`:                                     "expected",
+		`fileinto "INBOX";`:                        "missing require of fileinto",
+		`require "body";`:                          "unsupported extension body",
+		`keep; require "fileinto";`:                "require must be at the beginning",
+		`if true keep;`:                            "if expects block",
+		`else { keep; }`:                           "else without if",
+		`if header :is :contains "a" "b" {}`:       "cannot be used together",
+		`if header :comparator "i;foo" "a" "b" {}`: "unsupported comparator",
+		`if size 100 {}`:                           "size expects :over or :under",
+		`redirect "not an address";`:               "invalid redirect address",
+		`/* comment`:                               "unterminated comment",
+		`if foo {}`:                                "unknown test foo",
+		"\nfoo;":                                   "line 2: unknown command foo",
+	}
+
+	for script, wantErr := range tests {
+		_, err := Parse(script)
+		require.Error(t, err, script)
+		assert.Contains(t, err.Error(), wantErr, script)
+	}
+}
+
+func TestImplicitKeep(t *testing.T) {
+	result := execute(t, `# nothing to do`, newTestMessage(10))
+	assert.Equal(t, &Result{Keep: true, Flags: nil}, result)
+	assert.False(t, result.IsDiscarded())
+}
+
+func TestFileIntoAndFlags(t *testing.T) {
+	script := `
+require ["fileinto", "imap4flags"];
+if address :domain :is "from" "example.com" {
+	addflag "\\Seen";
+	fileinto "Folders/Work";
+	stop;
+}
+fileinto :flags "\\Flagged" "Folders/Other";
+`
+
+	result := execute(t, script, newTestMessage(10, "From", "John Doe <john@Example.com>"))
+	assert.Equal(t, &Result{FileInto: []string{"Folders/Work"}, Flags: []string{`\Seen`}}, result)
+
+	result = execute(t, script, newTestMessage(10, "From", "jane@example.org"))
+	assert.Equal(t, &Result{FileInto: []string{"Folders/Other"}, Flags: []string{`\Flagged`}}, result)
+}
+
+func TestDiscardAndRedirect(t *testing.T) {
+	result := execute(t, `if header :contains "subject" "SPAM" { discard; }`, newTestMessage(10, "Subject", "cheap spam"))
+	assert.True(t, result.IsDiscarded())
+
+	result = execute(t, `redirect "other@pm.me"; redirect "Other@pm.me";`, newTestMessage(10))
+	assert.Equal(t, []string{"other@pm.me"}, result.Redirect)
+	assert.True(t, result.IsDiscarded())
+
+	result = execute(t, `require "copy"; redirect :copy "other@pm.me";`, newTestMessage(10))
+	assert.Equal(t, []string{"other@pm.me"}, result.Redirect)
+	assert.True(t, result.Keep)
+}
+
+func TestIfElsifElse(t *testing.T) {
+	script := `
+require "fileinto";
+if size :over 1M {
+	fileinto "big";
+} elsif anyof (exists "x-spam", header :matches "subject" "*[ad]*") {
+	fileinto "ads";
+} elsif not exists "subject" {
+	fileinto "no-subject";
+} else {
+	keep;
+}
+`
+
+	assert.Equal(t, []string{"big"}, execute(t, script, newTestMessage(2<<20)).FileInto)
+	assert.Equal(t, []string{"ads"}, execute(t, script, newTestMessage(10, "X-Spam", "yes")).FileInto)
+	assert.Equal(t, []string{"ads"}, execute(t, script, newTestMessage(10, "Subject", "Buy [AD] now")).FileInto)
+	assert.Equal(t, []string{"no-subject"}, execute(t, script, newTestMessage(10)).FileInto)
+	assert.True(t, execute(t, script, newTestMessage(10, "Subject", "hello")).Keep)
+}
+
+func TestMultilineString(t *testing.T) {
+	script := "require \"fileinto\";\nfileinto text:\nFolders/Multi\n..dot\n.\n;"
+
+	result := execute(t, script, newTestMessage(10))
+	assert.Equal(t, []string{"Folders/Multi\n.dot\n"}, result.FileInto)
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		matcher    matcher
+		value, key string
+		want       bool
+	}{
+		{matcher{comparatorASCIICasemap, matchTypeIs}, "Hello", "hello", true},
+		{matcher{comparatorOctet, matchTypeIs}, "Hello", "hello", false},
+		{matcher{comparatorASCIICasemap, matchTypeContains}, "Hello World", "o w", true},
+		{matcher{comparatorASCIICasemap, matchTypeMatches}, "Hello World", "h*d", true},
+		{matcher{comparatorASCIICasemap, matchTypeMatches}, "Hello World", "h?llo*", true},
+		{matcher{comparatorASCIICasemap, matchTypeMatches}, "Hello", "h?llo?", false},
+		{matcher{comparatorASCIICasemap, matchTypeMatches}, "a*b", `a\*b`, true},
+		{matcher{comparatorASCIICasemap, matchTypeMatches}, "axb", `a\*b`, false},
+		{matcher{comparatorASCIICasemap, matchTypeMatches}, "Příliš", "p?íliš", true},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.want, test.matcher.match(test.value, test.key), "%v %q %q", test.matcher, test.value, test.key)
+	}
+}
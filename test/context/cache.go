@@ -49,6 +49,11 @@ func (c *fakeCache) GetIMAPCachePath() string {
 	return filepath.Join(c.dir, "user_info.json")
 }
 
+// GetMessageCacheDir returns folder for encrypted message cache of all users.
+func (c *fakeCache) GetMessageCacheDir() string {
+	return filepath.Join(c.dir, "messages")
+}
+
 // GetTransferDir returns folder for import-export rules files.
 func (c *fakeCache) GetTransferDir() string {
 	return c.dir
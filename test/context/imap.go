@@ -25,6 +25,7 @@ import (
 	"github.com/ProtonMail/proton-bridge/internal/config/settings"
 	"github.com/ProtonMail/proton-bridge/internal/config/tls"
 	"github.com/ProtonMail/proton-bridge/internal/imap"
+	"github.com/ProtonMail/proton-bridge/internal/serverutil"
 	"github.com/ProtonMail/proton-bridge/test/mocks"
 	"github.com/stretchr/testify/require"
 )
@@ -59,7 +60,7 @@ func (ctx *TestContext) withIMAPServer() {
 	tls, _ := tls.New(settingsPath).GetConfig()
 
 	backend := imap.NewIMAPBackend(ph, ctx.listener, ctx.cache, ctx.bridge)
-	server := imap.NewIMAPServer(ph, true, true, port, tls, backend, ctx.userAgent, ctx.listener)
+	server := imap.NewIMAPServer(ph, true, true, port, 0, true, tls, backend, ctx.userAgent, ctx.listener, serverutil.NewSessions())
 
 	go server.ListenAndServe()
 	require.NoError(ctx.t, waitForPort(port, 5*time.Second))
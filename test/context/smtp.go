@@ -24,6 +24,7 @@ import (
 	"github.com/ProtonMail/proton-bridge/internal/bridge"
 	"github.com/ProtonMail/proton-bridge/internal/config/settings"
 	"github.com/ProtonMail/proton-bridge/internal/config/tls"
+	"github.com/ProtonMail/proton-bridge/internal/serverutil"
 	"github.com/ProtonMail/proton-bridge/internal/smtp"
 	"github.com/ProtonMail/proton-bridge/test/mocks"
 	"github.com/stretchr/testify/require"
@@ -60,7 +61,7 @@ func (ctx *TestContext) withSMTPServer() {
 	useSSL := ctx.settings.GetBool(settings.SMTPSSLKey)
 
 	backend := smtp.NewSMTPBackend(ph, ctx.listener, ctx.settings, ctx.bridge)
-	server := smtp.NewSMTPServer(ph, true, port, useSSL, tls, backend, ctx.listener)
+	server := smtp.NewSMTPServer(ph, true, port, useSSL, tls, backend, ctx.listener, serverutil.NewSessions())
 
 	go server.ListenAndServe()
 	require.NoError(ctx.t, waitForPort(port, 5*time.Second))
@@ -38,10 +38,13 @@ type FakePMAPI struct {
 	user         *pmapi.User
 	userKeyRing  *crypto.KeyRing
 	addresses    *pmapi.AddressList
-	addrKeyRing  map[string]*crypto.KeyRing
-	labels       []*pmapi.Label
-	messages     []*pmapi.Message
-	events       []*pmapi.Event
+	// addressesCached is set once addresses were loaded with internet
+	// connection. Real client keeps them even when the connection is lost.
+	addressesCached bool
+	addrKeyRing     map[string]*crypto.KeyRing
+	labels          []*pmapi.Label
+	messages        []*pmapi.Message
+	events          []*pmapi.Event
 
 	// uid represents the API UID. It is the unique session ID.
 	uid string
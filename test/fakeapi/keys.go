@@ -19,6 +19,7 @@ package fakeapi
 
 import (
 	"context"
+	"strings"
 
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 )
@@ -63,7 +64,21 @@ func (api *FakePMAPI) GetPublicKeysForEmail(_ context.Context, email string) (ke
 	if err := api.checkAndRecordCall(GET, "/keys?Email="+email, nil); err != nil {
 		return nil, false, err
 	}
+	if !isInternalAddress(email) {
+		return nil, false, nil
+	}
 	return []pmapi.PublicKey{{
 		PublicKey: publicKey,
 	}}, true, nil
 }
+
+// isInternalAddress returns whether the email belongs to a ProtonMail domain.
+// Other addresses are external ones without any key, as in the real API.
+func isInternalAddress(email string) bool {
+	domain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+	switch domain {
+	case "protonmail.com", "protonmail.ch", "pm.me", "pm.test":
+		return true
+	}
+	return false
+}
@@ -180,6 +180,10 @@ func (api *FakePMAPI) SendMessage(ctx context.Context, messageID string, sendMes
 		return nil, nil, errors.Wrap(err, "draft does not exist")
 	}
 	message.Time = time.Now().Unix()
+	if sendMessageRequest.DeliveryTime != 0 {
+		message.Time = sendMessageRequest.DeliveryTime
+	}
+	message.ExpirationTime = sendMessageRequest.ExpirationTime
 	message.LabelIDs = append(message.LabelIDs, pmapi.SentLabel)
 	api.addEventMessage(pmapi.EventUpdate, message)
 	return message, nil, nil
@@ -36,6 +36,10 @@ func (api *FakePMAPI) IsUnlocked() bool {
 }
 
 func (api *FakePMAPI) Unlock(_ context.Context, passphrase []byte) (err error) {
+	if !api.controller.noInternetConnection {
+		api.addressesCached = true
+	}
+
 	if api.userKeyRing != nil {
 		return
 	}
@@ -116,7 +120,7 @@ func (api *FakePMAPI) ReorderAddresses(_ context.Context, addressIDs []string) e
 }
 
 func (api *FakePMAPI) Addresses() pmapi.AddressList {
-	if api.controller.noInternetConnection {
+	if api.controller.noInternetConnection && !api.addressesCached {
 		return nil
 	}
 	if api.addresses == nil {
@@ -0,0 +1,34 @@
+Feature: IMAP compression
+  Background:
+    Given there is connected user "user"
+    And there are 10 messages in mailbox "INBOX" for "user"
+
+  Scenario: Capabilities contain compression after login
+    Given there is IMAP client logged in as "user"
+    When IMAP client sends command "CAPABILITY"
+    Then IMAP response is "OK"
+    And IMAP response contains "COMPRESS=DEFLATE"
+
+  Scenario: Compression is not allowed before login
+    When IMAP client enables compression
+    Then IMAP response is "IMAP error: NO Not authenticated"
+
+  Scenario: Fetch messages over compressed connection
+    Given there is IMAP client logged in as "user"
+    When IMAP client enables compression
+    Then IMAP response is "OK"
+    When IMAP client selects "INBOX"
+    Then IMAP response is "OK"
+    When IMAP client fetches "1:*"
+    Then IMAP response is "OK"
+    And IMAP response has 10 messages
+
+  Scenario: Compression cannot be enabled twice
+    Given there is IMAP client logged in as "user"
+    When IMAP client enables compression
+    Then IMAP response is "OK"
+    When IMAP client enables compression
+    Then IMAP response is "IMAP error: NO \[COMPRESSIONACTIVE\] Compression is already active"
+    When IMAP client sends command "CAPABILITY"
+    Then IMAP response is "OK"
+    And IMAP response does not contain "COMPRESS=DEFLATE"
@@ -0,0 +1,72 @@
+Feature: IMAP conditional store and quick resynchronization
+  Background:
+    Given there is connected user "user"
+    And there are messages in mailbox "INBOX" for "user"
+      | from              | to         | subject | body  | read  |
+      | john.doe@mail.com | user@pm.me | foo     | hello | false |
+      | jane.doe@mail.com | user@pm.me | bar     | world | false |
+    And there is IMAP client logged in as "user"
+
+  Scenario: Select returns highest mod-sequence
+    When IMAP client sends command "SELECT INBOX (CONDSTORE)"
+    Then IMAP response is "OK"
+    And IMAP response contains "HIGHESTMODSEQ"
+
+  Scenario: Status returns highest mod-sequence
+    When IMAP client sends command "STATUS INBOX (HIGHESTMODSEQ)"
+    Then IMAP response is "OK"
+    And IMAP response contains "HIGHESTMODSEQ"
+
+  Scenario: Fetch returns mod-sequence of messages
+    Given there is IMAP client selected in "INBOX"
+    When IMAP client sends command "FETCH 1:* (FLAGS MODSEQ)"
+    Then IMAP response is "OK"
+    And IMAP response has 2 messages
+    And IMAP response contains "MODSEQ"
+
+  Scenario: Fetch returns only messages changed since mod-sequence
+    Given there is IMAP client selected in "INBOX"
+    When IMAP client sends command "FETCH 1:* (FLAGS) (CHANGEDSINCE 1)"
+    Then IMAP response is "OK"
+    And IMAP response has 2 messages
+    And IMAP response contains "MODSEQ"
+    When IMAP client sends command "FETCH 1:* (FLAGS) (CHANGEDSINCE 1000)"
+    Then IMAP response is "OK"
+    And IMAP response has 0 messages
+
+  Scenario: Conditional store fails for modified messages
+    Given there is IMAP client selected in "INBOX"
+    When IMAP client sends command "STORE 1:2 (UNCHANGEDSINCE 0) +FLAGS (\Flagged)"
+    Then IMAP response is "OK \[MODIFIED 1:2\] Conditional STORE failed"
+    When IMAP client sends command "STORE 1:2 (UNCHANGEDSINCE 1000) +FLAGS (\Flagged)"
+    Then IMAP response is "OK"
+    And IMAP response does not contain "MODIFIED"
+
+  Scenario: Search by mod-sequence
+    Given there is IMAP client selected in "INBOX"
+    When IMAP client sends command "SEARCH MODSEQ 1"
+    Then IMAP response is "OK"
+    And IMAP response contains "SEARCH 1 2 \(MODSEQ \d+\)"
+    When IMAP client sends command "SEARCH UNSEEN MODSEQ 1000"
+    Then IMAP response is "OK"
+    And IMAP response does not contain "MODSEQ"
+
+  Scenario: Fetch with vanished modifier requires QRESYNC
+    Given there is IMAP client selected in "INBOX"
+    When IMAP client sends command "UID FETCH 1:* (FLAGS) (CHANGEDSINCE 1 VANISHED)"
+    Then IMAP response is "IMAP error: NO QRESYNC is not enabled"
+
+  Scenario: Fetch with vanished modifier reports expunged messages
+    When IMAP client sends command "ENABLE QRESYNC"
+    Then IMAP response is "OK"
+    And IMAP response contains "ENABLED QRESYNC"
+    When IMAP client selects "INBOX"
+    Then IMAP response is "OK"
+    When IMAP client marks message seq "1" as deleted
+    Then IMAP response is "OK"
+    When IMAP client sends expunge
+    Then IMAP response is "OK"
+    When IMAP client sends command "UID FETCH 1:* (FLAGS) (CHANGEDSINCE 1 VANISHED)"
+    Then IMAP response is "OK"
+    And IMAP response contains "VANISHED \(EARLIER\) 1"
+    And IMAP response contains "UID 2"
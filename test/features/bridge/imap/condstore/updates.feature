@@ -0,0 +1,53 @@
+Feature: IMAP updates with conditional store and quick resynchronization
+  Background:
+    Given there is connected user "userMoreAddresses"
+    And there is "userMoreAddresses" in "split" address mode
+    And there is "userMoreAddresses" with mailbox "Folders/mbox"
+    And there are messages in mailbox "Folders/mbox" for "userMoreAddresses"
+      | from              | to          | subject | read  |
+      | john.doe@mail.com | [secondary] | foo     | false |
+      | jane.doe@mail.com | [secondary] | bar     | false |
+    And there is IMAP client "active" logged in as "userMoreAddresses" with address "secondary"
+    And there is IMAP client "active" selected in "Folders/mbox"
+    And there is IMAP client "idling" logged in as "userMoreAddresses" with address "primary"
+
+  Scenario: Flag update contains mod-sequence when CONDSTORE is enabled
+    When IMAP client "idling" sends command "ENABLE CONDSTORE"
+    Then IMAP response to "idling" is "OK"
+    Given there is IMAP client "idling" selected in "Other Users/secondaryaddress@pm.me/Folders/mbox"
+    When IMAP client "idling" starts IDLE-ing
+    And IMAP client "active" marks message seq "1" as read
+    And IMAP response to "active" is "OK"
+    And the event loop of "userMoreAddresses" loops once
+    Then IMAP client "idling" receives "FETCH \(FLAGS \(.*\\Seen.*\) UID 1 MODSEQ \(\d+\)\)" within 5 seconds
+
+  Scenario: Flag update does not contain mod-sequence without CONDSTORE
+    Given there is IMAP client "idling" selected in "Other Users/secondaryaddress@pm.me/Folders/mbox"
+    When IMAP client "idling" starts IDLE-ing
+    And IMAP client "active" marks message seq "1" as read
+    And IMAP response to "active" is "OK"
+    And the event loop of "userMoreAddresses" loops once
+    Then IMAP client "idling" receives "FETCH \(FLAGS \(.*\\Seen.*\) UID 1\)" within 5 seconds
+
+  Scenario: Expunge is reported by VANISHED when QRESYNC is enabled
+    When IMAP client "idling" sends command "ENABLE QRESYNC"
+    Then IMAP response to "idling" is "OK"
+    Given there is IMAP client "idling" selected in "Other Users/secondaryaddress@pm.me/Folders/mbox"
+    When IMAP client "idling" starts IDLE-ing
+    And IMAP client "active" marks message seq "1" as deleted
+    And IMAP response to "active" is "OK"
+    And IMAP client "active" sends expunge
+    And IMAP response to "active" is "OK"
+    And the event loop of "userMoreAddresses" loops once
+    Then IMAP client "idling" receives "VANISHED 1" within 5 seconds
+    And IMAP client "idling" does not receive "1 EXPUNGE" within 2 seconds
+
+  Scenario: Expunge is reported by EXPUNGE without QRESYNC
+    Given there is IMAP client "idling" selected in "Other Users/secondaryaddress@pm.me/Folders/mbox"
+    When IMAP client "idling" starts IDLE-ing
+    And IMAP client "active" marks message seq "1" as deleted
+    And IMAP response to "active" is "OK"
+    And IMAP client "active" sends expunge
+    And IMAP response to "active" is "OK"
+    And the event loop of "userMoreAddresses" loops once
+    Then IMAP client "idling" receives "1 EXPUNGE" within 5 seconds
@@ -0,0 +1,30 @@
+Feature: IMAP IDLE in namespace of other addresses
+  Background:
+    Given there is connected user "userMoreAddresses"
+    And there is "userMoreAddresses" in "split" address mode
+    And there is "userMoreAddresses" with mailbox "Folders/mbox"
+    And there are messages in mailbox "Folders/mbox" for "userMoreAddresses"
+      | from              | to          | subject |
+      | john.doe@mail.com | [primary]   | foo     |
+      | jane.doe@mail.com | [secondary] | bar     |
+    And there is IMAP client "active" logged in as "userMoreAddresses" with address "secondary"
+    And there is IMAP client "active" selected in "Folders/mbox"
+    And there is IMAP client "idling" logged in as "userMoreAddresses" with address "primary"
+
+  Scenario: New message in mailbox of other address is notified
+    Given there is IMAP client "idling" selected in "Other Users/secondaryaddress@pm.me/INBOX"
+    When IMAP client "idling" starts IDLE-ing
+    And IMAP client "active" sends command "COPY 1 INBOX"
+    And IMAP response to "active" is "OK"
+    And the event loop of "userMoreAddresses" loops once
+    Then IMAP client "idling" receives "1 EXISTS" within 5 seconds
+
+  Scenario: Expunged message in mailbox of other address is notified
+    Given there is IMAP client "idling" selected in "Other Users/secondaryaddress@pm.me/Folders/mbox"
+    When IMAP client "idling" starts IDLE-ing
+    And IMAP client "active" marks message seq "1" as deleted
+    And IMAP response to "active" is "OK"
+    And IMAP client "active" sends expunge
+    And IMAP response to "active" is "OK"
+    And the event loop of "userMoreAddresses" loops once
+    Then IMAP client "idling" receives "1 EXPUNGE" within 5 seconds
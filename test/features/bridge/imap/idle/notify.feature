@@ -0,0 +1,78 @@
+Feature: IMAP NOTIFY
+  Background:
+    Given there is connected user "user"
+    And there is "user" with mailbox "Folders/mbox"
+    And there are 10 messages in mailbox "INBOX" for "user"
+    And there are 5 messages in mailbox "Folders/mbox" for "user"
+    And there is IMAP client "notifying" logged in as "user"
+
+  Scenario: NOTIFY capability is announced
+    When IMAP client "notifying" sends command "CAPABILITY"
+    Then IMAP response to "notifying" is "OK"
+    And IMAP response to "notifying" contains "NOTIFY"
+
+  Scenario: NOTIFY NONE
+    When IMAP client "notifying" sends command "NOTIFY NONE"
+    Then IMAP response to "notifying" is "OK"
+
+  Scenario: NOTIFY SET with STATUS returns status of matching mailboxes
+    Given there is IMAP client "notifying" selected in "INBOX"
+    When IMAP client "notifying" sends command "NOTIFY SET STATUS (PERSONAL (MessageNew MessageExpunge))"
+    Then IMAP response to "notifying" is "OK"
+    And IMAP response to "notifying" contains "STATUS .Folders/mbox. \(.*MESSAGES 5"
+    And IMAP response to "notifying" does not contain "STATUS .INBOX."
+    And IMAP response to "notifying" does not contain "STATUS .Folders. "
+
+  Scenario: NOTIFY SET with unsupported event
+    When IMAP client "notifying" sends command "NOTIFY SET (PERSONAL (MessageNew MessageExpunge AnnotationChange))"
+    Then IMAP response to "notifying" is "IMAP error: NO \[BADEVENT"
+
+  Scenario: NOTIFY SET with incomplete message events
+    When IMAP client "notifying" sends command "NOTIFY SET (PERSONAL (MessageNew))"
+    Then IMAP response to "notifying" is "IMAP error: BAD"
+
+  Scenario: Change in non-selected mailbox is notified
+    Given there is IMAP client "notifying" selected in "INBOX"
+    And there is IMAP client "active" logged in as "user"
+    And there is IMAP client "active" selected in "Folders/mbox"
+    When IMAP client "notifying" sends command "NOTIFY SET (PERSONAL (MessageNew MessageExpunge FlagChange))"
+    And IMAP response to "notifying" is "OK"
+    And IMAP client "notifying" starts IDLE-ing
+    And IMAP client "active" marks message seq "1" as unread
+    And IMAP response to "active" is "OK"
+    And the event loop of "user" loops once
+    Then IMAP client "notifying" receives "STATUS .Folders/mbox. \(.*MESSAGES 5" within 5 seconds
+
+  Scenario: Change in mailbox outside of filter is not notified
+    Given there is IMAP client "notifying" selected in "INBOX"
+    And there is IMAP client "active" logged in as "user"
+    And there is IMAP client "active" selected in "Folders/mbox"
+    When IMAP client "notifying" sends command "NOTIFY SET (INBOXES (MessageNew MessageExpunge FlagChange))"
+    And IMAP response to "notifying" is "OK"
+    And IMAP client "notifying" starts IDLE-ing
+    And IMAP client "active" marks message seq "1" as unread
+    And IMAP response to "active" is "OK"
+    And the event loop of "user" loops once
+    Then IMAP client "notifying" does not receive "STATUS .Folders/mbox." within 2 seconds
+
+  Scenario: NOTIFY SET with MailboxName event is refused
+    When IMAP client "notifying" sends command "NOTIFY SET (PERSONAL (MailboxName))"
+    Then IMAP response to "notifying" is "IMAP error: NO \[BADEVENT"
+
+  Scenario: NOTIFY SET with fetch attributes is refused
+    When IMAP client "notifying" sends command "NOTIFY SET (PERSONAL (MessageNew (UID) MessageExpunge))"
+    Then IMAP response to "notifying" is "IMAP error: NO"
+
+  Scenario: Change in previously selected mailbox is notified
+    Given there is IMAP client "notifying" selected in "INBOX"
+    And there is IMAP client "active" logged in as "user"
+    And there is IMAP client "active" selected in "INBOX"
+    When IMAP client "notifying" sends command "NOTIFY SET (PERSONAL (MessageNew MessageExpunge FlagChange))"
+    And IMAP response to "notifying" is "OK"
+    And IMAP client "notifying" sends command "SELECT Folders/mbox"
+    And IMAP response to "notifying" is "OK"
+    And IMAP client "notifying" starts IDLE-ing
+    And IMAP client "active" marks message seq "1" as unread
+    And IMAP response to "active" is "OK"
+    And the event loop of "user" loops once
+    Then IMAP client "notifying" receives "STATUS INBOX \(.*MESSAGES 10" within 5 seconds
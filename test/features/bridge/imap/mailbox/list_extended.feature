@@ -0,0 +1,54 @@
+Feature: IMAP extended list of mailboxes
+  Background:
+    Given there is connected user "user"
+    And there is "user" with mailbox "Folders/mbox"
+    And there is IMAP client logged in as "user"
+
+  Scenario: Capabilities contain list and enable extensions
+    When IMAP client sends command "CAPABILITY"
+    Then IMAP response is "OK"
+    And IMAP response contains "LIST-EXTENDED"
+    And IMAP response contains "SPECIAL-USE"
+    And IMAP response contains "ENABLE"
+    And IMAP response contains "LITERAL\+"
+
+  Scenario: List mailboxes with special-use attributes
+    When IMAP client lists mailboxes with selection "" returning "SPECIAL-USE"
+    Then IMAP response is "OK"
+    And IMAP response contains "\\Sent\) \S+ \S?Sent"
+    And IMAP response contains "\\Drafts\) \S+ \S?Drafts"
+    And IMAP response contains "\\Trash\) \S+ \S?Trash"
+    And IMAP response contains "\\Junk\) \S+ \S?Spam"
+    And IMAP response contains "\\Archive\) \S+ \S?Archive"
+    And IMAP response contains "\\All\) \S+ \S?All Mail"
+    And IMAP response contains "Folders/mbox"
+
+  Scenario: List only special-use mailboxes
+    When IMAP client lists mailboxes with selection "SPECIAL-USE" returning ""
+    Then IMAP response is "OK"
+    And IMAP response contains "\\Sent"
+    And IMAP response does not contain "INBOX"
+    And IMAP response does not contain "Folders/mbox"
+
+  Scenario: List mailboxes matching multiple patterns
+    When IMAP client lists mailboxes "INBOX,Folders/*" returning "CHILDREN"
+    Then IMAP response is "OK"
+    And IMAP response contains "INBOX"
+    And IMAP response contains "\\HasNoChildren.*Folders/mbox"
+    And IMAP response does not contain "Sent"
+
+  Scenario: List subscribed mailboxes
+    When IMAP client lists mailboxes with selection "SUBSCRIBED" returning ""
+    Then IMAP response is "OK"
+    And IMAP response contains "\\Subscribed.*INBOX"
+
+  Scenario: Enable unknown capability
+    When IMAP client sends command "ENABLE UNKNOWN CONDSTORE"
+    Then IMAP response is "OK"
+    And IMAP response contains "ENABLED CONDSTORE"
+    And IMAP response does not contain "UNKNOWN"
+
+  Scenario: Enable is not allowed in selected state
+    Given there is IMAP client selected in "INBOX"
+    When IMAP client sends command "ENABLE CONDSTORE"
+    Then IMAP response is "IMAP error: NO ENABLE is not allowed in the selected state"
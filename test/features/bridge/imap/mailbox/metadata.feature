@@ -0,0 +1,60 @@
+Feature: IMAP mailbox metadata
+  Background:
+    Given there is connected user "user"
+    And there is "user" with mailbox "Folders/mbox" colored "#7272a7"
+    And there is "user" with mailbox "Labels/label"
+    And there is IMAP client logged in as "user"
+
+  Scenario: Capabilities contain metadata extension
+    When IMAP client sends command "CAPABILITY"
+    Then IMAP response is "OK"
+    And IMAP response contains "METADATA"
+
+  Scenario: Get color of folder
+    When IMAP client sends command "GETMETADATA Folders/mbox /private/color"
+    Then IMAP response is "OK"
+    And IMAP response contains "METADATA .Folders/mbox. \(/private/color .#7272a7.\)"
+
+  Scenario: Get missing color of label
+    When IMAP client sends command "GETMETADATA Labels/label /private/color"
+    Then IMAP response is "OK"
+    And IMAP response contains "METADATA .Labels/label. \(/private/color NIL\)"
+
+  Scenario: Get special-use of system mailbox
+    When IMAP client sends command "GETMETADATA Sent (/private/specialuse /private/color)"
+    Then IMAP response is "OK"
+    And IMAP response contains "METADATA .Sent. \(/private/specialuse \S+Sent. /private/color NIL\)"
+
+  Scenario: Get all private entries
+    When IMAP client sends command "GETMETADATA (DEPTH infinity) Folders/mbox /private"
+    Then IMAP response is "OK"
+    And IMAP response contains "METADATA .Folders/mbox. \(/private/color .#7272a7.\)"
+
+  Scenario: Get entries longer than maximal size
+    When IMAP client sends command "GETMETADATA (MAXSIZE 3) Folders/mbox /private/color"
+    Then IMAP response is "OK \[METADATA LONGENTRIES 7\]"
+    And IMAP response does not contain "METADATA .Folders/mbox."
+
+  Scenario: Set color of folder
+    When IMAP client sends command "SETMETADATA Folders/mbox (/private/color #cf5858)"
+    Then IMAP response is "OK"
+    And API endpoint "PUT /labels" is called with
+      """
+      {
+        "Name": "mbox",
+        "Color": "#cf5858"
+      }
+      """
+
+  Scenario: Set invalid color
+    When IMAP client sends command "SETMETADATA Folders/mbox (/private/color red)"
+    Then IMAP response is "IMAP error: NO color must be in the form #rrggbb"
+    And API endpoint "PUT /labels" is not called
+
+  Scenario: Set color of system mailbox
+    When IMAP client sends command "SETMETADATA INBOX (/private/color #cf5858)"
+    Then IMAP response is "IMAP error: NO cannot set color of system mailboxes"
+
+  Scenario: Set read-only entry
+    When IMAP client sends command "SETMETADATA Folders/mbox (/private/specialuse Sent)"
+    Then IMAP response is "IMAP error: NO entry cannot be set"
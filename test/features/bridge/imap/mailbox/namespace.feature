@@ -0,0 +1,66 @@
+Feature: IMAP namespace of other addresses
+  Background:
+    Given there is connected user "userMoreAddresses"
+    And there is "userMoreAddresses" with mailbox "Folders/mbox"
+    And there are messages in mailbox "Folders/mbox" for "userMoreAddresses"
+      | from              | to          | subject |
+      | john.doe@mail.com | [primary]   | foo     |
+      | jane.doe@mail.com | [secondary] | bar     |
+
+  Scenario: Capabilities contain namespace extension
+    Given there is "userMoreAddresses" in "split" address mode
+    And there is IMAP client logged in as "userMoreAddresses" with address "primary"
+    When IMAP client sends command "CAPABILITY"
+    Then IMAP response is "OK"
+    And IMAP response contains "NAMESPACE"
+
+  Scenario: Namespace of other addresses in split mode
+    Given there is "userMoreAddresses" in "split" address mode
+    And there is IMAP client logged in as "userMoreAddresses" with address "primary"
+    When IMAP client sends command "NAMESPACE"
+    Then IMAP response is "OK"
+    And IMAP response contains "NAMESPACE \(\(.. ./.\)\) \(\(.Other Users/. ./.\)\) NIL"
+
+  Scenario: No namespace of other addresses in combined mode
+    Given there is "userMoreAddresses" in "combined" address mode
+    And there is IMAP client logged in as "userMoreAddresses" with address "primary"
+    When IMAP client sends command "NAMESPACE"
+    Then IMAP response is "OK"
+    And IMAP response contains "NAMESPACE \(\(.. ./.\)\) NIL NIL"
+
+  Scenario: List mailboxes of other addresses
+    Given there is "userMoreAddresses" in "split" address mode
+    And there is IMAP client logged in as "userMoreAddresses" with address "primary"
+    When IMAP client lists mailboxes
+    Then IMAP response is "OK"
+    And IMAP response contains "\\Noselect.*Other Users/secondaryaddress@pm.me."
+    And IMAP response contains "Other Users/secondaryaddress@pm.me/INBOX"
+    And IMAP response contains "Other Users/secondaryaddress@pm.me/Folders/mbox"
+    And IMAP response does not contain "Other Users/primaryaddress@pm.me"
+
+  Scenario: Select mailbox of other address
+    Given there is "userMoreAddresses" in "split" address mode
+    And there is IMAP client logged in as "userMoreAddresses" with address "primary"
+    When IMAP client selects "Other Users/secondaryaddress@pm.me/Folders/mbox"
+    Then IMAP response is "OK"
+    And IMAP response contains "1 EXISTS"
+    When IMAP client fetches header of "1"
+    Then IMAP response is "OK"
+    And IMAP response contains "Subject: bar"
+
+  Scenario: Copy message within mailboxes of other address
+    Given there is "userMoreAddresses" in "split" address mode
+    And there is "userMoreAddresses" with mailbox "Folders/other"
+    And there is IMAP client logged in as "userMoreAddresses" with address "primary"
+    And there is IMAP client selected in "Other Users/secondaryaddress@pm.me/Folders/mbox"
+    When IMAP client copies message seq "1" to "Other Users/secondaryaddress@pm.me/Folders/other"
+    Then IMAP response is "OK"
+    And mailbox "Folders/other" for address "secondary" of "userMoreAddresses" has messages
+      | from              | to          | subject |
+      | jane.doe@mail.com | [secondary] | bar     |
+
+  Scenario: Select mailbox of unknown address
+    Given there is "userMoreAddresses" in "split" address mode
+    And there is IMAP client logged in as "userMoreAddresses" with address "primary"
+    When IMAP client selects "Other Users/unknown@pm.me/INBOX"
+    Then IMAP response is "IMAP error: NO no such mailbox"
@@ -0,0 +1,54 @@
+Feature: IMAP saved searches
+  Background:
+    Given there is connected user "user"
+    And there are messages in mailbox "INBOX" for "user"
+      | from              | to         | subject | read  |
+      | boss@example.com  | user@pm.me | foo     | false |
+      | boss@example.com  | user@pm.me | bar     | true  |
+      | john.doe@mail.com | user@pm.me | baz     | false |
+    And there is "user" with saved search "Unread from boss" for "UNSEEN FROM boss@example.com"
+    And there is IMAP client logged in as "user"
+
+  Scenario: List saved searches
+    When IMAP client lists mailboxes
+    Then IMAP response contains "Searches"
+    And IMAP response contains "Searches/Unread from boss"
+
+  Scenario: Fetch from saved search
+    Given there is IMAP client selected in "Searches/Unread from boss"
+    When IMAP client fetches "1:*"
+    Then IMAP response is "OK"
+    And IMAP response has 1 message
+    When IMAP client fetches header of "1"
+    Then IMAP response is "OK"
+    And IMAP response contains "Subject: foo"
+
+  Scenario: Saved search is updated when message no longer matches
+    Given there is IMAP client selected in "INBOX"
+    Then mailbox "Searches/Unread from boss" for "user" has 1 message
+    When IMAP client marks message seq "1" as read
+    Then IMAP response is "OK"
+    And mailbox "Searches/Unread from boss" for "user" has 0 messages
+
+  Scenario: Saved search is updated when message starts to match
+    Given there is IMAP client selected in "INBOX"
+    When IMAP client marks message seq "2" as unread
+    Then IMAP response is "OK"
+    And mailbox "Searches/Unread from boss" for "user" has 2 messages
+
+  Scenario: Copy to saved search is not allowed
+    Given there is IMAP client selected in "INBOX"
+    When IMAP client copies message seq "3" to "Searches/Unread from boss"
+    Then IMAP response is "IMAP error: NO operation not allowed for saved search mailbox"
+    And mailbox "Searches/Unread from boss" for "user" has 1 message
+
+  Scenario: Rename saved search is not allowed
+    When IMAP client renames mailbox "Searches/Unread from boss" to "Searches/Boss"
+    Then IMAP response is "IMAP error: NO operation not allowed for saved search mailbox"
+
+  Scenario: Delete saved search
+    When IMAP client deletes mailbox "Searches/Unread from boss"
+    Then IMAP response is "OK"
+    And "user" does not have mailbox "Searches/Unread from boss"
+    When IMAP client lists mailboxes
+    Then IMAP response does not contain "Searches"
@@ -88,3 +88,58 @@ Feature: IMAP search messages
     When IMAP client searches for "SUBJECT baz TO name@pm.me SEEN UNFLAGGED"
     Then IMAP response is "OK"
     And IMAP response contains "SEARCH 3[^0-9]*$"
+
+  Scenario: Search by NOT criteria
+    When IMAP client searches for "NOT FROM jane.doe@email.com"
+    Then IMAP response is "OK"
+    And IMAP response contains "SEARCH 1[^0-9]*$"
+
+  Scenario: Search by OR criteria
+    When IMAP client searches for "OR SUBJECT foo SUBJECT baz"
+    Then IMAP response is "OK"
+    And IMAP response contains "SEARCH 1 3[^0-9]*$"
+
+  Scenario: Search by nested NOT and OR criteria
+    When IMAP client searches for "NOT OR SUBJECT foo FLAGGED"
+    Then IMAP response is "OK"
+    And IMAP response contains "SEARCH 3[^0-9]*$"
+
+  Scenario: Search by Body
+    When IMAP client searches for "BODY world"
+    Then IMAP response is "OK"
+    And IMAP response contains "SEARCH 2[^0-9]*$"
+
+  Scenario: Search by Body without match
+    When IMAP client searches for "BODY foo"
+    Then IMAP response is "OK"
+    And IMAP response contains "SEARCH[^0-9]*$"
+
+  Scenario: Search by Text in header
+    When IMAP client searches for "TEXT baz"
+    Then IMAP response is "OK"
+    And IMAP response contains "SEARCH 3[^0-9]*$"
+
+  Scenario: Search by Text in body
+    When IMAP client searches for "TEXT hello"
+    Then IMAP response is "OK"
+    And IMAP response contains "SEARCH 1[^0-9]*$"
+
+  Scenario: Search by Body combined with OR criteria
+    When IMAP client searches for "OR BODY hello BODY bye"
+    Then IMAP response is "OK"
+    And IMAP response contains "SEARCH 1 3[^0-9]*$"
+
+  Scenario: Search by size of messages with unknown size
+    When IMAP client searches for "LARGER 1"
+    Then IMAP response is "OK"
+    And IMAP response contains "SEARCH 1 2 3[^0-9]*$"
+
+  Scenario: Search by NOT size of messages with unknown size
+    When IMAP client searches for "NOT LARGER 1000000"
+    Then IMAP response is "OK"
+    And IMAP response contains "SEARCH 1 2 3[^0-9]*$"
+
+  Scenario: Search by NOT size without match
+    When IMAP client searches for "NOT SMALLER 1000000"
+    Then IMAP response is "OK"
+    And IMAP response contains "SEARCH[^0-9]*$"
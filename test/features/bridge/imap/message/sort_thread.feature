@@ -0,0 +1,63 @@
+Feature: IMAP sort and thread messages
+  Background:
+    Given there is connected user "user"
+    Given there are messages in mailbox "INBOX" for "user"
+      | from               | to         | subject      | time                | conversation | body  |
+      | john.doe@email.com | user@pm.me | foo          | 2021-01-03T10:00:00 | conv1        | hello |
+      | jane.doe@email.com | user@pm.me | bar          | 2021-01-01T10:00:00 | conv2        | world |
+      | adam.doe@email.com | user@pm.me | Re: foo      | 2021-01-02T10:00:00 | conv1        | bye   |
+    And there is IMAP client logged in as "user"
+    And there is IMAP client selected in "INBOX"
+
+  Scenario: Capabilities contain sort and thread extensions
+    When IMAP client sends command "CAPABILITY"
+    Then IMAP response is "OK"
+    And IMAP response contains "SORT"
+    And IMAP response contains "SORT=DISPLAY"
+    And IMAP response contains "THREAD=ORDEREDSUBJECT"
+    And IMAP response contains "THREAD=REFERENCES"
+    And IMAP response contains "THREAD=X-CONVERSATION"
+
+  Scenario: Sort by date
+    When IMAP client sorts by "DATE" for "ALL"
+    Then IMAP response is "OK"
+    And IMAP response contains "SORT 2 3 1[^0-9]*$"
+
+  Scenario: Sort by reversed arrival
+    When IMAP client sorts by "REVERSE ARRIVAL" for "ALL"
+    Then IMAP response is "OK"
+    And IMAP response contains "SORT 1 3 2[^0-9]*$"
+
+  Scenario: Sort by subject and from
+    When IMAP client sorts by "SUBJECT FROM" for "ALL"
+    Then IMAP response is "OK"
+    And IMAP response contains "SORT 2 3 1[^0-9]*$"
+
+  Scenario: Sort with search criteria
+    When IMAP client sorts by "FROM" for "NOT FROM jane.doe@email.com"
+    Then IMAP response is "OK"
+    And IMAP response contains "SORT 3 1[^0-9]*$"
+
+  Scenario: Sort with unknown criterion
+    When IMAP client sorts by "UNKNOWN" for "ALL"
+    Then IMAP response is "IMAP error: BAD unknown sort criterion UNKNOWN"
+
+  Scenario: Thread by conversation
+    When IMAP client threads by "X-CONVERSATION" for "ALL"
+    Then IMAP response is "OK"
+    And IMAP response contains "THREAD \(2\)\(3 1\)"
+
+  Scenario: Thread by subject
+    When IMAP client threads by "ORDEREDSUBJECT" for "ALL"
+    Then IMAP response is "OK"
+    And IMAP response contains "THREAD \(2\)\(3 1\)"
+
+  Scenario: Thread by references
+    When IMAP client threads by "REFERENCES" for "ALL"
+    Then IMAP response is "OK"
+    And IMAP response contains "THREAD \(2\)\(1 3\)"
+
+  Scenario: Thread by conversation with UIDs
+    When IMAP client sends command "UID THREAD X-CONVERSATION UTF-8 1:2"
+    Then IMAP response is "OK"
+    And IMAP response contains "THREAD \(2\)\(1\)"
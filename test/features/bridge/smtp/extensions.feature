@@ -0,0 +1,54 @@
+Feature: SMTP extensions
+  Background:
+    Given there is connected user "user"
+
+  Scenario Outline: EHLO advertises <extension>
+    When SMTP client sends "EHLO example.com"
+    Then SMTP response contains "<extension>"
+
+    Examples:
+      | extension      |
+      | SIZE 36920932  |
+      | PIPELINING     |
+      | 8BITMIME       |
+      | CHUNKING       |
+      | SMTPUTF8       |
+      | DSN            |
+
+  Scenario: Oversized message is refused before upload
+    Given there is SMTP client logged in as "user"
+    When SMTP client sends "MAIL FROM:<[userAddress]> SIZE=40000000"
+    Then SMTP response is "SMTP error: 552 5.3.4 Max message size exceeded"
+
+  Scenario: Message of maximum size encoded by base64 is accepted
+    Given there is SMTP client logged in as "user"
+    When SMTP client sends "MAIL FROM:<[userAddress]> SIZE=35872356"
+    Then SMTP response is "OK"
+
+  Scenario: Message of announced size is accepted
+    Given there is SMTP client logged in as "user"
+    When SMTP client sends "MAIL FROM:<[userAddress]> SIZE=1000 BODY=8BITMIME"
+    Then SMTP response is "OK"
+
+  Scenario: Message is sent in chunks
+    Given there is SMTP client logged in as "user"
+    When SMTP client sends "MAIL FROM:<[userAddress]>"
+    And SMTP client sends "RCPT TO:<bridgetest@protonmail.com>"
+    And SMTP client sends "BDAT 51\r\nTo: <bridgetest@protonmail.com>\r\nSubject: Chunked"
+    Then SMTP response is "OK"
+    When SMTP client sends "BDAT 9 LAST\r\n\r\nHello"
+    Then SMTP response is "OK"
+    And API mailbox "Sent" for "user" has messages
+      | from          | to                        | subject |
+      | [userAddress] | bridgetest@protonmail.com | Chunked |
+
+  Scenario: Message is sent to internationalized address
+    Given there is SMTP client logged in as "user"
+    When SMTP client sends "MAIL FROM:<[userAddress]> SMTPUTF8"
+    And SMTP client sends "RCPT TO:<δοκιμή@παράδειγμα.δοκιμή>"
+    And SMTP client sends "DATA"
+    And SMTP client sends "To: <δοκιμή@παράδειγμα.δοκιμή>\r\nSubject: Internationalized\r\n\r\nHello\r\n."
+    Then SMTP response is "OK"
+    And API mailbox "Sent" for "user" has messages
+      | from          | to                       | subject           |
+      | [userAddress] | δοκιμή@παράδειγμα.δοκιμή | Internationalized |
@@ -0,0 +1,73 @@
+Feature: SMTP sending from plus and catch-all addresses
+  Scenario: Plus address is kept as sender by default
+    Given there is connected user "user"
+    And there is SMTP client logged in as "user"
+    When SMTP client sends message
+      """
+      From: Bridge Test <user+project@pm.me>
+      To: Internal Bridge <bridgetest@protonmail.com>
+      Subject: Plus address
+
+      Hello
+
+      """
+    Then SMTP response is "OK"
+    And mailbox "Sent" for "user" has messages
+      | from               | to                        | subject      |
+      | user+project@pm.me | bridgetest@protonmail.com | Plus address |
+
+  Scenario: Plus address in MAIL FROM is accepted by default
+    Given there is connected user "user"
+    When SMTP client authenticates "user"
+    Then SMTP response is "OK"
+    When SMTP client sends "MAIL FROM:<user+project@pm.me>"
+    Then SMTP response is "OK"
+
+  Scenario: Plus address is refused with exact policy
+    Given there is connected user "user"
+    And there is "user" with alias policy "exact"
+    And there is SMTP client logged in as "user"
+    When SMTP client sends message
+      """
+      From: Bridge Test <user+project@pm.me>
+      To: Internal Bridge <bridgetest@protonmail.com>
+      Subject: Plus address
+
+      Hello
+
+      """
+    Then SMTP response is "SMTP error: 554 5.0.0 Error: transaction failed: backend: invalid email address: not owned by user"
+    When SMTP client sends "MAIL FROM:<user+project@pm.me>"
+    Then SMTP response is "SMTP error: 451 4.0.0 backend: invalid return path: not owned by user"
+
+  Scenario: Catch-all identity is sent from the catch-all address
+    Given there is connected user "userCatchAll"
+    And there is "userCatchAll" with alias policy "catch-all"
+    And there is SMTP client logged in as "userCatchAll"
+    When SMTP client sends message
+      """
+      From: Sales <sales@example.com>
+      To: Internal Bridge <bridgetest@protonmail.com>
+      Subject: Catch-all
+
+      Hello
+
+      """
+    Then SMTP response is "OK"
+    And mailbox "Sent" for "userCatchAll" has messages
+      | from             | to                        | subject   |
+      | user@example.com | bridgetest@protonmail.com | Catch-all |
+
+  Scenario: Catch-all identity is refused with plus policy
+    Given there is connected user "userCatchAll"
+    And there is SMTP client logged in as "userCatchAll"
+    When SMTP client sends message
+      """
+      From: Sales <sales@example.com>
+      To: Internal Bridge <bridgetest@protonmail.com>
+      Subject: Catch-all
+
+      Hello
+
+      """
+    Then SMTP response is "SMTP error: 554 5.0.0 Error: transaction failed: backend: invalid email address: not owned by user"
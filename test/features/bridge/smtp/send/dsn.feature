@@ -0,0 +1,52 @@
+Feature: SMTP sending with delivery status notifications
+  Background:
+    Given there is connected user "user"
+    And there is SMTP client logged in as "user"
+
+  Scenario: Successful delivery is reported when requested
+    When SMTP client sends "MAIL FROM:<[userAddress]>"
+    Then SMTP response is "OK"
+    When SMTP client sends "RCPT TO:<bridgetest@protonmail.com> NOTIFY=SUCCESS,FAILURE ORCPT=rfc822;bridgetest@protonmail.com"
+    Then SMTP response is "OK"
+    When SMTP client sends "DATA"
+    Then SMTP response is "OK"
+    When SMTP client sends "From: Bridge Test <[userAddress]>\r\nTo: Internal Bridge <bridgetest@protonmail.com>\r\nSubject: Reported\r\n\r\nHello\r\n."
+    Then SMTP response is "OK"
+    And API mailbox "Sent" for "user" has messages
+      | from          | to                        | subject  |
+      | [userAddress] | bridgetest@protonmail.com | Reported |
+    And API mailbox "INBOX" for "user" has messages
+      | from                    | subject                         |
+      | MAILER-DAEMON@localhost | Successful Mail Delivery Report |
+
+  Scenario: Envelope parameters of delivery report are accepted
+    When SMTP client sends "MAIL FROM:<[userAddress]> RET=HDRS ENVID=QQ314159"
+    Then SMTP response is "OK"
+    When SMTP client sends "RCPT TO:<bridgetest@protonmail.com> NOTIFY=SUCCESS"
+    Then SMTP response is "OK"
+    When SMTP client sends "DATA"
+    Then SMTP response is "OK"
+    When SMTP client sends "From: Bridge Test <[userAddress]>\r\nTo: Internal Bridge <bridgetest@protonmail.com>\r\nSubject: Enveloped\r\n\r\nHello\r\n."
+    Then SMTP response is "OK"
+    And API mailbox "INBOX" for "user" has messages
+      | from                    | subject                         |
+      | MAILER-DAEMON@localhost | Successful Mail Delivery Report |
+
+  Scenario: Successful delivery is not reported by default
+    When SMTP client sends message
+      """
+      From: Bridge Test <[userAddress]>
+      To: Internal Bridge <bridgetest@protonmail.com>
+      Subject: Not reported
+
+      World
+
+      """
+    Then SMTP response is "OK"
+    And API mailbox "INBOX" for "user" has 0 messages
+
+  Scenario: Invalid notification request is refused
+    When SMTP client sends "MAIL FROM:<[userAddress]>"
+    Then SMTP response is "OK"
+    When SMTP client sends "RCPT TO:<bridgetest@protonmail.com> NOTIFY=NEVER,SUCCESS"
+    Then SMTP response is "SMTP error: 501 5.5.4 Malformed NOTIFY parameter value"
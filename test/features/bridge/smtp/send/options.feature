@@ -0,0 +1,95 @@
+Feature: SMTP sending with options in custom headers
+  Background:
+    Given there is connected user "user"
+    And there is SMTP client logged in as "user"
+
+  Scenario: Message is scheduled
+    When SMTP client sends message
+      """
+      From: Bridge Test <[userAddress]>
+      To: Internal Bridge <bridgetest@protonmail.com>
+      Subject: Scheduled
+      X-Pm-Deliver-At: Fri, 01 Jan 2100 10:00:00 +0000
+
+      World
+
+      """
+    Then SMTP response is "OK"
+    And API mailbox "Sent" for "user" has messages
+      | from          | to                        | subject   | time                            |
+      | [userAddress] | bridgetest@protonmail.com | Scheduled | Fri, 01 Jan 2100 10:00:00 +0000 |
+
+  Scenario: Message expires
+    When SMTP client sends message
+      """
+      From: Bridge Test <[userAddress]>
+      To: Internal Bridge <bridgetest@protonmail.com>
+      Subject: Expiring
+      X-Pm-Expires-In: 2h
+
+      World
+
+      """
+    Then SMTP response is "OK"
+    And API mailbox "Sent" for "user" has messages
+      | from          | to                        | subject  | expiresin |
+      | [userAddress] | bridgetest@protonmail.com | Expiring | 2h        |
+
+  Scenario: Message with invalid expiration is refused
+    When SMTP client sends message
+      """
+      From: Bridge Test <[userAddress]>
+      To: Internal Bridge <bridgetest@protonmail.com>
+      Subject: Expiring
+      X-Pm-Expires-In: 60d
+
+      World
+
+      """
+    Then SMTP response is "SMTP error: 554 5.0.0 Error: transaction failed: invalid X-Pm-Expires-In: 60d"
+    And API mailbox "Sent" for "user" has 0 messages
+
+  Scenario: Message with required encryption to internal address
+    When SMTP client sends message
+      """
+      From: Bridge Test <[userAddress]>
+      To: Internal Bridge <bridgetest@protonmail.com>
+      Subject: Encrypted
+      X-Pm-Encrypt: required
+
+      World
+
+      """
+    Then SMTP response is "OK"
+    And API mailbox "Sent" for "user" has messages
+      | from          | to                        | subject   |
+      | [userAddress] | bridgetest@protonmail.com | Encrypted |
+
+  Scenario: Message with required encryption to internal and external address is refused
+    When SMTP client sends message
+      """
+      From: Bridge Test <[userAddress]>
+      To: Internal Bridge <bridgetest@protonmail.com>, External Bridge <pm.bridge.qa@gmail.com>
+      Subject: Encrypted
+      X-Pm-Encrypt: required
+
+      World
+
+      """
+    Then SMTP response is "SMTP error: 554 5.0.0 Error: transaction failed: encryption is required but message to pm.bridge.qa@gmail.com cannot be encrypted"
+    And API mailbox "Sent" for "user" has 0 messages
+    And API mailbox "INBOX" for "user" has 0 messages
+
+  Scenario: Message without signature to internal address is refused
+    When SMTP client sends message
+      """
+      From: Bridge Test <[userAddress]>
+      To: Internal Bridge <bridgetest@protonmail.com>
+      Subject: Unsigned
+      X-Pm-Sign: no
+
+      World
+
+      """
+    Then SMTP response is "SMTP error: 554 5.0.0 Error: transaction failed: message to bridgetest@protonmail.com must be signed because it is encrypted"
+    And API mailbox "Sent" for "user" has 0 messages
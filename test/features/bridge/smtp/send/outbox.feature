@@ -0,0 +1,48 @@
+Feature: SMTP sending while offline
+  Background:
+    Given there is connected user "user"
+    And there is SMTP client logged in as "user"
+
+  Scenario: Message is queued and sent once the connection is restored
+    When the internet connection is lost
+    And SMTP client sends message
+      """
+      From: Bridge Test <[userAddress]>
+      To: Internal Bridge <bridgetest@protonmail.com>
+      Subject: Queued
+
+      World
+
+      """
+    Then SMTP response is "OK"
+    And API mailbox "Sent" for "user" has 0 messages
+    When the internet connection is restored
+    Then API mailbox "Sent" for "user" has 1 message
+    And mailbox "Sent" for "user" has messages
+      | from          | to                        | subject |
+      | [userAddress] | bridgetest@protonmail.com | Queued  |
+
+  Scenario: All queued messages are sent once the connection is restored
+    When the internet connection is lost
+    And SMTP client sends message
+      """
+      From: Bridge Test <[userAddress]>
+      To: Internal Bridge <bridgetest@protonmail.com>
+      Subject: First
+
+      World
+
+      """
+    Then SMTP response is "OK"
+    When SMTP client sends message
+      """
+      From: Bridge Test <[userAddress]>
+      To: Internal Bridge <bridgetest@protonmail.com>
+      Subject: Second
+
+      World
+
+      """
+    Then SMTP response is "OK"
+    When the internet connection is restored
+    Then API mailbox "Sent" for "user" has 2 messages
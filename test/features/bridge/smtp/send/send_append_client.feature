@@ -0,0 +1,95 @@
+Feature: SMTP sending with APPENDing to Sent by known clients
+  Background:
+    Given there is connected user "user"
+    And there is "user" with mailbox "Labels/label"
+    And there is IMAP client logged in as "user"
+    And there is SMTP client logged in as "user"
+
+  Scenario: Thunderbird appends to Sent
+    When IMAP client sends ID with argument:
+      """
+      "name" "Thunderbird" "version" "78.10.0"
+      """
+    And SMTP client sends message
+      """
+      To: Internal Bridge <bridgetest@protonmail.com>
+      Subject: Send and append
+      Message-ID: bridgemessage42
+
+      hello
+
+      """
+    Then SMTP response is "OK"
+    When IMAP client imports message to "Sent"
+      """
+      To: Internal Bridge <bridgetest@protonmail.com>
+      Subject: Send and append
+      Message-ID: bridgemessage42
+
+      hello
+
+      """
+    Then IMAP response is "OK"
+    And mailbox "Sent" for "user" has 1 messages
+
+  Scenario: Thunderbird appends to other mailbox
+    When IMAP client sends ID with argument:
+      """
+      "name" "Thunderbird" "version" "78.10.0"
+      """
+    And SMTP client sends message
+      """
+      To: Internal Bridge <bridgetest@protonmail.com>
+      Subject: Send and append
+      Message-ID: bridgemessage42
+
+      hello
+
+      """
+    Then SMTP response is "OK"
+    When IMAP client imports message to "Labels/label"
+      """
+      To: Internal Bridge <bridgetest@protonmail.com>
+      Subject: Send and append
+      Message-ID: bridgemessage42
+
+      hello
+
+      """
+    Then IMAP response is "OK"
+    And mailbox "Sent" for "user" has 1 messages
+    And mailbox "Labels/label" for "user" has messages
+      | externalid      | subject         |
+      | bridgemessage42 | Send and append |
+    And mailbox "All Mail" for "user" has 1 messages
+
+  Scenario: Other client appends to other mailbox
+    When IMAP client sends ID with argument:
+      """
+      "name" "Microsoft Outlook" "version" "16.0"
+      """
+    And SMTP client sends message
+      """
+      To: Internal Bridge <bridgetest@protonmail.com>
+      Subject: Send and append
+      Message-ID: bridgemessage42
+
+      hello
+
+      """
+    Then SMTP response is "OK"
+    When IMAP client imports message to "Labels/label"
+      """
+      To: Internal Bridge <bridgetest@protonmail.com>
+      Subject: Send and append
+      Message-ID: bridgemessage42
+
+      hello
+
+      """
+    Then IMAP response is "OK"
+    And mailbox "Sent" for "user" has 1 messages
+    And mailbox "Labels/label" for "user" has messages
+      | externalid      | subject         |
+      | bridgemessage42 | Send and append |
+    And mailbox "All Mail" for "user" has 1 messages
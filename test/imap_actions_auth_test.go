@@ -29,6 +29,7 @@ func IMAPActionsAuthFeatureContext(s *godog.Suite) {
 	s.Step(`^IMAP client authenticates "([^"]*)" with bad password$`, imapClientAuthenticatesWithBadPassword)
 	s.Step(`^IMAP client authenticates with username "([^"]*)" and password "([^"]*)"$`, imapClientAuthenticatesWithUsernameAndPassword)
 	s.Step(`^IMAP client logs out$`, imapClientLogsOut)
+	s.Step(`^IMAP client enables compression$`, imapClientEnablesCompression)
 }
 
 func imapClientAuthenticates(bddUserID string) error {
@@ -80,3 +81,9 @@ func imapClientLogsOut() error {
 	ctx.SetIMAPLastResponse("imap", res)
 	return nil
 }
+
+func imapClientEnablesCompression() error {
+	res := ctx.GetIMAPClient("imap").Compress()
+	ctx.SetIMAPLastResponse("imap", res)
+	return nil
+}
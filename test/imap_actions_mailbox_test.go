@@ -18,6 +18,7 @@
 package tests
 
 import (
+	"strings"
 	"time"
 
 	"github.com/cucumber/godog"
@@ -28,6 +29,8 @@ func IMAPActionsMailboxFeatureContext(s *godog.Suite) {
 	s.Step(`^IMAP client renames mailbox "([^"]*)" to "([^"]*)"$`, imapClientRenamesMailboxTo)
 	s.Step(`^IMAP client deletes mailbox "([^"]*)"$`, imapClientDeletesMailbox)
 	s.Step(`^IMAP client lists mailboxes$`, imapClientListsMailboxes)
+	s.Step(`^IMAP client lists mailboxes with selection "([^"]*)" returning "([^"]*)"$`, imapClientListsMailboxesWithSelectionReturning)
+	s.Step(`^IMAP client lists mailboxes "([^"]*)" returning "([^"]*)"$`, imapClientListsMailboxesReturning)
 	s.Step(`^IMAP client selects "([^"]*)"$`, imapClientSelects)
 	s.Step(`^IMAP client gets info of "([^"]*)"$`, imapClientGetsInfoOf)
 	s.Step(`^IMAP client "([^"]*)" gets info of "([^"]*)"$`, imapClientNamedGetsInfoOf)
@@ -68,6 +71,18 @@ func imapClientListsMailboxes() error {
 	return nil
 }
 
+func imapClientListsMailboxesWithSelectionReturning(selectionOptions, returnOptions string) error {
+	res := ctx.GetIMAPClient("imap").ListMailboxesExtended(selectionOptions, []string{"*"}, returnOptions)
+	ctx.SetIMAPLastResponse("imap", res)
+	return nil
+}
+
+func imapClientListsMailboxesReturning(patterns, returnOptions string) error {
+	res := ctx.GetIMAPClient("imap").ListMailboxesExtended("", strings.Split(patterns, ","), returnOptions)
+	ctx.SetIMAPLastResponse("imap", res)
+	return nil
+}
+
 func imapClientSelects(mailboxName string) error {
 	res := ctx.GetIMAPClient("imap").Select(mailboxName)
 	ctx.SetIMAPLastResponse("imap", res)
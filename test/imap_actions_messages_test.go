@@ -29,11 +29,14 @@ import (
 
 func IMAPActionsMessagesFeatureContext(s *godog.Suite) {
 	s.Step(`^IMAP client sends command "([^"]*)"$`, imapClientSendsCommand)
+	s.Step(`^IMAP client "([^"]*)" sends command "([^"]*)"$`, imapClientNamedSendsCommand)
 	s.Step(`^IMAP client fetches "([^"]*)"$`, imapClientFetches)
 	s.Step(`^IMAP client fetches header(?:s)? of "([^"]*)"$`, imapClientFetchesHeader)
 	s.Step(`^IMAP client fetches body "([^"]*)"$`, imapClientFetchesBody)
 	s.Step(`^IMAP client fetches by UID "([^"]*)"$`, imapClientFetchesByUID)
 	s.Step(`^IMAP client searches for "([^"]*)"$`, imapClientSearchesFor)
+	s.Step(`^IMAP client sorts by "([^"]*)" for "([^"]*)"$`, imapClientSortsByFor)
+	s.Step(`^IMAP client threads by "([^"]*)" for "([^"]*)"$`, imapClientThreadsByFor)
 	s.Step(`^IMAP client copies message seq "([^"]*)" to "([^"]*)"$`, imapClientCopiesMessagesTo)
 	s.Step(`^IMAP client moves message seq "([^"]*)" to "([^"]*)"$`, imapClientMovesMessagesTo)
 	s.Step(`^IMAP clients "([^"]*)" and "([^"]*)" move message seq "([^"]*)" of "([^"]*)" from "([^"]*)" to "([^"]*)" by append and delete$`, imapClientsMoveMessageSeqOfUserFromToByAppendAndDelete)
@@ -67,8 +70,12 @@ func IMAPActionsMessagesFeatureContext(s *godog.Suite) {
 }
 
 func imapClientSendsCommand(command string) error {
-	res := ctx.GetIMAPClient("imap").SendCommand(command)
-	ctx.SetIMAPLastResponse("imap", res)
+	return imapClientNamedSendsCommand("imap", command)
+}
+
+func imapClientNamedSendsCommand(imapClient, command string) error {
+	res := ctx.GetIMAPClient(imapClient).SendCommand(command)
+	ctx.SetIMAPLastResponse(imapClient, res)
 	return nil
 }
 
@@ -102,6 +109,18 @@ func imapClientSearchesFor(query string) error {
 	return nil
 }
 
+func imapClientSortsByFor(criteria, query string) error {
+	res := ctx.GetIMAPClient("imap").Sort(criteria, query)
+	ctx.SetIMAPLastResponse("imap", res)
+	return nil
+}
+
+func imapClientThreadsByFor(algorithm, query string) error {
+	res := ctx.GetIMAPClient("imap").Thread(algorithm, query)
+	ctx.SetIMAPLastResponse("imap", res)
+	return nil
+}
+
 func imapClientCopiesMessagesTo(messageSeq, newMailboxName string) error {
 	res := ctx.GetIMAPClient("imap").Copy(messageSeq, newMailboxName)
 	ctx.SetIMAPLastResponse("imap", res)
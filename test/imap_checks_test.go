@@ -40,6 +40,8 @@ func IMAPChecksFeatureContext(s *godog.Suite) {
 	s.Step(`^IMAP client receives update marking message seq "([^"]*)" as unread within (\d+) seconds$`, imapClientReceivesUpdateMarkingMessageSeqAsUnreadWithin)
 	s.Step(`^IMAP client "([^"]*)" receives update marking message seq "([^"]*)" as unread within (\d+) seconds$`, imapClientNamedReceivesUpdateMarkingMessageSeqAsUnreadWithin)
 	s.Step(`^IMAP client "([^"]*)" does not receive update for message seq "([^"]*)" within (\d+) seconds$`, imapClientDoesNotReceiveUpdateForMessageSeqWithin)
+	s.Step(`^IMAP client "([^"]*)" receives "([^"]*)" within (\d+) seconds$`, imapClientNamedReceivesWithin)
+	s.Step(`^IMAP client "([^"]*)" does not receive "([^"]*)" within (\d+) seconds$`, imapClientNamedDoesNotReceiveWithin)
 	s.Step(`^IMAP client is logged out$`, imapClientIsLoggedOut)
 	s.Step(`^IMAP client "([^"]*)" is logged out$`, imapClientNamedIsLoggedOut)
 }
@@ -127,6 +129,16 @@ func imapClientDoesNotReceiveUpdateForMessageSeqWithin(clientID, messageSeq stri
 	return ctx.GetTestingError()
 }
 
+func imapClientNamedReceivesWithin(clientID, expectedResponse string, seconds int) error {
+	ctx.GetIMAPLastResponse(clientID).WaitForSections(time.Duration(seconds)*time.Second, expectedResponse)
+	return ctx.GetTestingError()
+}
+
+func imapClientNamedDoesNotReceiveWithin(clientID, unwantedResponse string, seconds int) error {
+	ctx.GetIMAPLastResponse(clientID).WaitForNotSections(time.Duration(seconds)*time.Second, unwantedResponse)
+	return ctx.GetTestingError()
+}
+
 func iterateOverSeqSet(seqSet string, callback func(string)) {
 	seq, err := imap.ParseSeqSet(seqSet)
 	if err != nil {
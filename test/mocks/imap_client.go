@@ -24,6 +24,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/ProtonMail/proton-bridge/internal/imap/compress"
 	"github.com/ProtonMail/proton-bridge/pkg/message"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -83,6 +84,29 @@ func (c *IMAPClient) SendCommand(command string) *IMAPResponse {
 	return imapResponse
 }
 
+// Compress sends COMPRESS command and once accepted, compresses all
+// following communication.
+func (c *IMAPClient) Compress() *IMAPResponse {
+	res := c.SendCommand("COMPRESS DEFLATE")
+	res.wait()
+	if res.err != nil {
+		return res
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	conn, err := compress.NewDeflateConn(c.conn)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	c.conn = conn
+	c.response = bufio.NewReader(conn)
+
+	return res
+}
+
 // Auth
 
 func (c *IMAPClient) Login(account, password string) *IMAPResponse {
@@ -99,6 +123,14 @@ func (c *IMAPClient) ListMailboxes() *IMAPResponse {
 	return c.SendCommand("LIST \"\" *")
 }
 
+func (c *IMAPClient) ListMailboxesExtended(selectionOptions string, patterns []string, returnOptions string) *IMAPResponse {
+	quotedPatterns := []string{}
+	for _, pattern := range patterns {
+		quotedPatterns = append(quotedPatterns, fmt.Sprintf("\"%s\"", pattern))
+	}
+	return c.SendCommand(fmt.Sprintf("LIST (%s) \"\" (%s) RETURN (%s)", selectionOptions, strings.Join(quotedPatterns, " "), returnOptions))
+}
+
 func (c *IMAPClient) Select(mailboxName string) *IMAPResponse {
 	return c.SendCommand(fmt.Sprintf("SELECT \"%s\"", mailboxName)) //nolint[gosec]
 }
@@ -165,6 +197,14 @@ func (c *IMAPClient) Search(query string) *IMAPResponse {
 	return c.SendCommand(fmt.Sprintf("SEARCH %s", query))
 }
 
+func (c *IMAPClient) Sort(criteria, query string) *IMAPResponse {
+	return c.SendCommand(fmt.Sprintf("SORT (%s) UTF-8 %s", criteria, query))
+}
+
+func (c *IMAPClient) Thread(algorithm, query string) *IMAPResponse {
+	return c.SendCommand(fmt.Sprintf("THREAD %s UTF-8 %s", algorithm, query))
+}
+
 // Message
 
 func (c *IMAPClient) Append(mailboxName, msg string) *IMAPResponse {
@@ -48,7 +48,6 @@ func (ir *IMAPResponse) sendCommand(reqTag string, reqIndex int, command string,
 	debug.printReq(command)
 	fmt.Fprintf(conn, "%s\r\n", command)
 
-	var section string
 	for {
 		line, err := response.ReadString('\n')
 
@@ -73,19 +72,15 @@ func (ir *IMAPResponse) sendCommand(reqTag string, reqIndex int, command string,
 		}
 		debug.printRes(line)
 
-		if strings.HasPrefix(line, "* ") { //nolint[gocritic]
-			if section != "" {
-				ir.sections = append(ir.sections, section)
-			}
-			section = line
-		} else if strings.HasPrefix(line, commandID) {
-			if section != "" {
-				ir.sections = append(ir.sections, section)
-			}
+		// Sections are available right away so unsolicited responses,
+		// e.g. during IDLE, can be checked before the command is finished.
+		if strings.HasPrefix(line, commandID) { //nolint[gocritic]
 			ir.result = line
 			break
+		} else if strings.HasPrefix(line, "* ") || len(ir.sections) == 0 {
+			ir.sections = append(ir.sections, line)
 		} else {
-			section += line
+			ir.sections[len(ir.sections)-1] += line
 		}
 	}
 
@@ -89,7 +89,7 @@ func (c *SMTPClient) SendCommands(commands ...string) *SMTPResponse {
 		c.debug.printReq(command)
 		fmt.Fprintf(c.conn, "%s\r\n", command)
 
-		message, err := c.response.ReadString('\n')
+		message, err := c.readResponse()
 		if err != nil {
 			smtpResponse.err = fmt.Errorf("read response failed: %v", err)
 			c.debug.printErr(smtpResponse.err.Error() + "\n")
@@ -117,6 +117,22 @@ func (c *SMTPClient) SendCommands(commands ...string) *SMTPResponse {
 	return smtpResponse
 }
 
+// readResponse reads the whole response including continuation lines
+// of multiline responses, such as the list of extensions for EHLO.
+func (c *SMTPClient) readResponse() (string, error) {
+	var response string
+	for {
+		line, err := c.response.ReadString('\n')
+		if err != nil {
+			return response, err
+		}
+		response += line
+		if len(line) < 4 || line[3] != '-' {
+			return response, nil
+		}
+	}
+}
+
 // Auth
 
 func (c *SMTPClient) Login(account, password string) *SMTPResponse {
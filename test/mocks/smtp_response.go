@@ -40,3 +40,9 @@ func (sr *SMTPResponse) AssertError(wantErrMsg string) *SMTPResponse {
 	}
 	return sr
 }
+
+func (sr *SMTPResponse) AssertContains(wantResponse string) *SMTPResponse {
+	a.NoError(sr.t, sr.err)
+	a.Contains(sr.t, sr.result, wantResponse)
+	return sr
+}
@@ -24,6 +24,7 @@ import (
 func SMTPChecksFeatureContext(s *godog.Suite) {
 	s.Step(`^SMTP response is "([^"]*)"$`, smtpResponseIs)
 	s.Step(`^SMTP response to "([^"]*)" is "([^"]*)"$`, smtpResponseNamedIs)
+	s.Step(`^SMTP response contains "([^"]*)"$`, smtpResponseContains)
 	s.Step(`^SMTP client is logged out`, smtpClientIsLoggedOut)
 	s.Step(`^SMTP client "([^"]*)" is logged out`, smtpClientNamedIsLoggedOut)
 }
@@ -42,6 +43,11 @@ func smtpResponseNamedIs(clientID, expectedResponse string) error {
 	return ctx.GetTestingError()
 }
 
+func smtpResponseContains(expectedResponse string) error {
+	ctx.GetSMTPLastResponse("smtp").AssertContains(expectedResponse)
+	return ctx.GetTestingError()
+}
+
 func smtpClientIsLoggedOut() error {
 	return smtpClientNamedIsLoggedOut("smtp")
 }
@@ -19,6 +19,7 @@ package tests
 
 import (
 	"fmt"
+	"net/mail"
 	"strings"
 	"time"
 
@@ -128,23 +129,15 @@ func mailboxForAddressOfUserHasMessages(mailboxName, bddAddressID, bddUserID str
 	if err != nil {
 		return internalError(err, "getting store mailbox")
 	}
-	apiIDs, err := mailbox.GetAPIIDsFromSequenceRange(1, 1000)
-	if err != nil {
-		return internalError(err, "getting API IDs from sequence range")
-	}
-	allMessages := []*store.Message{}
-	for _, apiID := range apiIDs {
-		message, err := mailbox.GetMessage(apiID)
-		if err != nil {
-			return internalError(err, "getting message by ID")
-		}
-		allMessages = append(allMessages, message)
-	}
 
 	head := messages.Rows[0].Cells
 	start := time.Now()
 	for {
 		afterLimit := time.Since(start) > ctx.EventLoopTimeout()
+		allMessages, err := getStoreMailboxMessages(mailbox)
+		if err != nil {
+			return err
+		}
 		allFound := true
 		for _, row := range messages.Rows[1:] {
 			found, err := storeMessagesContainsMessageRow(account, allMessages, head, row)
@@ -172,6 +165,22 @@ func mailboxForAddressOfUserHasMessages(mailboxName, bddAddressID, bddUserID str
 	return nil
 }
 
+func getStoreMailboxMessages(mailbox *store.Mailbox) ([]*store.Message, error) {
+	apiIDs, err := mailbox.GetAPIIDsFromSequenceRange(1, 1000)
+	if err != nil {
+		return nil, internalError(err, "getting API IDs from sequence range")
+	}
+	allMessages := []*store.Message{}
+	for _, apiID := range apiIDs {
+		message, err := mailbox.GetMessage(apiID)
+		if err != nil {
+			return nil, internalError(err, "getting message by ID")
+		}
+		allMessages = append(allMessages, message)
+	}
+	return allMessages, nil
+}
+
 func pmapiMessagesContainsMessageRow(account *accounts.TestAccount, pmapiMessages []*pmapi.Message, head []*gherkin.TableCell, row *gherkin.TableRow) (bool, error) {
 	messages := make([]interface{}, len(pmapiMessages))
 	for i := range pmapiMessages {
@@ -254,6 +263,24 @@ func messagesContainsMessageRow(account *accounts.TestAccount, allMessages []int
 				if message.Body != cell.Value {
 					matches = false
 				}
+			case "time":
+				date, err := mail.ParseDate(cell.Value)
+				if err != nil {
+					return false, fmt.Errorf("invalid time %s: %v", cell.Value, err)
+				}
+				if message.Time != date.Unix() {
+					matches = false
+				}
+			case "expiresin":
+				duration, err := time.ParseDuration(cell.Value)
+				if err != nil {
+					return false, fmt.Errorf("invalid duration %s: %v", cell.Value, err)
+				}
+				// Allow a minute for the time the message was being sent.
+				expiresIn := time.Until(time.Unix(message.ExpirationTime, 0))
+				if expiresIn > duration || expiresIn < duration-time.Minute {
+					matches = false
+				}
 			case "read":
 				var unread pmapi.Boolean
 				if cell.Value == "true" { //nolint[goconst]
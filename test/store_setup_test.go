@@ -25,6 +25,7 @@ import (
 	"strings"
 	"time"
 
+	storePkg "github.com/ProtonMail/proton-bridge/internal/store"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 	"github.com/cucumber/godog"
 	"github.com/cucumber/godog/gherkin"
@@ -33,6 +34,9 @@ import (
 func StoreSetupFeatureContext(s *godog.Suite) {
 	s.Step(`^there is "([^"]*)" with mailboxes`, thereIsUserWithMailboxes)
 	s.Step(`^there is "([^"]*)" with mailbox "([^"]*)"$`, thereIsUserWithMailbox)
+	s.Step(`^there is "([^"]*)" with mailbox "([^"]*)" colored "([^"]*)"$`, thereIsUserWithMailboxColored)
+	s.Step(`^there is "([^"]*)" with saved search "([^"]*)" for "([^"]*)"$`, thereIsUserWithSavedSearchFor)
+	s.Step(`^there is "([^"]*)" with alias policy "([^"]*)"$`, thereIsUserWithAliasPolicy)
 	s.Step(`^there are messages in mailbox(?:es)? "([^"]*)" for "([^"]*)"$`, thereAreMessagesInMailboxesForUser)
 	s.Step(`^there are messages in mailbox(?:es)? "([^"]*)" for address "([^"]*)" of "([^"]*)"$`, thereAreMessagesInMailboxesForAddressOfUser)
 	s.Step(`^there are (\d+) messages in mailbox(?:es)? "([^"]*)" for "([^"]*)"$`, thereAreSomeMessagesInMailboxesForUser)
@@ -50,13 +54,18 @@ func thereIsUserWithMailboxes(bddUserID string, mailboxes *gherkin.DataTable) er
 }
 
 func thereIsUserWithMailbox(bddUserID, mailboxName string) error {
+	return thereIsUserWithMailboxColored(bddUserID, mailboxName, "")
+}
+
+func thereIsUserWithMailboxColored(bddUserID, mailboxName, color string) error {
 	account := ctx.GetTestAccount(bddUserID)
 	if account == nil {
 		return godog.ErrPending
 	}
 	err := ctx.GetPMAPIController().AddUserLabel(account.Username(), &pmapi.Label{
-		Name: mailboxName,
-		Type: pmapi.LabelTypeMailbox,
+		Name:  mailboxName,
+		Color: color,
+		Type:  pmapi.LabelTypeMailbox,
 	})
 	if err != nil {
 		return internalError(err, "adding label %s for %s", mailboxName, account.Username())
@@ -71,6 +80,30 @@ func thereIsUserWithMailbox(bddUserID, mailboxName string) error {
 	return internalError(store.RebuildMailboxes(), "rebuilding mailboxes")
 }
 
+func thereIsUserWithSavedSearchFor(bddUserID, name, query string) error {
+	account := ctx.GetTestAccount(bddUserID)
+	if account == nil {
+		return godog.ErrPending
+	}
+	store, err := ctx.GetStore(account.Username())
+	if err != nil {
+		return internalError(err, "getting store of %s", account.Username())
+	}
+	return internalError(store.AddSavedSearch(storePkg.SavedSearch{Name: name, Query: query}), "adding saved search")
+}
+
+func thereIsUserWithAliasPolicy(bddUserID, policy string) error {
+	account := ctx.GetTestAccount(bddUserID)
+	if account == nil {
+		return godog.ErrPending
+	}
+	store, err := ctx.GetStore(account.Username())
+	if err != nil {
+		return internalError(err, "getting store of %s", account.Username())
+	}
+	return internalError(store.SetAliasPolicy(storePkg.AliasPolicy(policy)), "setting alias policy")
+}
+
 func thereAreMessagesInMailboxesForUser(mailboxNames, bddUserID string, messages *gherkin.DataTable) error {
 	return thereAreMessagesInMailboxesForAddressOfUser(mailboxNames, "", bddUserID, messages)
 }
@@ -170,6 +203,8 @@ func processMessageTableCell(column, cellValue, username string, message *pmapi.
 		}}
 	case "subject":
 		message.Subject = cellValue
+	case "conversation":
+		message.ConversationID = cellValue
 	case "body":
 		message.Body = cellValue
 	case "read":